@@ -0,0 +1,149 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package bob implements XEP-0231: Bits of Binary.
+//
+// Bits of binary are small pieces of data, such as the image in a CAPTCHA, that
+// are referenced by a content ID (cid) URI.
+// The data is either included in the stanza that references it or can be
+// requested from the sender.
+package bob // import "mellium.im/xmpp/bob"
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// NS is the namespace used by this package, provided as a convenience.
+const NS = "urn:xmpp:bob"
+
+// Data is a bit of binary data.
+type Data struct {
+	// CID is the content ID of the data without the "cid:" scheme.
+	CID string
+
+	// Type is the MIME type of the data, for example "image/png".
+	Type string
+
+	// MaxAge is the number of seconds the data may be cached for.
+	// A negative value means that no maximum age was provided.
+	MaxAge int
+
+	Data []byte
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (d Data) TokenReader() xml.TokenReader {
+	attrs := []xml.Attr{{Name: xml.Name{Local: "cid"}, Value: d.CID}}
+	if d.MaxAge >= 0 {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "max-age"}, Value: strconv.Itoa(d.MaxAge)})
+	}
+	if d.Type != "" {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "type"}, Value: d.Type})
+	}
+	return xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(base64.StdEncoding.EncodeToString(d.Data))),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "data"}, Attr: attrs},
+	)
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (d Data) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, d.TokenReader())
+}
+
+// MarshalXML satisfies the xml.Marshaler interface.
+func (d Data) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := d.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// UnmarshalXML satisfies the xml.Unmarshaler interface.
+func (d *Data) UnmarshalXML(dec *xml.Decoder, start xml.StartElement) error {
+	s := struct {
+		CID    string `xml:"cid,attr"`
+		Type   string `xml:"type,attr"`
+		MaxAge string `xml:"max-age,attr"`
+		Data   string `xml:",chardata"`
+	}{}
+	err := dec.DecodeElement(&s, &start)
+	if err != nil {
+		return err
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s.Data), ""))
+	if err != nil {
+		return err
+	}
+	maxAge := -1
+	if s.MaxAge != "" {
+		maxAge, err = strconv.Atoi(s.MaxAge)
+		if err != nil {
+			return err
+		}
+	}
+	*d = Data{
+		CID:    s.CID,
+		Type:   s.Type,
+		MaxAge: maxAge,
+		Data:   b,
+	}
+	return nil
+}
+
+// Get requests the data with the provided content ID from the entity at to.
+func Get(ctx context.Context, s *xmpp.Session, to jid.JID, cid string) (Data, error) {
+	data := Data{}
+	err := s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		nil,
+		xml.StartElement{
+			Name: xml.Name{Space: NS, Local: "data"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "cid"}, Value: cid}},
+		},
+	), stanza.IQ{
+		Type: stanza.GetIQ,
+		To:   to,
+	}, &data)
+	return data, err
+}
+
+// Fetcher returns a form.Fetcher that retrieves cid URIs.
+//
+// The included data, normally decoded from the stanza that contained the form,
+// is checked first and if the content ID is not found it is requested from the
+// entity at to.
+func Fetcher(s *xmpp.Session, to jid.JID, included ...Data) form.Fetcher {
+	return form.FetcherFunc(func(ctx context.Context, uri string) (io.ReadCloser, error) {
+		const prefix = "cid:"
+		if len(uri) < len(prefix) || !strings.EqualFold(uri[:len(prefix)], prefix) {
+			return nil, form.ErrNoFetcher
+		}
+		cid := uri[len(prefix):]
+		for _, d := range included {
+			if d.CID == cid {
+				return ioutil.NopCloser(bytes.NewReader(d.Data)), nil
+			}
+		}
+		d, err := Get(ctx, s, to, cid)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(bytes.NewReader(d.Data)), nil
+	})
+}
@@ -0,0 +1,113 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package bob_test
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/bob"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+var (
+	_ xmlstream.Marshaler = bob.Data{}
+	_ xmlstream.WriterTo  = bob.Data{}
+	_ xml.Marshaler       = bob.Data{}
+	_ xml.Unmarshaler     = (*bob.Data)(nil)
+)
+
+const (
+	cid         = "sha1+8f35fef110ffc5df08d579a50083ff9308fb6242@bob.xmpp.org"
+	includedCID = "sha1+f24030b8d91d233bac14777be5ab531ca3b9f102@bob.xmpp.org"
+)
+
+func TestMarshal(t *testing.T) {
+	const want = `<data xmlns="urn:xmpp:bob" cid="` + cid + `" max-age="86400" type="text/plain">dGVzdA==</data>`
+	data := bob.Data{CID: cid, Type: "text/plain", MaxAge: 86400, Data: []byte("test")}
+	b, err := xml.Marshal(data)
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	if s := string(b); s != want {
+		t.Errorf("wrong XML:\nwant=%s,\n got=%s", want, s)
+	}
+
+	var decoded bob.Data
+	err = xml.Unmarshal(b, &decoded)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, data) {
+		t.Errorf("wrong data:\nwant=%+v,\n got=%+v", data, decoded)
+	}
+}
+
+func TestFetcher(t *testing.T) {
+	requests := 0
+	cs := xmpptest.NewClientServer(
+		xmpptest.ServerHandlerFunc(func(e xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			requests++
+			iq, err := stanza.NewIQ(*start)
+			if err != nil {
+				return err
+			}
+			req := struct {
+				XMLName xml.Name `xml:"urn:xmpp:bob data"`
+				CID     string   `xml:"cid,attr"`
+			}{}
+			err = xml.NewTokenDecoder(e).Decode(&req)
+			if err != nil {
+				return err
+			}
+			if req.CID != cid {
+				t.Errorf("wrong cid requested: want=%q, got=%q", cid, req.CID)
+			}
+			_, err = xmlstream.Copy(e, iq.Result(xmlstream.Wrap(
+				xmlstream.Token(xml.CharData("dGVz\ndA==")),
+				xml.StartElement{
+					Name: xml.Name{Space: bob.NS, Local: "data"},
+					Attr: []xml.Attr{
+						{Name: xml.Name{Local: "cid"}, Value: cid},
+						{Name: xml.Name{Local: "type"}, Value: "text/plain"},
+					},
+				},
+			)))
+			return err
+		}),
+	)
+	fetcher := bob.Fetcher(cs.Client, jid.JID{}, bob.Data{CID: includedCID, Data: []byte("included")})
+
+	for i, tc := range []struct {
+		uri      string
+		want     string
+		requests int
+	}{
+		// Data that was included in the stanza does not need to be requested.
+		0: {uri: "cid:" + includedCID, want: "included"},
+		1: {uri: "cid:" + cid, want: "test", requests: 1},
+	} {
+		r, err := fetcher.Fetch(context.Background(), tc.uri)
+		if err != nil {
+			t.Fatalf("error fetching data %d: %v", i, err)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("error reading data %d: %v", i, err)
+		}
+		if string(b) != tc.want {
+			t.Errorf("wrong data %d: want=%q, got=%q", i, tc.want, b)
+		}
+		if requests != tc.requests {
+			t.Errorf("wrong number of requests %d: want=%d, got=%d", i, tc.requests, requests)
+		}
+	}
+}
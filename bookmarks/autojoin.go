@@ -0,0 +1,66 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package bookmarks
+
+import (
+	"context"
+
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/muc"
+)
+
+// Autojoin fetches all bookmarks and joins every channel that has autojoin
+// set using the provided MUC client.
+// If a bookmark does not have a nickname, the localpart of the sessions
+// address is used.
+// Options are applied when joining each channel after the nickname and
+// password from the bookmark.
+//
+// If joining a channel fails, Autojoin continues to join the remaining
+// channels and returns the first error encountered along with any channels
+// that were joined successfully.
+func Autojoin(ctx context.Context, s *xmpp.Session, c *muc.Client, opt ...muc.Option) ([]*muc.Channel, error) {
+	iter := Fetch(ctx, s)
+	/* #nosec */
+	defer iter.Close()
+
+	var (
+		channels []*muc.Channel
+		firstErr error
+	)
+	for iter.Next() {
+		bookmark := iter.Channel()
+		if !bookmark.Autojoin {
+			continue
+		}
+		nick := bookmark.Nick
+		if nick == "" {
+			nick = s.LocalAddr().Localpart()
+		}
+		room, err := bookmark.JID.WithResource(nick)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		var opts []muc.Option
+		if bookmark.Password != "" {
+			opts = append(opts, muc.Password(bookmark.Password))
+		}
+		channel, err := c.Join(ctx, room, s, append(opts, opt...)...)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		channels = append(channels, channel)
+	}
+	if err := iter.Err(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return channels, firstErr
+}
@@ -0,0 +1,159 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package bookmarks implements storing bookmarks to chat rooms.
+//
+// Bookmarks are stored using the Personal Eventing Protocol (PEP) as described
+// in XEP-0402: PEP Native Bookmarks so that they are synchronized between all
+// of a users clients.
+// When another client changes the bookmarks, a notification is sent that can
+// be handled by registering a Handler with a multiplexer:
+//
+//     m := mux.New(
+//         bookmarks.Handle(bookmarks.Handler{
+//             HandleChannel: func(c bookmarks.Channel) {
+//                 log.Printf("bookmark added or updated: %s", c.JID)
+//             },
+//         }),
+//     )
+//
+// Bookmarks that were stored using the older XEP-0048: Bookmarks in private
+// XML storage can be fetched using FetchLegacy and migrated using Migrate.
+package bookmarks // import "mellium.im/xmpp/bookmarks"
+
+import (
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/pubsub"
+	"mellium.im/xmpp/jid"
+)
+
+// Various namespaces used by this package, provided as a convenience.
+const (
+	NS = `urn:xmpp:bookmarks:1`
+
+	// NSCompat is the feature advertised by servers that make bookmarks stored
+	// using this package available to older clients that still use private XML
+	// storage.
+	NSCompat = `urn:xmpp:bookmarks:1#compat`
+
+	// NSLegacy is the namespace used by bookmarks stored in private XML storage.
+	NSLegacy = `storage:bookmarks`
+
+	// FeatureNotify may be advertised to receive notifications when bookmarks
+	// are changed by another client.
+	FeatureNotify = NS + "+notify"
+)
+
+// Channel is a bookmarked chat room.
+type Channel struct {
+	// JID is the address of the room.
+	// It is used as the ID of the published item and is not part of the XML
+	// representation of the bookmark.
+	JID jid.JID
+
+	// Name is a friendly name for the bookmark.
+	Name string
+
+	// Autojoin indicates that the room should be joined automatically when the
+	// client starts.
+	Autojoin bool
+
+	// Nick and Password are used when joining the room.
+	Nick     string
+	Password string
+
+	// Extensions contains the tokens that make up the children of the
+	// extensions element, which can be used to store additional information
+	// about the bookmark.
+	Extensions []xml.Token
+}
+
+func optionalString(s, local string) xml.TokenReader {
+	if s == "" {
+		return nil
+	}
+	return xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(s)),
+		xml.StartElement{Name: xml.Name{Local: local}},
+	)
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (c Channel) TokenReader() xml.TokenReader {
+	var attrs []xml.Attr
+	if c.Name != "" {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "name"}, Value: c.Name})
+	}
+	if c.Autojoin {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "autojoin"}, Value: "true"})
+	}
+	var extensions xml.TokenReader
+	if len(c.Extensions) > 0 {
+		t := pubsub.Tokens(c.Extensions)
+		extensions = xmlstream.Wrap(&t, xml.StartElement{Name: xml.Name{Local: "extensions"}})
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(
+			optionalString(c.Nick, "nick"),
+			optionalString(c.Password, "password"),
+			extensions,
+		),
+		xml.StartElement{
+			Name: xml.Name{Space: NS, Local: "conference"},
+			Attr: attrs,
+		},
+	)
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (c Channel) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, c.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (c Channel) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	_, err := c.WriteXML(e)
+	return err
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+// Because the JID is not part of the XML representation of the bookmark it is
+// left unchanged.
+func (c *Channel) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for _, attr := range start.Attr {
+		switch attr.Name.Local {
+		case "name":
+			c.Name = attr.Value
+		case "autojoin":
+			c.Autojoin = attr.Value == "true" || attr.Value == "1"
+		}
+	}
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "nick":
+				err = d.DecodeElement(&c.Nick, &t)
+			case "password":
+				err = d.DecodeElement(&c.Password, &t)
+			case "extensions":
+				c.Extensions, err = pubsub.CopyInner(d)
+			default:
+				err = d.Skip()
+			}
+			if err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,332 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package bookmarks_test
+
+import (
+	"context"
+	"encoding/xml"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/bookmarks"
+	"mellium.im/xmpp/internal/pubsub"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+var marshalTestCases = []xmpptest.EncodingTestCase{
+	0: {
+		Value: &bookmarks.Channel{},
+		XML:   `<conference xmlns="urn:xmpp:bookmarks:1"></conference>`,
+	},
+	1: {
+		Value: &bookmarks.Channel{
+			Name:     "The Play's the Thing",
+			Autojoin: true,
+			Nick:     "JC",
+			Password: "secret",
+		},
+		XML: `<conference xmlns="urn:xmpp:bookmarks:1" name="The Play&#39;s the Thing" autojoin="true"><nick>JC</nick><password>secret</password></conference>`,
+	},
+}
+
+func TestEncode(t *testing.T) {
+	xmpptest.RunEncodingTests(t, marshalTestCases)
+}
+
+func TestUnmarshalExtensions(t *testing.T) {
+	const input = `<conference xmlns="urn:xmpp:bookmarks:1" autojoin="1"><extensions><state xmlns="http://myclient.example/bookmark/state" minimized="true"/></extensions></conference>`
+	var c bookmarks.Channel
+	err := xml.Unmarshal([]byte(input), &c)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	if !c.Autojoin {
+		t.Errorf("expected numeric autojoin to be parsed")
+	}
+	var buf strings.Builder
+	e := xml.NewEncoder(&buf)
+	_, err = xmlstream.Copy(e, c.TokenReader())
+	if err != nil {
+		t.Fatalf("error encoding: %v", err)
+	}
+	err = e.Flush()
+	if err != nil {
+		t.Fatalf("error flushing: %v", err)
+	}
+	const want = `<conference xmlns="urn:xmpp:bookmarks:1" autojoin="true"><extensions><state xmlns="http://myclient.example/bookmark/state" minimized="true"></state></extensions></conference>`
+	if out := buf.String(); out != want {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", want, out)
+	}
+}
+
+func fetchAll(t *testing.T, iter *bookmarks.Iter) []bookmarks.Channel {
+	t.Helper()
+	var channels []bookmarks.Channel
+	for iter.Next() {
+		channels = append(channels, iter.Channel())
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatalf("error iterating over bookmarks: %v", err)
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatalf("error closing iterator: %v", err)
+	}
+	return channels
+}
+
+func TestFetchSkipsInvalid(t *testing.T) {
+	pep := &xmpptest.PubSub{}
+	var items []pubsub.Item
+	for _, raw := range []string{
+		`<item id="theplay@conference.shakespeare.lit"><conference xmlns="urn:xmpp:bookmarks:1" name="The Play"/></item>`,
+		`<item id="@invalid"><conference xmlns="urn:xmpp:bookmarks:1"/></item>`,
+		`<item id="orchard@conference.shakespeare.lit"/>`,
+		`<item id="garden@conference.shakespeare.lit"><conference xmlns="urn:xmpp:bookmarks:1" name="The Garden"/></item>`,
+	} {
+		item := pubsub.Item{}
+		err := xml.Unmarshal([]byte(raw), &item)
+		if err != nil {
+			t.Fatalf("error decoding test item: %v", err)
+		}
+		items = append(items, item)
+	}
+	pep.SetItems(bookmarks.NS, items)
+	s := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(pep.Handle())),
+	)
+
+	iter := bookmarks.Fetch(context.Background(), s.Client)
+	var names []string
+	for iter.Next() {
+		names = append(names, iter.Channel().Name)
+	}
+	if want := []string{"The Play", "The Garden"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("wrong bookmarks: want=%q, got=%q", want, names)
+	}
+	if err := iter.Err(); err == nil || !strings.Contains(err.Error(), "@invalid") {
+		t.Errorf("expected error for the first invalid bookmark, got %v", err)
+	}
+}
+
+func TestPublishFetchRetract(t *testing.T) {
+	pep := &xmpptest.PubSub{}
+	s := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(pep.Handle())),
+	)
+	ctx := context.Background()
+
+	play := bookmarks.Channel{
+		JID:      jid.MustParse("theplay@conference.shakespeare.lit"),
+		Name:     "The Play's the Thing",
+		Autojoin: true,
+		Nick:     "JC",
+	}
+	orchard := bookmarks.Channel{
+		JID:  jid.MustParse("orchard@conference.shakespeare.lit"),
+		Name: "The Orchard",
+	}
+	for _, c := range []bookmarks.Channel{play, orchard} {
+		err := bookmarks.Publish(ctx, s.Client, c)
+		if err != nil {
+			t.Fatalf("error publishing %s: %v", c.JID, err)
+		}
+	}
+	opts := pep.PublishOptions(bookmarks.NS)
+	if access, _ := opts.GetString("pubsub#access_model"); access != "whitelist" {
+		t.Errorf("wrong access model: want=whitelist, got=%q", access)
+	}
+	if max, _ := opts.GetString("pubsub#max_items"); max != "max" {
+		t.Errorf("wrong max items: want=max, got=%q", max)
+	}
+
+	channels := fetchAll(t, bookmarks.Fetch(ctx, s.Client))
+	if want := []bookmarks.Channel{play, orchard}; !reflect.DeepEqual(channels, want) {
+		t.Errorf("wrong bookmarks:\nwant=%+v,\n got=%+v", want, channels)
+	}
+
+	err := bookmarks.Retract(ctx, s.Client, play.JID)
+	if err != nil {
+		t.Fatalf("error retracting: %v", err)
+	}
+	channels = fetchAll(t, bookmarks.Fetch(ctx, s.Client))
+	if want := []bookmarks.Channel{orchard}; !reflect.DeepEqual(channels, want) {
+		t.Errorf("wrong bookmarks after retraction:\nwant=%+v,\n got=%+v", want, channels)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	published := make(chan bookmarks.Channel, 2)
+	retracted := make(chan jid.JID, 1)
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(bookmarks.Handle(bookmarks.Handler{
+			HandleChannel: func(c bookmarks.Channel) {
+				published <- c
+			},
+			HandleRetract: func(j jid.JID) {
+				retracted <- j
+			},
+		}))),
+	)
+
+	const events = `<message xmlns="jabber:client" from="eve@example.net"><event xmlns="http://jabber.org/protocol/pubsub#event"><items node="urn:xmpp:bookmarks:1"><item id="spoofed@conference.example.net"><conference xmlns="urn:xmpp:bookmarks:1"/></item></items></event></message>` +
+		`<message xmlns="jabber:client"><event xmlns="http://jabber.org/protocol/pubsub#event"><items node="urn:xmpp:bookmarks:1"><item id="theplay@conference.shakespeare.lit"><conference xmlns="urn:xmpp:bookmarks:1" name="The Play" autojoin="true"/></item></items></event></message>` +
+		`<message xmlns="jabber:client" type="headline"><event xmlns="http://jabber.org/protocol/pubsub#event"><items node="urn:xmpp:bookmarks:1"><retract id="orchard@conference.shakespeare.lit"/></items></event></message>`
+	iter := xmlstream.NewIter(xml.NewDecoder(strings.NewReader(events)))
+	for iter.Next() {
+		start, inner := iter.Current()
+		err := s.Server.Send(context.Background(), xmlstream.MultiReader(
+			xmlstream.Token(*start),
+			inner,
+		))
+		if err != nil {
+			t.Fatalf("error sending event: %v", err)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatalf("error iterating over events: %v", err)
+	}
+
+	c := <-published
+	want := bookmarks.Channel{
+		JID:      jid.MustParse("theplay@conference.shakespeare.lit"),
+		Name:     "The Play",
+		Autojoin: true,
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("wrong bookmark published:\nwant=%+v,\n got=%+v", want, c)
+	}
+	if j := <-retracted; !j.Equal(jid.MustParse("orchard@conference.shakespeare.lit")) {
+		t.Errorf("wrong bookmark retracted: %v", j)
+	}
+	select {
+	case c := <-published:
+		t.Errorf("unexpected bookmark published: %+v", c)
+	default:
+	}
+}
+
+func TestAutojoin(t *testing.T) {
+	type join struct {
+		room     string
+		password string
+	}
+	joins := make(chan join, 3)
+	pep := &xmpptest.PubSub{}
+	mucClient := &muc.Client{}
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(muc.HandleClient(mucClient))),
+		xmpptest.ServerHandler(mux.New(
+			pep.Handle(),
+			mux.PresenceFunc(stanza.AvailablePresence, xml.Name{Space: muc.NS, Local: "x"}, func(p stanza.Presence, r xmlstream.TokenReadEncoder) error {
+				x := struct {
+					Password string `xml:"http://jabber.org/protocol/muc x>password"`
+				}{}
+				err := xml.NewTokenDecoder(r).Decode(&x)
+				if err != nil {
+					return err
+				}
+				joins <- join{room: p.To.String(), password: x.Password}
+
+				// Send back a self presence, indicating that the join is complete.
+				_, err = xmlstream.Copy(r, stanza.Presence{From: p.To}.Wrap(xmlstream.Wrap(
+					nil,
+					xml.StartElement{Name: xml.Name{Space: muc.NSUser, Local: "x"}},
+				)))
+				return err
+			}),
+		)),
+	)
+	ctx := context.Background()
+	for _, c := range []bookmarks.Channel{{
+		JID:      jid.MustParse("theplay@conference.shakespeare.lit"),
+		Autojoin: true,
+		Nick:     "JC",
+		Password: "secret",
+	}, {
+		JID: jid.MustParse("orchard@conference.shakespeare.lit"),
+	}, {
+		JID:      jid.MustParse("heath@conference.shakespeare.lit"),
+		Autojoin: true,
+	}} {
+		err := bookmarks.Publish(ctx, s.Client, c)
+		if err != nil {
+			t.Fatalf("error publishing %s: %v", c.JID, err)
+		}
+	}
+
+	channels, err := bookmarks.Autojoin(ctx, s.Client, mucClient, muc.MaxHistory(0))
+	if err != nil {
+		t.Fatalf("error autojoining: %v", err)
+	}
+	close(joins)
+	var joined []join
+	for j := range joins {
+		joined = append(joined, j)
+	}
+	sort.Slice(joined, func(i, j int) bool {
+		return joined[i].room < joined[j].room
+	})
+	want := []join{
+		{room: "heath@conference.shakespeare.lit/test"},
+		{room: "theplay@conference.shakespeare.lit/JC", password: "secret"},
+	}
+	if !reflect.DeepEqual(joined, want) {
+		t.Errorf("wrong rooms joined:\nwant=%+v,\n got=%+v", want, joined)
+	}
+	if len(channels) != 2 {
+		t.Fatalf("wrong number of channels returned: want=2, got=%d", len(channels))
+	}
+	for _, c := range channels {
+		if !c.Joined() {
+			t.Errorf("expected %v to be joined", c.Addr())
+		}
+	}
+}
+
+func TestLegacy(t *testing.T) {
+	pep := &xmpptest.PubSub{}
+	s := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(
+			pep.Handle(),
+			mux.IQFunc(stanza.GetIQ, xml.Name{Space: "jabber:iq:private", Local: "query"}, func(iq stanza.IQ, r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+				const storage = `<query xmlns="jabber:iq:private"><storage xmlns="storage:bookmarks"><conference name="Council of Oberon" autojoin="true" jid="council@conference.underhill.org"><nick>Puck</nick><password>titania</password></conference><url name="Complete Works of Shakespeare" url="http://www-tech.mit.edu/Shakespeare/works.html"/></storage></query>`
+				_, err := xmlstream.Copy(r, iq.Result(xml.NewDecoder(strings.NewReader(storage))))
+				return err
+			}),
+		)),
+	)
+	ctx := context.Background()
+
+	council := bookmarks.Channel{
+		JID:      jid.MustParse("council@conference.underhill.org"),
+		Name:     "Council of Oberon",
+		Autojoin: true,
+		Nick:     "Puck",
+		Password: "titania",
+	}
+	channels, err := bookmarks.FetchLegacy(ctx, s.Client)
+	if err != nil {
+		t.Fatalf("error fetching legacy bookmarks: %v", err)
+	}
+	if want := []bookmarks.Channel{council}; !reflect.DeepEqual(channels, want) {
+		t.Errorf("wrong legacy bookmarks:\nwant=%+v,\n got=%+v", want, channels)
+	}
+
+	err = bookmarks.Migrate(ctx, s.Client)
+	if err != nil {
+		t.Fatalf("error migrating bookmarks: %v", err)
+	}
+	channels = fetchAll(t, bookmarks.Fetch(ctx, s.Client))
+	if want := []bookmarks.Channel{council}; !reflect.DeepEqual(channels, want) {
+		t.Errorf("wrong bookmarks after migration:\nwant=%+v,\n got=%+v", want, channels)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package bookmarks
+
+import (
+	"context"
+	"encoding/xml"
+
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/private"
+)
+
+type legacyConference struct {
+	JID      jid.JID `xml:"jid,attr"`
+	Name     string  `xml:"name,attr"`
+	Autojoin string  `xml:"autojoin,attr"`
+	Nick     string  `xml:"nick"`
+	Password string  `xml:"password"`
+}
+
+// FetchLegacy fetches bookmarks stored in private XML storage as described
+// in XEP-0048: Bookmarks and converts them to channels.
+// Bookmarks to URLs are ignored.
+func FetchLegacy(ctx context.Context, s *xmpp.Session) ([]Channel, error) {
+	storage := struct {
+		Conference []legacyConference `xml:"conference"`
+	}{}
+	err := private.NewXML(s).Unmarshal(ctx, xml.Name{Space: NSLegacy, Local: "storage"}, &storage)
+	if err != nil {
+		return nil, err
+	}
+
+	channels := make([]Channel, 0, len(storage.Conference))
+	for _, conf := range storage.Conference {
+		channels = append(channels, Channel{
+			JID:      conf.JID,
+			Name:     conf.Name,
+			Autojoin: conf.Autojoin == "true" || conf.Autojoin == "1",
+			Nick:     conf.Nick,
+			Password: conf.Password,
+		})
+	}
+	return channels, nil
+}
+
+// Migrate fetches bookmarks stored in private XML storage and publishes them
+// using PEP.
+// The legacy bookmarks are not removed so that they remain available to older
+// clients.
+func Migrate(ctx context.Context, s *xmpp.Session) error {
+	channels, err := FetchLegacy(ctx, s)
+	if err != nil {
+		return err
+	}
+	for _, c := range channels {
+		err = Publish(ctx, s, c)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
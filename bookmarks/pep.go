@@ -0,0 +1,157 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package bookmarks
+
+import (
+	"context"
+	"fmt"
+
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/internal/pubsub"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// publishOptions are the node configuration options required by XEP-0402.
+func publishOptions() *form.Data {
+	return pubsub.PrivateOptions(
+		form.Text("pubsub#max_items", form.Value("max")),
+		form.List("pubsub#send_last_published_item", form.Value("never")),
+	)
+}
+
+// Publish creates or updates a bookmark.
+func Publish(ctx context.Context, s *xmpp.Session, c Channel) error {
+	_, err := pubsub.Publish(ctx, s, jid.JID{}, NS, c.JID.Bare().String(), publishOptions(), c.TokenReader())
+	return err
+}
+
+// Retract removes a bookmark.
+func Retract(ctx context.Context, s *xmpp.Session, j jid.JID) error {
+	return pubsub.Retract(ctx, s, jid.JID{}, NS, j.Bare().String(), true)
+}
+
+// Iter is an iterator over bookmarks.
+type Iter struct {
+	items   []pubsub.Item
+	current Channel
+	err     error
+	skipErr error
+}
+
+// Next returns true if there are more bookmarks to decode.
+// Items that are not valid bookmarks are skipped and the first error
+// encountered while decoding them is reported by Err once iteration stops.
+func (i *Iter) Next() bool {
+	if i.err != nil {
+		return false
+	}
+	for len(i.items) > 0 {
+		item := i.items[0]
+		i.items = i.items[1:]
+		c, err := decodeItem(item)
+		if err != nil {
+			if i.skipErr == nil {
+				i.skipErr = fmt.Errorf("bookmarks: skipped invalid bookmark %q: %w", item.ID, err)
+			}
+			continue
+		}
+		i.current = c
+		return true
+	}
+	return false
+}
+
+// Err returns the last error encountered by the iterator (if any).
+func (i *Iter) Err() error {
+	if i.err != nil {
+		return i.err
+	}
+	return i.skipErr
+}
+
+// Channel returns the last bookmark parsed by the iterator.
+func (i *Iter) Channel() Channel {
+	return i.current
+}
+
+// Close indicates that we are finished with the given iterator.
+// Calling it multiple times has no effect.
+func (i *Iter) Close() error {
+	i.items = nil
+	return nil
+}
+
+func decodeItem(item pubsub.Item) (Channel, error) {
+	j, err := jid.Parse(item.ID)
+	if err != nil {
+		return Channel{}, err
+	}
+	c := Channel{JID: j}
+	err = item.Decode(&c)
+	return c, err
+}
+
+// Fetch requests all bookmarks.
+func Fetch(ctx context.Context, s *xmpp.Session) *Iter {
+	items, err := pubsub.Fetch(ctx, s, jid.JID{}, NS, 0)
+	return &Iter{
+		items: items,
+		err:   err,
+	}
+}
+
+// Handler is called when another client publishes or retracts a bookmark.
+type Handler struct {
+	// HandleChannel is called when a bookmark is added or updated.
+	HandleChannel func(Channel)
+
+	// HandleRetract is called when a bookmark is removed.
+	HandleRetract func(jid.JID)
+}
+
+// Handle returns an option that registers a Handler for bookmark
+// notifications.
+func Handle(h Handler) mux.Option {
+	return pubsub.HandleEvents(NS, h.handleEvent)
+}
+
+func (h Handler) handleEvent(msg stanza.Message, items pubsub.Items) error {
+	// Only trust notifications from our own account, which will have had their
+	// from address removed by the session.
+	if !msg.From.Equal(jid.JID{}) {
+		return nil
+	}
+	// Invalid items are skipped so that one bad bookmark does not hide the
+	// others.
+	var firstErr error
+	if h.HandleChannel != nil {
+		for _, item := range items.Item {
+			c, err := decodeItem(item)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			h.HandleChannel(c)
+		}
+	}
+	if h.HandleRetract != nil {
+		for _, retract := range items.Retract {
+			j, err := jid.Parse(retract.ID)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			h.HandleRetract(j)
+		}
+	}
+	return firstErr
+}
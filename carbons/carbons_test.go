@@ -9,11 +9,15 @@ import (
 	"context"
 	"encoding/xml"
 	"errors"
+	"reflect"
 	"testing"
 
 	"mellium.im/xmlstream"
 	"mellium.im/xmpp/carbons"
+	"mellium.im/xmpp/forward"
 	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
 	"mellium.im/xmpp/stanza"
 )
 
@@ -54,3 +58,99 @@ func TestEnableDisable(t *testing.T) {
 		t.Errorf("wrong XML:\nwant=%s,\n got=%s", expected, output)
 	}
 }
+
+func TestHandler(t *testing.T) {
+	type carbon struct {
+		msg  stanza.Message
+		sent bool
+		body string
+	}
+	carbonsC := make(chan carbon, 10)
+	h := carbons.Handler{
+		HandleCarbon: func(msg stanza.Message, sent bool, payload xml.TokenReader) error {
+			v := struct {
+				Body string `xml:"body"`
+			}{}
+			err := xml.NewTokenDecoder(xmlstream.Wrap(payload, xml.StartElement{Name: xml.Name{Local: "message"}})).Decode(&v)
+			if err != nil {
+				return err
+			}
+			carbonsC <- carbon{msg: msg, sent: sent, body: v.Body}
+			return nil
+		},
+	}
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(carbons.Handle(h))),
+	)
+
+	juliet := jid.MustParse("juliet@capulet.example/balcony")
+	romeo := jid.MustParse("romeo@montague.example/home")
+	wrap := func(from jid.JID, local string, inner stanza.Message, body string) xml.TokenReader {
+		inner.XMLName = xml.Name{Space: "jabber:client", Local: "message"}
+		return stanza.Message{From: from, Type: stanza.ChatMessage}.Wrap(xmlstream.Wrap(
+			forward.Forwarded{}.Wrap(inner.Wrap(xmlstream.Wrap(
+				xmlstream.Token(xml.CharData(body)),
+				xml.StartElement{Name: xml.Name{Local: "body"}},
+			))),
+			xml.StartElement{Name: xml.Name{Space: carbons.NS, Local: local}},
+		))
+	}
+	ctx := context.Background()
+	for i, r := range []xml.TokenReader{
+		// Carbons from anyone other than our own account must be dropped.
+		wrap(jid.MustParse("mallory@evil.example"), "received", stanza.Message{From: juliet, To: romeo, Type: stanza.ChatMessage}, "spoofed"),
+		wrap(jid.JID{}, "received", stanza.Message{From: juliet, To: romeo, Type: stanza.ChatMessage}, "Wherefore art thou?"),
+		wrap(jid.JID{}, "sent", stanza.Message{From: romeo, To: juliet, Type: stanza.ChatMessage}, "Here!"),
+	} {
+		err := s.Server.Send(ctx, r)
+		if err != nil {
+			t.Fatalf("error sending message %d: %v", i, err)
+		}
+	}
+
+	for i, want := range []carbon{
+		{msg: stanza.Message{XMLName: xml.Name{Space: "jabber:client", Local: "message"}, From: juliet, To: romeo, Type: stanza.ChatMessage}, body: "Wherefore art thou?"},
+		{msg: stanza.Message{XMLName: xml.Name{Space: "jabber:client", Local: "message"}, From: romeo, To: juliet, Type: stanza.ChatMessage}, sent: true, body: "Here!"},
+	} {
+		c := <-carbonsC
+		if !reflect.DeepEqual(c, want) {
+			t.Errorf("wrong carbon %d:\nwant=%+v,\n got=%+v", i, want, c)
+		}
+	}
+	select {
+	case c := <-carbonsC:
+		t.Errorf("unexpected carbon: %+v", c)
+	default:
+	}
+}
+
+func TestHints(t *testing.T) {
+	msg := stanza.Message{To: jid.MustParse("juliet@capulet.example"), Type: stanza.ChatMessage}
+	for _, tc := range []struct {
+		transformer xmlstream.Transformer
+		out         string
+	}{
+		{
+			transformer: carbons.Private,
+			out:         `<message type="chat" to="juliet@capulet.example"><private xmlns="urn:xmpp:carbons:2"></private></message>`,
+		},
+		{
+			transformer: carbons.NoCopy,
+			out:         `<message type="chat" to="juliet@capulet.example"><no-copy xmlns="urn:xmpp:hints"></no-copy></message>`,
+		},
+	} {
+		var buf bytes.Buffer
+		e := xml.NewEncoder(&buf)
+		_, err := xmlstream.Copy(e, tc.transformer(msg.Wrap(nil)))
+		if err != nil {
+			t.Fatalf("error encoding: %v", err)
+		}
+		err = e.Flush()
+		if err != nil {
+			t.Fatalf("error flushing: %v", err)
+		}
+		if out := buf.String(); out != tc.out {
+			t.Errorf("wrong output:\nwant=%s,\n got=%s", tc.out, out)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package carbons
+
+import (
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/forward"
+	"mellium.im/xmpp/hints"
+	"mellium.im/xmpp/internal/ns"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// Handle returns an option that registers a Handler for carbon copies.
+func Handle(h Handler) mux.Option {
+	return func(m *mux.ServeMux) {
+		received := xml.Name{Space: NS, Local: "received"}
+		sent := xml.Name{Space: NS, Local: "sent"}
+		for _, typ := range []stanza.MessageType{"", stanza.NormalMessage, stanza.ChatMessage, stanza.GroupChatMessage} {
+			mux.Message(typ, received, h)(m)
+			mux.Message(typ, sent, h)(m)
+		}
+	}
+}
+
+// Handler unwraps carbon copies of messages that were sent or received by
+// other clients connected to our account.
+//
+// Carbon copies can only be sent by our own account, so any copies with a
+// "from" attribute are dropped to prevent other entities from injecting
+// messages that appear to have been sent by us.
+// The session removes the "from" attribute from stanzas sent by our own bare
+// JID, so legitimate carbon copies never have one by the time they reach the
+// handler.
+type Handler struct {
+	// HandleCarbon is called with the header of the copied message and a reader
+	// over its payload.
+	// Sent is true if the message was sent by another of our clients and false
+	// if it was received by another of our clients.
+	HandleCarbon func(msg stanza.Message, sent bool, payload xml.TokenReader) error
+}
+
+// HandleMessage satisfies mux.MessageHandler.
+// it is used by the multiplexer and normally does not need to be called by the
+// user.
+func (h Handler) HandleMessage(p stanza.Message, r xmlstream.TokenReadEncoder) error {
+	if !p.From.Equal(jid.JID{}) {
+		return nil
+	}
+
+	// Pop the start message token.
+	_, err := r.Token()
+	if err != nil {
+		return err
+	}
+
+	iter := xmlstream.NewIter(r)
+	/* #nosec */
+	defer iter.Close()
+	for iter.Next() {
+		start, inner := iter.Current()
+		if start == nil || start.Name.Space != NS || (start.Name.Local != "sent" && start.Name.Local != "received") {
+			continue
+		}
+		sent := start.Name.Local == "sent"
+		return h.unwrap(sent, inner)
+	}
+	return iter.Err()
+}
+
+// unwrap finds the forwarded message in the carbon element read from r.
+func (h Handler) unwrap(sent bool, r xml.TokenReader) error {
+	iter := xmlstream.NewIter(r)
+	/* #nosec */
+	defer iter.Close()
+	for iter.Next() {
+		start, inner := iter.Current()
+		if start == nil || start.Name.Space != forward.NS || start.Name.Local != "forwarded" {
+			continue
+		}
+		_, msg, payload, err := forward.UnwrapMessage(xmlstream.MultiReader(xmlstream.Token(*start), inner))
+		if err != nil {
+			return err
+		}
+		if h.HandleCarbon == nil {
+			return nil
+		}
+		return h.HandleCarbon(msg, sent, payload)
+	}
+	return iter.Err()
+}
+
+var (
+	insertPrivate = xmlstream.InsertFunc(func(start xml.StartElement, level uint64, w xmlstream.TokenWriter) error {
+		// Messages that are being constructed often do not have a namespace yet,
+		// so unlike incoming messages we also allow an empty namespace here.
+		if level == 1 && start.Name.Local == "message" && (start.Name.Space == "" || start.Name.Space == ns.Client || start.Name.Space == ns.Server) {
+			_, err := xmlstream.Copy(w, xmlstream.Wrap(nil, xml.StartElement{
+				Name: xml.Name{Space: NS, Local: "private"},
+			}))
+			return err
+		}
+		return nil
+	})
+	insertNoCopy = hints.Insert(hints.NoCopy)
+)
+
+// Private is an xmlstream.Transformer that marks any messages found in the
+// input stream as private so that the server does not send carbon copies of
+// them to our other clients.
+func Private(r xml.TokenReader) xml.TokenReader {
+	return insertPrivate(r)
+}
+
+// NoCopy is an xmlstream.Transformer that adds the hints.NoCopy hint to any
+// messages found in the input stream indicating that they should not be copied
+// to other resources.
+// Unlike Private, the hint is advisory and may also affect entities other than
+// the carbons service.
+func NoCopy(r xml.TokenReader) xml.TokenReader {
+	return insertNoCopy(r)
+}
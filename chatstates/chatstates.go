@@ -0,0 +1,88 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package chatstates implements XEP-0085: Chat State Notifications.
+//
+// Chat states let the participants in a conversation know whether the other
+// party is paying attention to the conversation or typing a message.
+// Incoming notifications are reported by Handler, which also keeps track of
+// which contacts have shown support for chat states.
+// Outgoing notifications are managed by Sender, a state machine that is driven
+// by events from the user interface and by timers and that only notifies
+// contacts when our state actually changes.
+package chatstates // import "mellium.im/xmpp/chatstates"
+
+import (
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+)
+
+// NS is the namespace used by this package, provided as a convenience.
+const NS = `http://jabber.org/protocol/chatstates`
+
+// State is the state of a participant in a conversation.
+// The zero value indicates that no state is known.
+type State string
+
+// A list of possible chat states.
+const (
+	// Active indicates that the user is actively participating in the chat
+	// session.
+	Active State = "active"
+
+	// Composing indicates that the user is composing a message.
+	Composing State = "composing"
+
+	// Paused indicates that the user had been composing a message but has
+	// stopped.
+	Paused State = "paused"
+
+	// Inactive indicates that the user has not been actively participating in
+	// the chat session.
+	Inactive State = "inactive"
+
+	// Gone indicates that the user has effectively ended their participation in
+	// the chat session.
+	Gone State = "gone"
+)
+
+func (s State) valid() bool {
+	switch s {
+	case Active, Composing, Paused, Inactive, Gone:
+		return true
+	}
+	return false
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+// If the state is not one of the known states, no tokens are returned.
+func (s State) TokenReader() xml.TokenReader {
+	if !s.valid() {
+		return xmlstream.MultiReader()
+	}
+	return xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: NS, Local: string(s)}})
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (s State) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, s.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (s State) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	_, err := s.WriteXML(e)
+	return err
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+// If the element is not a chat state the state is set to the zero value.
+func (s *State) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	*s = ""
+	if state := State(start.Name.Local); start.Name.Space == NS && state.valid() {
+		*s = state
+	}
+	return d.Skip()
+}
@@ -0,0 +1,272 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package chatstates_test
+
+import (
+	"context"
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/chatstates"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+var (
+	_ xml.Marshaler       = chatstates.State("")
+	_ xmlstream.Marshaler = chatstates.State("")
+	_ xmlstream.WriterTo  = chatstates.State("")
+	_ xml.Unmarshaler     = (*chatstates.State)(nil)
+)
+
+func state(s chatstates.State) *chatstates.State {
+	return &s
+}
+
+var encodingTestCases = []xmpptest.EncodingTestCase{
+	0: {
+		Value: state(chatstates.Active),
+		XML:   `<active xmlns="http://jabber.org/protocol/chatstates"></active>`,
+	},
+	1: {
+		Value: state(chatstates.Composing),
+		XML:   `<composing xmlns="http://jabber.org/protocol/chatstates"></composing>`,
+	},
+	2: {
+		Value: state(chatstates.Paused),
+		XML:   `<paused xmlns="http://jabber.org/protocol/chatstates"></paused>`,
+	},
+	3: {
+		Value: state(chatstates.Inactive),
+		XML:   `<inactive xmlns="http://jabber.org/protocol/chatstates"></inactive>`,
+	},
+	4: {
+		Value: state(chatstates.Gone),
+		XML:   `<gone xmlns="http://jabber.org/protocol/chatstates"></gone>`,
+	},
+	5: {
+		Value:       state("typing"),
+		XML:         ``,
+		NoUnmarshal: true,
+	},
+	6: {
+		Value:     state(""),
+		XML:       `<active xmlns="urn:example"></active>`,
+		NoMarshal: true,
+	},
+}
+
+func TestEncode(t *testing.T) {
+	xmpptest.RunEncodingTests(t, encodingTestCases)
+}
+
+type stateEvent struct {
+	j     jid.JID
+	state chatstates.State
+}
+
+func TestHandler(t *testing.T) {
+	events := make(chan stateEvent, 10)
+	h := &chatstates.Handler{
+		HandleState: func(from jid.JID, state chatstates.State) {
+			events <- stateEvent{j: from, state: state}
+		},
+	}
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(chatstates.Handle(h))),
+	)
+	juliet := jid.MustParse("juliet@example.net/balcony")
+	for _, state := range []chatstates.State{
+		chatstates.Active,
+		chatstates.Composing,
+		chatstates.Composing,
+		chatstates.Paused,
+	} {
+		err := s.Server.Send(context.Background(), stanza.Message{
+			From: juliet,
+			Type: stanza.ChatMessage,
+		}.Wrap(state.TokenReader()))
+		if err != nil {
+			t.Fatalf("error sending %s: %v", state, err)
+		}
+	}
+
+	// The repeated composing state should not be reported.
+	for i, want := range []chatstates.State{chatstates.Active, chatstates.Composing, chatstates.Paused} {
+		e := <-events
+		if !e.j.Equal(juliet) || e.state != want {
+			t.Errorf("wrong event %d: want=%s from %s, got=%s from %s", i, want, juliet, e.state, e.j)
+		}
+	}
+	if state := h.State(juliet); state != chatstates.Paused {
+		t.Errorf("wrong state: want=%s, got=%s", chatstates.Paused, state)
+	}
+	if !h.Supported(juliet.Bare()) {
+		t.Errorf("expected %s to be supported", juliet.Bare())
+	}
+	if romeo := jid.MustParse("romeo@example.net"); h.Supported(romeo) {
+		t.Errorf("did not expect %s to be supported", romeo)
+	}
+}
+
+func TestSender(t *testing.T) {
+	supported := make(chan stateEvent, 1)
+	h := &chatstates.Handler{
+		HandleState: func(from jid.JID, state chatstates.State) {
+			supported <- stateEvent{j: from, state: state}
+		},
+	}
+	sent := make(chan stateEvent, 10)
+	var opts []mux.Option
+	for _, state := range []chatstates.State{
+		chatstates.Active,
+		chatstates.Composing,
+		chatstates.Paused,
+		chatstates.Inactive,
+		chatstates.Gone,
+	} {
+		state := state
+		opts = append(opts, mux.MessageFunc(stanza.ChatMessage, xml.Name{Space: chatstates.NS, Local: string(state)}, func(msg stanza.Message, _ xmlstream.TokenReadEncoder) error {
+			sent <- stateEvent{j: msg.To, state: state}
+			return nil
+		}))
+	}
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(chatstates.Handle(h))),
+		xmpptest.ServerHandler(mux.New(opts...)),
+	)
+	ctx := context.Background()
+
+	// Nothing should be sent to entities that have not shown support.
+	romeo := jid.MustParse("romeo@example.net")
+	unsupported := chatstates.NewSender(s.Client, romeo, h)
+	err := unsupported.Typing(ctx)
+	if err != nil {
+		t.Fatalf("error sending to unsupported entity: %v", err)
+	}
+	err = unsupported.Close(ctx)
+	if err != nil {
+		t.Fatalf("error closing unsupported sender: %v", err)
+	}
+
+	juliet := jid.MustParse("juliet@example.net")
+	err = s.Server.Send(ctx, stanza.Message{
+		From: juliet,
+		Type: stanza.ChatMessage,
+	}.Wrap(chatstates.Active.TokenReader()))
+	if err != nil {
+		t.Fatalf("error sending active state: %v", err)
+	}
+	<-supported
+
+	sender := chatstates.NewSender(s.Client, juliet, h)
+	sender.PausedAfter = 10 * time.Millisecond
+	sender.InactiveAfter = 10 * time.Millisecond
+	sender.GoneAfter = 10 * time.Millisecond
+
+	// Sending a message changes the state to active without a notification.
+	sender.Sent()
+	err = sender.Typing(ctx)
+	if err != nil {
+		t.Fatalf("error typing: %v", err)
+	}
+	err = sender.Typing(ctx)
+	if err != nil {
+		t.Fatalf("error typing again: %v", err)
+	}
+
+	for i, want := range []chatstates.State{
+		chatstates.Composing,
+		chatstates.Paused,
+		chatstates.Inactive,
+		chatstates.Gone,
+	} {
+		select {
+		case e := <-sent:
+			if !e.j.Equal(juliet) || e.state != want {
+				t.Errorf("wrong notification %d: want=%s to %s, got=%s to %s", i, want, juliet, e.state, e.j)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for notification %d", i)
+		}
+	}
+	if state := sender.State(); state != chatstates.Gone {
+		t.Errorf("wrong final state: want=%s, got=%s", chatstates.Gone, state)
+	}
+	select {
+	case e := <-sent:
+		t.Errorf("unexpected notification after gone: %+v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSenderConcurrent(t *testing.T) {
+	supported := make(chan struct{}, 1)
+	h := &chatstates.Handler{
+		HandleState: func(jid.JID, chatstates.State) {
+			supported <- struct{}{}
+		},
+	}
+	const n = 100
+	sent := make(chan chatstates.State, 2*n)
+	var opts []mux.Option
+	for _, state := range []chatstates.State{chatstates.Composing, chatstates.Inactive} {
+		state := state
+		opts = append(opts, mux.MessageFunc(stanza.ChatMessage, xml.Name{Space: chatstates.NS, Local: string(state)}, func(stanza.Message, xmlstream.TokenReadEncoder) error {
+			sent <- state
+			return nil
+		}))
+	}
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(chatstates.Handle(h))),
+		xmpptest.ServerHandler(mux.New(opts...)),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	juliet := jid.MustParse("juliet@example.net")
+	err := s.Server.Send(ctx, stanza.Message{
+		From: juliet,
+		Type: stanza.ChatMessage,
+	}.Wrap(chatstates.Active.TokenReader()))
+	if err != nil {
+		t.Fatalf("error sending active state: %v", err)
+	}
+	<-supported
+
+	sender := chatstates.NewSender(s.Client, juliet, h)
+	errs := make(chan error, 2*n)
+	for i := 0; i < n; i++ {
+		go func() {
+			errs <- sender.Typing(ctx)
+		}()
+		go func() {
+			errs <- sender.Blur(ctx)
+		}()
+	}
+	for i := 0; i < 2*n; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("error changing state: %v", err)
+		}
+	}
+
+	// The last notification must always match the final state.
+	var last chatstates.State
+	for {
+		select {
+		case last = <-sent:
+			continue
+		case <-time.After(100 * time.Millisecond):
+		}
+		break
+	}
+	if state := sender.State(); last != state {
+		t.Errorf("last notification does not match the state: want=%s, got=%s", state, last)
+	}
+}
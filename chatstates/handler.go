@@ -0,0 +1,105 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package chatstates
+
+import (
+	"encoding/xml"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// Handle returns an option that registers a Handler for chat states.
+func Handle(h *Handler) mux.Option {
+	return func(m *mux.ServeMux) {
+		for _, state := range []State{Active, Composing, Paused, Inactive, Gone} {
+			name := xml.Name{Space: NS, Local: string(state)}
+			mux.Message(stanza.ChatMessage, name, h)(m)
+			mux.Message(stanza.GroupChatMessage, name, h)(m)
+		}
+	}
+}
+
+// Handler keeps track of the chat states of remote entities and reports when
+// they change.
+// The zero value is ready to use.
+type Handler struct {
+	// HandleState is called when the chat state of an entity changes.
+	// Repeated notifications of the same state are not reported.
+	HandleState func(from jid.JID, state State)
+
+	m         sync.Mutex
+	states    map[string]State
+	supported map[string]struct{}
+}
+
+// HandleMessage satisfies mux.MessageHandler.
+// it is used by the multiplexer and normally does not need to be called by the
+// user.
+func (h *Handler) HandleMessage(msg stanza.Message, r xmlstream.TokenReadEncoder) error {
+	// Pop the start message token.
+	_, err := r.Token()
+	if err != nil {
+		return err
+	}
+
+	var state State
+	iter := xmlstream.NewIter(r)
+	/* #nosec */
+	defer iter.Close()
+	for iter.Next() {
+		start, _ := iter.Current()
+		if start == nil {
+			continue
+		}
+		if s := State(start.Name.Local); start.Name.Space == NS && s.valid() {
+			state = s
+			break
+		}
+	}
+	err = iter.Err()
+	if err != nil {
+		return err
+	}
+	if state == "" {
+		return nil
+	}
+
+	key := msg.From.String()
+	h.m.Lock()
+	if h.states == nil {
+		h.states = make(map[string]State)
+		h.supported = make(map[string]struct{})
+	}
+	h.supported[msg.From.Bare().String()] = struct{}{}
+	changed := h.states[key] != state
+	h.states[key] = state
+	h.m.Unlock()
+
+	if changed && h.HandleState != nil {
+		h.HandleState(msg.From, state)
+	}
+	return nil
+}
+
+// State returns the last chat state received from j, or the zero value if no
+// chat state has been received.
+func (h *Handler) State(j jid.JID) State {
+	h.m.Lock()
+	defer h.m.Unlock()
+	return h.states[j.String()]
+}
+
+// Supported reports whether the account of j has sent us any chat state
+// notifications, indicating that it supports them.
+func (h *Handler) Supported(j jid.JID) bool {
+	h.m.Lock()
+	defer h.m.Unlock()
+	_, ok := h.supported[j.Bare().String()]
+	return ok
+}
@@ -0,0 +1,196 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package chatstates
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// Default durations after which a Sender changes state if no other events
+// occur, as suggested by XEP-0085.
+const (
+	DefaultPausedAfter   = 30 * time.Second
+	DefaultInactiveAfter = 2 * time.Minute
+	DefaultGoneAfter     = 10 * time.Minute
+)
+
+// Sender manages our chat state in a conversation with a single entity.
+//
+// The state is driven by events from the user interface (see the Typing,
+// Focus, Blur, Sent, and Close methods) and by timers:
+// composing becomes paused, paused and active become inactive, and inactive
+// becomes gone if no other events occur in the meantime.
+// Each time the state changes a standalone chat state notification is sent,
+// but only if the entity has shown support for chat states by sending us one
+// of its own.
+// Notifications triggered by timers are abandoned if they cannot be sent
+// within 30 seconds and errors sending them are ignored.
+type Sender struct {
+	// The durations after which the state changes automatically.
+	// They are set to the defaults by NewSender and must not be modified after
+	// the first event is reported.
+	PausedAfter   time.Duration
+	InactiveAfter time.Duration
+	GoneAfter     time.Duration
+
+	session *xmpp.Session
+	to      jid.JID
+	handler *Handler
+
+	m     sync.Mutex
+	state State
+	timer *time.Timer
+	gen   uint64
+
+	// sendM serializes notifications so that they cannot be reordered on their
+	// way to the session.
+	sendM sync.Mutex
+}
+
+// NewSender returns a Sender for the conversation with to.
+// The handler is used to determine whether the entity supports chat states and
+// should be the one registered with the multiplexer that handles incoming
+// messages on s.
+func NewSender(s *xmpp.Session, to jid.JID, h *Handler) *Sender {
+	return &Sender{
+		PausedAfter:   DefaultPausedAfter,
+		InactiveAfter: DefaultInactiveAfter,
+		GoneAfter:     DefaultGoneAfter,
+		session:       s,
+		to:            to,
+		handler:       h,
+	}
+}
+
+// State returns our current chat state.
+func (s *Sender) State() State {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.state
+}
+
+// Typing is called when the user interacts with the message input, for
+// example by pressing a key.
+// It changes the state to composing.
+func (s *Sender) Typing(ctx context.Context) error {
+	return s.set(ctx, Composing, true)
+}
+
+// Focus is called when the user starts paying attention to the conversation.
+// It changes the state to active.
+func (s *Sender) Focus(ctx context.Context) error {
+	return s.set(ctx, Active, true)
+}
+
+// Blur is called when the user stops paying attention to the conversation.
+// It changes the state to inactive.
+func (s *Sender) Blur(ctx context.Context) error {
+	return s.set(ctx, Inactive, true)
+}
+
+// Sent is called when the user sends a message in the conversation.
+// It changes the state to active without sending a notification.
+// Instead the active state should be included in the message itself, which is
+// also how support for chat states is discovered.
+func (s *Sender) Sent() {
+	/* #nosec */
+	s.set(context.Background(), Active, false)
+}
+
+// Close is called when the user ends the conversation, for example by closing
+// the chat window.
+// It changes the state to gone and stops any timers.
+func (s *Sender) Close(ctx context.Context) error {
+	return s.set(ctx, Gone, true)
+}
+
+// next returns the state that follows state if no events occur and how long
+// to wait before changing to it.
+func (s *Sender) next(state State) (State, time.Duration) {
+	switch state {
+	case Composing:
+		return Paused, s.PausedAfter
+	case Paused, Active:
+		return Inactive, s.InactiveAfter
+	case Inactive:
+		return Gone, s.GoneAfter
+	}
+	return "", 0
+}
+
+// timerSendTimeout bounds how long notifications triggered by timers may take
+// to send.
+const timerSendTimeout = 30 * time.Second
+
+func (s *Sender) set(ctx context.Context, state State, notify bool) error {
+	s.m.Lock()
+	send := s.transition(state, notify)
+	s.m.Unlock()
+	if !send {
+		return nil
+	}
+	return s.notify(ctx, state)
+}
+
+// transition changes the state and resets the timer and reports whether a
+// notification should be sent.
+// It must be called with the lock held.
+func (s *Sender) transition(state State, notify bool) bool {
+	// Stopping the timer may race with it firing, so invalidate any pending
+	// callbacks as well.
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.gen++
+	if next, after := s.next(state); next != "" && after > 0 {
+		gen := s.gen
+		s.timer = time.AfterFunc(after, func() {
+			s.m.Lock()
+			if s.gen != gen {
+				s.m.Unlock()
+				return
+			}
+			send := s.transition(next, true)
+			s.m.Unlock()
+			if !send {
+				return
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), timerSendTimeout)
+			defer cancel()
+			/* #nosec */
+			s.notify(ctx, next)
+		})
+	}
+
+	changed := s.state != state
+	s.state = state
+	return changed && notify && s.handler.Supported(s.to)
+}
+
+// notify sends a standalone chat state notification.
+// If the state has changed again since the notification was triggered nothing
+// is sent because the notification would be stale.
+// It must not be called with the lock held.
+func (s *Sender) notify(ctx context.Context, state State) error {
+	s.sendM.Lock()
+	defer s.sendM.Unlock()
+	s.m.Lock()
+	current := s.state
+	s.m.Unlock()
+	if current != state {
+		return nil
+	}
+	return s.session.Send(ctx, stanza.Message{
+		To:   s.to,
+		Type: stanza.ChatMessage,
+	}.Wrap(state.TokenReader()))
+}
@@ -0,0 +1,178 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package correction implements editing and deleting messages.
+//
+// Messages are edited using XEP-0308: Last Message Correction and deleted
+// using XEP-0424: Message Retraction.
+// Retractions include a fallback body so that clients that do not support
+// retractions can show something meaningful to the user.
+//
+// Only the original sender of a message may correct or retract it.
+// To enforce this the Handler looks up the original message before reporting
+// any corrections or retractions and drops them if it cannot.
+// In group chats, where anyone can choose the nickname of a previous occupant,
+// the occupant IDs described in XEP-0421: Anonymous unique occupant
+// identifiers for MUCs are compared instead if the channel supports them.
+package correction // import "mellium.im/xmpp/correction"
+
+import (
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/fallback"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// Various namespaces used by this package, provided as a convenience.
+const (
+	NSCorrect = `urn:xmpp:message-correct:0`
+	NSRetract = `urn:xmpp:message-retract:1`
+)
+
+// RetractFallback is the body included in retractions for clients that do not
+// support them.
+const RetractFallback = "This person attempted to retract a previous message, but your client does not support it."
+
+// Replace indicates that a message replaces an earlier message with the
+// provided ID.
+type Replace struct {
+	XMLName xml.Name `xml:"urn:xmpp:message-correct:0 replace"`
+	ID      string   `xml:"id,attr"`
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (r Replace) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NSCorrect, Local: "replace"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: r.ID}},
+	})
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (r Replace) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, r.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (r Replace) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	_, err := r.WriteXML(e)
+	return err
+}
+
+// Retract indicates that the message with the provided ID should be removed.
+type Retract struct {
+	XMLName xml.Name `xml:"urn:xmpp:message-retract:1 retract"`
+	ID      string   `xml:"id,attr"`
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (r Retract) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NSRetract, Local: "retract"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: r.ID}},
+	})
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (r Retract) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, r.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (r Retract) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	_, err := r.WriteXML(e)
+	return err
+}
+
+func body(s string) xml.TokenReader {
+	return xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(s)),
+		xml.StartElement{Name: xml.Name{Local: "body"}},
+	)
+}
+
+// Correction returns a message that replaces the body of the message with the
+// provided ID.
+// The ID should be the origin ID of the original message if it had one, or
+// the ID of the message otherwise.
+func Correction(msg stanza.Message, id, newBody string) xml.TokenReader {
+	return msg.Wrap(xmlstream.MultiReader(
+		body(newBody),
+		Replace{ID: id}.TokenReader(),
+	))
+}
+
+// Retraction returns a message that retracts the message with the provided
+// ID.
+// In group chats the ID must be the stanza ID assigned by the channel.
+// Otherwise it should be the origin ID of the original message if it had one,
+// or the ID of the message.
+func Retraction(msg stanza.Message, id string) xml.TokenReader {
+	return msg.Wrap(xmlstream.MultiReader(
+		Retract{ID: id}.TokenReader(),
+		fallback.Fallback{For: NSRetract}.TokenReader(),
+		body(RetractFallback),
+	))
+}
+
+// Original contains the information about a previously received message that
+// is needed to decide whether it may be corrected or retracted.
+type Original struct {
+	// ID is the origin ID of the message if it had one, or the ID of the message
+	// otherwise.
+	ID   string
+	From jid.JID
+	// OccupantID is the occupant ID added by the channel if the message was sent
+	// in a group chat that supports occupant IDs.
+	OccupantID string
+	// StanzaID is the stanza ID assigned by the channel if the message was sent
+	// in a group chat.
+	// Retractions in group chats reference this ID instead of ID.
+	StanzaID string
+}
+
+// NewOriginal returns the information needed to validate corrections and
+// retractions of a received message.
+// If the message contained an origin ID it is used instead of the message ID.
+// For group chat messages the stanza ID assigned by the channel, if any, is
+// picked from stanzaIDs.
+func NewOriginal(msg stanza.Message, originID stanza.OriginID, stanzaIDs []stanza.ID, occupantID string) Original {
+	id := originID.ID
+	if id == "" {
+		id = msg.ID
+	}
+	o := Original{
+		ID:         id,
+		From:       msg.From,
+		OccupantID: occupantID,
+	}
+	if msg.Type == stanza.GroupChatMessage {
+		if sid, ok := stanza.TrustedID(stanzaIDs, msg.From.Bare()); ok {
+			o.StanzaID = sid.ID
+		}
+	}
+	return o
+}
+
+// SentBy reports whether a correction or retraction of type typ sent by from
+// with the provided occupant ID (which may be empty) came from the sender of
+// the original message.
+//
+// In group chats the occupant IDs are compared if the original message had one,
+// otherwise the full JIDs (which contain the nickname) must match.
+// In all other chats the bare JIDs must match so that corrections sent from
+// other clients on the same account are allowed.
+func (o Original) SentBy(typ stanza.MessageType, from jid.JID, occupantID string) bool {
+	if typ == stanza.GroupChatMessage {
+		if o.OccupantID != "" {
+			return o.OccupantID == occupantID
+		}
+		return o.From.Equal(from)
+	}
+	return o.From.Bare().Equal(from.Bare())
+}
@@ -0,0 +1,326 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package correction_test
+
+import (
+	"context"
+	"encoding/xml"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/correction"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+var (
+	_ xml.Marshaler       = correction.Replace{}
+	_ xmlstream.Marshaler = correction.Replace{}
+	_ xmlstream.WriterTo  = correction.Replace{}
+	_ xml.Marshaler       = correction.Retract{}
+	_ xmlstream.Marshaler = correction.Retract{}
+	_ xmlstream.WriterTo  = correction.Retract{}
+)
+
+var encodingTestCases = []xmpptest.EncodingTestCase{
+	0: {
+		Value: &correction.Replace{
+			XMLName: xml.Name{Space: correction.NSCorrect, Local: "replace"},
+			ID:      "bad1",
+		},
+		XML: `<replace xmlns="urn:xmpp:message-correct:0" id="bad1"></replace>`,
+	},
+	1: {
+		Value: &correction.Retract{
+			XMLName: xml.Name{Space: correction.NSRetract, Local: "retract"},
+			ID:      "origin-id-1",
+		},
+		XML: `<retract xmlns="urn:xmpp:message-retract:1" id="origin-id-1"></retract>`,
+	},
+}
+
+func TestEncode(t *testing.T) {
+	xmpptest.RunEncodingTests(t, encodingTestCases)
+}
+
+func encode(t *testing.T, r xml.TokenReader) string {
+	t.Helper()
+	var buf strings.Builder
+	e := xml.NewEncoder(&buf)
+	_, err := xmlstream.Copy(e, r)
+	if err != nil {
+		t.Fatalf("error encoding: %v", err)
+	}
+	err = e.Flush()
+	if err != nil {
+		t.Fatalf("error flushing: %v", err)
+	}
+	return buf.String()
+}
+
+func TestBuild(t *testing.T) {
+	msg := stanza.Message{
+		To:   jid.MustParse("juliet@capulet.net/balcony"),
+		Type: stanza.ChatMessage,
+	}
+	const wantCorrection = `<message type="chat" to="juliet@capulet.net/balcony"><body>But soft, what light through yonder window breaks?</body><replace xmlns="urn:xmpp:message-correct:0" id="bad1"></replace></message>`
+	if out := encode(t, correction.Correction(msg, "bad1", "But soft, what light through yonder window breaks?")); out != wantCorrection {
+		t.Errorf("wrong correction:\nwant=%s,\n got=%s", wantCorrection, out)
+	}
+	const wantRetraction = `<message type="chat" to="juliet@capulet.net/balcony"><retract xmlns="urn:xmpp:message-retract:1" id="origin-id-1"></retract><fallback xmlns="urn:xmpp:fallback:0" for="urn:xmpp:message-retract:1"></fallback><body>` + correction.RetractFallback + `</body></message>`
+	if out := encode(t, correction.Retraction(msg, "origin-id-1")); out != wantRetraction {
+		t.Errorf("wrong retraction:\nwant=%s,\n got=%s", wantRetraction, out)
+	}
+}
+
+func TestNewOriginal(t *testing.T) {
+	msg := stanza.Message{ID: "id1", From: jid.MustParse("romeo@montague.net/orchard")}
+	if o := correction.NewOriginal(msg, stanza.OriginID{}, nil, ""); o.ID != "id1" {
+		t.Errorf("wrong ID without origin ID: want=id1, got=%s", o.ID)
+	}
+	if o := correction.NewOriginal(msg, stanza.OriginID{ID: "origin1"}, nil, ""); o.ID != "origin1" {
+		t.Errorf("wrong ID with origin ID: want=origin1, got=%s", o.ID)
+	}
+
+	room := jid.MustParse("room@muc.example.net")
+	ids := []stanza.ID{
+		{ID: "spoofed", By: jid.MustParse("romeo@montague.net")},
+		{ID: "sid1", By: room},
+	}
+	msg = stanza.Message{ID: "id1", From: jid.MustParse("room@muc.example.net/romeo"), Type: stanza.GroupChatMessage}
+	if o := correction.NewOriginal(msg, stanza.OriginID{}, ids, ""); o.StanzaID != "sid1" {
+		t.Errorf("wrong stanza ID in group chat: want=sid1, got=%s", o.StanzaID)
+	}
+	msg.Type = stanza.ChatMessage
+	if o := correction.NewOriginal(msg, stanza.OriginID{}, ids, ""); o.StanzaID != "" {
+		t.Errorf("unexpected stanza ID outside of group chat: %s", o.StanzaID)
+	}
+}
+
+var sentByTestCases = [...]struct {
+	orig       correction.Original
+	typ        stanza.MessageType
+	from       string
+	occupantID string
+	sentBy     bool
+}{
+	0: {
+		orig:   correction.Original{From: jid.MustParse("romeo@montague.net/orchard")},
+		typ:    stanza.ChatMessage,
+		from:   "romeo@montague.net/garden",
+		sentBy: true,
+	},
+	1: {
+		orig: correction.Original{From: jid.MustParse("romeo@montague.net/orchard")},
+		typ:  stanza.ChatMessage,
+		from: "tybalt@capulet.net/orchard",
+	},
+	2: {
+		orig:   correction.Original{From: jid.MustParse("room@muc.example.net/romeo")},
+		typ:    stanza.GroupChatMessage,
+		from:   "room@muc.example.net/romeo",
+		sentBy: true,
+	},
+	3: {
+		orig: correction.Original{From: jid.MustParse("room@muc.example.net/romeo")},
+		typ:  stanza.GroupChatMessage,
+		from: "room@muc.example.net/tybalt",
+	},
+	4: {
+		// A different occupant has taken the nickname.
+		orig:       correction.Original{From: jid.MustParse("room@muc.example.net/romeo"), OccupantID: "a"},
+		typ:        stanza.GroupChatMessage,
+		from:       "room@muc.example.net/romeo",
+		occupantID: "b",
+	},
+	5: {
+		// The same occupant has changed their nickname.
+		orig:       correction.Original{From: jid.MustParse("room@muc.example.net/romeo"), OccupantID: "a"},
+		typ:        stanza.GroupChatMessage,
+		from:       "room@muc.example.net/romeo2",
+		occupantID: "a",
+		sentBy:     true,
+	},
+	6: {
+		orig: correction.Original{From: jid.MustParse("room@muc.example.net/romeo"), OccupantID: "a"},
+		typ:  stanza.GroupChatMessage,
+		from: "room@muc.example.net/romeo",
+	},
+}
+
+func TestSentBy(t *testing.T) {
+	for i, tc := range sentByTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			sentBy := tc.orig.SentBy(tc.typ, jid.MustParse(tc.from), tc.occupantID)
+			if sentBy != tc.sentBy {
+				t.Errorf("wrong result: want=%t, got=%t", tc.sentBy, sentBy)
+			}
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	room := jid.MustParse("room@muc.example.net")
+	romeo := jid.MustParse("room@muc.example.net/romeo")
+	tybalt := jid.MustParse("room@muc.example.net/tybalt")
+	originals := map[string]correction.Original{
+		"1": {ID: "1", From: romeo, OccupantID: "romeo-id"},
+		"2": {ID: "2", From: romeo, OccupantID: "romeo-id", StanzaID: "sid2"},
+	}
+	originals["sid2"] = originals["2"]
+
+	events := make(chan interface{}, 10)
+	h := &correction.Handler{
+		Lookup: func(conv jid.JID, id string) (correction.Original, bool) {
+			if !conv.Equal(room) {
+				return correction.Original{}, false
+			}
+			o, ok := originals[id]
+			return o, ok
+		},
+		HandleCorrection: func(c correction.Corrected) {
+			events <- c
+		},
+		HandleRetraction: func(r correction.Retracted) {
+			events <- r
+		},
+	}
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(correction.Handle(h))),
+	)
+
+	occupantID := func(id string) xml.TokenReader {
+		return xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Space: "urn:xmpp:occupant-id:0", Local: "occupant-id"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: id}},
+		})
+	}
+	msg := func(from jid.JID) stanza.Message {
+		return stanza.Message{From: from, Type: stanza.GroupChatMessage}
+	}
+	ctx := context.Background()
+	for i, r := range []xml.TokenReader{
+		// Sent by a different occupant and should be dropped.
+		msg(tybalt).Wrap(xmlstream.MultiReader(
+			correction.Replace{ID: "1"}.TokenReader(),
+			occupantID("tybalt-id"),
+		)),
+		// Sent by a different occupant using the same nickname and should be
+		// dropped.
+		msg(romeo).Wrap(xmlstream.MultiReader(
+			correction.Retract{ID: "1"}.TokenReader(),
+			occupantID("tybalt-id"),
+		)),
+		// Unknown original message and should be dropped.
+		msg(romeo).Wrap(xmlstream.MultiReader(
+			correction.Retract{ID: "3"}.TokenReader(),
+			occupantID("romeo-id"),
+		)),
+		msg(romeo).Wrap(xmlstream.MultiReader(
+			xmlstream.Wrap(xmlstream.Token(xml.CharData("fixed")), xml.StartElement{Name: xml.Name{Local: "body"}}),
+			correction.Replace{ID: "1"}.TokenReader(),
+			occupantID("romeo-id"),
+		)),
+		// References the senders ID instead of the one assigned by the channel
+		// and should be dropped.
+		msg(romeo).Wrap(xmlstream.MultiReader(
+			correction.Retract{ID: "2"}.TokenReader(),
+			occupantID("romeo-id"),
+		)),
+		msg(romeo).Wrap(xmlstream.MultiReader(
+			correction.Retract{ID: "sid2"}.TokenReader(),
+			occupantID("romeo-id"),
+		)),
+	} {
+		err := s.Server.Send(ctx, r)
+		if err != nil {
+			t.Fatalf("error sending message %d: %v", i, err)
+		}
+	}
+
+	want := []interface{}{
+		correction.Corrected{
+			Message:    stanza.Message{XMLName: xml.Name{Space: "jabber:client", Local: "message"}, From: romeo, Type: stanza.GroupChatMessage},
+			ID:         "1",
+			Body:       "fixed",
+			OccupantID: "romeo-id",
+		},
+		correction.Retracted{
+			Message:    stanza.Message{XMLName: xml.Name{Space: "jabber:client", Local: "message"}, From: romeo, Type: stanza.GroupChatMessage},
+			ID:         "sid2",
+			OccupantID: "romeo-id",
+		},
+	}
+	for i, w := range want {
+		e := <-events
+		// IDs are assigned randomly when sending.
+		switch ev := e.(type) {
+		case correction.Corrected:
+			ev.Message.ID = ""
+			ev.Message.To = jid.JID{}
+			e = ev
+		case correction.Retracted:
+			ev.Message.ID = ""
+			ev.Message.To = jid.JID{}
+			e = ev
+		}
+		if !reflect.DeepEqual(e, w) {
+			t.Errorf("wrong event %d:\nwant=%+v,\n got=%+v", i, w, e)
+		}
+	}
+}
+
+func TestHandlerNoLookup(t *testing.T) {
+	events := make(chan interface{}, 10)
+	h := &correction.Handler{
+		HandleCorrection: func(c correction.Corrected) {
+			events <- c
+		},
+		HandleRetraction: func(r correction.Retracted) {
+			events <- r
+		},
+	}
+	// Messages are handled in order, so a message handled after the others
+	// lets us know when they have all been handled.
+	done := xml.Name{Space: "urn:example", Local: "done"}
+	handled := make(chan struct{})
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(
+			correction.Handle(h),
+			mux.MessageFunc(stanza.HeadlineMessage, done, func(stanza.Message, xmlstream.TokenReadEncoder) error {
+				close(handled)
+				return nil
+			}),
+		)),
+	)
+	ctx := context.Background()
+	romeo := jid.MustParse("romeo@montague.net/orchard")
+	for i, r := range []xml.TokenReader{
+		correction.Correction(stanza.Message{From: romeo, Type: stanza.ChatMessage}, "1", "fixed"),
+		correction.Retraction(stanza.Message{From: romeo, Type: stanza.ChatMessage}, "1"),
+		stanza.Message{From: romeo, Type: stanza.HeadlineMessage}.Wrap(xmlstream.Wrap(nil, xml.StartElement{Name: done})),
+	} {
+		err := s.Server.Send(ctx, r)
+		if err != nil {
+			t.Fatalf("error sending message %d: %v", i, err)
+		}
+	}
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for messages to be handled")
+	}
+	select {
+	case e := <-events:
+		t.Errorf("unvalidated event reported: %+v", e)
+	default:
+	}
+}
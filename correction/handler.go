@@ -0,0 +1,121 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package correction
+
+import (
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// Corrected is a message that replaces the body of an earlier message.
+type Corrected struct {
+	stanza.Message
+
+	// ID is the ID of the message being replaced.
+	ID   string
+	Body string
+	// OccupantID is the occupant ID of the sender in a group chat, if any.
+	OccupantID string
+}
+
+// Retracted is a message that removes an earlier message.
+type Retracted struct {
+	stanza.Message
+
+	// ID is the ID of the message being removed.
+	ID string
+	// OccupantID is the occupant ID of the sender in a group chat, if any.
+	OccupantID string
+}
+
+// Handle returns an option that registers a Handler for corrections and
+// retractions.
+func Handle(h *Handler) mux.Option {
+	return func(m *mux.ServeMux) {
+		replace := xml.Name{Space: NSCorrect, Local: "replace"}
+		retract := xml.Name{Space: NSRetract, Local: "retract"}
+		for _, typ := range []stanza.MessageType{"", stanza.NormalMessage, stanza.ChatMessage, stanza.GroupChatMessage} {
+			mux.Message(typ, replace, h)(m)
+			mux.Message(typ, retract, h)(m)
+		}
+	}
+}
+
+// Handler reports corrections and retractions of messages.
+type Handler struct {
+	// Lookup returns the original message with the provided ID in the
+	// conversation with the bare JID conv (the contact or channel).
+	// In group chats retractions reference the stanza ID assigned by the channel
+	// so Lookup must also find messages by their Original.StanzaID.
+	// If the message is not found, or was not sent by the same sender as the
+	// correction or retraction, the correction or retraction is dropped.
+	//
+	// Lookup is required: if it is nil every correction and retraction is
+	// dropped because there is no way to tell whether they were sent by the
+	// author of the original message.
+	Lookup func(conv jid.JID, id string) (Original, bool)
+
+	HandleCorrection func(Corrected)
+	HandleRetraction func(Retracted)
+}
+
+// HandleMessage satisfies mux.MessageHandler.
+// it is used by the multiplexer and normally does not need to be called by the
+// user.
+func (h *Handler) HandleMessage(p stanza.Message, r xmlstream.TokenReadEncoder) error {
+	msg := struct {
+		stanza.Message
+		Body       string   `xml:"body"`
+		Replace    *Replace `xml:"urn:xmpp:message-correct:0 replace"`
+		Retract    *Retract `xml:"urn:xmpp:message-retract:1 retract"`
+		OccupantID struct {
+			ID string `xml:"id,attr"`
+		} `xml:"urn:xmpp:occupant-id:0 occupant-id"`
+	}{}
+	err := xml.NewTokenDecoder(r).Decode(&msg)
+	if err != nil {
+		return err
+	}
+
+	occupantID := msg.OccupantID.ID
+	valid := func(id string, retract bool) bool {
+		if h.Lookup == nil {
+			return false
+		}
+		orig, ok := h.Lookup(p.From.Bare(), id)
+		if !ok || !orig.SentBy(p.Type, p.From, occupantID) {
+			return false
+		}
+		// Retractions in group chats must reference the ID assigned by the
+		// channel, not one chosen by the sender.
+		return !retract || p.Type != stanza.GroupChatMessage || (orig.StanzaID != "" && orig.StanzaID == id)
+	}
+	switch {
+	case msg.Retract != nil:
+		if h.HandleRetraction == nil || !valid(msg.Retract.ID, true) {
+			return nil
+		}
+		h.HandleRetraction(Retracted{
+			Message:    p,
+			ID:         msg.Retract.ID,
+			OccupantID: occupantID,
+		})
+	case msg.Replace != nil:
+		if h.HandleCorrection == nil || !valid(msg.Replace.ID, false) {
+			return nil
+		}
+		h.HandleCorrection(Corrected{
+			Message:    p,
+			ID:         msg.Replace.ID,
+			Body:       msg.Body,
+			OccupantID: occupantID,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package csi
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/internal/ns"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// DefaultMaxBuffered is the number of stanzas that a Buffer holds back before
+// it is flushed if MaxBuffered is not set.
+const DefaultMaxBuffered = 100
+
+// Handle returns an option that registers a Buffer to receive state changes
+// from the client.
+func Handle(b *Buffer) mux.Option {
+	return func(m *mux.ServeMux) {
+		mux.Handle(xml.Name{Space: NS, Local: "active"}, b)(m)
+		mux.Handle(xml.Name{Space: NS, Local: "inactive"}, b)(m)
+	}
+}
+
+// Buffer wraps a server session and holds back stanzas that are sent to the
+// client while it is inactive.
+//
+// While the client is inactive, presence updates are coalesced so that only the
+// latest available or unavailable presence from each entity is kept, and
+// messages that are not urgent (see Urgent) are held back.
+// IQs, presence subscription requests, and anything that is not a stanza are
+// always sent immediately.
+// When an urgent message is sent or the client becomes active again, all held
+// back stanzas are sent in order before continuing.
+//
+// Buffer is safe for concurrent use by multiple goroutines.
+type Buffer struct {
+	// Urgent reports whether a message must be delivered immediately.
+	// The reader starts with the message start element.
+	// If Urgent is nil, messages with a body are considered urgent.
+	Urgent func(msg stanza.Message, r xml.TokenReader) bool
+
+	// MaxBuffered is the number of stanzas that will be held back before the
+	// buffer is flushed.
+	// If MaxBuffered is zero, DefaultMaxBuffered is used.
+	MaxBuffered int
+
+	s        *xmpp.Session
+	m        sync.Mutex
+	inactive bool
+	queue    []queued
+}
+
+type queued struct {
+	// presence and from are used to coalesce presence updates from the same
+	// entity.
+	presence bool
+	from     string
+	toks     tokenSlice
+}
+
+// NewBuffer returns a buffer that wraps the provided session.
+// Clients start out in the active state.
+func NewBuffer(s *xmpp.Session) *Buffer {
+	return &Buffer{s: s}
+}
+
+// Active reports whether the client is currently active.
+func (b *Buffer) Active() bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return !b.inactive
+}
+
+// SetActive sets the state of the client.
+// If the client becomes active, any held back stanzas are sent.
+func (b *Buffer) SetActive(ctx context.Context, active bool) error {
+	return b.write(ctx, func() []queued {
+		return b.setActive(active)
+	})
+}
+
+// setActive sets the state of the client and returns the stanzas that must be
+// sent as a result.
+func (b *Buffer) setActive(active bool) []queued {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.inactive = !active
+	if active {
+		return b.take()
+	}
+	return nil
+}
+
+// HandleXMPP satisfies xmpp.Handler and updates the state of the client when it
+// sends an active or inactive element.
+// It is used by the multiplexer and normally does not need to be called by the
+// user.
+func (b *Buffer) HandleXMPP(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	if start.Name.Space != NS {
+		return nil
+	}
+	// The session cannot be written to directly while a handler is running, so
+	// any held back stanzas are written to the handlers encoder instead.
+	switch start.Name.Local {
+	case "active":
+		return writeQueue(t, b.setActive(true))
+	case "inactive":
+		b.setActive(false)
+	}
+	return nil
+}
+
+// Flush sends all held back stanzas without changing the state of the client.
+func (b *Buffer) Flush(ctx context.Context) error {
+	return b.write(ctx, func() []queued {
+		b.m.Lock()
+		defer b.m.Unlock()
+		return b.take()
+	})
+}
+
+// take removes all held back stanzas from the queue and returns them.
+// It must be called with the lock held.
+func (b *Buffer) take() []queued {
+	q := b.queue
+	b.queue = nil
+	return q
+}
+
+// write locks the session and then writes the stanzas returned by f.
+//
+// Handlers are called with the session locked and may then lock the buffer, so
+// the session must always be locked first and the buffer must never be locked
+// while writing.
+// Holding the session for the entire write also ensures that held back stanzas
+// are not reordered with stanzas sent by other goroutines.
+func (b *Buffer) write(ctx context.Context, f func() []queued) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	w := b.s.TokenWriter()
+	/* #nosec */
+	defer w.Close()
+	return writeQueue(w, f())
+}
+
+func writeQueue(w xmlstream.TokenWriter, q []queued) error {
+	for _, item := range q {
+		toks := item.toks
+		_, err := xmlstream.Copy(w, &toks)
+		if err != nil {
+			return err
+		}
+	}
+	if f, ok := w.(xmlstream.Flusher); ok && len(q) > 0 {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Send transmits the first element read from the provided token reader, or
+// holds it back if the client is inactive.
+// For more information see the documentation on Buffer.
+func (b *Buffer) Send(ctx context.Context, r xml.TokenReader) error {
+	if b.Active() {
+		return b.s.Send(ctx, r)
+	}
+
+	tok, err := r.Token()
+	if err != nil {
+		return err
+	}
+	start, ok := tok.(xml.StartElement)
+	if !ok || !isStanza(start.Name) {
+		return b.s.Send(ctx, xmlstream.MultiReader(xmlstream.Token(tok), r))
+	}
+	inner, err := xmlstream.ReadAll(xmlstream.Inner(r))
+	if err != nil {
+		return err
+	}
+	toks := append(tokenSlice{start}, inner...)
+	toks = append(toks, start.End())
+
+	q := queued{toks: toks}
+	var urgent bool
+	switch start.Name.Local {
+	case "presence":
+		_, typ := attr.Get(start.Attr, "type")
+		if typ != "" && typ != string(stanza.UnavailablePresence) {
+			urgent = true
+			break
+		}
+		q.presence = true
+		_, q.from = attr.Get(start.Attr, "from")
+	case "message":
+		msg, err := stanza.NewMessage(start)
+		if err != nil {
+			return err
+		}
+		r := toks
+		urgent = b.urgent(msg, &r)
+	default:
+		return b.s.Send(ctx, &toks)
+	}
+
+	return b.write(ctx, func() []queued {
+		return b.hold(q, urgent)
+	})
+}
+
+// hold adds q to the queue and returns the stanzas that must be sent now.
+// If the client has become active since Send was called, or if q is urgent,
+// the queue is flushed and q is sent after it.
+func (b *Buffer) hold(q queued, urgent bool) []queued {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if !b.inactive || urgent {
+		return append(b.take(), q)
+	}
+	if q.presence {
+		for i, old := range b.queue {
+			if old.presence && old.from == q.from {
+				b.queue = append(b.queue[:i], b.queue[i+1:]...)
+				break
+			}
+		}
+	}
+	b.queue = append(b.queue, q)
+	max := b.MaxBuffered
+	if max == 0 {
+		max = DefaultMaxBuffered
+	}
+	if len(b.queue) >= max {
+		return b.take()
+	}
+	return nil
+}
+
+func (b *Buffer) urgent(msg stanza.Message, r xml.TokenReader) bool {
+	if b.Urgent != nil {
+		return b.Urgent(msg, r)
+	}
+
+	// Pop the message start token.
+	_, err := r.Token()
+	if err != nil {
+		return true
+	}
+	iter := xmlstream.NewIter(r)
+	/* #nosec */
+	defer iter.Close()
+	for iter.Next() {
+		start, _ := iter.Current()
+		if start != nil && start.Name.Local == "body" {
+			return true
+		}
+	}
+	return false
+}
+
+// isStanza is like stanza.Is except that it also allows an empty namespace
+// since stanzas that were constructed by the user often do not have one yet.
+func isStanza(name xml.Name) bool {
+	if name.Space == "" {
+		name.Space = ns.Client
+	}
+	return stanza.Is(name)
+}
+
+type tokenSlice []xml.Token
+
+func (t *tokenSlice) Token() (xml.Token, error) {
+	if len(*t) == 0 {
+		return nil, io.EOF
+	}
+	tok := (*t)[0]
+	*t = (*t)[1:]
+	return tok, nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package csi implements XEP-0352: Client State Indication.
+//
+// Client state indication lets clients tell the server whether the user is
+// actively using them, for example when a mobile app is moved to the
+// background.
+// While a client is inactive the server may hold back stanzas that are not
+// important, such as presence updates and chat state notifications, to save
+// bandwidth and battery.
+//
+// Clients should add Feature to the list of stream features so that the
+// servers advertisement is recorded, and then use Active and Inactive to
+// toggle the state.
+// Servers can use Feature to advertise support, and wrap the session in a
+// Buffer to hold back stanzas while the client is inactive.
+package csi // import "mellium.im/xmpp/csi"
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+)
+
+// NS is the namespace used by this package, provided as a convenience.
+const NS = `urn:xmpp:csi:0`
+
+// ErrNotSupported is returned if the client attempts to set its state but the
+// server did not advertise support for client state indication.
+var ErrNotSupported = errors.New("csi: client state indication not supported by the server")
+
+// Feature returns a stream feature that advertises support for client state
+// indication and records whether the server advertised it.
+//
+// Actually attempting to negotiate the feature does nothing as it is meant to
+// be informational only.
+func Feature() xmpp.StreamFeature {
+	return xmpp.StreamFeature{
+		Name:      xml.Name{Space: NS, Local: "csi"},
+		Necessary: xmpp.Authn,
+		List: func(_ context.Context, e xmlstream.TokenWriter, start xml.StartElement) (bool, error) {
+			err := e.EncodeToken(start)
+			if err != nil {
+				return false, err
+			}
+			return false, e.EncodeToken(start.End())
+		},
+		Parse: func(_ context.Context, d *xml.Decoder, _ *xml.StartElement) (bool, interface{}, error) {
+			return false, nil, d.Skip()
+		},
+		Negotiate: func(context.Context, *xmpp.Session, interface{}) (xmpp.SessionState, io.ReadWriter, error) {
+			return 0, nil, nil
+		},
+	}
+}
+
+// Supported reports whether the server advertised support for client state
+// indication on the current stream.
+func Supported(s *xmpp.Session) bool {
+	_, ok := s.Feature(NS)
+	return ok
+}
+
+// Active tells the server that the user is actively using the client.
+// If the server did not advertise support for client state indication,
+// ErrNotSupported is returned.
+func Active(ctx context.Context, s *xmpp.Session) error {
+	return setState(ctx, s, "active")
+}
+
+// Inactive tells the server that the user is not actively using the client.
+// If the server did not advertise support for client state indication,
+// ErrNotSupported is returned.
+func Inactive(ctx context.Context, s *xmpp.Session) error {
+	return setState(ctx, s, "inactive")
+}
+
+func setState(ctx context.Context, s *xmpp.Session, state string) error {
+	if !Supported(s) {
+		return ErrNotSupported
+	}
+	return s.Send(ctx, xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NS, Local: state},
+	}))
+}
@@ -0,0 +1,198 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package csi_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/csi"
+	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+var featureTestCases = [...]xmpptest.FeatureTestCase{
+	0: {
+		State:   xmpp.Authn,
+		Feature: csi.Feature(),
+	},
+}
+
+func TestFeature(t *testing.T) {
+	xmpptest.RunFeatureTests(t, featureTestCases[:])
+}
+
+func TestNotSupported(t *testing.T) {
+	s := xmpptest.NewClientServer()
+	if csi.Supported(s.Client) {
+		t.Errorf("expected CSI to not be supported")
+	}
+	err := csi.Inactive(context.Background(), s.Client)
+	if !errors.Is(err, csi.ErrNotSupported) {
+		t.Errorf("wrong error: want=%v, got=%v", csi.ErrNotSupported, err)
+	}
+	err = csi.Active(context.Background(), s.Client)
+	if !errors.Is(err, csi.ErrNotSupported) {
+		t.Errorf("wrong error: want=%v, got=%v", csi.ErrNotSupported, err)
+	}
+}
+
+func TestBuffer(t *testing.T) {
+	received := make(chan string, 10)
+	var buf *csi.Buffer
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandlerFunc(func(_ xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			_, id := attr.Get(start.Attr, "id")
+			received <- id
+			return nil
+		}),
+		xmpptest.ServerHandlerFunc(func(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			return mux.New(csi.Handle(buf)).HandleXMPP(t, start)
+		}),
+	)
+	buf = csi.NewBuffer(s.Server)
+
+	ctx := context.Background()
+	setState := func(state string) {
+		t.Helper()
+		err := s.Client.Send(ctx, xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Space: csi.NS, Local: state},
+		}))
+		if err != nil {
+			t.Fatalf("error sending %s: %v", state, err)
+		}
+		timeout := time.After(5 * time.Second)
+		for buf.Active() != (state == "active") {
+			select {
+			case <-timeout:
+				t.Fatalf("timed out waiting for client to become %s", state)
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}
+	send := func(r xml.TokenReader) {
+		t.Helper()
+		err := buf.Send(ctx, r)
+		if err != nil {
+			t.Fatalf("error sending stanza: %v", err)
+		}
+	}
+	presence := func(id, from string, typ stanza.PresenceType) xml.TokenReader {
+		return stanza.Presence{ID: id, From: jid.MustParse(from), Type: typ}.Wrap(nil)
+	}
+	body := xmlstream.Wrap(
+		xmlstream.Token(xml.CharData("Wherefore art thou?")),
+		xml.StartElement{Name: xml.Name{Local: "body"}},
+	)
+
+	setState("inactive")
+	send(presence("p1", "juliet@example.net/balcony", ""))
+	send(presence("p2", "nurse@example.net/kitchen", ""))
+	// Replaces p1.
+	send(presence("p3", "juliet@example.net/balcony", stanza.UnavailablePresence))
+	send(stanza.Message{ID: "m1", Type: stanza.ChatMessage}.Wrap(nil))
+	// Urgent, so it flushes the buffer.
+	send(stanza.Message{ID: "m2", Type: stanza.ChatMessage}.Wrap(body))
+	send(presence("p4", "juliet@example.net/balcony", ""))
+	// Subscription requests are always sent immediately.
+	send(presence("p5", "romeo@example.net", stanza.SubscribePresence))
+	send(presence("p6", "nurse@example.net/kitchen", ""))
+	setState("active")
+	send(presence("p7", "juliet@example.net/balcony", ""))
+
+	want := []string{"p2", "p3", "m1", "m2", "p4", "p5", "p6", "p7"}
+	var got []string
+	for range want {
+		select {
+		case id := <-received:
+			got = append(got, id)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for stanzas, got %v", got)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong order of stanzas: want=%v, got=%v", want, got)
+	}
+}
+
+func TestBufferConcurrentActive(t *testing.T) {
+	const n = 500
+	received := make(chan string, n)
+	var buf *csi.Buffer
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandlerFunc(func(_ xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			_, id := attr.Get(start.Attr, "id")
+			received <- id
+			return nil
+		}),
+		xmpptest.ServerHandlerFunc(func(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			return mux.New(csi.Handle(buf)).HandleXMPP(t, start)
+		}),
+	)
+	buf = csi.NewBuffer(s.Server)
+	buf.MaxBuffered = 2
+
+	ctx := context.Background()
+	err := buf.SetActive(ctx, false)
+	if err != nil {
+		t.Fatalf("error setting state: %v", err)
+	}
+
+	// Deliver stanzas to the buffer while the client becomes active.
+	errs := make(chan error, 2)
+	go func() {
+		for i := 0; i < n; i++ {
+			err := buf.Send(ctx, stanza.Presence{
+				ID:   strconv.Itoa(i),
+				From: jid.MustParse("juliet@example.net/" + strconv.Itoa(i)),
+			}.Wrap(nil))
+			if err != nil {
+				errs <- err
+				return
+			}
+		}
+		errs <- nil
+	}()
+	go func() {
+		errs <- s.Client.Send(ctx, xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Space: csi.NS, Local: "active"},
+		}))
+	}()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Fatalf("error sending: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out, possible deadlock")
+		}
+	}
+
+	err = buf.Flush(ctx)
+	if err != nil {
+		t.Fatalf("error flushing: %v", err)
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case id := <-received:
+			if id != strconv.Itoa(i) {
+				t.Fatalf("wrong stanza received: want=%d, got=%s", i, id)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for stanza %d", i)
+		}
+	}
+}
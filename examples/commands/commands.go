@@ -440,6 +440,11 @@ func handleForm(formData form.Data, actions commands.Actions, resp commands.Resp
 			})
 		}
 	})
+	if formData.Len() > 0 {
+		for _, line := range formTable(formData) {
+			box.AddFormItem(newLabel(line))
+		}
+	}
 	var action commands.Actions
 	var submit xml.TokenReader
 	if actions&commands.Prev == commands.Prev {
@@ -485,6 +490,38 @@ func handleForm(formData form.Data, actions commands.Actions, resp commands.Resp
 	return action, submit, nil
 }
 
+// formTable formats the items of a multi-item form as the lines of a table
+// with one column for each reported field.
+func formTable(formData form.Data) []string {
+	var vars []string
+	var b strings.Builder
+	tabWriter := tabwriter.NewWriter(&b, 0, 8, 1, ' ', 0)
+	formData.ForReported(func(field form.FieldData) {
+		vars = append(vars, field.Var)
+		label := field.Label
+		if label == "" {
+			label = field.Var
+		}
+		fmt.Fprintf(tabWriter, "%s\t", label)
+	})
+	fmt.Fprintln(tabWriter)
+	formData.ForRows(func(row form.Row) {
+		for _, v := range vars {
+			val, ok := row.Get(v)
+			if !ok {
+				val = ""
+			}
+			fmt.Fprintf(tabWriter, "%v\t", val)
+		}
+		fmt.Fprintln(tabWriter)
+	})
+	err := tabWriter.Flush()
+	if err != nil {
+		log.Printf("error formatting multi-item form: %v", err)
+	}
+	return strings.Split(strings.TrimRight(b.String(), "\n"), "\n")
+}
+
 func listCommands(cmdIter commands.Iter, theirJID jid.JID, session *xmpp.Session) error {
 	/* #nosec */
 	defer cmdIter.Close()
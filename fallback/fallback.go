@@ -0,0 +1,110 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package fallback implements XEP-0428: Fallback Indication.
+//
+// Fallbacks mark parts of the body of a message that are only meant for
+// clients that do not support some other specification, for example the
+// quotation at the start of a reply.
+// Clients that do support the specification should remove the fallback text
+// before showing the body to the user.
+package fallback // import "mellium.im/xmpp/fallback"
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+
+	"mellium.im/xmlstream"
+)
+
+// NS is the namespace used by this package, provided as a convenience.
+const NS = `urn:xmpp:fallback:0`
+
+// Range is a range of characters in the body of a message.
+// Start is inclusive and End is exclusive, and both are counted in Unicode
+// code points, not bytes.
+type Range struct {
+	Start int `xml:"start,attr"`
+	End   int `xml:"end,attr"`
+}
+
+// Fallback indicates that some or all of the body of a message is only meant
+// for clients that do not support the specification with the namespace For.
+// If Body is empty the entire body is a fallback.
+type Fallback struct {
+	XMLName xml.Name `xml:"urn:xmpp:fallback:0 fallback"`
+	For     string   `xml:"for,attr,omitempty"`
+	Body    []Range  `xml:"body"`
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (f Fallback) TokenReader() xml.TokenReader {
+	start := xml.StartElement{Name: xml.Name{Space: NS, Local: "fallback"}}
+	if f.For != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "for"}, Value: f.For})
+	}
+	var inner []xml.TokenReader
+	for _, r := range f.Body {
+		inner = append(inner, xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: "body"},
+			Attr: []xml.Attr{
+				{Name: xml.Name{Local: "start"}, Value: strconv.Itoa(r.Start)},
+				{Name: xml.Name{Local: "end"}, Value: strconv.Itoa(r.End)},
+			},
+		}))
+	}
+	return xmlstream.Wrap(xmlstream.MultiReader(inner...), start)
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (f Fallback) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, f.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (f Fallback) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	_, err := f.WriteXML(e)
+	return err
+}
+
+// Strip returns the body with any text that is a fallback for the
+// specification with namespace ns removed.
+// Ranges that are out of bounds are clamped to the length of the body.
+func Strip(body string, fallbacks []Fallback, ns string) string {
+	runes := []rune(body)
+	remove := make([]bool, len(runes))
+	for _, f := range fallbacks {
+		if f.For != ns {
+			continue
+		}
+		if len(f.Body) == 0 {
+			return ""
+		}
+		for _, r := range f.Body {
+			for i := clamp(r.Start, len(runes)); i < clamp(r.End, len(runes)); i++ {
+				remove[i] = true
+			}
+		}
+	}
+
+	var buf strings.Builder
+	for i, r := range runes {
+		if !remove[i] {
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
+}
+
+func clamp(i, max int) int {
+	switch {
+	case i < 0:
+		return 0
+	case i > max:
+		return max
+	}
+	return i
+}
@@ -0,0 +1,112 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package fallback_test
+
+import (
+	"encoding/xml"
+	"strconv"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/fallback"
+	"mellium.im/xmpp/internal/xmpptest"
+)
+
+var (
+	_ xml.Marshaler       = fallback.Fallback{}
+	_ xmlstream.Marshaler = fallback.Fallback{}
+	_ xmlstream.WriterTo  = fallback.Fallback{}
+)
+
+var encodingTestCases = []xmpptest.EncodingTestCase{
+	0: {
+		Value: &fallback.Fallback{
+			XMLName: xml.Name{Space: fallback.NS, Local: "fallback"},
+		},
+		XML: `<fallback xmlns="urn:xmpp:fallback:0"></fallback>`,
+	},
+	1: {
+		Value: &fallback.Fallback{
+			XMLName: xml.Name{Space: fallback.NS, Local: "fallback"},
+			For:     "urn:xmpp:reply:0",
+			Body:    []fallback.Range{{Start: 0, End: 33}},
+		},
+		XML: `<fallback xmlns="urn:xmpp:fallback:0" for="urn:xmpp:reply:0"><body start="0" end="33"></body></fallback>`,
+	},
+	2: {
+		Value: &fallback.Fallback{
+			XMLName: xml.Name{Space: fallback.NS, Local: "fallback"},
+			For:     "urn:example",
+			Body:    []fallback.Range{{Start: 0, End: 1}, {Start: 5, End: 8}},
+		},
+		XML: `<fallback xmlns="urn:xmpp:fallback:0" for="urn:example"><body start="0" end="1"></body><body start="5" end="8"></body></fallback>`,
+	},
+}
+
+func TestEncode(t *testing.T) {
+	xmpptest.RunEncodingTests(t, encodingTestCases)
+}
+
+var stripTestCases = [...]struct {
+	body      string
+	fallbacks []fallback.Fallback
+	ns        string
+	out       string
+}{
+	0: {
+		body: "test",
+		out:  "test",
+	},
+	1: {
+		body:      "test",
+		fallbacks: []fallback.Fallback{{For: "urn:example"}},
+		ns:        "urn:example",
+	},
+	2: {
+		body:      "test",
+		fallbacks: []fallback.Fallback{{For: "urn:other"}},
+		ns:        "urn:example",
+		out:       "test",
+	},
+	3: {
+		body: "> Anna wrote:\n> We should bake a cake\nGreat idea!",
+		fallbacks: []fallback.Fallback{{
+			For:  "urn:xmpp:reply:0",
+			Body: []fallback.Range{{Start: 0, End: 38}},
+		}},
+		ns:  "urn:xmpp:reply:0",
+		out: "Great idea!",
+	},
+	4: {
+		// Ranges are counted in code points, not bytes.
+		body: "> 🎂\nYes!",
+		fallbacks: []fallback.Fallback{{
+			For:  "urn:xmpp:reply:0",
+			Body: []fallback.Range{{Start: 0, End: 4}},
+		}},
+		ns:  "urn:xmpp:reply:0",
+		out: "Yes!",
+	},
+	5: {
+		body: "abcdef",
+		fallbacks: []fallback.Fallback{{
+			For:  "urn:example",
+			Body: []fallback.Range{{Start: 0, End: 1}, {Start: 4, End: 100}},
+		}},
+		ns:  "urn:example",
+		out: "bcd",
+	},
+}
+
+func TestStrip(t *testing.T) {
+	for i, tc := range stripTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			out := fallback.Strip(tc.body, tc.fallbacks, tc.ns)
+			if out != tc.out {
+				t.Errorf("wrong output: want=%q, got=%q", tc.out, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package form_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"mellium.im/xmpp/form"
+)
+
+func ExampleFetcherFunc_http() {
+	fetchHTTP := form.FetcherFunc(func(ctx context.Context, uri string) (io.ReadCloser, error) {
+		req, err := http.NewRequest(http.MethodGet, uri, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			/* #nosec */
+			resp.Body.Close()
+			return nil, fmt.Errorf("unexpected status fetching %s: %s", uri, resp.Status)
+		}
+		return resp.Body, nil
+	})
+
+	// Fetchers for other schemes, such as bob.Fetcher for cid URIs, can be added
+	// to the map as well.
+	fetcher := form.Schemes{
+		"http":  fetchHTTP,
+		"https": fetchHTTP,
+	}
+	media := form.Media{URI: []form.MediaURI{
+		{Type: "image/png", URI: "https://example.net/captcha.png"},
+	}}
+	_, r, err := media.Fetch(context.TODO(), fetcher)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	/* #nosec */
+	defer r.Close()
+	// Display the image…
+}
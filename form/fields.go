@@ -69,6 +69,14 @@ type FieldData struct {
 	Label    string
 	Desc     string
 	Required bool
+
+	// Validation contains the datatype and validation rules for the field, if
+	// any were provided.
+	Validation *Validation
+
+	// Media contains any images, audio, or other media associated with the
+	// field.
+	Media []Media
 }
 
 type field struct {
@@ -79,17 +87,21 @@ type field struct {
 	value    []string
 	option   []fieldOpt
 	required bool
+	validate *Validation
+	media    []Media
 }
 
 func (f *field) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 	s := struct {
-		Type     FieldType  `xml:"type,attr"`
-		Label    string     `xml:"label,attr"`
-		Var      string     `xml:"var,attr"`
-		Desc     string     `xml:"desc"`
-		Required *string    `xml:"required"`
-		Value    []string   `xml:"value"`
-		Option   []fieldOpt `xml:"option"`
+		Type     FieldType   `xml:"type,attr"`
+		Label    string      `xml:"label,attr"`
+		Var      string      `xml:"var,attr"`
+		Desc     string      `xml:"desc"`
+		Required *string     `xml:"required"`
+		Value    []string    `xml:"value"`
+		Option   []fieldOpt  `xml:"option"`
+		Validate *Validation `xml:"http://jabber.org/protocol/xdata-validate validate"`
+		Media    []Media     `xml:"urn:xmpp:media-element media"`
 	}{}
 
 	err := d.DecodeElement(&s, &start)
@@ -100,6 +112,8 @@ func (f *field) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 	f.required = s.Required != nil
 	f.value = s.Value
 	f.option = s.Option
+	f.validate = s.Validate
+	f.media = s.Media
 	return err
 }
 
@@ -173,6 +187,12 @@ func (f *field) TokenReader() xml.TokenReader {
 			))
 		}
 	}
+	if f.validate != nil {
+		child = append(child, f.validate.TokenReader())
+	}
+	for _, m := range f.media {
+		child = append(child, m.TokenReader())
+	}
 
 	return xmlstream.Wrap(
 		xmlstream.MultiReader(child...),
@@ -249,3 +269,26 @@ func TextPrivate(id string, o ...Option) Field {
 func Text(id string, o ...Option) Field {
 	return newField(TypeText, id, o...)
 }
+
+// itemTokenReader returns the field as it appears in an item of a multi-item
+// form: without any of the information already provided by the reported
+// fields.
+func (f *field) itemTokenReader() xml.TokenReader {
+	var child []xml.TokenReader
+	for _, val := range f.value {
+		child = append(child, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(val)),
+			xml.StartElement{Name: xml.Name{Local: "value"}},
+		))
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(child...),
+		xml.StartElement{
+			Name: xml.Name{Local: "field"},
+			Attr: []xml.Attr{{
+				Name:  xml.Name{Local: "var"},
+				Value: f.varName,
+			}},
+		},
+	)
+}
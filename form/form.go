@@ -55,8 +55,11 @@ type Data struct {
 	instructions string
 	typ          Type
 
-	fields []field
-	values map[string]interface{}
+	fields   []field
+	reported []field
+	items    [][]field
+	pages    []Section
+	values   map[string]interface{}
 }
 
 // Title returns the title of the form.
@@ -79,6 +82,9 @@ func (d *Data) ForFields(f func(FieldData)) {
 			Label:    field.label,
 			Desc:     field.desc,
 			Required: field.required,
+
+			Validation: field.validate,
+			Media:      field.media,
 		})
 	}
 }
@@ -93,6 +99,9 @@ func (d *Data) UnmarshalXML(decoder *xml.Decoder, start xml.StartElement) error
 	}
 
 	d.values = make(map[string]interface{})
+	d.reported = nil
+	d.items = nil
+	d.pages = nil
 
 	for {
 		tok, err := decoder.Token()
@@ -118,6 +127,16 @@ func (d *Data) UnmarshalXML(decoder *xml.Decoder, start xml.StartElement) error
 			return errors.New("unexpected token type")
 		}
 
+		if start.Name.Space == NSLayout && start.Name.Local == "page" {
+			page := Section{}
+			err = decoder.DecodeElement(&page, &start)
+			if err != nil {
+				return err
+			}
+			d.pages = append(d.pages, page)
+			continue
+		}
+
 		switch start.Name.Local {
 		case "title":
 			s := struct {
@@ -153,6 +172,29 @@ func (d *Data) UnmarshalXML(decoder *xml.Decoder, start xml.StartElement) error
 				f.typ = TypeText
 			}
 			d.fields = append(d.fields, f)
+		case "reported":
+			s := struct {
+				Fields []field `xml:"field"`
+			}{}
+			err = decoder.DecodeElement(&s, &start)
+			if err != nil {
+				return err
+			}
+			for i, f := range s.Fields {
+				if f.typ == "" {
+					s.Fields[i].typ = TypeText
+				}
+			}
+			d.reported = s.Fields
+		case "item":
+			s := struct {
+				Fields []field `xml:"field"`
+			}{}
+			err = decoder.DecodeElement(&s, &start)
+			if err != nil {
+				return err
+			}
+			d.items = append(d.items, s.Fields)
 		default:
 			return fmt.Errorf("unexpected element %v", start.Name)
 		}
@@ -185,13 +227,17 @@ func (d *Data) Get(id string) (v interface{}, ok bool) {
 	}
 
 	// We found a field, so use its default.
-	field := d.fields[fieldIDX]
-	switch field.typ {
+	return fieldValue(d.fields[fieldIDX])
+}
+
+// fieldValue returns the typed default value of a field.
+func fieldValue(f field) (v interface{}, ok bool) {
+	switch f.typ {
 	case TypeFixed:
 		// A submission of type fixed has no value.
 		return "", false
 	case TypeBoolean:
-		for _, vv := range field.value {
+		for _, vv := range f.value {
 			if vv == "false" || vv == "0" {
 				return false, true
 			}
@@ -201,12 +247,12 @@ func (d *Data) Get(id string) (v interface{}, ok bool) {
 		}
 		return false, false
 	case TypeText, TypeTextPrivate, TypeHidden, TypeList, "":
-		if len(field.value) == 0 {
+		if len(f.value) == 0 {
 			return "", false
 		}
-		return field.value[0], true
+		return f.value[0], true
 	case TypeJID:
-		for _, vv := range field.value {
+		for _, vv := range f.value {
 			if j, err := jid.Parse(vv); err == nil {
 				return j, true
 			}
@@ -214,7 +260,7 @@ func (d *Data) Get(id string) (v interface{}, ok bool) {
 		return jid.JID{}, false
 	case TypeJIDMulti:
 		var jids []jid.JID
-		for _, vv := range field.value {
+		for _, vv := range f.value {
 			if j, err := jid.Parse(vv); err == nil {
 				jids = append(jids, j)
 			}
@@ -222,16 +268,16 @@ func (d *Data) Get(id string) (v interface{}, ok bool) {
 		return jids, len(jids) > 0
 	case TypeTextMulti:
 		b := &strings.Builder{}
-		for i, vv := range field.value {
+		for i, vv := range f.value {
 			if i > 0 {
 				b.WriteString("\n")
 			}
 			b.WriteString(vv)
 		}
-		return b.String(), len(field.value) > 0
+		return b.String(), len(f.value) > 0
 	case TypeListMulti:
 		var items []string
-		items = append(items, field.value...)
+		items = append(items, f.value...)
 		return items, len(items) > 0
 	}
 	return nil, false
@@ -368,6 +414,56 @@ func (d *Data) Submit() (submission xml.TokenReader, ok bool) {
 	return submissionData.TokenReader(), ok
 }
 
+// SubmitValid is like Submit except that it validates the form first.
+// If validation fails no submission is returned and the error will be a
+// ValidationError listing every violation.
+func (d *Data) SubmitValid() (xml.TokenReader, error) {
+	err := d.Validate()
+	if err != nil {
+		return nil, err
+	}
+	submission, _ := d.Submit()
+	return submission, nil
+}
+
+// valueStrings converts a value as returned by Get into the raw strings used as
+// the value elements of the field.
+// If the value is of an unknown type the fields existing values are returned.
+func valueStrings(f field, v interface{}) []string {
+	switch typed := v.(type) {
+	case []string:
+		return typed
+	case string:
+		if f.typ != TypeTextMulti {
+			return []string{typed}
+		}
+		var lines []string
+		for {
+			idx := strings.IndexAny(typed, "\n\r")
+			if idx == -1 {
+				if len(typed) > 0 {
+					lines = append(lines, typed)
+				}
+				break
+			}
+			lines = append(lines, typed[:idx])
+			typed = typed[idx+1:]
+		}
+		return lines
+	case jid.JID:
+		return []string{typed.String()}
+	case []jid.JID:
+		s := make([]string, 0, len(typed))
+		for _, j := range typed {
+			s = append(s, j.String())
+		}
+		return s
+	case bool:
+		return []string{strconv.FormatBool(typed)}
+	}
+	return f.value
+}
+
 // TokenReader implements xmlstream.Marshaler for Data.
 func (d *Data) TokenReader() xml.TokenReader {
 	var child []xml.TokenReader
@@ -402,6 +498,13 @@ func (d *Data) TokenReader() xml.TokenReader {
 		}
 	}
 
+	// Layout is only meaningful to the form-submitting entity.
+	if d.typ != TypeSubmit {
+		for _, page := range d.pages {
+			child = append(child, page.TokenReader())
+		}
+	}
+
 	for _, f := range d.fields {
 		// If we're type submit, skip unset fields and use the value from Get
 		// instead of the raw field value (get returns defaults even if the field is
@@ -415,41 +518,35 @@ func (d *Data) TokenReader() xml.TokenReader {
 			if !f.required && !isSet {
 				continue
 			}
-			switch typed := vv.(type) {
-			case []string:
-				f.value = typed
-			case string:
-				if f.typ == TypeTextMulti {
-					var lines []string
-					for {
-						idx := strings.IndexAny(typed, "\n\r")
-						if idx == -1 {
-							if len(typed) > 0 {
-								lines = append(lines, typed)
-								break
-							}
-						}
-						lines = append(lines, typed[:idx])
-						typed = typed[idx+1:]
-					}
-					f.value = lines
-				} else {
-					f.value = []string{typed}
-				}
-			case jid.JID:
-				f.value = []string{typed.String()}
-			case []jid.JID:
-				f.value = make([]string, 0, len(typed))
-				for _, j := range typed {
-					f.value = append(f.value, j.String())
-				}
-			case bool:
-				f.value = []string{strconv.FormatBool(typed)}
-			}
+			f.value = valueStrings(f, vv)
 		}
 		child = append(child, f.TokenReader())
 	}
 
+	// Multi-item results are not part of a submission.
+	if d.typ != TypeSubmit {
+		if len(d.reported) > 0 {
+			var reported []xml.TokenReader
+			for _, f := range d.reported {
+				reported = append(reported, f.TokenReader())
+			}
+			child = append(child, xmlstream.Wrap(
+				xmlstream.MultiReader(reported...),
+				xml.StartElement{Name: xml.Name{Local: "reported"}},
+			))
+		}
+		for _, item := range d.items {
+			var fields []xml.TokenReader
+			for _, f := range item {
+				fields = append(fields, f.itemTokenReader())
+			}
+			child = append(child, xmlstream.Wrap(
+				xmlstream.MultiReader(fields...),
+				xml.StartElement{Name: xml.Name{Local: "item"}},
+			))
+		}
+	}
+
 	return xmlstream.Wrap(
 		xmlstream.MultiReader(child...),
 		xml.StartElement{
@@ -0,0 +1,145 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package form
+
+import (
+	"mellium.im/xmpp/jid"
+)
+
+// Reported sets the fields that describe the columns of a multi-item result
+// form.
+// Only the type, var, and label of each field are used.
+func Reported(f ...Field) Field {
+	return func(data *Data) {
+		tmp := &Data{}
+		for _, field := range f {
+			field(tmp)
+		}
+		data.reported = tmp.fields
+	}
+}
+
+// Item adds a row to a multi-item result form.
+// The fields should have the same vars as the reported fields and only their
+// values are used.
+func Item(f ...Field) Field {
+	return func(data *Data) {
+		tmp := &Data{}
+		for _, field := range f {
+			field(tmp)
+		}
+		data.items = append(data.items, tmp.fields)
+	}
+}
+
+// ForReported iterates over the reported fields of a multi-item form and calls
+// a function for each one, passing it information about the field.
+// The reported fields describe the columns of each row returned by ForRows.
+func (d *Data) ForReported(f func(FieldData)) {
+	for _, field := range d.reported {
+		f(FieldData{
+			Type:  field.typ,
+			Var:   field.varName,
+			Label: field.label,
+			Desc:  field.desc,
+		})
+	}
+}
+
+// ForRows iterates over the items of a multi-item form and calls a function for
+// each one.
+func (d *Data) ForRows(f func(Row)) {
+	for _, item := range d.items {
+		f(Row{reported: d.reported, fields: item})
+	}
+}
+
+// Len returns the number of items in a multi-item form.
+func (d *Data) Len() int {
+	return len(d.items)
+}
+
+// Row is a single item in a multi-item form.
+// The values of the row are typed using the reported fields of the form.
+type Row struct {
+	reported []field
+	fields   []field
+}
+
+// Get looks up the value of a field in the row.
+// If the field does not exist or has no value, ok will be false.
+func (r Row) Get(id string) (v interface{}, ok bool) {
+	typ := TypeText
+	for _, f := range r.reported {
+		if f.varName == id {
+			typ = f.typ
+			break
+		}
+	}
+	for _, f := range r.fields {
+		if f.varName == id {
+			f.typ = typ
+			return fieldValue(f)
+		}
+	}
+	return nil, false
+}
+
+// GetJID is like Get except that it asserts that the value is a JID.
+// If the value was not a JID or is not set, ok will be false.
+func (r Row) GetJID(id string) (j jid.JID, ok bool) {
+	v, ok := r.Get(id)
+	if !ok {
+		return j, ok
+	}
+	j, ok = v.(jid.JID)
+	return j, ok
+}
+
+// GetString is like Get except that it asserts that the value is a string.
+// If the value was not a string or is not set, ok will be false.
+func (r Row) GetString(id string) (s string, ok bool) {
+	v, ok := r.Get(id)
+	if !ok {
+		return s, ok
+	}
+	s, ok = v.(string)
+	return s, ok
+}
+
+// GetStrings is like Get except that it asserts that the value is a slice of
+// strings.
+// If the value was not a string slice or is not set, ok will be false.
+func (r Row) GetStrings(id string) (s []string, ok bool) {
+	v, ok := r.Get(id)
+	if !ok {
+		return s, ok
+	}
+	s, ok = v.([]string)
+	return s, ok
+}
+
+// GetBool is like Get except that it asserts that the value is a bool.
+// If the value was not a bool or is not set, ok will be false.
+func (r Row) GetBool(id string) (b, ok bool) {
+	v, ok := r.Get(id)
+	if !ok {
+		return b, ok
+	}
+	b, ok = v.(bool)
+	return b, ok
+}
+
+// GetJIDs is like Get except that it asserts that the value is a slice of
+// JIDs.
+// If the value was not a JID slice or is not set, ok will be false.
+func (r Row) GetJIDs(id string) (j []jid.JID, ok bool) {
+	v, ok := r.Get(id)
+	if !ok {
+		return j, ok
+	}
+	j, ok = v.([]jid.JID)
+	return j, ok
+}
@@ -0,0 +1,90 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package form_test
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/jid"
+)
+
+const itemsForm = `<x xmlns="jabber:x:data" type="result"><title>Search Results</title><reported><field type="text-single" var="name" label="Name"></field><field type="jid-single" var="jid" label="JID"></field><field type="boolean" var="online"></field></reported><item><field var="name"><value>Juliet</value></field><field var="jid"><value>juliet@example.com</value></field><field var="online"><value>1</value></field></item><item><field var="name"><value>Romeo</value></field><field var="jid"><value>romeo@example.net</value></field></item></x>`
+
+func TestItemsMarshal(t *testing.T) {
+	data := form.New(
+		form.Result,
+		form.Title("Search Results"),
+		form.Reported(
+			form.Text("name", form.Label("Name")),
+			form.JID("jid", form.Label("JID")),
+			form.Boolean("online"),
+		),
+		form.Item(
+			form.Text("name", form.Value("Juliet")),
+			form.Text("jid", form.Value("juliet@example.com")),
+			form.Text("online", form.Value("1")),
+		),
+		form.Item(
+			form.Text("name", form.Value("Romeo")),
+			form.Text("jid", form.Value("romeo@example.net")),
+		),
+	)
+	b, err := xml.Marshal(data)
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	if s := string(b); s != itemsForm {
+		t.Errorf("wrong XML:\nwant=%s,\n got=%s", itemsForm, s)
+	}
+}
+
+func TestItemsUnmarshal(t *testing.T) {
+	data := &form.Data{}
+	err := xml.Unmarshal([]byte(itemsForm), data)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+
+	var cols []string
+	data.ForReported(func(f form.FieldData) {
+		cols = append(cols, f.Var)
+	})
+	if len(cols) != 3 || cols[0] != "name" || cols[1] != "jid" || cols[2] != "online" {
+		t.Errorf("wrong reported fields: %v", cols)
+	}
+	if l := data.Len(); l != 2 {
+		t.Fatalf("wrong number of items: want=2, got=%d", l)
+	}
+
+	var rows []form.Row
+	data.ForRows(func(r form.Row) {
+		rows = append(rows, r)
+	})
+	if name, ok := rows[0].GetString("name"); !ok || name != "Juliet" {
+		t.Errorf("wrong name: want=Juliet, got=%q, %t", name, ok)
+	}
+	if j, ok := rows[0].GetJID("jid"); !ok || !j.Equal(jid.MustParse("juliet@example.com")) {
+		t.Errorf("wrong jid: want=juliet@example.com, got=%v, %t", j, ok)
+	}
+	if online, ok := rows[0].GetBool("online"); !ok || !online {
+		t.Errorf("wrong online: want=true, got=%t, %t", online, ok)
+	}
+	if _, ok := rows[1].GetBool("online"); ok {
+		t.Errorf("expected unset value to not be ok")
+	}
+	if _, ok := rows[1].GetString("jid"); ok {
+		t.Errorf("expected JID value to not be a string")
+	}
+
+	b, err := xml.Marshal(data)
+	if err != nil {
+		t.Fatalf("error remarshaling: %v", err)
+	}
+	if s := string(b); s != itemsForm {
+		t.Errorf("wrong XML after remarshal:\nwant=%s,\n got=%s", itemsForm, s)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package form
+
+import (
+	"encoding/xml"
+	"fmt"
+
+	"mellium.im/xmlstream"
+)
+
+// NSLayout is the namespace used by data forms layout.
+const NSLayout = "http://jabber.org/protocol/xdata-layout"
+
+// Section is a page or a section of a page as defined in XEP-0141: Data Forms
+// Layout.
+// Pages are represented as the top level sections of a form.
+type Section struct {
+	Label string
+	Items []LayoutItem
+}
+
+// LayoutItem is a single entry in a page or section.
+// Only one of its fields should be set.
+type LayoutItem struct {
+	// Text is a natural-language note about the page or section.
+	Text string
+
+	// Var references the field with the same var.
+	Var string
+
+	// Reported references the reported fields of a multi-item form.
+	Reported bool
+
+	// Section is a subsection.
+	Section *Section
+}
+
+// TokenReader returns the section as a page.
+// For a subsection, wrap it in a LayoutItem.
+func (s Section) TokenReader() xml.TokenReader {
+	return s.tokenReader("page", NSLayout)
+}
+
+// WriteXML implements xmlstream.WriterTo for Section.
+func (s Section) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, s.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler for Section.
+func (s Section) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := s.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+func (s Section) tokenReader(local, ns string) xml.TokenReader {
+	var attr []xml.Attr
+	if s.Label != "" {
+		attr = append(attr, xml.Attr{Name: xml.Name{Local: "label"}, Value: s.Label})
+	}
+	var child []xml.TokenReader
+	for _, item := range s.Items {
+		child = append(child, item.TokenReader())
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(child...),
+		xml.StartElement{
+			Name: xml.Name{Space: ns, Local: local},
+			Attr: attr,
+		},
+	)
+}
+
+// UnmarshalXML implements xml.Unmarshaler for Section.
+// It can decode both pages and sections.
+func (s *Section) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	*s = Section{}
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "label" {
+			s.Label = attr.Value
+			break
+		}
+	}
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		var child xml.StartElement
+		switch t := tok.(type) {
+		case xml.StartElement:
+			child = t
+		case xml.EndElement:
+			return nil
+		default:
+			continue
+		}
+
+		switch child.Name.Local {
+		case "text":
+			var text string
+			err = d.DecodeElement(&text, &child)
+			if err != nil {
+				return err
+			}
+			s.Items = append(s.Items, LayoutItem{Text: text})
+		case "fieldref":
+			for _, attr := range child.Attr {
+				if attr.Name.Local == "var" {
+					s.Items = append(s.Items, LayoutItem{Var: attr.Value})
+					break
+				}
+			}
+			err = d.Skip()
+			if err != nil {
+				return err
+			}
+		case "reportedref":
+			s.Items = append(s.Items, LayoutItem{Reported: true})
+			err = d.Skip()
+			if err != nil {
+				return err
+			}
+		case "section":
+			sub := &Section{}
+			err = d.DecodeElement(sub, &child)
+			if err != nil {
+				return err
+			}
+			s.Items = append(s.Items, LayoutItem{Section: sub})
+		default:
+			return fmt.Errorf("form: unexpected layout element %v", child.Name)
+		}
+	}
+}
+
+// TokenReader implements xmlstream.Marshaler for LayoutItem.
+func (l LayoutItem) TokenReader() xml.TokenReader {
+	switch {
+	case l.Section != nil:
+		return l.Section.tokenReader("section", "")
+	case l.Reported:
+		return xmlstream.Wrap(
+			nil,
+			xml.StartElement{Name: xml.Name{Local: "reportedref"}},
+		)
+	case l.Var != "":
+		return xmlstream.Wrap(
+			nil,
+			xml.StartElement{
+				Name: xml.Name{Local: "fieldref"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "var"}, Value: l.Var}},
+			},
+		)
+	}
+	return xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(l.Text)),
+		xml.StartElement{Name: xml.Name{Local: "text"}},
+	)
+}
+
+// Layout adds pages to the form.
+func Layout(pages ...Section) Field {
+	return func(data *Data) {
+		data.pages = append(data.pages, pages...)
+	}
+}
+
+// ForPages iterates over the pages of the form, if any, and calls a function
+// for each one.
+func (d *Data) ForPages(f func(Section)) {
+	for _, page := range d.pages {
+		f(page)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package form_test
+
+import (
+	"encoding/xml"
+	"reflect"
+	"testing"
+
+	"mellium.im/xmpp/form"
+)
+
+const layoutForm = `<x xmlns="jabber:x:data" type="form"><title>Wizard</title><page xmlns="http://jabber.org/protocol/xdata-layout" label="Personal Information"><text>This is page one of three.</text><fieldref var="name"></fieldref><section label="Contact"><fieldref var="email"></fieldref></section><reportedref></reportedref></page><field type="text-single" var="name"></field><field type="text-single" var="email"></field></x>`
+
+var layoutPage = form.Section{
+	Label: "Personal Information",
+	Items: []form.LayoutItem{
+		{Text: "This is page one of three."},
+		{Var: "name"},
+		{Section: &form.Section{
+			Label: "Contact",
+			Items: []form.LayoutItem{{Var: "email"}},
+		}},
+		{Reported: true},
+	},
+}
+
+func TestLayout(t *testing.T) {
+	data := form.New(
+		form.Title("Wizard"),
+		form.Layout(layoutPage),
+		form.Text("name"),
+		form.Text("email"),
+	)
+	b, err := xml.Marshal(data)
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	if s := string(b); s != layoutForm {
+		t.Errorf("wrong XML:\nwant=%s,\n got=%s", layoutForm, s)
+	}
+
+	data = &form.Data{}
+	err = xml.Unmarshal([]byte(layoutForm), data)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	var pages []form.Section
+	data.ForPages(func(p form.Section) {
+		pages = append(pages, p)
+	})
+	if len(pages) != 1 {
+		t.Fatalf("wrong number of pages: want=1, got=%d", len(pages))
+	}
+	if !reflect.DeepEqual(pages[0], layoutPage) {
+		t.Errorf("wrong page decoded:\nwant=%+v,\n got=%+v", layoutPage, pages[0])
+	}
+
+	// Layout is not included in submissions.
+	submission, _ := data.Submit()
+	data = &form.Data{}
+	err = xml.NewTokenDecoder(submission).Decode(data)
+	if err != nil {
+		t.Fatalf("error decoding submission: %v", err)
+	}
+	data.ForPages(func(form.Section) {
+		t.Errorf("did not expect pages in submission")
+	})
+}
@@ -0,0 +1,176 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package form
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"mellium.im/xmlstream"
+)
+
+// NSMedia is the namespace used by media elements.
+const NSMedia = "urn:xmpp:media-element"
+
+// ErrNoFetcher is returned by Fetch if no fetcher could handle any of the URIs
+// of a media element.
+var ErrNoFetcher = errors.New("form: no fetcher for media URI")
+
+// MediaURI is a single representation of a media element.
+type MediaURI struct {
+	// Type is the MIME type of the media, for example "image/png".
+	Type string
+	URI  string
+}
+
+// Media is a media element as defined in XEP-0221: Data Forms Media Element.
+// It is used to include images, audio, and other media in a form field, for
+// example as part of a CAPTCHA.
+type Media struct {
+	// Width and Height are the suggested display size of the media.
+	// Zero means no size was provided.
+	Width  uint32
+	Height uint32
+
+	// URI contains alternative representations of the media in order of
+	// preference.
+	URI []MediaURI
+}
+
+// TokenReader implements xmlstream.Marshaler for Media.
+func (m Media) TokenReader() xml.TokenReader {
+	var attr []xml.Attr
+	if m.Height != 0 {
+		attr = append(attr, xml.Attr{
+			Name:  xml.Name{Local: "height"},
+			Value: strconv.FormatUint(uint64(m.Height), 10),
+		})
+	}
+	if m.Width != 0 {
+		attr = append(attr, xml.Attr{
+			Name:  xml.Name{Local: "width"},
+			Value: strconv.FormatUint(uint64(m.Width), 10),
+		})
+	}
+	var child []xml.TokenReader
+	for _, uri := range m.URI {
+		child = append(child, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(uri.URI)),
+			xml.StartElement{
+				Name: xml.Name{Local: "uri"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "type"}, Value: uri.Type}},
+			},
+		))
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(child...),
+		xml.StartElement{
+			Name: xml.Name{Space: NSMedia, Local: "media"},
+			Attr: attr,
+		},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo for Media.
+func (m Media) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, m.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler for Media.
+func (m Media) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := m.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// UnmarshalXML implements xml.Unmarshaler for Media.
+func (m *Media) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	s := struct {
+		Width  uint32 `xml:"width,attr"`
+		Height uint32 `xml:"height,attr"`
+		URI    []struct {
+			Type string `xml:"type,attr"`
+			URI  string `xml:",chardata"`
+		} `xml:"uri"`
+	}{}
+	err := d.DecodeElement(&s, &start)
+	if err != nil {
+		return err
+	}
+	*m = Media{
+		Width:  s.Width,
+		Height: s.Height,
+	}
+	for _, uri := range s.URI {
+		m.URI = append(m.URI, MediaURI{
+			Type: uri.Type,
+			URI:  strings.TrimSpace(uri.URI),
+		})
+	}
+	return nil
+}
+
+// Fetch tries to fetch each URI of the media element in order and returns the
+// first one that succeeds.
+// If f is unable to fetch any of the URIs, the last error is returned.
+func (m Media) Fetch(ctx context.Context, f Fetcher) (MediaURI, io.ReadCloser, error) {
+	err := ErrNoFetcher
+	for _, uri := range m.URI {
+		var r io.ReadCloser
+		r, err = f.Fetch(ctx, uri.URI)
+		if err == nil {
+			return uri, r, nil
+		}
+	}
+	return MediaURI{}, nil, err
+}
+
+// Fetcher retrieves the data referenced by a media URI.
+//
+// Fetchers for cid URIs are provided by the bob package, and http URIs can be
+// fetched by wrapping an HTTP client in a FetcherFunc.
+type Fetcher interface {
+	Fetch(ctx context.Context, uri string) (io.ReadCloser, error)
+}
+
+// FetcherFunc is an adapter to allow the use of ordinary functions as a
+// Fetcher.
+type FetcherFunc func(ctx context.Context, uri string) (io.ReadCloser, error)
+
+// Fetch calls f(ctx, uri).
+func (f FetcherFunc) Fetch(ctx context.Context, uri string) (io.ReadCloser, error) {
+	return f(ctx, uri)
+}
+
+// Schemes is a Fetcher that picks another Fetcher based on the scheme of the
+// URI.
+// If no fetcher is registered for the scheme, ErrNoFetcher is returned.
+type Schemes map[string]Fetcher
+
+// Fetch satisfies the Fetcher interface.
+func (s Schemes) Fetch(ctx context.Context, uri string) (io.ReadCloser, error) {
+	idx := strings.IndexByte(uri, ':')
+	if idx == -1 {
+		return nil, ErrNoFetcher
+	}
+	f, ok := s[strings.ToLower(uri[:idx])]
+	if !ok {
+		return nil, ErrNoFetcher
+	}
+	return f.Fetch(ctx, uri)
+}
+
+// AddMedia adds a media element to the field.
+func AddMedia(m Media) Option {
+	return func(f *field) {
+		f.media = append(f.media, m)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package form_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/form"
+)
+
+var (
+	_ xmlstream.Marshaler = form.Media{}
+	_ xmlstream.WriterTo  = form.Media{}
+	_ xml.Unmarshaler     = (*form.Media)(nil)
+)
+
+const mediaForm = `<x xmlns="jabber:x:data" type="form"><field type="text-single" var="ocr" label="Enter the text you see"><required></required><media xmlns="urn:xmpp:media-element" height="80" width="290"><uri type="image/jpeg">http://www.victim.example/challenges/ocr.jpeg?F3A6292C</uri><uri type="image/jpeg">cid:sha1+f24030b8d91d233bac14777be5ab531ca3b9f102@bob.xmpp.org</uri></media></field></x>`
+
+var captcha = form.Media{
+	Width:  290,
+	Height: 80,
+	URI: []form.MediaURI{
+		{Type: "image/jpeg", URI: "http://www.victim.example/challenges/ocr.jpeg?F3A6292C"},
+		{Type: "image/jpeg", URI: "cid:sha1+f24030b8d91d233bac14777be5ab531ca3b9f102@bob.xmpp.org"},
+	},
+}
+
+func TestMedia(t *testing.T) {
+	data := form.New(
+		form.Text("ocr", form.Label("Enter the text you see"), form.Required, form.AddMedia(captcha)),
+	)
+	b, err := xml.Marshal(data)
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	if s := string(b); s != mediaForm {
+		t.Errorf("wrong XML:\nwant=%s,\n got=%s", mediaForm, s)
+	}
+
+	data = &form.Data{}
+	err = xml.Unmarshal([]byte(mediaForm), data)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	var media []form.Media
+	data.ForFields(func(f form.FieldData) {
+		media = append(media, f.Media...)
+	})
+	if len(media) != 1 || !reflect.DeepEqual(media[0], captcha) {
+		t.Errorf("wrong media decoded:\nwant=%+v,\n got=%+v", captcha, media)
+	}
+}
+
+func TestFetchSchemes(t *testing.T) {
+	fetcher := form.Schemes{
+		"cid": form.FetcherFunc(func(_ context.Context, uri string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(strings.NewReader(uri)), nil
+		}),
+	}
+	uri, r, err := captcha.Fetch(context.Background(), fetcher)
+	if err != nil {
+		t.Fatalf("error fetching media: %v", err)
+	}
+	if uri != captcha.URI[1] {
+		t.Errorf("wrong URI fetched: want=%v, got=%v", captcha.URI[1], uri)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("error reading media: %v", err)
+	}
+	if string(b) != captcha.URI[1].URI {
+		t.Errorf("wrong data: want=%q, got=%q", captcha.URI[1].URI, b)
+	}
+
+	_, _, err = captcha.Fetch(context.Background(), form.Schemes{})
+	if !errors.Is(err, form.ErrNoFetcher) {
+		t.Errorf("wrong error: want=%v, got=%v", form.ErrNoFetcher, err)
+	}
+}
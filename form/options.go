@@ -87,6 +87,13 @@ func ListItem(label, value string) Option {
 	}
 }
 
+// Validate sets the datatype and validation rules for the field.
+func Validate(v Validation) Option {
+	return func(f *field) {
+		f.validate = &v
+	}
+}
+
 func getFieldOpts(f *field, o ...Option) {
 	for _, opt := range o {
 		opt(f)
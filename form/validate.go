@@ -0,0 +1,490 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package form
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"mellium.im/xmlstream"
+)
+
+// NSValidate is the namespace used by data form validation.
+const NSValidate = "http://jabber.org/protocol/xdata-validate"
+
+// Errors that may be wrapped by a FieldError.
+var (
+	ErrRequired  = errors.New("form: required field is not set")
+	ErrDatatype  = errors.New("form: value does not match the fields datatype")
+	ErrOption    = errors.New("form: value is not one of the fields options")
+	ErrRange     = errors.New("form: value is out of range")
+	ErrRegex     = errors.New("form: value does not match the regular expression")
+	ErrListRange = errors.New("form: wrong number of values")
+)
+
+// Method is the validation method used when validating a field.
+// For more information see the constants defined in this package.
+type Method string
+
+const (
+	// MethodBasic indicates that the value should be validated against the
+	// datatype only.
+	// For list fields, the value must also be one of the options.
+	// If a validation has no method, MethodBasic is assumed.
+	MethodBasic Method = "basic"
+
+	// MethodOpen is like MethodBasic except that list fields may contain values
+	// that are not one of the options.
+	MethodOpen Method = "open"
+
+	// MethodRange indicates that the value must fall within the range set by Min
+	// and Max (inclusive) when compared using the datatype.
+	MethodRange Method = "range"
+
+	// MethodRegex indicates that the value must match the regular expression.
+	MethodRegex Method = "regex"
+)
+
+// ListRange limits the number of values that may be submitted for a field
+// that supports multiple values.
+// A Max of zero means there is no upper limit.
+type ListRange struct {
+	Min uint32
+	Max uint32
+}
+
+// Validation describes the datatype and validation rules for a field as defined
+// in XEP-0122: Data Forms Validation.
+type Validation struct {
+	// Datatype is the XML Schema datatype of the field, for example
+	// "xs:integer".
+	// If it is empty "xs:string" is assumed.
+	Datatype string
+
+	// Method is the validation method to use.
+	Method Method
+
+	// Min and Max are the inclusive range used by MethodRange.
+	// Either may be empty to leave that end of the range open.
+	Min string
+	Max string
+
+	// Regex is the regular expression used by MethodRegex.
+	// It must match the entire value.
+	Regex string
+
+	// ListRange limits the number of values for multi-value fields.
+	ListRange *ListRange
+}
+
+// TokenReader implements xmlstream.Marshaler for Validation.
+func (v Validation) TokenReader() xml.TokenReader {
+	var attr []xml.Attr
+	if v.Datatype != "" {
+		attr = append(attr, xml.Attr{Name: xml.Name{Local: "datatype"}, Value: v.Datatype})
+	}
+	var child []xml.TokenReader
+	switch v.Method {
+	case MethodBasic, MethodOpen:
+		child = append(child, xmlstream.Wrap(
+			nil,
+			xml.StartElement{Name: xml.Name{Local: string(v.Method)}},
+		))
+	case MethodRange:
+		var rangeAttr []xml.Attr
+		if v.Min != "" {
+			rangeAttr = append(rangeAttr, xml.Attr{Name: xml.Name{Local: "min"}, Value: v.Min})
+		}
+		if v.Max != "" {
+			rangeAttr = append(rangeAttr, xml.Attr{Name: xml.Name{Local: "max"}, Value: v.Max})
+		}
+		child = append(child, xmlstream.Wrap(
+			nil,
+			xml.StartElement{Name: xml.Name{Local: "range"}, Attr: rangeAttr},
+		))
+	case MethodRegex:
+		child = append(child, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(v.Regex)),
+			xml.StartElement{Name: xml.Name{Local: "regex"}},
+		))
+	}
+	if v.ListRange != nil {
+		var rangeAttr []xml.Attr
+		if v.ListRange.Min != 0 {
+			rangeAttr = append(rangeAttr, xml.Attr{
+				Name:  xml.Name{Local: "min"},
+				Value: strconv.FormatUint(uint64(v.ListRange.Min), 10),
+			})
+		}
+		if v.ListRange.Max != 0 {
+			rangeAttr = append(rangeAttr, xml.Attr{
+				Name:  xml.Name{Local: "max"},
+				Value: strconv.FormatUint(uint64(v.ListRange.Max), 10),
+			})
+		}
+		child = append(child, xmlstream.Wrap(
+			nil,
+			xml.StartElement{Name: xml.Name{Local: "list-range"}, Attr: rangeAttr},
+		))
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(child...),
+		xml.StartElement{
+			Name: xml.Name{Space: NSValidate, Local: "validate"},
+			Attr: attr,
+		},
+	)
+}
+
+// WriteXML implements xmlstream.WriterTo for Validation.
+func (v Validation) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, v.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler for Validation.
+func (v Validation) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := v.WriteXML(e)
+	if err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// UnmarshalXML implements xml.Unmarshaler for Validation.
+func (v *Validation) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	s := struct {
+		Datatype string    `xml:"datatype,attr"`
+		Basic    *struct{} `xml:"basic"`
+		Open     *struct{} `xml:"open"`
+		Range    *struct {
+			Min string `xml:"min,attr"`
+			Max string `xml:"max,attr"`
+		} `xml:"range"`
+		Regex     *string `xml:"regex"`
+		ListRange *struct {
+			Min uint32 `xml:"min,attr"`
+			Max uint32 `xml:"max,attr"`
+		} `xml:"list-range"`
+	}{}
+	err := d.DecodeElement(&s, &start)
+	if err != nil {
+		return err
+	}
+
+	*v = Validation{Datatype: s.Datatype}
+	switch {
+	case s.Basic != nil:
+		v.Method = MethodBasic
+	case s.Open != nil:
+		v.Method = MethodOpen
+	case s.Range != nil:
+		v.Method = MethodRange
+		v.Min = s.Range.Min
+		v.Max = s.Range.Max
+	case s.Regex != nil:
+		v.Method = MethodRegex
+		v.Regex = *s.Regex
+	}
+	if s.ListRange != nil {
+		v.ListRange = &ListRange{
+			Min: s.ListRange.Min,
+			Max: s.ListRange.Max,
+		}
+	}
+	return nil
+}
+
+// FieldError is a validation error for a single field.
+type FieldError struct {
+	Var string
+	Err error
+}
+
+// Error satisfies the error interface.
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%v: %q", e.Err, e.Var)
+}
+
+// Unwrap returns the underlying error.
+func (e FieldError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError is returned when a form fails validation.
+// It contains every violation that was found.
+type ValidationError []FieldError
+
+// Error satisfies the error interface.
+func (e ValidationError) Error() string {
+	s := make([]string, 0, len(e))
+	for _, fe := range e {
+		s = append(s, fe.Error())
+	}
+	return strings.Join(s, "; ")
+}
+
+// Is reports whether any of the field errors matches target.
+func (e ValidationError) Is(target error) bool {
+	for _, fe := range e {
+		if errors.Is(fe, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that all required fields are set and that all values satisfy
+// the fields validation rules.
+// If any violations are found, the returned error is a ValidationError
+// containing all of them.
+func (d *Data) Validate() error {
+	if d == nil {
+		return nil
+	}
+	var errs ValidationError
+	for _, f := range d.fields {
+		if f.typ == TypeFixed {
+			continue
+		}
+		v, isSet := d.Get(f.varName)
+		if !isSet {
+			if f.required {
+				errs = append(errs, FieldError{Var: f.varName, Err: ErrRequired})
+			}
+			continue
+		}
+		if f.validate == nil {
+			continue
+		}
+		for _, err := range f.validate.check(f, valueStrings(f, v)) {
+			errs = append(errs, FieldError{Var: f.varName, Err: err})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// check validates the values of a field and returns all errors encountered.
+func (v *Validation) check(f field, values []string) []error {
+	var errs []error
+	if v.ListRange != nil && isMulti(f.typ) {
+		l := uint64(len(values))
+		if l < uint64(v.ListRange.Min) || (v.ListRange.Max != 0 && l > uint64(v.ListRange.Max)) {
+			errs = append(errs, fmt.Errorf("%w: got %d", ErrListRange, l))
+		}
+	}
+
+	var re *regexp.Regexp
+	if v.Method == MethodRegex {
+		var err error
+		re, err = regexp.Compile("^(?:" + v.Regex + ")$")
+		if err != nil {
+			return append(errs, fmt.Errorf("%w: %v", ErrRegex, err))
+		}
+	}
+	var min, max interface{}
+	if v.Method == MethodRange {
+		var err error
+		if v.Min != "" {
+			min, err = parseDatatype(v.Datatype, v.Min)
+			if err != nil {
+				return append(errs, fmt.Errorf("%w: invalid minimum %q", ErrRange, v.Min))
+			}
+		}
+		if v.Max != "" {
+			max, err = parseDatatype(v.Datatype, v.Max)
+			if err != nil {
+				return append(errs, fmt.Errorf("%w: invalid maximum %q", ErrRange, v.Max))
+			}
+		}
+	}
+
+	for _, val := range values {
+		parsed, err := parseDatatype(v.Datatype, val)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w %s: %q", ErrDatatype, v.Datatype, val))
+			continue
+		}
+		switch v.Method {
+		case MethodBasic, "":
+			if (f.typ == TypeList || f.typ == TypeListMulti) && len(f.option) > 0 && !hasOption(f, val) {
+				errs = append(errs, fmt.Errorf("%w: %q", ErrOption, val))
+			}
+		case MethodRange:
+			if c, ok := compareDatatype(parsed, min); min != nil && (!ok || c < 0) {
+				errs = append(errs, fmt.Errorf("%w: %q is less than %q", ErrRange, val, v.Min))
+			}
+			if c, ok := compareDatatype(parsed, max); max != nil && (!ok || c > 0) {
+				errs = append(errs, fmt.Errorf("%w: %q is greater than %q", ErrRange, val, v.Max))
+			}
+		case MethodRegex:
+			if !re.MatchString(val) {
+				errs = append(errs, fmt.Errorf("%w: %q", ErrRegex, val))
+			}
+		}
+	}
+	return errs
+}
+
+func isMulti(typ FieldType) bool {
+	return typ == TypeListMulti || typ == TypeJIDMulti || typ == TypeTextMulti
+}
+
+func hasOption(f field, val string) bool {
+	for _, opt := range f.option {
+		if opt.Value == val {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	decimalRegexp  = regexp.MustCompile(`^[+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)$`)
+	languageRegexp = regexp.MustCompile(`^[a-zA-Z]{1,8}(-[a-zA-Z0-9]{1,8})*$`)
+)
+
+var timeLayouts = []string{
+	"15:04:05.999999999Z07:00",
+	"15:04:05.999999999",
+}
+
+var dateLayouts = []string{
+	"2006-01-02Z07:00",
+	"2006-01-02",
+}
+
+// parseDatatype parses s as the provided XML Schema datatype.
+// The returned value is a *big.Rat, float64, bool, time.Time, or string.
+// Unknown datatypes are treated as strings.
+func parseDatatype(datatype, s string) (interface{}, error) {
+	switch datatype {
+	case "xs:byte":
+		return parseInt(s, 8)
+	case "xs:short":
+		return parseInt(s, 16)
+	case "xs:int":
+		return parseInt(s, 32)
+	case "xs:long":
+		return parseInt(s, 64)
+	case "xs:integer":
+		i, ok := new(big.Int).SetString(strings.TrimPrefix(s, "+"), 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid integer %q", s)
+		}
+		return new(big.Rat).SetInt(i), nil
+	case "xs:decimal":
+		if !decimalRegexp.MatchString(s) {
+			return nil, fmt.Errorf("invalid decimal %q", s)
+		}
+		r, ok := new(big.Rat).SetString(strings.TrimPrefix(s, "+"))
+		if !ok {
+			return nil, fmt.Errorf("invalid decimal %q", s)
+		}
+		return r, nil
+	case "xs:double":
+		switch s {
+		case "INF":
+			s = "+Inf"
+		case "-INF":
+			s = "-Inf"
+		case "NaN":
+		default:
+			if strings.ContainsAny(s, "nN") {
+				return nil, fmt.Errorf("invalid double %q", s)
+			}
+		}
+		return strconv.ParseFloat(s, 64)
+	case "xs:boolean":
+		switch s {
+		case "true", "1":
+			return true, nil
+		case "false", "0":
+			return false, nil
+		}
+		return nil, fmt.Errorf("invalid boolean %q", s)
+	case "xs:date":
+		return parseTime(dateLayouts, s)
+	case "xs:dateTime":
+		return time.Parse(time.RFC3339Nano, s)
+	case "xs:time":
+		return parseTime(timeLayouts, s)
+	case "xs:anyURI":
+		_, err := url.Parse(s)
+		return s, err
+	case "xs:language":
+		if !languageRegexp.MatchString(s) {
+			return nil, fmt.Errorf("invalid language %q", s)
+		}
+		return s, nil
+	}
+	return s, nil
+}
+
+func parseInt(s string, bitSize int) (interface{}, error) {
+	i, err := strconv.ParseInt(s, 10, bitSize)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Rat).SetInt64(i), nil
+}
+
+func parseTime(layouts []string, s string) (interface{}, error) {
+	var err error
+	for _, layout := range layouts {
+		var t time.Time
+		t, err = time.Parse(layout, s)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return nil, err
+}
+
+// compareDatatype compares two values returned by parseDatatype.
+// If the values cannot be compared ok will be false.
+func compareDatatype(a, b interface{}) (c int, ok bool) {
+	switch av := a.(type) {
+	case *big.Rat:
+		if bv, ok := b.(*big.Rat); ok {
+			return av.Cmp(bv), true
+		}
+	case float64:
+		if bv, ok := b.(float64); ok {
+			switch {
+			case av < bv:
+				return -1, true
+			case av > bv:
+				return 1, true
+			case av == bv:
+				return 0, true
+			}
+		}
+	case time.Time:
+		if bv, ok := b.(time.Time); ok {
+			switch {
+			case av.Before(bv):
+				return -1, true
+			case av.After(bv):
+				return 1, true
+			}
+			return 0, true
+		}
+	case string:
+		if bv, ok := b.(string); ok {
+			return strings.Compare(av, bv), true
+		}
+	}
+	return 0, false
+}
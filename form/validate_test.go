@@ -0,0 +1,187 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package form_test
+
+import (
+	"encoding/xml"
+	"errors"
+	"strconv"
+	"testing"
+
+	"mellium.im/xmpp/form"
+)
+
+var validateTestCases = [...]struct {
+	Data *form.Data
+	Set  map[string]interface{}
+	Err  []error
+}{
+	0: {},
+	1: {
+		Data: form.New(form.Text("t", form.Required), form.Boolean("b", form.Required)),
+		Err:  []error{form.ErrRequired, form.ErrRequired},
+	},
+	2: {
+		Data: form.New(form.Text("t", form.Required, form.Value("default"))),
+	},
+	3: {
+		Data: form.New(form.Text("t", form.Validate(form.Validation{Datatype: "xs:integer"}))),
+		Set:  map[string]interface{}{"t": "12a"},
+		Err:  []error{form.ErrDatatype},
+	},
+	4: {
+		Data: form.New(form.Text("t", form.Validate(form.Validation{Datatype: "xs:byte"}))),
+		Set:  map[string]interface{}{"t": "128"},
+		Err:  []error{form.ErrDatatype},
+	},
+	5: {
+		Data: form.New(form.Text("t", form.Validate(form.Validation{
+			Datatype: "xs:integer",
+			Method:   form.MethodRange,
+			Min:      "1",
+			Max:      "10",
+		}))),
+		Set: map[string]interface{}{"t": "11"},
+		Err: []error{form.ErrRange},
+	},
+	6: {
+		Data: form.New(form.Text("t", form.Validate(form.Validation{
+			Datatype: "xs:decimal",
+			Method:   form.MethodRange,
+			Min:      "1.5",
+		}))),
+		Set: map[string]interface{}{"t": "100"},
+	},
+	7: {
+		Data: form.New(form.Text("t", form.Validate(form.Validation{
+			Datatype: "xs:date",
+			Method:   form.MethodRange,
+			Max:      "2021-01-01",
+		}))),
+		Set: map[string]interface{}{"t": "2021-01-02"},
+		Err: []error{form.ErrRange},
+	},
+	8: {
+		Data: form.New(form.Text("t", form.Validate(form.Validation{
+			Method: form.MethodRegex,
+			Regex:  "([0-9]{3})-([0-9]{2})-([0-9]{4})",
+		}))),
+		Set: map[string]interface{}{"t": "123-45-67890"},
+		Err: []error{form.ErrRegex},
+	},
+	9: {
+		Data: form.New(form.Text("t", form.Validate(form.Validation{
+			Method: form.MethodRegex,
+			Regex:  "([0-9]{3})-([0-9]{2})-([0-9]{4})",
+		}))),
+		Set: map[string]interface{}{"t": "123-45-6789"},
+	},
+	10: {
+		Data: form.New(form.List("l",
+			form.ListItem("", "one"),
+			form.ListItem("", "two"),
+			form.Validate(form.Validation{}),
+		)),
+		Set: map[string]interface{}{"l": "three"},
+		Err: []error{form.ErrOption},
+	},
+	11: {
+		Data: form.New(form.List("l",
+			form.ListItem("", "one"),
+			form.ListItem("", "two"),
+			form.Validate(form.Validation{Method: form.MethodOpen}),
+		)),
+		Set: map[string]interface{}{"l": "three"},
+	},
+	12: {
+		Data: form.New(form.ListMulti("l",
+			form.ListItem("", "one"),
+			form.ListItem("", "two"),
+			form.ListItem("", "three"),
+			form.Validate(form.Validation{ListRange: &form.ListRange{Min: 1, Max: 2}}),
+		)),
+		Set: map[string]interface{}{"l": []string{"one", "two", "three"}},
+		Err: []error{form.ErrListRange},
+	},
+	13: {
+		// All violations are reported.
+		Data: form.New(
+			form.Text("a", form.Required),
+			form.Text("b", form.Validate(form.Validation{Datatype: "xs:int"})),
+			form.TextMulti("c", form.Validate(form.Validation{Datatype: "xs:boolean"})),
+		),
+		Set: map[string]interface{}{"b": "x", "c": "true\nmaybe\nnope"},
+		Err: []error{form.ErrRequired, form.ErrDatatype, form.ErrDatatype, form.ErrDatatype},
+	},
+}
+
+func TestValidate(t *testing.T) {
+	for i, tc := range validateTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			for k, v := range tc.Set {
+				_, err := tc.Data.Set(k, v)
+				if err != nil {
+					t.Fatalf("error setting %s: %v", k, err)
+				}
+			}
+			err := tc.Data.Validate()
+			if len(tc.Err) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				_, err = tc.Data.SubmitValid()
+				if err != nil {
+					t.Fatalf("unexpected error on submit: %v", err)
+				}
+				return
+			}
+			var valErr form.ValidationError
+			if !errors.As(err, &valErr) {
+				t.Fatalf("wrong error type: want=%T, got=%T (%[2]v)", valErr, err)
+			}
+			if len(valErr) != len(tc.Err) {
+				t.Fatalf("wrong number of errors: want=%d, got=%d (%v)", len(tc.Err), len(valErr), valErr)
+			}
+			for j, e := range tc.Err {
+				if !errors.Is(valErr[j], e) {
+					t.Errorf("wrong error %d: want=%v, got=%v", j, e, valErr[j])
+				}
+			}
+			submission, err := tc.Data.SubmitValid()
+			if submission != nil || err == nil {
+				t.Errorf("expected submission to fail validation")
+			}
+		})
+	}
+}
+
+func TestUnmarshalValidate(t *testing.T) {
+	const formData = `<x xmlns="jabber:x:data" type="form"><field type="list-multi" var="l"><validate xmlns="http://jabber.org/protocol/xdata-validate" datatype="xs:integer"><range min="1" max="5"></range><list-range min="1" max="2"></list-range></validate></field></x>`
+	data := &form.Data{}
+	err := xml.Unmarshal([]byte(formData), data)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	var v *form.Validation
+	data.ForFields(func(f form.FieldData) {
+		v = f.Validation
+	})
+	if v == nil {
+		t.Fatalf("expected validation to be decoded")
+	}
+	if v.Datatype != "xs:integer" || v.Method != form.MethodRange || v.Min != "1" || v.Max != "5" {
+		t.Errorf("wrong validation decoded: %+v", v)
+	}
+	if v.ListRange == nil || v.ListRange.Min != 1 || v.ListRange.Max != 2 {
+		t.Errorf("wrong list range decoded: %+v", v.ListRange)
+	}
+	b, err := xml.Marshal(data)
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	if string(b) != formData {
+		t.Errorf("wrong XML after remarshal:\nwant=%s,\n got=%s", formData, b)
+	}
+}
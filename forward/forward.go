@@ -7,6 +7,9 @@ package forward // import "mellium.im/xmpp/forward"
 
 import (
 	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
 	"time"
 
 	"mellium.im/xmlstream"
@@ -19,6 +22,10 @@ const (
 	NS = "urn:xmpp:forward:0"
 )
 
+// ErrNoStanza is returned when unwrapping a forwarded element that does not
+// contain a stanza.
+var ErrNoStanza = errors.New("forward: no stanza found in forwarded element")
+
 // Forwarded can be embedded into another struct along with a stanza to wrap the
 // stanza for forwarding.
 type Forwarded struct {
@@ -48,6 +55,158 @@ func (f Forwarded) WriteXML(w xmlstream.TokenWriter) (int, error) {
 	return xmlstream.Copy(w, f.TokenReader())
 }
 
+// MarshalXML implements xml.Marshaler.
+func (f Forwarded) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	_, err := f.WriteXML(e)
+	return err
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+// The forwarded stanza is skipped, to read it use Unwrap.
+func (f *Forwarded) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	f.XMLName = start.Name
+	f.Delay = delay.Delay{}
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Space == delay.NS && t.Name.Local == "delay" {
+				err = d.DecodeElement(&f.Delay, &t)
+			} else {
+				err = d.Skip()
+			}
+			if err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// Unwrap reads a forwarded element from r and returns the forwarding
+// information along with the start element of the forwarded stanza and a
+// reader over the payload of the stanza.
+// The payload is not buffered, so it must be read (or discarded) before any
+// further tokens are read from r.
+// Once the payload has been consumed r is positioned before the end of the
+// forwarded element.
+//
+// The first element read from r must be a forwarded element.
+// If it does not contain a stanza ErrNoStanza is returned.
+func Unwrap(r xml.TokenReader) (Forwarded, xml.StartElement, xml.TokenReader, error) {
+	f := Forwarded{}
+	start, err := nextStart(r)
+	if err != nil {
+		return f, xml.StartElement{}, nil, err
+	}
+	if start.Name.Space != NS || start.Name.Local != "forwarded" {
+		return f, xml.StartElement{}, nil, fmt.Errorf("forward: expected forwarded element, got %v", start.Name)
+	}
+	f.XMLName = start.Name
+
+	for {
+		start, err = nextStart(r)
+		switch {
+		case err == io.EOF:
+			return f, xml.StartElement{}, nil, ErrNoStanza
+		case err != nil:
+			return f, xml.StartElement{}, nil, err
+		}
+		switch {
+		case start.Name.Space == delay.NS && start.Name.Local == "delay":
+			d := xml.NewTokenDecoder(xmlstream.MultiReader(
+				xmlstream.Token(start),
+				xmlstream.Inner(r),
+				xmlstream.Token(start.End()),
+			))
+			err = d.Decode(&f.Delay)
+		case stanza.Is(start.Name):
+			return f, start, xmlstream.Inner(r), nil
+		default:
+			err = xmlstream.Skip(r)
+		}
+		if err != nil {
+			return f, xml.StartElement{}, nil, err
+		}
+	}
+}
+
+// UnwrapMessage is like Unwrap except that it returns an error if the
+// forwarded stanza is not a message.
+func UnwrapMessage(r xml.TokenReader) (Forwarded, stanza.Message, xml.TokenReader, error) {
+	f, start, payload, err := Unwrap(r)
+	if err != nil {
+		return f, stanza.Message{}, nil, err
+	}
+	if start.Name.Local != "message" {
+		return f, stanza.Message{}, nil, fmt.Errorf("forward: expected message, got %v", start.Name)
+	}
+	msg, err := stanza.NewMessage(start)
+	if err != nil {
+		return f, msg, nil, err
+	}
+	return f, msg, payload, nil
+}
+
+// UnwrapPresence is like Unwrap except that it returns an error if the
+// forwarded stanza is not a presence.
+func UnwrapPresence(r xml.TokenReader) (Forwarded, stanza.Presence, xml.TokenReader, error) {
+	f, start, payload, err := Unwrap(r)
+	if err != nil {
+		return f, stanza.Presence{}, nil, err
+	}
+	if start.Name.Local != "presence" {
+		return f, stanza.Presence{}, nil, fmt.Errorf("forward: expected presence, got %v", start.Name)
+	}
+	p, err := stanza.NewPresence(start)
+	if err != nil {
+		return f, p, nil, err
+	}
+	return f, p, payload, nil
+}
+
+// UnwrapIQ is like Unwrap except that it returns an error if the forwarded
+// stanza is not an IQ.
+func UnwrapIQ(r xml.TokenReader) (Forwarded, stanza.IQ, xml.TokenReader, error) {
+	f, start, payload, err := Unwrap(r)
+	if err != nil {
+		return f, stanza.IQ{}, nil, err
+	}
+	if start.Name.Local != "iq" {
+		return f, stanza.IQ{}, nil, fmt.Errorf("forward: expected iq, got %v", start.Name)
+	}
+	iq, err := stanza.NewIQ(start)
+	if err != nil {
+		return f, iq, nil, err
+	}
+	return f, iq, payload, nil
+}
+
+// nextStart returns the next start element at the current level of r, skipping
+// any character data.
+// If the end of the current element is reached first io.EOF is returned.
+func nextStart(r xml.TokenReader) (xml.StartElement, error) {
+	for {
+		tok, err := r.Token()
+		if tok == nil && err != nil {
+			return xml.StartElement{}, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			return t, nil
+		case xml.EndElement:
+			return xml.StartElement{}, io.EOF
+		}
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+	}
+}
+
 // Wrap forwards the provided token stream by wrapping it in a new message
 // stanza and recording the original delivery time of the stanza.
 // The body is in addition to the forwarded stanza and is not meant as a
@@ -6,15 +6,25 @@ package forward_test
 
 import (
 	"encoding/xml"
+	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"mellium.im/xmlstream"
 	"mellium.im/xmpp/forward"
+	"mellium.im/xmpp/jid"
 	"mellium.im/xmpp/stanza"
 )
 
+var (
+	_ xml.Marshaler       = forward.Forwarded{}
+	_ xml.Unmarshaler     = (*forward.Forwarded)(nil)
+	_ xmlstream.Marshaler = forward.Forwarded{}
+	_ xmlstream.WriterTo  = forward.Forwarded{}
+)
+
 func TestWrap(t *testing.T) {
 	r := forward.Wrap(stanza.Message{
 		Type: stanza.NormalMessage,
@@ -54,3 +64,124 @@ func TestMarshal(t *testing.T) {
 		t.Fatalf("wrong output:\nwant=%s,\n got=%s", expected, out)
 	}
 }
+
+func TestUnmarshal(t *testing.T) {
+	const input = `<forwarded xmlns="urn:xmpp:forward:0"><delay xmlns="urn:xmpp:delay" stamp="2010-07-10T23:08:25Z"></delay><message xmlns="jabber:client"><body>foo</body></message></forwarded>`
+	f := forward.Forwarded{}
+	err := xml.Unmarshal([]byte(input), &f)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	want := time.Date(2010, 7, 10, 23, 8, 25, 0, time.UTC)
+	if !f.Delay.Time.Equal(want) {
+		t.Errorf("wrong delay: want=%v, got=%v", want, f.Delay.Time)
+	}
+}
+
+var unwrapTestCases = [...]struct {
+	in      string
+	name    string
+	payload string
+	stamp   time.Time
+	err     error
+}{
+	0: {
+		in:      `<forwarded xmlns="urn:xmpp:forward:0"><delay xmlns="urn:xmpp:delay" stamp="2010-07-10T23:08:25Z"></delay><message xmlns="jabber:client" type="chat" from="juliet@capulet.lit/balcony"><body>foo</body></message></forwarded>`,
+		name:    "message",
+		payload: `<body xmlns="jabber:client">foo</body>`,
+		stamp:   time.Date(2010, 7, 10, 23, 8, 25, 0, time.UTC),
+	},
+	1: {
+		// Whitespace and unknown elements are ignored.
+		in:   "<forwarded xmlns=\"urn:xmpp:forward:0\">\n\t<foo><bar/></foo>\n\t<presence xmlns=\"jabber:client\"/>\n</forwarded>",
+		name: "presence",
+	},
+	2: {
+		in:      `<forwarded xmlns="urn:xmpp:forward:0"><iq xmlns="jabber:client" type="get" id="123"><query/></iq></forwarded>`,
+		name:    "iq",
+		payload: `<query xmlns="jabber:client"></query>`,
+	},
+	3: {
+		in:  `<forwarded xmlns="urn:xmpp:forward:0"><delay xmlns="urn:xmpp:delay" stamp="2010-07-10T23:08:25Z"></delay></forwarded>`,
+		err: forward.ErrNoStanza,
+	},
+	4: {
+		in:  `<forwarded xmlns="urn:xmpp:forward:0"><message xmlns="urn:example"/></forwarded>`,
+		err: forward.ErrNoStanza,
+	},
+}
+
+func TestUnwrap(t *testing.T) {
+	for i, tc := range unwrapTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			d := xml.NewDecoder(strings.NewReader(tc.in))
+			f, start, payload, err := forward.Unwrap(d)
+			if err != tc.err {
+				t.Fatalf("unexpected error: want=%v, got=%v", tc.err, err)
+			}
+			if err != nil {
+				return
+			}
+			if start.Name.Local != tc.name {
+				t.Errorf("wrong stanza: want=%s, got=%s", tc.name, start.Name.Local)
+			}
+			if !f.Delay.Time.Equal(tc.stamp) {
+				t.Errorf("wrong delay: want=%v, got=%v", tc.stamp, f.Delay.Time)
+			}
+			var buf strings.Builder
+			e := xml.NewEncoder(&buf)
+			_, err = xmlstream.Copy(e, payload)
+			if err != nil {
+				t.Fatalf("error encoding payload: %v", err)
+			}
+			err = e.Flush()
+			if err != nil {
+				t.Fatalf("error flushing: %v", err)
+			}
+			if out := buf.String(); out != tc.payload {
+				t.Errorf("wrong payload:\nwant=%s,\n got=%s", tc.payload, out)
+			}
+
+			// The end of the forwarded element should be the only thing left.
+			tok, err := d.Token()
+			if err != nil {
+				t.Fatalf("error reading remaining tokens: %v", err)
+			}
+			if _, ok := tok.(xml.CharData); ok {
+				tok, err = d.Token()
+				if err != nil {
+					t.Fatalf("error reading remaining tokens: %v", err)
+				}
+			}
+			if end, ok := tok.(xml.EndElement); !ok || end.Name.Local != "forwarded" {
+				t.Errorf("expected end of forwarded element, got %#v", tok)
+			}
+		})
+	}
+}
+
+func TestUnwrapMessage(t *testing.T) {
+	const input = `<forwarded xmlns="urn:xmpp:forward:0"><message xmlns="jabber:client" type="chat" id="1" from="juliet@capulet.lit/balcony"></message></forwarded>`
+	_, msg, _, err := forward.UnwrapMessage(xml.NewDecoder(strings.NewReader(input)))
+	if err != nil {
+		t.Fatalf("error unwrapping message: %v", err)
+	}
+	want := stanza.Message{
+		XMLName: xml.Name{Space: "jabber:client", Local: "message"},
+		ID:      "1",
+		From:    jid.MustParse("juliet@capulet.lit/balcony"),
+		Type:    stanza.ChatMessage,
+	}
+	if !reflect.DeepEqual(msg, want) {
+		t.Errorf("wrong message:\nwant=%+v,\n got=%+v", want, msg)
+	}
+
+	_, _, _, err = forward.UnwrapPresence(xml.NewDecoder(strings.NewReader(input)))
+	if err == nil {
+		t.Errorf("expected error unwrapping message as presence")
+	}
+	_, _, _, err = forward.UnwrapIQ(xml.NewDecoder(strings.NewReader(input)))
+	if err == nil {
+		t.Errorf("expected error unwrapping message as IQ")
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package hints implements XEP-0334: Message Processing Hints.
+//
+// Hints let the sender of a message give advice to entities that route or store
+// it, for example asking servers not to archive the message or not to send
+// carbon copies of it to other clients.
+// Hints are advisory and entities are free to ignore them.
+package hints // import "mellium.im/xmpp/hints"
+
+import (
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/ns"
+)
+
+// NS is the namespace used by this package, provided as a convenience.
+const NS = `urn:xmpp:hints`
+
+// Hint is a message processing hint.
+type Hint string
+
+// A list of possible hints.
+const (
+	// NoPermanentStore asks entities not to store the message permanently, for
+	// example in a message archive, but it may still be stored temporarily for
+	// later delivery.
+	NoPermanentStore Hint = "no-permanent-store"
+
+	// NoStore asks entities not to store the message at all, including for later
+	// delivery to offline clients.
+	NoStore Hint = "no-store"
+
+	// NoCopy asks entities not to copy the message to other resources, for
+	// example using message carbons.
+	NoCopy Hint = "no-copy"
+
+	// Store asks entities to store the message even if they would not normally
+	// do so.
+	Store Hint = "store"
+)
+
+func (h Hint) valid() bool {
+	switch h {
+	case NoPermanentStore, NoStore, NoCopy, Store:
+		return true
+	}
+	return false
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+// If the hint is not valid an empty token reader is returned.
+func (h Hint) TokenReader() xml.TokenReader {
+	if !h.valid() {
+		return xmlstream.MultiReader()
+	}
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NS, Local: string(h)},
+	})
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (h Hint) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, h.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (h Hint) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	_, err := h.WriteXML(e)
+	return err
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+// If the element is not a hint, h is set to the empty string.
+func (h *Hint) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	*h = ""
+	if hint := Hint(start.Name.Local); start.Name.Space == NS && hint.valid() {
+		*h = hint
+	}
+	return d.Skip()
+}
+
+// Insert returns a transformer that adds the provided hints to any messages
+// read through it.
+func Insert(hints ...Hint) xmlstream.Transformer {
+	return xmlstream.InsertFunc(func(start xml.StartElement, level uint64, w xmlstream.TokenWriter) error {
+		// Messages that are being constructed often do not have a namespace yet,
+		// so we also allow an empty namespace here.
+		if level != 1 || start.Name.Local != "message" || (start.Name.Space != "" && start.Name.Space != ns.Client && start.Name.Space != ns.Server) {
+			return nil
+		}
+		for _, h := range hints {
+			_, err := h.WriteXML(w)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Parse reads a message from r and returns the hints that it contains.
+// Only direct children of the message are considered.
+func Parse(r xml.TokenReader) ([]Hint, error) {
+	tok, err := r.Token()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := tok.(xml.StartElement); !ok {
+		return nil, nil
+	}
+
+	var hints []Hint
+	iter := xmlstream.NewIter(r)
+	/* #nosec */
+	defer iter.Close()
+	for iter.Next() {
+		start, _ := iter.Current()
+		if start == nil || start.Name.Space != NS {
+			continue
+		}
+		if h := Hint(start.Name.Local); h.valid() {
+			hints = append(hints, h)
+		}
+	}
+	return hints, iter.Err()
+}
+
+// Contains reports whether the hint h is in hints.
+func Contains(hints []Hint, h Hint) bool {
+	for _, hint := range hints {
+		if hint == h {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package hints_test
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/hints"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/stanza"
+)
+
+var (
+	_ xml.Marshaler       = hints.Hint("")
+	_ xml.Unmarshaler     = (*hints.Hint)(nil)
+	_ xmlstream.Marshaler = hints.Hint("")
+	_ xmlstream.WriterTo  = hints.Hint("")
+)
+
+func newHint(h hints.Hint) *hints.Hint {
+	return &h
+}
+
+var encodingTestCases = []xmpptest.EncodingTestCase{
+	0: {
+		Value: newHint(hints.NoPermanentStore),
+		XML:   `<no-permanent-store xmlns="urn:xmpp:hints"></no-permanent-store>`,
+	},
+	1: {
+		Value: newHint(hints.NoStore),
+		XML:   `<no-store xmlns="urn:xmpp:hints"></no-store>`,
+	},
+	2: {
+		Value: newHint(hints.NoCopy),
+		XML:   `<no-copy xmlns="urn:xmpp:hints"></no-copy>`,
+	},
+	3: {
+		Value: newHint(hints.Store),
+		XML:   `<store xmlns="urn:xmpp:hints"></store>`,
+	},
+	4: {
+		Value:       newHint("no-thanks"),
+		XML:         ``,
+		NoUnmarshal: true,
+	},
+	5: {
+		Value:     newHint(""),
+		XML:       `<no-thanks xmlns="urn:xmpp:hints"></no-thanks>`,
+		NoMarshal: true,
+	},
+}
+
+func TestEncode(t *testing.T) {
+	xmpptest.RunEncodingTests(t, encodingTestCases)
+}
+
+func TestInsert(t *testing.T) {
+	r := hints.Insert(hints.NoStore, hints.NoCopy)(stanza.Message{Type: stanza.ChatMessage}.Wrap(nil))
+	var buf strings.Builder
+	e := xml.NewEncoder(&buf)
+	_, err := xmlstream.Copy(e, r)
+	if err != nil {
+		t.Fatalf("error encoding: %v", err)
+	}
+	err = e.Flush()
+	if err != nil {
+		t.Fatalf("error flushing: %v", err)
+	}
+	const want = `<message type="chat"><no-store xmlns="urn:xmpp:hints"></no-store><no-copy xmlns="urn:xmpp:hints"></no-copy></message>`
+	if out := buf.String(); out != want {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", want, out)
+	}
+}
+
+var parseTestCases = [...]struct {
+	in    string
+	hints []hints.Hint
+}{
+	0: {
+		in: `<message xmlns="jabber:client"><body>test</body></message>`,
+	},
+	1: {
+		in:    `<message xmlns="jabber:client"><store xmlns="urn:xmpp:hints"/><body>test</body><no-copy xmlns="urn:xmpp:hints"/></message>`,
+		hints: []hints.Hint{hints.Store, hints.NoCopy},
+	},
+	2: {
+		// Unknown hints and hints that are not direct children are ignored.
+		in:    `<message xmlns="jabber:client"><no-thanks xmlns="urn:xmpp:hints"/><x><no-store xmlns="urn:xmpp:hints"/></x><no-permanent-store xmlns="urn:xmpp:hints"/></message>`,
+		hints: []hints.Hint{hints.NoPermanentStore},
+	},
+}
+
+func TestParse(t *testing.T) {
+	for i, tc := range parseTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			h, err := hints.Parse(xml.NewDecoder(strings.NewReader(tc.in)))
+			if err != nil {
+				t.Fatalf("error parsing hints: %v", err)
+			}
+			if !reflect.DeepEqual(h, tc.hints) {
+				t.Errorf("wrong hints: want=%v, got=%v", tc.hints, h)
+			}
+			for _, hint := range tc.hints {
+				if !hints.Contains(h, hint) {
+					t.Errorf("expected hints to contain %s", hint)
+				}
+			}
+		})
+	}
+}
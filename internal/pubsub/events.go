@@ -0,0 +1,81 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package pubsub
+
+import (
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// EventHandler is called with the items element of an event notification.
+type EventHandler func(msg stanza.Message, items Items) error
+
+// Dispatcher is a message handler that passes event notifications to the
+// handler registered for the node that changed.
+// Packages do not create a Dispatcher directly, instead they register handlers
+// using HandleEvents so that every package shares the same Dispatcher.
+type Dispatcher struct {
+	nodes map[string]EventHandler
+}
+
+// HandleEvents returns an option that registers h to be called for event
+// notifications about node.
+//
+// The first call for a multiplexer registers a Dispatcher for event
+// notifications and later calls add their node to the existing Dispatcher.
+// If multiple handlers are registered for the same node, HandleEvents panics.
+func HandleEvents(node string, h EventHandler) mux.Option {
+	return func(m *mux.ServeMux) {
+		if h == nil {
+			panic("pubsub: nil event handler")
+		}
+		event := xml.Name{Space: NSEvent, Local: "event"}
+		d, ok := dispatcher(m, event)
+		if !ok {
+			d = &Dispatcher{nodes: make(map[string]EventHandler)}
+			// Event notifications are normally sent without a type, which is
+			// equivalent to the normal type.
+			mux.Message("", event, d)(m)
+			mux.Message(stanza.NormalMessage, event, d)(m)
+			mux.Message(stanza.HeadlineMessage, event, d)(m)
+		}
+		if _, ok := d.nodes[node]; ok {
+			panic("pubsub: multiple registrations for node " + node)
+		}
+		d.nodes[node] = h
+	}
+}
+
+func dispatcher(m *mux.ServeMux, event xml.Name) (*Dispatcher, bool) {
+	h, ok := m.MessageHandler("", event)
+	if !ok {
+		return nil, false
+	}
+	d, ok := h.(*Dispatcher)
+	return d, ok
+}
+
+// HandleMessage satisfies mux.MessageHandler.
+// it is used by the multiplexer and normally does not need to be called by the
+// user.
+func (d *Dispatcher) HandleMessage(p stanza.Message, r xmlstream.TokenReadEncoder) error {
+	msg := struct {
+		stanza.Message
+		Event Event
+	}{}
+	err := xml.NewTokenDecoder(r).Decode(&msg)
+	if err != nil {
+		return err
+	}
+
+	h, ok := d.nodes[msg.Event.Items.Node]
+	if !ok {
+		return nil
+	}
+	return h(msg.Message, msg.Event.Items)
+}
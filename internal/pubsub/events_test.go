@@ -0,0 +1,96 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package pubsub_test
+
+import (
+	"context"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/bookmarks"
+	"mellium.im/xmpp/internal/pubsub"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mix"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/profile"
+	"mellium.im/xmpp/stanza"
+)
+
+const sharedEvents = `<message xmlns="jabber:client" from="juliet@capulet.lit"><event xmlns="http://jabber.org/protocol/pubsub#event"><items node="urn:xmpp:unknown"><item id="1"/></items></event></message>` +
+	`<message xmlns="jabber:client" from="juliet@capulet.lit"><event xmlns="http://jabber.org/protocol/pubsub#event"><items node="http://jabber.org/protocol/nick"><item id="current"><nick xmlns="http://jabber.org/protocol/nick">Jules</nick></item></items></event></message>` +
+	`<message xmlns="jabber:client" type="headline"><event xmlns="http://jabber.org/protocol/pubsub#event"><items node="urn:xmpp:bookmarks:1"><item id="theplay@conference.shakespeare.lit"><conference xmlns="urn:xmpp:bookmarks:1" name="The Play"/></item></items></event></message>` +
+	`<message xmlns="jabber:client" from="coven@mix.shakespeare.example"><event xmlns="http://jabber.org/protocol/pubsub#event"><items node="urn:xmpp:mix:nodes:participants"><item id="123456"><participant xmlns="urn:xmpp:mix:core:1"><nick>thirdwitch</nick><jid>hecate@mix.shakespeare.example</jid></participant></item></items></event></message>`
+
+func TestHandleEventsShared(t *testing.T) {
+	events := make(chan string, 10)
+	m := mux.New(
+		profile.Handle(&profile.Handler{
+			HandleNick: func(from jid.JID, nick string) {
+				events <- "nick " + from.String() + " " + nick
+			},
+		}),
+		bookmarks.Handle(bookmarks.Handler{
+			HandleChannel: func(c bookmarks.Channel) {
+				events <- "bookmark " + c.JID.String() + " " + c.Name
+			},
+		}),
+		mix.HandleClient(&mix.Client{
+			HandleParticipant: func(p mix.Participant) {
+				events <- "participant " + p.Channel.String() + " " + p.Nick
+			},
+		}),
+	)
+	s := xmpptest.NewClientServer(xmpptest.ClientHandler(m))
+
+	iter := xmlstream.NewIter(xml.NewDecoder(strings.NewReader(sharedEvents)))
+	for iter.Next() {
+		start, inner := iter.Current()
+		if start == nil {
+			continue
+		}
+		err := s.Server.Send(context.Background(), xmlstream.MultiReader(
+			xmlstream.Token(*start),
+			inner,
+		))
+		if err != nil {
+			t.Fatalf("error sending event: %v", err)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatalf("error iterating over events: %v", err)
+	}
+
+	for _, want := range []string{
+		"nick juliet@capulet.lit Jules",
+		"bookmark theplay@conference.shakespeare.lit The Play",
+		"participant coven@mix.shakespeare.example thirdwitch",
+	} {
+		select {
+		case got := <-events:
+			if got != want {
+				t.Errorf("wrong event: want=%q, got=%q", want, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event %q", want)
+		}
+	}
+}
+
+func TestHandleEventsDuplicateNode(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("expected registering a node twice to panic")
+		}
+	}()
+	h := func(stanza.Message, pubsub.Items) error { return nil }
+	mux.New(
+		pubsub.HandleEvents("urn:example", h),
+		pubsub.HandleEvents("urn:example", h),
+	)
+}
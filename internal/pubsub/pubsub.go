@@ -0,0 +1,283 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package pubsub contains unexported functionality related to publishing and
+// fetching items using Publish-Subscribe and the Personal Eventing Protocol.
+package pubsub // import "mellium.im/xmpp/internal/pubsub"
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// Various namespaces used by this package.
+const (
+	NS               = `http://jabber.org/protocol/pubsub`
+	NSEvent          = `http://jabber.org/protocol/pubsub#event`
+	NSOwner          = `http://jabber.org/protocol/pubsub#owner`
+	NSPublishOptions = `http://jabber.org/protocol/pubsub#publish-options`
+)
+
+// Item is an item published to a node.
+type Item struct {
+	ID      string
+	payload []xml.Token
+}
+
+// UnmarshalXML satisfies the xml.Unmarshaler interface for *Item.
+func (i *Item) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "id" {
+			i.ID = attr.Value
+		}
+	}
+	var err error
+	i.payload, err = CopyInner(d)
+	return err
+}
+
+// TokenReader returns a stream of XML tokens for the payload of the item.
+func (i Item) TokenReader() xml.TokenReader {
+	t := Tokens(i.payload)
+	return &t
+}
+
+// Decode unmarshals the first element in the payload of the item into v.
+func (i Item) Decode(v interface{}) error {
+	return xml.NewTokenDecoder(i.TokenReader()).Decode(v)
+}
+
+// CopyInner copies the tokens up to the end of the element that was most
+// recently started and consumes the end element.
+// Payloads such as items are stored as tokens so that they can be decoded into
+// whatever type is expected by the caller.
+func CopyInner(d *xml.Decoder) ([]xml.Token, error) {
+	var toks []xml.Token
+	var depth int
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			// The decoder has already resolved the namespace, so drop the
+			// declaration to avoid encoding it twice if the payload is
+			// re-sent.
+			t = t.Copy()
+			attrs := t.Attr[:0]
+			for _, attr := range t.Attr {
+				if attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+					continue
+				}
+				attrs = append(attrs, attr)
+			}
+			t.Attr = attrs
+			toks = append(toks, t)
+			continue
+		case xml.EndElement:
+			if depth == 0 {
+				return toks, nil
+			}
+			depth--
+		}
+		toks = append(toks, xml.CopyToken(tok))
+	}
+}
+
+// Tokens is an xml.TokenReader over a slice of tokens.
+type Tokens []xml.Token
+
+// Token satisfies the xml.TokenReader interface.
+func (t *Tokens) Token() (xml.Token, error) {
+	if len(*t) == 0 {
+		return nil, io.EOF
+	}
+	tok := (*t)[0]
+	*t = (*t)[1:]
+	return tok, nil
+}
+
+// Event is the payload of a message that notifies subscribers of changes to a
+// node.
+type Event struct {
+	XMLName xml.Name `xml:"http://jabber.org/protocol/pubsub#event event"`
+	Items   Items    `xml:"items"`
+}
+
+// Items is a list of items that were published or retracted from a node.
+type Items struct {
+	Node    string `xml:"node,attr"`
+	Item    []Item `xml:"item"`
+	Retract []struct {
+		ID string `xml:"id,attr"`
+	} `xml:"retract"`
+}
+
+// PublishOptions returns a form that can be submitted as publish options to
+// require the node to be configured with the provided fields.
+func PublishOptions(fields ...form.Field) *form.Data {
+	return form.New(append([]form.Field{
+		form.Hidden("FORM_TYPE", form.Value(NSPublishOptions)),
+	}, fields...)...)
+}
+
+// PrivateOptions is like PublishOptions except that it also requires that
+// items be persisted and only be accessible by the owner of the node.
+func PrivateOptions(fields ...form.Field) *form.Data {
+	return PublishOptions(append([]form.Field{
+		form.Boolean("pubsub#persist_items", form.Value("true")),
+		form.List("pubsub#access_model", form.Value("whitelist")),
+	}, fields...)...)
+}
+
+func wrap(inner xml.TokenReader) xml.TokenReader {
+	return xmlstream.Wrap(inner, xml.StartElement{Name: xml.Name{Space: NS, Local: "pubsub"}})
+}
+
+func nodeStart(local, node string, attr ...xml.Attr) xml.StartElement {
+	return xml.StartElement{
+		Name: xml.Name{Local: local},
+		Attr: append([]xml.Attr{{Name: xml.Name{Local: "node"}, Value: node}}, attr...),
+	}
+}
+
+func itemStart(id string) xml.StartElement {
+	start := xml.StartElement{Name: xml.Name{Local: "item"}}
+	if id != "" {
+		start.Attr = []xml.Attr{{Name: xml.Name{Local: "id"}, Value: id}}
+	}
+	return start
+}
+
+// Publish publishes a payload to a node on the provided entity, or to our own
+// personal eventing service if to is the zero value.
+// If id is empty the service will assign one.
+// If opts is not nil, it is submitted as the publish options.
+// The ID of the published item is returned.
+func Publish(ctx context.Context, s *xmpp.Session, to jid.JID, node, id string, opts *form.Data, payload xml.TokenReader) (string, error) {
+	inner := xmlstream.Wrap(
+		xmlstream.Wrap(payload, itemStart(id)),
+		nodeStart("publish", node),
+	)
+	if opts != nil {
+		submission, _ := opts.Submit()
+		inner = xmlstream.MultiReader(
+			inner,
+			xmlstream.Wrap(submission, xml.StartElement{Name: xml.Name{Local: "publish-options"}}),
+		)
+	}
+	resp, err := s.SendIQElement(ctx, wrap(inner), stanza.IQ{
+		Type: stanza.SetIQ,
+		To:   to,
+	})
+	if err != nil {
+		return "", err
+	}
+	/* #nosec */
+	defer resp.Close()
+
+	tok, err := resp.Token()
+	if err != nil {
+		return "", err
+	}
+	start, ok := tok.(xml.StartElement)
+	if !ok {
+		return "", fmt.Errorf("pubsub: expected IQ start token, got %T %[1]v", tok)
+	}
+	_, err = stanza.UnmarshalIQError(resp, start)
+	if err != nil {
+		return "", err
+	}
+
+	// The service is not required to include a payload in the response.
+	tok, err = resp.Token()
+	switch {
+	case err == io.EOF:
+		return id, nil
+	case err != nil:
+		return "", err
+	}
+	start, ok = tok.(xml.StartElement)
+	if !ok {
+		return id, nil
+	}
+	d := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(start), resp))
+	published := struct {
+		Publish struct {
+			Item struct {
+				ID string `xml:"id,attr"`
+			} `xml:"item"`
+		} `xml:"publish"`
+	}{}
+	err = d.Decode(&published)
+	if err != nil {
+		return "", err
+	}
+	if published.Publish.Item.ID == "" {
+		return id, nil
+	}
+	return published.Publish.Item.ID, nil
+}
+
+// Fetch requests items from a node on the provided entity, or from our own
+// personal eventing service if to is the zero value.
+// If ids are provided, only those items are requested.
+// Otherwise, if max is greater than zero, only the most recent max items are
+// requested.
+func Fetch(ctx context.Context, s *xmpp.Session, to jid.JID, node string, max int, ids ...string) ([]Item, error) {
+	var attr []xml.Attr
+	if max > 0 && len(ids) == 0 {
+		attr = append(attr, xml.Attr{Name: xml.Name{Local: "max_items"}, Value: strconv.Itoa(max)})
+	}
+	var items []xml.TokenReader
+	for _, id := range ids {
+		items = append(items, xmlstream.Wrap(nil, itemStart(id)))
+	}
+	resp := struct {
+		XMLName xml.Name `xml:"http://jabber.org/protocol/pubsub pubsub"`
+		Items   struct {
+			Item []Item `xml:"item"`
+		} `xml:"items"`
+	}{}
+	err := s.UnmarshalIQElement(ctx, wrap(
+		xmlstream.Wrap(xmlstream.MultiReader(items...), nodeStart("items", node, attr...)),
+	), stanza.IQ{
+		Type: stanza.GetIQ,
+		To:   to,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Items.Item, nil
+}
+
+// Retract removes an item from a node on the provided entity, or from our own
+// personal eventing service if to is the zero value.
+// If notify is true, subscribers are notified of the retraction.
+func Retract(ctx context.Context, s *xmpp.Session, to jid.JID, node, id string, notify bool) error {
+	var attr []xml.Attr
+	if notify {
+		attr = append(attr, xml.Attr{Name: xml.Name{Local: "notify"}, Value: "true"})
+	}
+	return s.UnmarshalIQElement(ctx, wrap(
+		xmlstream.Wrap(
+			xmlstream.Wrap(nil, itemStart(id)),
+			nodeStart("retract", node, attr...),
+		),
+	), stanza.IQ{
+		Type: stanza.SetIQ,
+		To:   to,
+	}, nil)
+}
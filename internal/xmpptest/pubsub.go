@@ -0,0 +1,189 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package xmpptest
+
+import (
+	"encoding/xml"
+	"strconv"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/internal/pubsub"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// PubSub is a minimal publish-subscribe service that stores published items in
+// memory.
+// It supports publishing, retracting, and fetching items and is meant to be
+// registered with a multiplexer on the server side of a ClientServer.
+// The zero value is ready to use.
+type PubSub struct {
+	m       sync.Mutex
+	nextID  int
+	items   map[string][]pubsub.Item
+	options map[string]*form.Data
+}
+
+// Items returns the items that have been published to a node.
+func (p *PubSub) Items(node string) []pubsub.Item {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return append([]pubsub.Item(nil), p.items[node]...)
+}
+
+// SetItems replaces the items stored in a node.
+func (p *PubSub) SetItems(node string, items []pubsub.Item) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	if p.items == nil {
+		p.items = make(map[string][]pubsub.Item)
+	}
+	p.items[node] = items
+}
+
+// PublishOptions returns the publish options that were last submitted when
+// publishing to a node.
+func (p *PubSub) PublishOptions(node string) *form.Data {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return p.options[node]
+}
+
+// Handle returns an option that registers the service with a multiplexer.
+func (p *PubSub) Handle() mux.Option {
+	return func(m *mux.ServeMux) {
+		name := xml.Name{Space: pubsub.NS, Local: "pubsub"}
+		mux.IQFunc(stanza.SetIQ, name, p.handleSet)(m)
+		mux.IQFunc(stanza.GetIQ, name, p.handleGet)(m)
+	}
+}
+
+type itemID struct {
+	ID string `xml:"id,attr"`
+}
+
+func (p *PubSub) handleSet(iq stanza.IQ, r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	req := struct {
+		Publish *struct {
+			Node string      `xml:"node,attr"`
+			Item pubsub.Item `xml:"item"`
+		} `xml:"publish"`
+		Options *form.Data `xml:"publish-options>x"`
+		Retract *struct {
+			Node string `xml:"node,attr"`
+			Item itemID `xml:"item"`
+		} `xml:"retract"`
+	}{}
+	err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), r)).Decode(&req)
+	if err != nil {
+		return err
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+	if p.items == nil {
+		p.items = make(map[string][]pubsub.Item)
+		p.options = make(map[string]*form.Data)
+	}
+	switch {
+	case req.Publish != nil:
+		node, item := req.Publish.Node, req.Publish.Item
+		if item.ID == "" {
+			p.nextID++
+			item.ID = strconv.Itoa(p.nextID)
+		}
+		items := p.items[node]
+		for i, existing := range items {
+			if existing.ID == item.ID {
+				items = append(items[:i], items[i+1:]...)
+				break
+			}
+		}
+		p.items[node] = append(items, item)
+		if req.Options != nil {
+			p.options[node] = req.Options
+		}
+		_, err = xmlstream.Copy(r, iq.Result(xmlstream.Wrap(
+			xmlstream.Wrap(
+				xmlstream.Wrap(nil, xml.StartElement{
+					Name: xml.Name{Local: "item"},
+					Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: item.ID}},
+				}),
+				xml.StartElement{
+					Name: xml.Name{Local: "publish"},
+					Attr: []xml.Attr{{Name: xml.Name{Local: "node"}, Value: node}},
+				},
+			),
+			xml.StartElement{Name: xml.Name{Space: pubsub.NS, Local: "pubsub"}},
+		)))
+		return err
+	case req.Retract != nil:
+		items := p.items[req.Retract.Node]
+		for i, existing := range items {
+			if existing.ID == req.Retract.Item.ID {
+				p.items[req.Retract.Node] = append(items[:i], items[i+1:]...)
+				break
+			}
+		}
+		_, err = xmlstream.Copy(r, iq.Result(nil))
+		return err
+	}
+	_, err = xmlstream.Copy(r, iq.Error(stanza.Error{
+		Type:      stanza.Cancel,
+		Condition: stanza.FeatureNotImplemented,
+	}))
+	return err
+}
+
+func (p *PubSub) handleGet(iq stanza.IQ, r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	req := struct {
+		Items struct {
+			Node     string   `xml:"node,attr"`
+			MaxItems int      `xml:"max_items,attr"`
+			Item     []itemID `xml:"item"`
+		} `xml:"items"`
+	}{}
+	err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), r)).Decode(&req)
+	if err != nil {
+		return err
+	}
+
+	p.m.Lock()
+	stored := append([]pubsub.Item(nil), p.items[req.Items.Node]...)
+	p.m.Unlock()
+	if max := req.Items.MaxItems; max > 0 && len(stored) > max {
+		stored = stored[len(stored)-max:]
+	}
+	var items []xml.TokenReader
+	for _, item := range stored {
+		match := len(req.Items.Item) == 0
+		for _, want := range req.Items.Item {
+			if want.ID == item.ID {
+				match = true
+				break
+			}
+		}
+		if !match {
+			continue
+		}
+		items = append(items, xmlstream.Wrap(item.TokenReader(), xml.StartElement{
+			Name: xml.Name{Local: "item"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: item.ID}},
+		}))
+	}
+	_, err = xmlstream.Copy(r, iq.Result(xmlstream.Wrap(
+		xmlstream.Wrap(
+			xmlstream.MultiReader(items...),
+			xml.StartElement{
+				Name: xml.Name{Local: "items"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "node"}, Value: req.Items.Node}},
+			},
+		),
+		xml.StartElement{Name: xml.Name{Space: pubsub.NS, Local: "pubsub"}},
+	)))
+	return err
+}
@@ -0,0 +1,85 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package markers
+
+import (
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// Marked is a message containing a chat marker.
+type Marked struct {
+	stanza.Message
+	Marker Marker
+}
+
+// By returns the entity that sent the marker as used by the Tracker.
+// For group chat messages this is the full occupant JID, otherwise it is the
+// bare JID of the sender.
+func (m Marked) By() string {
+	if m.Type == stanza.GroupChatMessage {
+		return m.From.String()
+	}
+	return m.From.Bare().String()
+}
+
+// Handle returns an option that registers a Handler for chat markers.
+func Handle(h *Handler) mux.Option {
+	return func(m *mux.ServeMux) {
+		for _, typ := range []stanza.MessageType{"", stanza.NormalMessage, stanza.ChatMessage, stanza.GroupChatMessage} {
+			for _, marker := range types {
+				mux.Message(typ, xml.Name{Space: NS, Local: string(marker)}, h)(m)
+			}
+		}
+	}
+}
+
+// Handler reports chat markers.
+// If Tracker is not nil each marker is recorded in the tracker and markers
+// that do not change the state of any message are not reported.
+type Handler struct {
+	HandleMarker func(Marked)
+	Tracker      *Tracker
+}
+
+// HandleMessage satisfies mux.MessageHandler.
+// it is used by the multiplexer and normally does not need to be called by the
+// user.
+func (h *Handler) HandleMessage(p stanza.Message, r xmlstream.TokenReadEncoder) error {
+	msg := struct {
+		stanza.Message
+		Received     *Marker `xml:"urn:xmpp:chat-markers:0 received"`
+		Displayed    *Marker `xml:"urn:xmpp:chat-markers:0 displayed"`
+		Acknowledged *Marker `xml:"urn:xmpp:chat-markers:0 acknowledged"`
+	}{}
+	err := xml.NewTokenDecoder(r).Decode(&msg)
+	if err != nil {
+		return err
+	}
+
+	var marker *Marker
+	switch {
+	case msg.Acknowledged != nil:
+		marker = msg.Acknowledged
+	case msg.Displayed != nil:
+		marker = msg.Displayed
+	case msg.Received != nil:
+		marker = msg.Received
+	default:
+		return nil
+	}
+
+	ev := Marked{Message: p, Marker: *marker}
+	if h.Tracker != nil && !h.Tracker.Mark(p.From, ev.By(), ev.Marker) {
+		return nil
+	}
+	if h.HandleMarker != nil {
+		h.HandleMarker(ev)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package markers implements XEP-0333: Chat Markers.
+//
+// Chat markers let the recipient of a message indicate that it was received,
+// displayed to the user, or acknowledged by the user.
+// Unlike delivery receipts, markers are only sent for messages that were marked
+// as markable by the sender, and a marker implies that all earlier messages in
+// the conversation have been marked as well.
+// Tracker uses this to keep the latest state of each message in a
+// conversation.
+//
+// In group chats markers reference the stanza ID assigned by the channel
+// instead of the ID chosen by the sender, see ID.
+package markers // import "mellium.im/xmpp/markers"
+
+import (
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/stanza"
+)
+
+// NS is the namespace used by this package, provided as a convenience.
+const NS = `urn:xmpp:chat-markers:0`
+
+// Type is the type of a chat marker.
+// Each type implies the types that come before it, so a message that has been
+// displayed has also been received.
+type Type string
+
+// A list of possible chat markers in the order in which they apply.
+const (
+	Received     Type = "received"
+	Displayed    Type = "displayed"
+	Acknowledged Type = "acknowledged"
+)
+
+var types = [...]Type{Received, Displayed, Acknowledged}
+
+// rank returns the position of t in the list of markers starting at 1, or 0
+// if t is not a valid marker.
+func (t Type) rank() int {
+	for i, typ := range types {
+		if t == typ {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Markable indicates that the sender of a message would like to receive chat
+// markers for it.
+type Markable struct {
+	XMLName xml.Name `xml:"urn:xmpp:chat-markers:0 markable"`
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (Markable) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NS, Local: "markable"},
+	})
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (m Markable) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, m.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (m Markable) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	_, err := m.WriteXML(e)
+	return err
+}
+
+// Marker marks the message with the provided ID and all messages before it.
+type Marker struct {
+	Type Type
+	ID   string
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+// If the marker type is not valid an empty token reader is returned.
+func (m Marker) TokenReader() xml.TokenReader {
+	if m.Type.rank() == 0 {
+		return xmlstream.MultiReader()
+	}
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NS, Local: string(m.Type)},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: m.ID}},
+	})
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (m Marker) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, m.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (m Marker) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	_, err := m.WriteXML(e)
+	return err
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+// If the element is not a chat marker the type is left empty.
+func (m *Marker) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	m.Type = ""
+	m.ID = ""
+	if start.Name.Space == NS && Type(start.Name.Local).rank() != 0 {
+		m.Type = Type(start.Name.Local)
+		for _, attr := range start.Attr {
+			if attr.Name.Local == "id" {
+				m.ID = attr.Value
+				break
+			}
+		}
+	}
+	return d.Skip()
+}
+
+// Mark returns a message containing a marker of the provided type for the
+// message with the provided ID.
+// The ID should be chosen using the ID function.
+func Mark(msg stanza.Message, typ Type, id string) xml.TokenReader {
+	return msg.Wrap(Marker{Type: typ, ID: id}.TokenReader())
+}
+
+// ID returns the ID that should be used to reference a message in a marker.
+// For group chat messages this is the stanza ID assigned by the channel,
+// otherwise it is the ID of the message itself.
+// Stanza IDs added by other entities are ignored since they could be spoofed by
+// the sender, and if the channel did not assign an ID the group chat message
+// cannot be marked and an empty string is returned.
+func ID(msg stanza.Message, ids []stanza.ID) string {
+	if msg.Type == stanza.GroupChatMessage {
+		id, _ := stanza.TrustedID(ids, msg.From)
+		return id.ID
+	}
+	return msg.ID
+}
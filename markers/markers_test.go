@@ -0,0 +1,241 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package markers_test
+
+import (
+	"context"
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/markers"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+var (
+	_ xml.Marshaler       = markers.Markable{}
+	_ xmlstream.Marshaler = markers.Markable{}
+	_ xmlstream.WriterTo  = markers.Markable{}
+	_ xml.Marshaler       = markers.Marker{}
+	_ xml.Unmarshaler     = (*markers.Marker)(nil)
+	_ xmlstream.Marshaler = markers.Marker{}
+	_ xmlstream.WriterTo  = markers.Marker{}
+)
+
+var encodingTestCases = []xmpptest.EncodingTestCase{
+	0: {
+		Value: &markers.Markable{
+			XMLName: xml.Name{Space: markers.NS, Local: "markable"},
+		},
+		XML: `<markable xmlns="urn:xmpp:chat-markers:0"></markable>`,
+	},
+	1: {
+		Value: &markers.Marker{Type: markers.Received, ID: "message-1"},
+		XML:   `<received xmlns="urn:xmpp:chat-markers:0" id="message-1"></received>`,
+	},
+	2: {
+		Value: &markers.Marker{Type: markers.Displayed, ID: "message-1"},
+		XML:   `<displayed xmlns="urn:xmpp:chat-markers:0" id="message-1"></displayed>`,
+	},
+	3: {
+		Value: &markers.Marker{Type: markers.Acknowledged, ID: "message-1"},
+		XML:   `<acknowledged xmlns="urn:xmpp:chat-markers:0" id="message-1"></acknowledged>`,
+	},
+	4: {
+		Value:       &markers.Marker{Type: "read", ID: "message-1"},
+		XML:         ``,
+		NoUnmarshal: true,
+	},
+	5: {
+		Value:     &markers.Marker{},
+		XML:       `<markable xmlns="urn:xmpp:chat-markers:0"></markable>`,
+		NoMarshal: true,
+	},
+}
+
+func TestEncode(t *testing.T) {
+	xmpptest.RunEncodingTests(t, encodingTestCases)
+}
+
+func TestMark(t *testing.T) {
+	msg := stanza.Message{
+		To:   jid.MustParse("northumberland@shakespeare.lit/westminster"),
+		Type: stanza.ChatMessage,
+	}
+	var buf strings.Builder
+	e := xml.NewEncoder(&buf)
+	_, err := xmlstream.Copy(e, markers.Mark(msg, markers.Displayed, "message-1"))
+	if err != nil {
+		t.Fatalf("error encoding: %v", err)
+	}
+	err = e.Flush()
+	if err != nil {
+		t.Fatalf("error flushing: %v", err)
+	}
+	const want = `<message type="chat" to="northumberland@shakespeare.lit/westminster"><displayed xmlns="urn:xmpp:chat-markers:0" id="message-1"></displayed></message>`
+	if out := buf.String(); out != want {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", want, out)
+	}
+}
+
+var idTestCases = [...]struct {
+	msg stanza.Message
+	ids []stanza.ID
+	out string
+}{
+	0: {
+		msg: stanza.Message{ID: "message-1", Type: stanza.ChatMessage},
+		ids: []stanza.ID{{ID: "archive-1", By: jid.MustParse("romeo@montague.lit")}},
+		out: "message-1",
+	},
+	1: {
+		msg: stanza.Message{ID: "message-1", From: jid.MustParse("room@muc.example.net/romeo"), Type: stanza.GroupChatMessage},
+		ids: []stanza.ID{
+			{ID: "spoofed", By: jid.MustParse("muc.example.net")},
+			{ID: "room-1", By: jid.MustParse("room@muc.example.net")},
+		},
+		out: "room-1",
+	},
+	2: {
+		msg: stanza.Message{ID: "message-1", From: jid.MustParse("room@muc.example.net/romeo"), Type: stanza.GroupChatMessage},
+	},
+}
+
+func TestID(t *testing.T) {
+	for i, tc := range idTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			out := markers.ID(tc.msg, tc.ids)
+			if out != tc.out {
+				t.Errorf("wrong ID: want=%q, got=%q", tc.out, out)
+			}
+		})
+	}
+}
+
+func TestTracker(t *testing.T) {
+	juliet := jid.MustParse("juliet@capulet.lit/balcony")
+	const by = "juliet@capulet.lit"
+	var tracker markers.Tracker
+	for _, id := range []string{"1", "2", "3"} {
+		tracker.Markable(juliet, id)
+	}
+
+	if tracker.Mark(juliet, by, markers.Marker{Type: markers.Displayed, ID: "unknown"}) {
+		t.Errorf("unknown message should not change the state")
+	}
+	if !tracker.Mark(juliet, by, markers.Marker{Type: markers.Displayed, ID: "2"}) {
+		t.Errorf("expected displayed marker to change the state")
+	}
+	if tracker.Mark(juliet, by, markers.Marker{Type: markers.Received, ID: "1"}) {
+		t.Errorf("earlier received marker should have been collapsed")
+	}
+	if !tracker.Mark(juliet, by, markers.Marker{Type: markers.Received, ID: "3"}) {
+		t.Errorf("expected later received marker to change the state")
+	}
+
+	for id, want := range map[string]markers.Type{
+		"1":       markers.Displayed,
+		"2":       markers.Displayed,
+		"3":       markers.Received,
+		"unknown": "",
+	} {
+		if state := tracker.State(juliet.Bare(), by, id); state != want {
+			t.Errorf("wrong state for message %s: want=%q, got=%q", id, want, state)
+		}
+	}
+	if state := tracker.State(juliet, "nurse@capulet.lit", "1"); state != "" {
+		t.Errorf("wrong state for other sender: want empty, got=%q", state)
+	}
+
+	tracker.Forget(juliet)
+	if state := tracker.State(juliet, by, "1"); state != "" {
+		t.Errorf("wrong state after forgetting conversation: want empty, got=%q", state)
+	}
+}
+
+func TestTrackerMaxMarkable(t *testing.T) {
+	juliet := jid.MustParse("juliet@capulet.lit")
+	const by = "juliet@capulet.lit"
+	tracker := markers.Tracker{MaxMarkable: 2}
+	for _, id := range []string{"1", "2", "3"} {
+		tracker.Markable(juliet, id)
+	}
+
+	if tracker.Mark(juliet, by, markers.Marker{Type: markers.Displayed, ID: "1"}) {
+		t.Errorf("forgotten message should not change the state")
+	}
+	if !tracker.Mark(juliet, by, markers.Marker{Type: markers.Displayed, ID: "2"}) {
+		t.Errorf("expected displayed marker to change the state")
+	}
+	for id, want := range map[string]markers.Type{
+		"1": "",
+		"2": markers.Displayed,
+		"3": "",
+	} {
+		if state := tracker.State(juliet, by, id); state != want {
+			t.Errorf("wrong state for message %s: want=%q, got=%q", id, want, state)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	room := jid.MustParse("room@muc.example.net")
+	romeo := jid.MustParse("room@muc.example.net/romeo")
+
+	tracker := &markers.Tracker{}
+	for _, id := range []string{"room-1", "room-2"} {
+		tracker.Markable(room, id)
+	}
+	events := make(chan markers.Marked, 10)
+	h := &markers.Handler{
+		Tracker: tracker,
+		HandleMarker: func(m markers.Marked) {
+			events <- m
+		},
+	}
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(markers.Handle(h))),
+	)
+
+	msg := stanza.Message{From: romeo, Type: stanza.GroupChatMessage}
+	ctx := context.Background()
+	for i, r := range []xml.TokenReader{
+		markers.Mark(msg, markers.Displayed, "room-2"),
+		// Collapsed by the earlier displayed marker and should not be reported.
+		markers.Mark(msg, markers.Received, "room-1"),
+		markers.Mark(msg, markers.Acknowledged, "room-1"),
+	} {
+		err := s.Server.Send(ctx, r)
+		if err != nil {
+			t.Fatalf("error sending message %d: %v", i, err)
+		}
+	}
+
+	for i, want := range []markers.Marker{
+		{Type: markers.Displayed, ID: "room-2"},
+		{Type: markers.Acknowledged, ID: "room-1"},
+	} {
+		ev := <-events
+		if ev.Marker != want {
+			t.Errorf("wrong marker %d: want=%+v, got=%+v", i, want, ev.Marker)
+		}
+		if by := ev.By(); by != romeo.String() {
+			t.Errorf("wrong sender %d: want=%s, got=%s", i, romeo, by)
+		}
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("unexpected event: %+v", ev)
+	default:
+	}
+	if state := tracker.State(room, romeo.String(), "room-2"); state != markers.Displayed {
+		t.Errorf("wrong state: want=%q, got=%q", markers.Displayed, state)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package markers
+
+import (
+	"sync"
+
+	"mellium.im/xmpp/jid"
+)
+
+// Tracker keeps track of the markers received for markable messages in each
+// conversation.
+// Because a marker implies that all earlier messages have been marked as well,
+// only the latest marker of each type is stored for each sender.
+//
+// In one-to-one conversations markers are tracked per bare JID of the sender,
+// in group chats they are tracked per occupant.
+//
+// The zero value is ready to use and Tracker is safe for concurrent use by
+// multiple goroutines.
+type Tracker struct {
+	// MaxMarkable is the number of markable messages that are remembered in each
+	// conversation.
+	// Once it is reached the oldest message is forgotten each time a new one is
+	// added and markers for forgotten messages are ignored.
+	// If MaxMarkable is zero, DefaultMaxMarkable is used.
+	MaxMarkable int
+
+	m     sync.Mutex
+	convs map[string]*conversation
+}
+
+// DefaultMaxMarkable is the number of markable messages remembered in each
+// conversation if the tracker does not specify otherwise.
+const DefaultMaxMarkable = 1000
+
+type conversation struct {
+	// pos maps the ID of each markable message to its position in the
+	// conversation and ids lists the remembered IDs from oldest to newest.
+	pos  map[string]int
+	ids  []string
+	next int
+	// marks maps each sender to the position of the latest message marked with
+	// each type plus one, or 0 if no message has been marked with that type.
+	marks map[string]*[len(types)]int
+}
+
+// Markable records that a markable message was sent in the conversation conv.
+// Messages must be added in the order in which they appear in the
+// conversation.
+// In group chats the ID should be the stanza ID assigned by the channel (see
+// ID), which is only known once the message is reflected back to us.
+func (t *Tracker) Markable(conv jid.JID, id string) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	if t.convs == nil {
+		t.convs = make(map[string]*conversation)
+	}
+	key := conv.Bare().String()
+	c, ok := t.convs[key]
+	if !ok {
+		c = &conversation{
+			pos:   make(map[string]int),
+			marks: make(map[string]*[len(types)]int),
+		}
+		t.convs[key] = c
+	}
+	if _, ok := c.pos[id]; ok {
+		return
+	}
+	c.pos[id] = c.next
+	c.ids = append(c.ids, id)
+	c.next++
+
+	max := t.MaxMarkable
+	if max <= 0 {
+		max = DefaultMaxMarkable
+	}
+	for len(c.ids) > max {
+		delete(c.pos, c.ids[0])
+		c.ids = c.ids[1:]
+	}
+}
+
+// Mark records a marker sent by the entity by in the conversation conv.
+// It reports whether the marker changed the state of any message, which is
+// not the case if the message is unknown or a later message had already been
+// marked with the same or a higher type.
+func (t *Tracker) Mark(conv jid.JID, by string, m Marker) bool {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	rank := m.Type.rank()
+	if rank == 0 {
+		return false
+	}
+	c, ok := t.convs[conv.Bare().String()]
+	if !ok {
+		return false
+	}
+	pos, ok := c.pos[m.ID]
+	if !ok {
+		return false
+	}
+	marks, ok := c.marks[by]
+	if !ok {
+		marks = new([len(types)]int)
+		c.marks[by] = marks
+	}
+	var changed bool
+	for i := 0; i < rank; i++ {
+		if marks[i] < pos+1 {
+			marks[i] = pos + 1
+			changed = true
+		}
+	}
+	return changed
+}
+
+// State returns the highest marker type that applies to the message with the
+// provided ID for the entity by, or an empty Type if the message has not been
+// marked.
+func (t *Tracker) State(conv jid.JID, by, id string) Type {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	c, ok := t.convs[conv.Bare().String()]
+	if !ok {
+		return ""
+	}
+	pos, ok := c.pos[id]
+	if !ok {
+		return ""
+	}
+	marks, ok := c.marks[by]
+	if !ok {
+		return ""
+	}
+	for i := len(types) - 1; i >= 0; i-- {
+		if marks[i] > pos {
+			return types[i]
+		}
+	}
+	return ""
+}
+
+// Forget removes all state for the conversation conv.
+func (t *Tracker) Forget(conv jid.JID) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	delete(t.convs, conv.Bare().String())
+}
@@ -0,0 +1,246 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package mix
+
+import (
+	"context"
+	"encoding/xml"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// Channel is a MIX channel that we have joined.
+type Channel struct {
+	addr    jid.JID
+	session *xmpp.Session
+
+	m     sync.Mutex
+	id    string
+	nick  string
+	nodes []string
+}
+
+// Addr returns the address of the channel.
+func (c *Channel) Addr() jid.JID {
+	return c.addr
+}
+
+// ID returns our stable participant ID in the channel.
+func (c *Channel) ID() string {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.id
+}
+
+// Nick returns our nickname in the channel, if any.
+func (c *Channel) Nick() string {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.nick
+}
+
+// Nodes returns the nodes that we are subscribed to.
+func (c *Channel) Nodes() []string {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return append([]string(nil), c.nodes...)
+}
+
+type subscription struct {
+	Node string `xml:"node,attr"`
+}
+
+func subscriptions(local string, nodes []string) xml.TokenReader {
+	var r []xml.TokenReader
+	for _, node := range nodes {
+		r = append(r, xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: local},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "node"}, Value: node}},
+		}))
+	}
+	return xmlstream.MultiReader(r...)
+}
+
+func optionalNick(nick string) xml.TokenReader {
+	if nick == "" {
+		return nil
+	}
+	return xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(nick)),
+		xml.StartElement{Name: xml.Name{Local: "nick"}},
+	)
+}
+
+// Join joins a channel using our server as a proxy so that all of our clients
+// receive messages from the channel.
+//
+// If no nodes are provided using the Subscribe option, the messages,
+// presence, participants, and info nodes are subscribed to.
+func Join(ctx context.Context, channel jid.JID, s *xmpp.Session, opt ...Option) (*Channel, error) {
+	return JoinIQ(ctx, stanza.IQ{}, channel, s, opt...)
+}
+
+// JoinIQ is like Join except that it allows you to customize the IQ.
+// If the IQ has no "to" address it is addressed to our own bare JID.
+// Changing the type of the IQ has no effect.
+func JoinIQ(ctx context.Context, iq stanza.IQ, channel jid.JID, s *xmpp.Session, opt ...Option) (*Channel, error) {
+	cfg := config{
+		nodes: []string{NodeMessages, NodePresence, NodeParticipants, NodeInfo},
+	}
+	for _, o := range opt {
+		o(&cfg)
+	}
+
+	iq.Type = stanza.SetIQ
+	if iq.To.Equal(jid.JID{}) {
+		iq.To = s.LocalAddr().Bare()
+	}
+	resp := struct {
+		XMLName xml.Name `xml:"urn:xmpp:mix:pam:2 client-join"`
+		Join    struct {
+			ID        string         `xml:"id,attr"`
+			Subscribe []subscription `xml:"subscribe"`
+			Nick      string         `xml:"nick"`
+		} `xml:"urn:xmpp:mix:core:1 join"`
+	}{}
+	err := s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		xmlstream.Wrap(
+			xmlstream.MultiReader(
+				subscriptions("subscribe", cfg.nodes),
+				optionalNick(cfg.nick),
+			),
+			xml.StartElement{Name: xml.Name{Space: NS, Local: "join"}},
+		),
+		xml.StartElement{
+			Name: xml.Name{Space: NSPAM, Local: "client-join"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "channel"}, Value: channel.Bare().String()}},
+		},
+	), iq, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Channel{
+		addr:    channel.Bare(),
+		session: s,
+		id:      resp.Join.ID,
+		nick:    resp.Join.Nick,
+	}
+	for _, sub := range resp.Join.Subscribe {
+		c.nodes = append(c.nodes, sub.Node)
+	}
+	return c, nil
+}
+
+// Leave leaves the channel using our server as a proxy.
+func (c *Channel) Leave(ctx context.Context) error {
+	return c.LeaveIQ(ctx, stanza.IQ{})
+}
+
+// LeaveIQ is like Leave except that it allows you to customize the IQ.
+// If the IQ has no "to" address it is addressed to our own bare JID.
+// Changing the type of the IQ has no effect.
+func (c *Channel) LeaveIQ(ctx context.Context, iq stanza.IQ) error {
+	iq.Type = stanza.SetIQ
+	if iq.To.Equal(jid.JID{}) {
+		iq.To = c.session.LocalAddr().Bare()
+	}
+	resp := struct {
+		XMLName xml.Name `xml:"urn:xmpp:mix:pam:2 client-leave"`
+	}{}
+	return c.session.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: NS, Local: "leave"}}),
+		xml.StartElement{
+			Name: xml.Name{Space: NSPAM, Local: "client-leave"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "channel"}, Value: c.addr.String()}},
+		},
+	), iq, &resp)
+}
+
+// SetNick sets our nickname in the channel.
+// The channel may assign a different nickname than the one requested, in
+// which case the assigned nickname is what will be returned by Nick.
+func (c *Channel) SetNick(ctx context.Context, nick string) error {
+	resp := struct {
+		XMLName xml.Name `xml:"urn:xmpp:mix:core:1 setnick"`
+		Nick    string   `xml:"nick"`
+	}{}
+	err := c.session.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		optionalNick(nick),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "setnick"}},
+	), stanza.IQ{
+		Type: stanza.SetIQ,
+		To:   c.addr,
+	}, &resp)
+	if err != nil {
+		return err
+	}
+	if resp.Nick == "" {
+		resp.Nick = nick
+	}
+	c.m.Lock()
+	c.nick = resp.Nick
+	c.m.Unlock()
+	return nil
+}
+
+// Subscribe subscribes to additional nodes in the channel.
+func (c *Channel) Subscribe(ctx context.Context, nodes ...string) error {
+	return c.updateSubscription(ctx, nodes, nil)
+}
+
+// Unsubscribe unsubscribes from nodes in the channel.
+func (c *Channel) Unsubscribe(ctx context.Context, nodes ...string) error {
+	return c.updateSubscription(ctx, nil, nodes)
+}
+
+func (c *Channel) updateSubscription(ctx context.Context, subscribe, unsubscribe []string) error {
+	resp := struct {
+		XMLName     xml.Name       `xml:"urn:xmpp:mix:core:1 update-subscription"`
+		Subscribe   []subscription `xml:"subscribe"`
+		Unsubscribe []subscription `xml:"unsubscribe"`
+	}{}
+	err := c.session.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		xmlstream.MultiReader(
+			subscriptions("subscribe", subscribe),
+			subscriptions("unsubscribe", unsubscribe),
+		),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "update-subscription"}},
+	), stanza.IQ{
+		Type: stanza.SetIQ,
+		To:   c.addr,
+	}, &resp)
+	if err != nil {
+		return err
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+	for _, sub := range resp.Unsubscribe {
+		for i, node := range c.nodes {
+			if node == sub.Node {
+				c.nodes = append(c.nodes[:i], c.nodes[i+1:]...)
+				break
+			}
+		}
+	}
+	for _, sub := range resp.Subscribe {
+		var found bool
+		for _, node := range c.nodes {
+			if node == sub.Node {
+				found = true
+				break
+			}
+		}
+		if !found {
+			c.nodes = append(c.nodes, sub.Node)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package mix implements Mediated Information eXchange (MIX).
+//
+// MIX is a group chat protocol that is designed as a replacement for
+// Multi-User Chat (see the muc package).
+// Unlike MUC, channels are joined by the users account instead of by each
+// client.
+// This package joins and leaves channels using the users server as a proxy as
+// described in XEP-0405: Mediated Information eXchange (MIX): Participant
+// Server Requirements, which means that all of the users clients will receive
+// messages from the channel.
+//
+// Like the muc package, the mix package tries to be as stateless as possible.
+// The Client type can be registered with a multiplexer to receive messages,
+// participant and presence changes, and channel information updates, but it is
+// up to the user to keep track of them:
+//
+//     mixClient := &mix.Client{
+//         HandleMessage: func(msg mix.Message) {
+//             log.Printf("%s: %s", msg.Nick, msg.Body)
+//         },
+//     }
+//     m := mux.New(
+//         mix.HandleClient(mixClient),
+//     )
+//     channel, err := mix.Join(ctx, channelAddr, session, mix.Nick("thirdwitch"))
+package mix // import "mellium.im/xmpp/mix"
+
+import (
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/internal/pubsub"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// Various namespaces used by this package, provided as a convenience.
+const (
+	NS         = `urn:xmpp:mix:core:1`
+	NSPAM      = `urn:xmpp:mix:pam:2`
+	NSPresence = `urn:xmpp:mix:presence:0`
+
+	// NSEvent is the namespace used by publish-subscribe event notifications
+	// sent when nodes in the channel change.
+	NSEvent = `http://jabber.org/protocol/pubsub#event`
+)
+
+// A list of nodes that can be subscribed to when joining a channel.
+const (
+	NodeMessages     = `urn:xmpp:mix:nodes:messages`
+	NodePresence     = `urn:xmpp:mix:nodes:presence`
+	NodeParticipants = `urn:xmpp:mix:nodes:participants`
+	NodeInfo         = `urn:xmpp:mix:nodes:info`
+	NodeAllowed      = `urn:xmpp:mix:nodes:allowed`
+	NodeBanned       = `urn:xmpp:mix:nodes:banned`
+	NodeConfig       = `urn:xmpp:mix:nodes:config`
+)
+
+// Message is a message sent to a channel.
+type Message struct {
+	stanza.Message
+
+	// Body is the body of the message, if any.
+	Body string
+
+	// Nick and JID identify the participant that sent the message.
+	// The JID is only set in channels that share participants JIDs.
+	Nick string
+	JID  jid.JID
+}
+
+// Participant is a user that has joined a channel.
+type Participant struct {
+	Channel jid.JID
+
+	// ID is the stable participant ID assigned by the channel.
+	ID   string
+	Nick string
+	JID  jid.JID
+
+	// Left is true if the participant has left the channel, in which case only
+	// Channel and ID are set.
+	Left bool
+}
+
+// Presence is the presence of a participants client as shared with the
+// channel.
+type Presence struct {
+	stanza.Presence
+
+	Nick string
+	JID  jid.JID
+}
+
+// Info is the information about a channel published to the info node.
+type Info struct {
+	Channel     jid.JID
+	Name        string
+	Description string
+	Contact     []jid.JID
+}
+
+// Client contains callbacks for messages, presence, and publish-subscribe
+// events sent from MIX channels.
+// It is registered with a multiplexer using HandleClient.
+type Client struct {
+	// HandleMessage is called for each message sent to a channel.
+	HandleMessage func(Message)
+
+	// HandleParticipant is called when a participant joins or leaves a channel
+	// or changes their nickname.
+	HandleParticipant func(Participant)
+
+	// HandlePresence is called when the presence of a participants client
+	// changes.
+	HandlePresence func(Presence)
+
+	// HandleInfo is called when the channel information changes.
+	HandleInfo func(Info)
+}
+
+// HandleClient returns an option that registers the handler for use with a
+// multiplexer.
+func HandleClient(h *Client) mux.Option {
+	return func(m *mux.ServeMux) {
+		presence := xml.Name{Space: NSPresence, Local: "mix"}
+
+		handler := clientHandler{c: h}
+		mux.Message(stanza.GroupChatMessage, xml.Name{Space: NS, Local: "mix"}, handler)(m)
+		pubsub.HandleEvents(NodeParticipants, handler.handleParticipants)(m)
+		pubsub.HandleEvents(NodeInfo, handler.handleInfo)(m)
+		mux.Presence(stanza.AvailablePresence, presence, handler)(m)
+		mux.Presence(stanza.UnavailablePresence, presence, handler)(m)
+	}
+}
+
+type clientHandler struct {
+	c *Client
+}
+
+func (h clientHandler) HandleMessage(p stanza.Message, r xmlstream.TokenReadEncoder) error {
+	c := h.c
+	if c.HandleMessage == nil {
+		return nil
+	}
+	msg := struct {
+		stanza.Message
+		Body string `xml:"body"`
+		Mix  struct {
+			Nick string  `xml:"nick"`
+			JID  jid.JID `xml:"jid"`
+		} `xml:"urn:xmpp:mix:core:1 mix"`
+	}{}
+	err := xml.NewTokenDecoder(r).Decode(&msg)
+	if err != nil {
+		return err
+	}
+	c.HandleMessage(Message{
+		Message: msg.Message,
+		Body:    msg.Body,
+		Nick:    msg.Mix.Nick,
+		JID:     msg.Mix.JID,
+	})
+	return nil
+}
+
+// itemPayload is the payload of an item published to one of the channel
+// nodes.
+type itemPayload struct {
+	Participant *struct {
+		Nick string  `xml:"nick"`
+		JID  jid.JID `xml:"jid"`
+	} `xml:"urn:xmpp:mix:core:1 participant"`
+	Info *form.Data `xml:"jabber:x:data x"`
+}
+
+func decodePayload(item pubsub.Item) (itemPayload, error) {
+	payload := itemPayload{}
+	err := xml.NewTokenDecoder(xmlstream.Wrap(
+		item.TokenReader(),
+		xml.StartElement{Name: xml.Name{Local: "item"}},
+	)).Decode(&payload)
+	return payload, err
+}
+
+func (h clientHandler) handleParticipants(msg stanza.Message, items pubsub.Items) error {
+	c := h.c
+	if c.HandleParticipant == nil {
+		return nil
+	}
+	channel := msg.From.Bare()
+	for _, item := range items.Item {
+		payload, err := decodePayload(item)
+		if err != nil {
+			return err
+		}
+		if payload.Participant == nil {
+			continue
+		}
+		c.HandleParticipant(Participant{
+			Channel: channel,
+			ID:      item.ID,
+			Nick:    payload.Participant.Nick,
+			JID:     payload.Participant.JID,
+		})
+	}
+	for _, retract := range items.Retract {
+		c.HandleParticipant(Participant{
+			Channel: channel,
+			ID:      retract.ID,
+			Left:    true,
+		})
+	}
+	return nil
+}
+
+func (h clientHandler) handleInfo(msg stanza.Message, items pubsub.Items) error {
+	c := h.c
+	if c.HandleInfo == nil {
+		return nil
+	}
+	channel := msg.From.Bare()
+	for _, item := range items.Item {
+		payload, err := decodePayload(item)
+		if err != nil {
+			return err
+		}
+		if payload.Info == nil {
+			continue
+		}
+		c.HandleInfo(newInfo(channel, payload.Info))
+	}
+	return nil
+}
+
+func newInfo(channel jid.JID, data *form.Data) Info {
+	info := Info{Channel: channel}
+	info.Name, _ = data.GetString("Name")
+	info.Description, _ = data.GetString("Description")
+	if contacts, ok := data.GetJIDs("Contact"); ok {
+		info.Contact = contacts
+	} else if contact, ok := data.GetString("Contact"); ok {
+		// The field type is not always included, in which case only the first
+		// value is available.
+		if j, err := jid.Parse(contact); err == nil {
+			info.Contact = []jid.JID{j}
+		}
+	}
+	return info
+}
+
+func (h clientHandler) HandlePresence(p stanza.Presence, r xmlstream.TokenReadEncoder) error {
+	c := h.c
+	if c.HandlePresence == nil {
+		return nil
+	}
+	presence := struct {
+		stanza.Presence
+		Mix struct {
+			Nick string  `xml:"nick"`
+			JID  jid.JID `xml:"jid"`
+		} `xml:"urn:xmpp:mix:presence:0 mix"`
+	}{}
+	err := xml.NewTokenDecoder(r).Decode(&presence)
+	if err != nil {
+		return err
+	}
+	c.HandlePresence(Presence{
+		Presence: presence.Presence,
+		Nick:     presence.Mix.Nick,
+		JID:      presence.Mix.JID,
+	})
+	return nil
+}
@@ -0,0 +1,283 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package mix_test
+
+import (
+	"context"
+	"encoding/xml"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mix"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+var channelJID = jid.MustParse("coven@mix.shakespeare.example")
+
+func TestJoinLeave(t *testing.T) {
+	type joinRequest struct {
+		Channel string `xml:"channel,attr"`
+		Join    struct {
+			Subscribe []struct {
+				Node string `xml:"node,attr"`
+			} `xml:"subscribe"`
+			Nick string `xml:"nick"`
+		} `xml:"urn:xmpp:mix:core:1 join"`
+	}
+	requests := make(chan joinRequest, 1)
+	left := make(chan string, 1)
+	s := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(
+			mux.IQFunc(stanza.SetIQ, xml.Name{Space: mix.NSPAM, Local: "client-join"}, func(iq stanza.IQ, r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+				var req joinRequest
+				err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), r)).Decode(&req)
+				if err != nil {
+					return err
+				}
+				requests <- req
+				_, err = xmlstream.Copy(r, iq.Result(xmlstream.Wrap(
+					xmlstream.Wrap(
+						xmlstream.MultiReader(
+							xmlstream.Wrap(nil, xml.StartElement{
+								Name: xml.Name{Local: "subscribe"},
+								Attr: []xml.Attr{{Name: xml.Name{Local: "node"}, Value: mix.NodeMessages}},
+							}),
+							xmlstream.Wrap(
+								xmlstream.Token(xml.CharData("thirdwitch")),
+								xml.StartElement{Name: xml.Name{Local: "nick"}},
+							),
+						),
+						xml.StartElement{
+							Name: xml.Name{Space: mix.NS, Local: "join"},
+							Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: "123456"}},
+						},
+					),
+					xml.StartElement{Name: xml.Name{Space: mix.NSPAM, Local: "client-join"}},
+				)))
+				return err
+			}),
+			mux.IQFunc(stanza.SetIQ, xml.Name{Space: mix.NSPAM, Local: "client-leave"}, func(iq stanza.IQ, r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+				req := struct {
+					Channel string `xml:"channel,attr"`
+				}{}
+				err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), r)).Decode(&req)
+				if err != nil {
+					return err
+				}
+				left <- req.Channel
+				_, err = xmlstream.Copy(r, iq.Result(xmlstream.Wrap(
+					xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: mix.NS, Local: "leave"}}),
+					xml.StartElement{Name: xml.Name{Space: mix.NSPAM, Local: "client-leave"}},
+				)))
+				return err
+			}),
+			mux.IQFunc(stanza.SetIQ, xml.Name{Space: mix.NS, Local: "setnick"}, func(iq stanza.IQ, r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+				// The channel assigns a different nickname than the one requested.
+				_, err := xmlstream.Copy(r, iq.Result(xmlstream.Wrap(
+					xmlstream.Wrap(
+						xmlstream.Token(xml.CharData("oldhag")),
+						xml.StartElement{Name: xml.Name{Local: "nick"}},
+					),
+					xml.StartElement{Name: xml.Name{Space: mix.NS, Local: "setnick"}},
+				)))
+				return err
+			}),
+			mux.IQFunc(stanza.SetIQ, xml.Name{Space: mix.NS, Local: "update-subscription"}, func(iq stanza.IQ, r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+				// Echo back the requested changes.
+				_, err := xmlstream.Copy(r, iq.Result(xmlstream.MultiReader(
+					xmlstream.Token(*start),
+					xmlstream.Inner(r),
+					xmlstream.Token(start.End()),
+				)))
+				return err
+			}),
+		)),
+	)
+
+	ctx := context.Background()
+	channel, err := mix.Join(ctx, channelJID, s.Client, mix.Nick("thirdwitch"), mix.Subscribe(mix.NodeMessages, mix.NodeBanned))
+	if err != nil {
+		t.Fatalf("error joining: %v", err)
+	}
+	req := <-requests
+	if req.Channel != channelJID.String() {
+		t.Errorf("wrong channel: want=%s, got=%s", channelJID, req.Channel)
+	}
+	if req.Join.Nick != "thirdwitch" {
+		t.Errorf("wrong nick in join request: %q", req.Join.Nick)
+	}
+	if len(req.Join.Subscribe) != 2 || req.Join.Subscribe[1].Node != mix.NodeBanned {
+		t.Errorf("wrong subscriptions in join request: %+v", req.Join.Subscribe)
+	}
+	if id := channel.ID(); id != "123456" {
+		t.Errorf("wrong participant ID: %q", id)
+	}
+	if nick := channel.Nick(); nick != "thirdwitch" {
+		t.Errorf("wrong nick: %q", nick)
+	}
+	// The channel only accepted one of our subscriptions.
+	if nodes := channel.Nodes(); !reflect.DeepEqual(nodes, []string{mix.NodeMessages}) {
+		t.Errorf("wrong nodes: %v", nodes)
+	}
+
+	err = channel.SetNick(ctx, "secondwitch")
+	if err != nil {
+		t.Fatalf("error setting nick: %v", err)
+	}
+	if nick := channel.Nick(); nick != "oldhag" {
+		t.Errorf("expected assigned nick, got %q", nick)
+	}
+
+	err = channel.Subscribe(ctx, mix.NodePresence, mix.NodeInfo)
+	if err != nil {
+		t.Fatalf("error subscribing: %v", err)
+	}
+	err = channel.Unsubscribe(ctx, mix.NodeMessages)
+	if err != nil {
+		t.Fatalf("error unsubscribing: %v", err)
+	}
+	if nodes := channel.Nodes(); !reflect.DeepEqual(nodes, []string{mix.NodePresence, mix.NodeInfo}) {
+		t.Errorf("wrong nodes after updating subscription: %v", nodes)
+	}
+
+	err = channel.Leave(ctx)
+	if err != nil {
+		t.Fatalf("error leaving: %v", err)
+	}
+	if c := <-left; c != channelJID.String() {
+		t.Errorf("left wrong channel: %s", c)
+	}
+}
+
+const eventsXML = `
+<message from='coven@mix.shakespeare.example' to='hag66@shakespeare.example' id='foo' type='groupchat'>
+  <body>Harpier cries: 'tis time, 'tis time.</body>
+  <mix xmlns='urn:xmpp:mix:core:1'>
+    <nick>thirdwitch</nick>
+    <jid>hag66@shakespeare.example</jid>
+  </mix>
+</message>
+<message from='coven@mix.shakespeare.example' to='hag66@shakespeare.example' id='bar'>
+  <event xmlns='http://jabber.org/protocol/pubsub#event'>
+    <items node='urn:xmpp:mix:nodes:participants'>
+      <item id='123457'>
+        <participant xmlns='urn:xmpp:mix:core:1'>
+          <nick>fourthwitch</nick>
+          <jid>hecate@shakespeare.example</jid>
+        </participant>
+      </item>
+      <retract id='123456'/>
+    </items>
+  </event>
+</message>
+<message from='coven@mix.shakespeare.example' to='hag66@shakespeare.example' id='baz'>
+  <event xmlns='http://jabber.org/protocol/pubsub#event'>
+    <items node='urn:xmpp:mix:nodes:info'>
+      <item id='2016-05-30T09:00:00'>
+        <x xmlns='jabber:x:data' type='result'>
+          <field var='FORM_TYPE' type='hidden'>
+            <value>urn:xmpp:mix:core:1</value>
+          </field>
+          <field var='Name'>
+            <value>Witches Coven</value>
+          </field>
+          <field var='Description'>
+            <value>A location not far from the blasted heath where the three witches meet</value>
+          </field>
+          <field var='Contact' type='jid-multi'>
+            <value>greymalkin@shakespeare.example</value>
+            <value>joan@shakespeare.example</value>
+          </field>
+        </x>
+      </item>
+    </items>
+  </event>
+</message>
+<presence from='coven@mix.shakespeare.example/123457' to='hag66@shakespeare.example'>
+  <show>dnd</show>
+  <mix xmlns='urn:xmpp:mix:presence:0'>
+    <jid>hecate@shakespeare.example/broom</jid>
+    <nick>fourthwitch</nick>
+  </mix>
+</presence>`
+
+func TestClient(t *testing.T) {
+	events := make(chan interface{}, 10)
+	h := &mix.Client{
+		HandleMessage: func(m mix.Message) {
+			events <- m
+		},
+		HandleParticipant: func(p mix.Participant) {
+			events <- p
+		},
+		HandlePresence: func(p mix.Presence) {
+			events <- p
+		},
+		HandleInfo: func(i mix.Info) {
+			events <- i
+		},
+	}
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(mix.HandleClient(h))),
+	)
+	// Remove indentation between elements.
+	d := xml.NewDecoder(strings.NewReader(regexp.MustCompile(`>\s+<`).ReplaceAllString(eventsXML, "><")))
+	iter := xmlstream.NewIter(d)
+	for iter.Next() {
+		start, inner := iter.Current()
+		if start == nil {
+			continue
+		}
+		err := s.Server.Send(context.Background(), xmlstream.MultiReader(
+			xmlstream.Token(*start),
+			inner,
+		))
+		if err != nil {
+			t.Fatalf("error sending: %v", err)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatalf("error iterating over events: %v", err)
+	}
+
+	hag := jid.MustParse("hag66@shakespeare.example")
+	hecate := jid.MustParse("hecate@shakespeare.example")
+
+	msg := (<-events).(mix.Message)
+	if msg.Body != "Harpier cries: 'tis time, 'tis time." || msg.Nick != "thirdwitch" || !msg.JID.Equal(hag) || msg.ID != "foo" {
+		t.Errorf("wrong message: %+v", msg)
+	}
+	want := []interface{}{
+		mix.Participant{Channel: channelJID, ID: "123457", Nick: "fourthwitch", JID: hecate},
+		mix.Participant{Channel: channelJID, ID: "123456", Left: true},
+		mix.Info{
+			Channel:     channelJID,
+			Name:        "Witches Coven",
+			Description: "A location not far from the blasted heath where the three witches meet",
+			Contact: []jid.JID{
+				jid.MustParse("greymalkin@shakespeare.example"),
+				jid.MustParse("joan@shakespeare.example"),
+			},
+		},
+	}
+	for i, w := range want {
+		if e := <-events; !reflect.DeepEqual(e, w) {
+			t.Errorf("wrong event %d:\nwant=%+v,\n got=%+v", i, w, e)
+		}
+	}
+	presence := (<-events).(mix.Presence)
+	if presence.Nick != "fourthwitch" || !presence.JID.Equal(jid.MustParse("hecate@shakespeare.example/broom")) {
+		t.Errorf("wrong presence: %+v", presence)
+	}
+	if presence.From.Resourcepart() != "123457" {
+		t.Errorf("wrong presence from: %v", presence.From)
+	}
+}
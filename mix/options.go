@@ -0,0 +1,30 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package mix
+
+type config struct {
+	nick  string
+	nodes []string
+}
+
+// Option is used to configure joining a channel.
+type Option func(*config)
+
+// Nick sets the nickname to use in the channel when joining.
+// If no nickname is set, one may be assigned by the channel or set later using
+// SetNick.
+func Nick(n string) Option {
+	return func(c *config) {
+		c.nick = n
+	}
+}
+
+// Subscribe sets the nodes to subscribe to when joining the channel, replacing
+// the default nodes.
+func Subscribe(nodes ...string) Option {
+	return func(c *config) {
+		c.nodes = nodes
+	}
+}
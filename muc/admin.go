@@ -0,0 +1,267 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// Errors returned by moderation and administration requests when the room
+// rejects them.
+// The original stanza.Error is still available using errors.As.
+var (
+	// ErrForbidden is returned when we do not have the affiliation or role
+	// required to perform an action.
+	ErrForbidden = errors.New("muc: insufficient privileges")
+
+	// ErrNotAllowed is returned when the action is not permitted regardless of
+	// privileges, for example attempting to kick a user with a higher
+	// affiliation.
+	ErrNotAllowed = errors.New("muc: action not allowed")
+
+	// ErrConflict is returned when an affiliation change would conflict with
+	// the state of the room, for example removing the last owner.
+	ErrConflict = errors.New("muc: conflicting change")
+
+	// ErrNotFound is returned when the target of an action does not exist.
+	ErrNotFound = errors.New("muc: not found")
+)
+
+type adminError struct {
+	sentinel error
+	err      stanza.Error
+}
+
+func (e adminError) Error() string {
+	return e.sentinel.Error() + ": " + e.err.Error()
+}
+
+func (e adminError) Is(target error) bool {
+	return target == e.sentinel
+}
+
+func (e adminError) Unwrap() error {
+	return e.err
+}
+
+// mapError converts stanza errors that indicate a lack of privileges into
+// errors that can be compared against the sentinel errors in this package.
+func mapError(err error) error {
+	var stanzaErr stanza.Error
+	if !errors.As(err, &stanzaErr) {
+		return err
+	}
+	switch stanzaErr.Condition {
+	case stanza.Forbidden:
+		return adminError{sentinel: ErrForbidden, err: stanzaErr}
+	case stanza.NotAllowed:
+		return adminError{sentinel: ErrNotAllowed, err: stanzaErr}
+	case stanza.Conflict:
+		return adminError{sentinel: ErrConflict, err: stanzaErr}
+	case stanza.ItemNotFound:
+		return adminError{sentinel: ErrNotFound, err: stanzaErr}
+	}
+	return err
+}
+
+func optionalReason(reason string) xml.TokenReader {
+	return optionalString(reason, xml.Name{Local: "reason"})
+}
+
+// adminItem sends a muc#admin query containing a single item.
+func (c *Channel) adminItem(ctx context.Context, attr []xml.Attr, reason string) error {
+	payload := xmlstream.Wrap(
+		xmlstream.Wrap(
+			optionalReason(reason),
+			xml.StartElement{
+				Name: xml.Name{Local: "item"},
+				Attr: attr,
+			},
+		),
+		xml.StartElement{Name: xml.Name{Space: NSAdmin, Local: "query"}},
+	)
+	err := c.session.UnmarshalIQElement(ctx, payload, stanza.IQ{
+		Type: stanza.SetIQ,
+		To:   c.Addr(),
+	}, nil)
+	return mapError(err)
+}
+
+// SetRole changes the role of the occupant with the provided nickname.
+// Roles only last for the duration of the occupants visit to the room.
+func (c *Channel) SetRole(ctx context.Context, r Role, nick, reason string) error {
+	return c.adminItem(ctx, []xml.Attr{
+		{Name: xml.Name{Local: "nick"}, Value: nick},
+		{Name: xml.Name{Local: "role"}, Value: r.String()},
+	}, reason)
+}
+
+// Kick removes the occupant with the provided nickname from the room by
+// setting their role to none.
+// The occupant may rejoin the room later.
+func (c *Channel) Kick(ctx context.Context, nick, reason string) error {
+	return c.SetRole(ctx, RoleNone, nick, reason)
+}
+
+// Ban removes the user from the room and prevents them from joining again by
+// setting their affiliation to outcast.
+// The JID should be the users real bare JID (not their room JID).
+func (c *Channel) Ban(ctx context.Context, j jid.JID, reason string) error {
+	return c.SetAffiliation(ctx, AffiliationOutcast, j, "", reason)
+}
+
+// Affiliations returns an iterator over all users with the provided
+// affiliation.
+// Retrieving the list normally requires that we be an admin or owner of the
+// room.
+//
+// The iterator must be closed before anything else is done on the session.
+// Any errors encountered while creating the iter are deferred until the iter is
+// used.
+func (c *Channel) Affiliations(ctx context.Context, a Affiliation) *Iter {
+	return c.list(ctx, xml.Attr{Name: xml.Name{Local: "affiliation"}, Value: a.String()})
+}
+
+// Roles returns an iterator over all occupants with the provided role.
+// For more information see Affiliations.
+func (c *Channel) Roles(ctx context.Context, r Role) *Iter {
+	return c.list(ctx, xml.Attr{Name: xml.Name{Local: "role"}, Value: r.String()})
+}
+
+// Members is a convenience function that returns an iterator over all users
+// with the member affiliation.
+// For more information see Affiliations.
+func (c *Channel) Members(ctx context.Context) *Iter {
+	return c.Affiliations(ctx, AffiliationMember)
+}
+
+// Admins is a convenience function that returns an iterator over all users
+// with the admin affiliation.
+// For more information see Affiliations.
+func (c *Channel) Admins(ctx context.Context) *Iter {
+	return c.Affiliations(ctx, AffiliationAdmin)
+}
+
+// Owners is a convenience function that returns an iterator over all users
+// with the owner affiliation.
+// For more information see Affiliations.
+func (c *Channel) Owners(ctx context.Context) *Iter {
+	return c.Affiliations(ctx, AffiliationOwner)
+}
+
+// Outcasts is a convenience function that returns an iterator over all banned
+// users.
+// For more information see Affiliations.
+func (c *Channel) Outcasts(ctx context.Context) *Iter {
+	return c.Affiliations(ctx, AffiliationOutcast)
+}
+
+func (c *Channel) list(ctx context.Context, attr xml.Attr) *Iter {
+	iter, err := c.session.IterIQElement(ctx, xmlstream.Wrap(
+		xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: "item"},
+			Attr: []xml.Attr{attr},
+		}),
+		xml.StartElement{Name: xml.Name{Space: NSAdmin, Local: "query"}},
+	), stanza.IQ{
+		Type: stanza.GetIQ,
+		To:   c.Addr(),
+	})
+	if err != nil {
+		return &Iter{err: mapError(err)}
+	}
+	return &Iter{iter: iter}
+}
+
+// Destroy destroys the room.
+// All occupants are removed and informed of the reason and the address of an
+// alternate venue and its password, if any.
+// Destroying a room requires that we be an owner of the room.
+func (c *Channel) Destroy(ctx context.Context, reason string, alternate jid.JID, password string) error {
+	var attr []xml.Attr
+	if !alternate.Equal(jid.JID{}) {
+		attr = append(attr, xml.Attr{Name: xml.Name{Local: "jid"}, Value: alternate.Bare().String()})
+	}
+	payload := xmlstream.Wrap(
+		xmlstream.Wrap(
+			xmlstream.MultiReader(
+				optionalReason(reason),
+				optionalString(password, xml.Name{Local: "password"}),
+			),
+			xml.StartElement{
+				Name: xml.Name{Local: "destroy"},
+				Attr: attr,
+			},
+		),
+		xml.StartElement{Name: xml.Name{Space: NSOwner, Local: "query"}},
+	)
+	err := c.session.UnmarshalIQElement(ctx, payload, stanza.IQ{
+		Type: stanza.SetIQ,
+		To:   c.Addr(),
+	}, nil)
+	return mapError(err)
+}
+
+// Iter is an iterator over room users returned by queries such as Members or
+// Roles.
+type Iter struct {
+	iter    *xmlstream.Iter
+	current Item
+	err     error
+}
+
+// Next returns true if there are more items to decode.
+func (i *Iter) Next() bool {
+	if i.err != nil || !i.iter.Next() {
+		return false
+	}
+	start, r := i.iter.Current()
+	// If we encounter a lone token that doesn't begin with a start element (eg.
+	// a comment) skip it. This should never happen with XMPP, but we don't want
+	// to panic in case this somehow happens so just skip it.
+	if start == nil {
+		return i.Next()
+	}
+	d := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), r))
+	item := Item{}
+	i.err = d.Decode(&item)
+	if i.err != nil {
+		return false
+	}
+	i.current = item
+	return true
+}
+
+// Err returns the last error encountered by the iterator (if any).
+func (i *Iter) Err() error {
+	if i.err != nil {
+		return i.err
+	}
+	if i.iter == nil {
+		return nil
+	}
+	return i.iter.Err()
+}
+
+// Item returns the last item parsed by the iterator.
+func (i *Iter) Item() Item {
+	return i.current
+}
+
+// Close indicates that we are finished with the given iterator and processing
+// the stream may continue.
+// Calling it multiple times has no effect.
+func (i *Iter) Close() error {
+	if i.iter == nil {
+		return nil
+	}
+	return i.iter.Close()
+}
@@ -0,0 +1,192 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// joinedChannel returns a channel joined to a test server that records the
+// inner payload of any IQ sent to the room and replies with resp.
+// If resp is nil, an empty result is returned.
+func joinedChannel(t *testing.T, handled chan<- string, resp func(iq stanza.IQ) xml.TokenReader) *muc.Channel {
+	t.Helper()
+	j := jid.MustParse("room@example.net/me")
+	h := &muc.Client{}
+	m := mux.New(muc.HandleClient(h))
+	iqHandler := func(iq stanza.IQ, r xmlstream.TokenReadEncoder, _ *xml.StartElement) error {
+		var buf strings.Builder
+		e := xml.NewEncoder(&buf)
+		_, err := xmlstream.Copy(e, xmlstream.Inner(r))
+		if err != nil {
+			return err
+		}
+		err = e.Flush()
+		if err != nil {
+			return err
+		}
+		handled <- buf.String()
+		if resp == nil {
+			_, err = xmlstream.Copy(r, iq.Result(nil))
+			return err
+		}
+		_, err = xmlstream.Copy(r, resp(iq))
+		return err
+	}
+	server := mux.New(
+		mux.PresenceFunc("", xml.Name{Local: "x"}, func(p stanza.Presence, r xmlstream.TokenReadEncoder) error {
+			// Send back a self presence, indicating that the join is complete.
+			p.To, p.From = p.From, p.To
+			_, err := xmlstream.Copy(r, p.Wrap(xmlstream.Wrap(
+				nil,
+				xml.StartElement{Name: xml.Name{Space: muc.NSUser, Local: "x"}},
+			)))
+			return err
+		}),
+		mux.IQFunc(stanza.SetIQ, xml.Name{Space: muc.NSAdmin, Local: "query"}, iqHandler),
+		mux.IQFunc(stanza.GetIQ, xml.Name{Space: muc.NSAdmin, Local: "query"}, iqHandler),
+		mux.IQFunc(stanza.SetIQ, xml.Name{Space: muc.NSOwner, Local: "query"}, iqHandler),
+	)
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(m),
+		xmpptest.ServerHandler(server),
+	)
+
+	channel, err := h.Join(context.Background(), j, s.Client)
+	if err != nil {
+		t.Fatalf("error joining: %v", err)
+	}
+	return channel
+}
+
+func TestKick(t *testing.T) {
+	handled := make(chan string, 1)
+	channel := joinedChannel(t, handled, nil)
+	err := channel.Kick(context.Background(), "pistol", "Avaunt, you cullion!")
+	if err != nil {
+		t.Fatalf("error kicking: %v", err)
+	}
+	const expected = `<item xmlns="http://jabber.org/protocol/muc#admin" nick="pistol" role="none"><reason xmlns="http://jabber.org/protocol/muc#admin">Avaunt, you cullion!</reason></item>`
+	if x := <-handled; x != expected {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", expected, x)
+	}
+}
+
+func TestBan(t *testing.T) {
+	handled := make(chan string, 1)
+	channel := joinedChannel(t, handled, nil)
+	err := channel.Ban(context.Background(), jid.MustParse("earlofcambridge@shakespeare.lit/desktop"), "Treason")
+	if err != nil {
+		t.Fatalf("error banning: %v", err)
+	}
+	const expected = `<item xmlns="http://jabber.org/protocol/muc#admin" affiliation="outcast" jid="earlofcambridge@shakespeare.lit"><reason xmlns="http://jabber.org/protocol/muc#admin">Treason</reason></item>`
+	if x := <-handled; x != expected {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", expected, x)
+	}
+}
+
+func TestDestroy(t *testing.T) {
+	handled := make(chan string, 1)
+	channel := joinedChannel(t, handled, nil)
+	err := channel.Destroy(context.Background(), "Macbeth doth come.", jid.MustParse("coven@chat.shakespeare.lit"), "cauldronburn")
+	if err != nil {
+		t.Fatalf("error destroying: %v", err)
+	}
+	const expected = `<destroy xmlns="http://jabber.org/protocol/muc#owner" jid="coven@chat.shakespeare.lit"><reason xmlns="http://jabber.org/protocol/muc#owner">Macbeth doth come.</reason><password xmlns="http://jabber.org/protocol/muc#owner">cauldronburn</password></destroy>`
+	if x := <-handled; x != expected {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", expected, x)
+	}
+}
+
+func TestMembers(t *testing.T) {
+	handled := make(chan string, 1)
+	channel := joinedChannel(t, handled, func(iq stanza.IQ) xml.TokenReader {
+		return iq.Result(xmlstream.Wrap(
+			xmlstream.MultiReader(
+				xmlstream.Wrap(nil, xml.StartElement{
+					Name: xml.Name{Local: "item"},
+					Attr: []xml.Attr{
+						{Name: xml.Name{Local: "affiliation"}, Value: "member"},
+						{Name: xml.Name{Local: "jid"}, Value: "hag66@shakespeare.lit"},
+						{Name: xml.Name{Local: "nick"}, Value: "thirdwitch"},
+						{Name: xml.Name{Local: "role"}, Value: "participant"},
+					},
+				}),
+				xmlstream.Wrap(nil, xml.StartElement{
+					Name: xml.Name{Local: "item"},
+					Attr: []xml.Attr{
+						{Name: xml.Name{Local: "affiliation"}, Value: "member"},
+						{Name: xml.Name{Local: "jid"}, Value: "hecate@shakespeare.lit"},
+					},
+				}),
+			),
+			xml.StartElement{Name: xml.Name{Space: muc.NSAdmin, Local: "query"}},
+		))
+	})
+	iter := channel.Members(context.Background())
+	var items []muc.Item
+	for iter.Next() {
+		items = append(items, iter.Item())
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatalf("error iterating over members: %v", err)
+	}
+	err := iter.Close()
+	if err != nil {
+		t.Fatalf("error closing iter: %v", err)
+	}
+	const expected = `<item xmlns="http://jabber.org/protocol/muc#admin" affiliation="member"></item>`
+	if x := <-handled; x != expected {
+		t.Errorf("wrong output:\nwant=%s,\n got=%s", expected, x)
+	}
+	if len(items) != 2 {
+		t.Fatalf("wrong number of items: want=2, got=%d", len(items))
+	}
+	if items[0].Nick != "thirdwitch" || items[0].Role != muc.RoleParticipant || items[0].Affiliation != muc.AffiliationMember {
+		t.Errorf("wrong first item: %+v", items[0])
+	}
+	if !items[1].JID.Equal(jid.MustParse("hecate@shakespeare.lit")) {
+		t.Errorf("wrong JID: want=hecate@shakespeare.lit, got=%v", items[1].JID)
+	}
+}
+
+func TestAdminForbidden(t *testing.T) {
+	handled := make(chan string, 1)
+	channel := joinedChannel(t, handled, func(iq stanza.IQ) xml.TokenReader {
+		return iq.Error(stanza.Error{
+			Type:      stanza.Auth,
+			Condition: stanza.Forbidden,
+		})
+	})
+	err := channel.SetRole(context.Background(), muc.RoleVisitor, "thirdwitch", "")
+	<-handled
+	if !errors.Is(err, muc.ErrForbidden) {
+		t.Errorf("wrong error: want=%v, got=%v", muc.ErrForbidden, err)
+	}
+	var stanzaErr stanza.Error
+	if !errors.As(err, &stanzaErr) || stanzaErr.Condition != stanza.Forbidden {
+		t.Errorf("expected underlying stanza error, got %v", err)
+	}
+
+	iter := channel.Outcasts(context.Background())
+	<-handled
+	if iter.Next() {
+		t.Errorf("did not expect iter to have items")
+	}
+	if err := iter.Err(); !errors.Is(err, muc.ErrForbidden) {
+		t.Errorf("wrong iter error: want=%v, got=%v", muc.ErrForbidden, err)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// ErrRoomExists is returned by Create if the room already existed.
+var ErrRoomExists = errors.New("muc: room already exists")
+
+// Create joins a room that does not yet exist, creating it, and then unlocks
+// it so that other users may join.
+// Room should be a full JID in which the desired nickname is the resourcepart.
+//
+// If configure is nil, an instant room is created using the default
+// configuration.
+// Otherwise a reserved room is created: the owner configuration form is
+// requested and passed to configure, which may set any values before the form
+// is submitted.
+// If configure returns an error, configuration is canceled (which destroys the
+// room) and the error is returned.
+//
+// If the room already existed, it is left and ErrRoomExists is returned.
+func (c *Client) Create(ctx context.Context, room jid.JID, s *xmpp.Session, configure func(*form.Data) error, opt ...Option) (*Channel, error) {
+	return c.CreatePresence(ctx, stanza.Presence{
+		To: room,
+	}, s, configure, opt...)
+}
+
+// CreatePresence is like Create except that it gives you more control over the
+// presence.
+// Changing the presence type has no effect.
+func (c *Client) CreatePresence(ctx context.Context, p stanza.Presence, s *xmpp.Session, configure func(*form.Data) error, opt ...Option) (*Channel, error) {
+	channel, err := c.JoinPresence(ctx, p, s, opt...)
+	if err != nil {
+		return channel, err
+	}
+	if !channel.created {
+		err = channel.Leave(ctx, "")
+		if err != nil {
+			return channel, err
+		}
+		return channel, ErrRoomExists
+	}
+
+	room := channel.Addr()
+	if configure == nil {
+		return channel, SetConfig(ctx, room, form.New(), s)
+	}
+
+	config, err := GetConfig(ctx, room, s)
+	if err != nil {
+		return channel, err
+	}
+	err = configure(config)
+	if err != nil {
+		cancelErr := cancelConfig(ctx, room, s)
+		if cancelErr != nil {
+			return channel, cancelErr
+		}
+		return channel, err
+	}
+	return channel, SetConfig(ctx, room, config, s)
+}
+
+// cancelConfig cancels the initial configuration of a reserved room, causing it
+// to be destroyed.
+func cancelConfig(ctx context.Context, room jid.JID, s *xmpp.Session) error {
+	err := s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		form.Cancel("", "").TokenReader(),
+		xml.StartElement{Name: xml.Name{Space: NSOwner, Local: "query"}},
+	), stanza.IQ{
+		Type: stanza.SetIQ,
+		To:   room,
+	}, nil)
+	return mapError(err)
+}
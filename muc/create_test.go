@@ -0,0 +1,248 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+const configFormType = "http://jabber.org/protocol/muc#roomconfig"
+
+type ownerPayload struct {
+	typ  string
+	data *form.Data
+}
+
+// createServer returns a client and session connected to a server that creates
+// a room (or joins an existing room if exists is true) and records any owner
+// forms that are submitted.
+func createServer(t *testing.T, exists bool, owner chan<- ownerPayload) (*muc.Client, *xmpp.Session) {
+	t.Helper()
+	h := &muc.Client{}
+	selfPresence := func(p stanza.Presence, r xmlstream.TokenReadEncoder, codes ...string) error {
+		p.To, p.From = p.From, p.To
+		var status []xml.TokenReader
+		for _, code := range codes {
+			status = append(status, xmlstream.Wrap(nil, xml.StartElement{
+				Name: xml.Name{Local: "status"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "code"}, Value: code}},
+			}))
+		}
+		_, err := xmlstream.Copy(r, p.Wrap(xmlstream.Wrap(
+			xmlstream.MultiReader(status...),
+			xml.StartElement{Name: xml.Name{Space: muc.NSUser, Local: "x"}},
+		)))
+		return err
+	}
+	server := mux.New(
+		mux.PresenceFunc("", xml.Name{Local: "x"}, func(p stanza.Presence, r xmlstream.TokenReadEncoder) error {
+			if exists {
+				return selfPresence(p, r, "110")
+			}
+			return selfPresence(p, r, "110", "201")
+		}),
+		mux.PresenceFunc(stanza.UnavailablePresence, xml.Name{}, func(p stanza.Presence, r xmlstream.TokenReadEncoder) error {
+			return selfPresence(p, r, "110")
+		}),
+		mux.IQFunc(stanza.GetIQ, xml.Name{Space: muc.NSOwner, Local: "query"}, func(iq stanza.IQ, r xmlstream.TokenReadEncoder, _ *xml.StartElement) error {
+			config := form.New(
+				form.Hidden("FORM_TYPE", form.Value(configFormType)),
+				form.Text("muc#roomconfig_roomname"),
+			)
+			_, err := xmlstream.Copy(r, iq.Result(xmlstream.Wrap(
+				config.TokenReader(),
+				xml.StartElement{Name: xml.Name{Space: muc.NSOwner, Local: "query"}},
+			)))
+			return err
+		}),
+		mux.IQFunc(stanza.SetIQ, xml.Name{Space: muc.NSOwner, Local: "query"}, func(iq stanza.IQ, r xmlstream.TokenReadEncoder, _ *xml.StartElement) error {
+			var buf strings.Builder
+			e := xml.NewEncoder(&buf)
+			_, err := xmlstream.Copy(e, xmlstream.Inner(r))
+			if err != nil {
+				return err
+			}
+			err = e.Flush()
+			if err != nil {
+				return err
+			}
+			formType := struct {
+				Type string `xml:"type,attr"`
+			}{}
+			err = xml.Unmarshal([]byte(buf.String()), &formType)
+			if err != nil {
+				return err
+			}
+			data := &form.Data{}
+			err = xml.Unmarshal([]byte(buf.String()), data)
+			if err != nil {
+				return err
+			}
+			owner <- ownerPayload{typ: formType.Type, data: data}
+			_, err = xmlstream.Copy(r, iq.Result(nil))
+			return err
+		}),
+	)
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(muc.HandleClient(h))),
+		xmpptest.ServerHandler(server),
+	)
+	return h, s.Client
+}
+
+func TestCreateInstant(t *testing.T) {
+	owner := make(chan ownerPayload, 1)
+	h, s := createServer(t, false, owner)
+	_, err := h.Create(context.Background(), jid.MustParse("coven@chat.shakespeare.lit/firstwitch"), s, nil)
+	if err != nil {
+		t.Fatalf("error creating room: %v", err)
+	}
+	if x := <-owner; x.typ != string(form.TypeSubmit) {
+		t.Errorf("wrong form type: want=%s, got=%s", form.TypeSubmit, x.typ)
+	}
+}
+
+func TestCreateReserved(t *testing.T) {
+	owner := make(chan ownerPayload, 1)
+	h, s := createServer(t, false, owner)
+	_, err := h.Create(context.Background(), jid.MustParse("coven@chat.shakespeare.lit/firstwitch"), s, func(data *form.Data) error {
+		_, err := data.Set("muc#roomconfig_roomname", "A Dark Cave")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("error creating room: %v", err)
+	}
+	x := <-owner
+	if x.typ != string(form.TypeSubmit) {
+		t.Errorf("wrong form type: want=%s, got=%s", form.TypeSubmit, x.typ)
+	}
+	const expected = "A Dark Cave"
+	if name, _ := x.data.GetString("muc#roomconfig_roomname"); name != expected {
+		t.Errorf("wrong room name: want=%q, got=%q", expected, name)
+	}
+}
+
+func TestCreateReservedCancel(t *testing.T) {
+	owner := make(chan ownerPayload, 1)
+	h, s := createServer(t, false, owner)
+	errCancel := errors.New("cancel")
+	_, err := h.Create(context.Background(), jid.MustParse("coven@chat.shakespeare.lit/firstwitch"), s, func(*form.Data) error {
+		return errCancel
+	})
+	if err != errCancel {
+		t.Fatalf("wrong error: want=%v, got=%v", errCancel, err)
+	}
+	if x := <-owner; x.typ != string(form.TypeCancel) {
+		t.Errorf("wrong form type: want=%s, got=%s", form.TypeCancel, x.typ)
+	}
+}
+
+func TestCreateExists(t *testing.T) {
+	h, s := createServer(t, true, nil)
+	channel, err := h.Create(context.Background(), jid.MustParse("coven@chat.shakespeare.lit/firstwitch"), s, nil)
+	if err != muc.ErrRoomExists {
+		t.Fatalf("wrong error: want=%v, got=%v", muc.ErrRoomExists, err)
+	}
+	if channel.Joined() {
+		t.Errorf("expected room to be left after failing to create it")
+	}
+}
+
+func TestRegister(t *testing.T) {
+	const nick = "thirdwitch"
+	submitted := make(chan string, 1)
+	var registered bool
+	s := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(
+			mux.IQFunc(stanza.GetIQ, xml.Name{Space: muc.NSRegister, Local: "query"}, func(iq stanza.IQ, r xmlstream.TokenReadEncoder, _ *xml.StartElement) error {
+				payload := form.New(
+					form.Hidden("FORM_TYPE", form.Value("http://jabber.org/protocol/muc#register")),
+					form.Text("muc#register_roomnick", form.Required),
+				).TokenReader()
+				if registered {
+					payload = xmlstream.MultiReader(
+						xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Local: "registered"}}),
+						xmlstream.Wrap(xmlstream.Token(xml.CharData(nick)), xml.StartElement{Name: xml.Name{Local: "username"}}),
+					)
+				}
+				_, err := xmlstream.Copy(r, iq.Result(xmlstream.Wrap(
+					payload,
+					xml.StartElement{Name: xml.Name{Space: muc.NSRegister, Local: "query"}},
+				)))
+				return err
+			}),
+			mux.IQFunc(stanza.SetIQ, xml.Name{Space: muc.NSRegister, Local: "query"}, func(iq stanza.IQ, r xmlstream.TokenReadEncoder, _ *xml.StartElement) error {
+				data := &form.Data{}
+				err := xml.NewTokenDecoder(xmlstream.Inner(r)).Decode(data)
+				if err != nil {
+					return err
+				}
+				n, _ := data.GetString("muc#register_roomnick")
+				submitted <- n
+				registered = true
+				_, err = xmlstream.Copy(r, iq.Result(nil))
+				return err
+			}),
+			mux.IQFunc(stanza.GetIQ, xml.Name{Space: disco.NSInfo, Local: "query"}, func(iq stanza.IQ, r xmlstream.TokenReadEncoder, _ *xml.StartElement) error {
+				_, err := xmlstream.Copy(r, iq.Result(xmlstream.Wrap(
+					xmlstream.Wrap(nil, xml.StartElement{
+						Name: xml.Name{Local: "identity"},
+						Attr: []xml.Attr{
+							{Name: xml.Name{Local: "category"}, Value: "conference"},
+							{Name: xml.Name{Local: "name"}, Value: nick},
+							{Name: xml.Name{Local: "type"}, Value: "text"},
+						},
+					}),
+					xml.StartElement{Name: xml.Name{Space: disco.NSInfo, Local: "query"}},
+				)))
+				return err
+			}),
+		)),
+	)
+	room := jid.MustParse("coven@chat.shakespeare.lit")
+
+	data, err := muc.GetRegisterForm(context.Background(), room, s.Client)
+	if err != nil {
+		t.Fatalf("error fetching registration form: %v", err)
+	}
+	_, err = data.Set("muc#register_roomnick", nick)
+	if err != nil {
+		t.Fatalf("error setting nick: %v", err)
+	}
+	err = muc.Register(context.Background(), room, data, s.Client)
+	if err != nil {
+		t.Fatalf("error registering: %v", err)
+	}
+	if n := <-submitted; n != nick {
+		t.Errorf("wrong nick submitted: want=%q, got=%q", nick, n)
+	}
+
+	_, err = muc.GetRegisterForm(context.Background(), room, s.Client)
+	if err != muc.ErrRegistered {
+		t.Errorf("wrong error: want=%v, got=%v", muc.ErrRegistered, err)
+	}
+
+	n, err := muc.ReservedNick(context.Background(), room, s.Client)
+	if err != nil {
+		t.Fatalf("error fetching reserved nick: %v", err)
+	}
+	if n != nick {
+		t.Errorf("wrong reserved nick: want=%q, got=%q", nick, n)
+	}
+}
@@ -12,6 +12,8 @@
 // This is best left up to the user who may want to use a distributed datastore
 // to keep track of users in a large system for searching many public channels,
 // or may want a simple in-memory map for a small client.
+// For the latter case an opt-in Tracker is provided that keeps track of the
+// occupants and subject of every joined channel.
 //
 // The main entrypoint into the muc package (for clients) is the Client type.
 // It can be used to join MUCs and has callbacks for receiving MUC events such
@@ -37,6 +39,7 @@ import (
 	"context"
 	"encoding/xml"
 	"sync"
+	"time"
 
 	"mellium.im/xmlstream"
 	"mellium.im/xmpp"
@@ -53,6 +56,10 @@ const (
 	NSOwner = `http://jabber.org/protocol/muc#owner`
 	NSAdmin = `http://jabber.org/protocol/muc#admin`
 
+	// NSRegister is the in-band registration namespace, used by this package
+	// for reserving nicknames.
+	NSRegister = `jabber:iq:register`
+
 	// NSConf is the legacy conference namespace, now only used for direct MUC
 	// invitations and backwards compatibility.
 	NSConf = `jabber:x:conference`
@@ -100,14 +107,11 @@ func SetConfigIQ(ctx context.Context, iq stanza.IQ, form *form.Data, s *xmpp.Ses
 		iq.Type = stanza.SetIQ
 	}
 	submission, _ := form.Submit()
-	r, err := s.SendIQElement(ctx, xmlstream.Wrap(
+	err := s.UnmarshalIQElement(ctx, xmlstream.Wrap(
 		submission,
 		xml.StartElement{Name: xml.Name{Space: NSOwner, Local: "query"}},
-	), iq)
-	if err != nil {
-		return err
-	}
-	return r.Close()
+	), iq, nil)
+	return mapError(err)
 }
 
 // HandleClient returns an option that registers the handler for use with a
@@ -119,6 +123,9 @@ func HandleClient(h *Client) mux.Option {
 		mux.Presence(stanza.AvailablePresence, userPresence, h)(m)
 		mux.Presence(stanza.UnavailablePresence, userPresence, h)(m)
 		mux.Message(stanza.NormalMessage, userPresence, h)(m)
+		if h.Tracker != nil {
+			mux.Message(stanza.GroupChatMessage, xml.Name{Local: "subject"}, h)(m)
+		}
 	}
 }
 
@@ -131,6 +138,32 @@ type Client struct {
 	// HandleInvite will be called if we receive a mediated MUC invitation.
 	HandleInvite       func(Invitation)
 	HandleUserPresence func(stanza.Presence, Item)
+
+	// HandleOccupantPresence, if set, is called for presence from every
+	// occupant of a managed channel, including our own.
+	HandleOccupantPresence func(stanza.Presence, Item)
+
+	// PingInterval, if non-zero, is how often each joined channel is pinged to
+	// check that we are still joined as described in XEP-0410: MUC Self-Ping
+	// (Schrödinger's Chat).
+	// It is also used as the timeout for each ping.
+	PingInterval time.Duration
+
+	// Rejoin is used to decide whether to rejoin channels that self-pings show
+	// we have been removed from.
+	// Channels are rejoined using the original presence and options passed to
+	// Join, including any password.
+	// If Rejoin is nil, channels are never rejoined automatically.
+	Rejoin RejoinPolicy
+
+	// HandleState, if set, is called when we join or leave a channel or when
+	// self-pings show that the state of the channel has changed.
+	HandleState func(*Channel, State)
+
+	// Tracker, if set, keeps track of the occupants and subject of all managed
+	// channels.
+	// It must be set before the client is registered using HandleClient.
+	Tracker *Tracker
 }
 
 // HandleMessage satisfies mux.MessageHandler.
@@ -140,13 +173,29 @@ func (c *Client) HandleMessage(p stanza.Message, r xmlstream.TokenReadEncoder) e
 	d := xml.NewTokenDecoder(r)
 	msg := struct {
 		stanza.Message
-		X Invitation `xml:"http://jabber.org/protocol/muc#user x"`
+		X       Invitation `xml:"http://jabber.org/protocol/muc#user x"`
+		Subject *string    `xml:"subject"`
+		Body    []string   `xml:"body"`
 	}{}
 	err := d.Decode(&msg)
 	if err != nil {
 		return err
 	}
 
+	// A groupchat message with a subject and no body is a subject change.
+	if msg.Type == stanza.GroupChatMessage {
+		if msg.Subject == nil || len(msg.Body) > 0 || c.Tracker == nil {
+			return nil
+		}
+		c.managedM.Lock()
+		_, ok := c.managed[msg.From.Bare().String()]
+		c.managedM.Unlock()
+		if ok {
+			c.Tracker.subject(msg.From, *msg.Subject)
+		}
+		return nil
+	}
+
 	if msg.X.XMLName.Local != "" && c.HandleInvite != nil {
 		c.HandleInvite(msg.X)
 		return nil
@@ -162,7 +211,14 @@ type mucPresence struct {
 		Status  []struct {
 			Code int `xml:"code,attr"`
 		} `xml:"status,omitempty"`
-	} `xml:"x"`
+		Destroy *struct {
+			JID    jid.JID `xml:"jid,attr"`
+			Reason string  `xml:"reason"`
+		} `xml:"destroy"`
+	} `xml:"http://jabber.org/protocol/muc#user x"`
+	OccupantID struct {
+		ID string `xml:"id,attr"`
+	} `xml:"urn:xmpp:occupant-id:0 occupant-id"`
 }
 
 func (p *mucPresence) HasStatus(code int) bool {
@@ -178,11 +234,9 @@ func (p *mucPresence) HasStatus(code int) bool {
 // it is used by the multiplexer and normally does not need to be called by the
 // user.
 func (c *Client) HandlePresence(p stanza.Presence, r xmlstream.TokenReadEncoder) error {
-	// If this is a self-presence, check if we're joining or departing and send on
-	// the channel.
 	c.managedM.Lock()
-	defer c.managedM.Unlock()
-	channel, ok := c.managed[p.From.String()]
+	channel, ok := c.managed[p.From.Bare().String()]
+	c.managedM.Unlock()
 	// TODO: what do we do with presences that aren't managed?
 	if !ok {
 		return nil
@@ -194,19 +248,50 @@ func (c *Client) HandlePresence(p stanza.Presence, r xmlstream.TokenReadEncoder)
 		return err
 	}
 
-	switch p.Type {
-	case stanza.AvailablePresence:
-		if channel.join != nil {
-			channel.join <- p.From
-			channel.join = nil
-			return nil
-		}
-		if decodedPresence.X.XMLName.Space == NSUser && c.HandleUserPresence != nil {
-			c.HandleUserPresence(decodedPresence.Presence, decodedPresence.X.Item)
+	// If this is a self-presence, check if we're joining or departing and send on
+	// the channel.
+	c.managedM.Lock()
+	var joined, left bool
+	self := decodedPresence.HasStatus(110) || p.From.Equal(channel.addr)
+	if self {
+		switch p.Type {
+		case stanza.AvailablePresence:
+			if channel.join != nil {
+				channel.created = decodedPresence.HasStatus(201)
+				channel.join <- p.From
+				channel.join = nil
+				joined = true
+			}
+		case stanza.UnavailablePresence:
+			if decodedPresence.HasStatus(303) {
+				newAddr, err := channel.addr.WithResource(decodedPresence.X.Item.Nick)
+				if err == nil {
+					channel.addr = newAddr
+				}
+				break
+			}
+			select {
+			case channel.depart <- struct{}{}:
+			default:
+			}
+			channel.unmanage()
+			channel.cancelPing()
+			left = true
 		}
-	case stanza.UnavailablePresence:
-		channel.depart <- struct{}{}
-		delete(c.managed, channel.addr.String())
+	}
+	c.managedM.Unlock()
+	if left {
+		channel.setState(StateLeft)
+	}
+
+	if c.Tracker != nil {
+		c.Tracker.presence(&decodedPresence)
+	}
+	if self && !joined && p.Type == stanza.AvailablePresence && c.HandleUserPresence != nil {
+		c.HandleUserPresence(decodedPresence.Presence, decodedPresence.X.Item)
+	}
+	if c.HandleOccupantPresence != nil {
+		c.HandleOccupantPresence(decodedPresence.Presence, decodedPresence.X.Item)
 	}
 	return nil
 }
@@ -225,22 +310,13 @@ func (c *Client) Join(ctx context.Context, room jid.JID, s *xmpp.Session, opt ..
 // presence.
 // Changing the presence type has no effect.
 func (c *Client) JoinPresence(ctx context.Context, p stanza.Presence, s *xmpp.Session, opt ...Option) (*Channel, error) {
-	c.managedM.Lock()
-
 	channel := &Channel{
 		addr:    p.To,
 		client:  c,
 		session: s,
 
-		join:   make(chan jid.JID, 1),
-		depart: make(chan struct{}),
-	}
-	if c.managed == nil {
-		c.managed = make(map[string]*Channel)
+		depart: make(chan struct{}, 1),
 	}
-	c.managed[p.To.String()] = channel
-	c.managedM.Unlock()
-
 	err := channel.JoinPresence(ctx, p, opt...)
 	return channel, err
 }
@@ -10,6 +10,7 @@ import (
 	"errors"
 	"strings"
 	"testing"
+	"time"
 
 	"mellium.im/xmlstream"
 	"mellium.im/xmpp/internal/xmpptest"
@@ -51,6 +52,9 @@ func TestJoinPartMuc(t *testing.T) {
 	if !channel.Addr().Equal(j.Bare()) {
 		t.Errorf("wrong JID: want=%v, got=%v", j.Bare(), channel.Addr())
 	}
+	if !channel.Joined() {
+		t.Errorf("expected channel to be joined")
+	}
 
 	err = channel.Leave(context.Background(), "")
 	if err != nil {
@@ -186,3 +190,63 @@ func TestGetForm(t *testing.T) {
 		t.Errorf("wrong title, form decode failed: want=%q, got=%q", expected, title)
 	}
 }
+
+func TestLeaveAfterKick(t *testing.T) {
+	j := jid.MustParse("room@example.net/me")
+	h := &muc.Client{}
+	m := mux.New(muc.HandleClient(h))
+	errHotelCalifornia := stanza.Error{
+		Type:      stanza.Auth,
+		Condition: stanza.NotAllowed,
+	}
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(m),
+		xmpptest.ServerHandlerFunc(func(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			p, err := stanza.NewPresence(*start)
+			if err != nil {
+				return err
+			}
+			p.To, p.From = p.From, p.To
+			switch p.Type {
+			case "":
+				_, err = xmlstream.Copy(t, p.Wrap(xmlstream.Wrap(
+					nil,
+					xml.StartElement{Name: xml.Name{Space: muc.NSUser, Local: "x"}},
+				)))
+			case stanza.UnavailablePresence:
+				p.Type = stanza.ErrorPresence
+				_, err = xmlstream.Copy(t, p.Wrap(errHotelCalifornia.TokenReader()))
+			}
+			return err
+		}),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	channel, err := h.Join(ctx, j, s.Client)
+	if err != nil {
+		t.Fatalf("error joining: %v", err)
+	}
+
+	err = s.Server.Send(ctx, xml.NewDecoder(strings.NewReader(`<presence xmlns='jabber:client' from='room@example.net/me' type='unavailable'><x xmlns='http://jabber.org/protocol/muc#user'><item affiliation='none' role='none'/><status code='110'/><status code='307'/></x></presence>`)))
+	if err != nil {
+		t.Fatalf("error kicking: %v", err)
+	}
+	for channel.Joined() {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("channel still joined after kick: %v", ctx.Err())
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	err = channel.JoinPresence(ctx, stanza.Presence{To: j})
+	if err != nil {
+		t.Fatalf("error rejoining: %v", err)
+	}
+	// The kick must not be mistaken for the response to leaving.
+	err = channel.Leave(ctx, "")
+	if !errors.Is(err, errHotelCalifornia) {
+		t.Errorf("wrong error leaving: %v", err)
+	}
+}
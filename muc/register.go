@@ -0,0 +1,106 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// ErrRegistered is returned by GetRegisterForm if we already have a registered
+// nickname in the room.
+var ErrRegistered = errors.New("muc: already registered with the room")
+
+// reservedNickNode is the disco#info node used to discover our reserved
+// nickname.
+const reservedNickNode = "x-roomuser-item"
+
+// GetRegisterForm requests the form used to register with a room and reserve a
+// nickname.
+// The form normally has the FORM_TYPE "http://jabber.org/protocol/muc#register"
+// and should be filled out and passed to Register.
+// If we are already registered with the room, ErrRegistered is returned.
+func GetRegisterForm(ctx context.Context, room jid.JID, s *xmpp.Session) (*form.Data, error) {
+	return GetRegisterFormIQ(ctx, stanza.IQ{
+		To: room,
+	}, s)
+}
+
+// GetRegisterFormIQ is like GetRegisterForm except that it lets you customize
+// the IQ.
+// Changing the type of the IQ has no effect.
+func GetRegisterFormIQ(ctx context.Context, iq stanza.IQ, s *xmpp.Session) (*form.Data, error) {
+	if iq.Type != stanza.GetIQ {
+		iq.Type = stanza.GetIQ
+	}
+	formResp := struct {
+		XMLName    xml.Name   `xml:"jabber:iq:register query"`
+		Registered *struct{}  `xml:"registered"`
+		DataForm   *form.Data `xml:"jabber:x:data x"`
+	}{}
+	err := s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		nil,
+		xml.StartElement{Name: xml.Name{Space: NSRegister, Local: "query"}},
+	), iq, &formResp)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	if formResp.Registered != nil {
+		return nil, ErrRegistered
+	}
+	if formResp.DataForm == nil {
+		return form.New(), nil
+	}
+	return formResp.DataForm, nil
+}
+
+// Register submits a registration form previously retrieved with
+// GetRegisterForm to reserve a nickname in the room.
+func Register(ctx context.Context, room jid.JID, f *form.Data, s *xmpp.Session) error {
+	return RegisterIQ(ctx, stanza.IQ{
+		To: room,
+	}, f, s)
+}
+
+// RegisterIQ is like Register except that it lets you customize the IQ.
+// Changing the type of the IQ has no effect.
+func RegisterIQ(ctx context.Context, iq stanza.IQ, f *form.Data, s *xmpp.Session) error {
+	if iq.Type != stanza.SetIQ {
+		iq.Type = stanza.SetIQ
+	}
+	submission, _ := f.Submit()
+	err := s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		submission,
+		xml.StartElement{Name: xml.Name{Space: NSRegister, Local: "query"}},
+	), iq, nil)
+	return mapError(err)
+}
+
+// ReservedNick looks up the nickname reserved by the user in the room, if any.
+// If no nickname has been reserved, an empty string is returned.
+func ReservedNick(ctx context.Context, room jid.JID, s *xmpp.Session) (string, error) {
+	info, err := disco.GetInfo(ctx, reservedNickNode, room.Bare(), s)
+	if err != nil {
+		var stanzaErr stanza.Error
+		if errors.As(err, &stanzaErr) && stanzaErr.Condition == stanza.ItemNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+	for _, ident := range info.Identity {
+		if ident.Category == "conference" && ident.Name != "" {
+			return ident.Name, nil
+		}
+	}
+	return "", nil
+}
@@ -21,23 +21,33 @@ import (
 // subject and participant list are not stored.
 // Instead, it is up to the user to store this information and associate it with
 // the channel (probably by mapping details to the channel address).
+// For simple in-memory state see Tracker.
 type Channel struct {
 	addr    jid.JID
 	pass    string
 	client  *Client
 	session *xmpp.Session
 
-	join   chan jid.JID
-	depart chan struct{}
+	join    chan jid.JID
+	depart  chan struct{}
+	created bool
+
+	// State used to check that we are still joined and rejoin if not.
+	presence stanza.Presence
+	opts     []Option
+	state    State
+	stopPing context.CancelFunc
 }
 
 // Addr returns the address of the channel.
 func (c *Channel) Addr() jid.JID {
-	return c.addr.Bare()
+	return c.Me().Bare()
 }
 
 // Me returns the users last-known address in the channel.
 func (c *Channel) Me() jid.JID {
+	c.client.managedM.Lock()
+	defer c.client.managedM.Unlock()
 	return c.addr
 }
 
@@ -61,8 +71,8 @@ func (c *Channel) LeavePresence(ctx context.Context, status string, p stanza.Pre
 	if p.Type != stanza.UnavailablePresence {
 		p.Type = stanza.UnavailablePresence
 	}
-	if !p.To.Equal(c.addr) {
-		p.To = c.addr
+	if me := c.Me(); !p.To.Equal(me) {
+		p.To = me
 	}
 	if p.ID == "" {
 		p.ID = attr.RandomID()
@@ -75,7 +85,7 @@ func (c *Channel) LeavePresence(ctx context.Context, status string, p stanza.Pre
 			xml.StartElement{Name: xml.Name{Local: "status"}},
 		)
 	}
-	errChan := make(chan error)
+	errChan := make(chan error, 1)
 	go func(errChan chan<- error) {
 		resp, err := c.session.SendPresenceElement(ctx, inner, p)
 		//err := s.Send(ctx, p.Wrap(conf.TokenReader()))
@@ -99,6 +109,12 @@ func (c *Channel) LeavePresence(ctx context.Context, status string, p stanza.Pre
 		errChan <- stanzaError
 	}(errChan)
 
+	// Whether or not the room acknowledges our departure, stop managing it.
+	defer c.forget()
+	c.client.managedM.Lock()
+	c.cancelPing()
+	c.client.managedM.Unlock()
+
 	select {
 	case err := <-errChan:
 		return err
@@ -107,6 +123,22 @@ func (c *Channel) LeavePresence(ctx context.Context, status string, p stanza.Pre
 	return nil
 }
 
+// forget removes the channel from the client so that Joined returns false.
+func (c *Channel) forget() {
+	c.client.managedM.Lock()
+	defer c.client.managedM.Unlock()
+	c.unmanage()
+}
+
+// unmanage is like forget except that the caller must hold the clients lock.
+func (c *Channel) unmanage() {
+	c.join = nil
+	key := c.addr.Bare().String()
+	if c.client.managed[key] == c {
+		delete(c.client.managed, key)
+	}
+}
+
 // Invite sends a mediated invitation (an invitation sent from the channel
 // itself) to the user.
 //
@@ -114,7 +146,7 @@ func (c *Channel) LeavePresence(ctx context.Context, status string, p stanza.Pre
 // block all unrecognized JIDs) see the Invite function.
 func (c *Channel) Invite(ctx context.Context, reason string, to jid.JID) error {
 	return c.session.Send(ctx, stanza.Message{
-		To:   c.addr.Bare(),
+		To:   c.Addr(),
 		Type: stanza.NormalMessage,
 	}.Wrap(Invitation{
 		JID:      to,
@@ -126,13 +158,6 @@ func (c *Channel) Invite(ctx context.Context, reason string, to jid.JID) error {
 // SetAffiliation changes the affiliation of the provided JID which should be
 // the users real bare-JID (not their room JID).
 func (c *Channel) SetAffiliation(ctx context.Context, a Affiliation, j jid.JID, nick, reason string) error {
-	var reasonEl xml.TokenReader
-	if reason != "" {
-		reasonEl = xmlstream.Wrap(
-			xmlstream.Token(xml.CharData(reason)),
-			xml.StartElement{Name: xml.Name{Local: "reason"}},
-		)
-	}
 	attr := []xml.Attr{
 		{Name: xml.Name{Local: "affiliation"}, Value: a.String()},
 		{Name: xml.Name{Local: "jid"}, Value: j.Bare().String()},
@@ -140,20 +165,7 @@ func (c *Channel) SetAffiliation(ctx context.Context, a Affiliation, j jid.JID,
 	if nick != "" {
 		attr = append(attr, xml.Attr{Name: xml.Name{Local: "nick"}, Value: nick})
 	}
-	payload := xmlstream.Wrap(
-		xmlstream.Wrap(
-			reasonEl,
-			xml.StartElement{
-				Name: xml.Name{Local: "item"},
-				Attr: attr,
-			},
-		),
-		xml.StartElement{Name: xml.Name{Space: NSAdmin, Local: "query"}},
-	)
-	return c.session.UnmarshalIQElement(ctx, payload, stanza.IQ{
-		Type: stanza.SetIQ,
-		To:   c.addr.Bare(),
-	}, nil)
+	return c.adminItem(ctx, attr, reason)
 }
 
 // Join is like the Join function except that it joins or re-synchronizes the
@@ -171,25 +183,48 @@ func (c *Channel) JoinPresence(ctx context.Context, p stanza.Presence, opt ...Op
 	if p.Type != "" {
 		p.Type = ""
 	}
-	if p.ID == "" {
-		p.ID = attr.RandomID()
-	}
-	p.To = c.addr
 
 	conf := config{}
 	for _, o := range opt {
 		o(&conf)
 	}
+
+	c.client.managedM.Lock()
+	// Remember how we joined so that we can rejoin if we are removed from the
+	// room unexpectedly.
+	c.presence = p
+	c.opts = opt
+	if p.ID == "" {
+		p.ID = attr.RandomID()
+	}
 	c.pass = conf.password
 	if conf.newNick != "" {
 		newAddr, err := c.addr.WithResource(conf.newNick)
 		if err != nil {
+			c.client.managedM.Unlock()
 			return err
 		}
 		c.addr = newAddr
 	}
+	p.To = c.addr
+	// If we've previously joined or left the room, start managing it again and
+	// forget about any departure that nobody was waiting for (eg. if we were
+	// kicked) so that it is not mistaken for the response to the next Leave.
+	select {
+	case <-c.depart:
+	default:
+	}
+	if c.join == nil {
+		c.join = make(chan jid.JID, 1)
+	}
+	join := c.join
+	if c.client.managed == nil {
+		c.client.managed = make(map[string]*Channel)
+	}
+	c.client.managed[c.addr.Bare().String()] = c
+	c.client.managedM.Unlock()
 
-	errChan := make(chan error)
+	errChan := make(chan error, 1)
 	go func(errChan chan<- error) {
 		resp, err := c.session.SendPresenceElement(ctx, conf.TokenReader(), p)
 		if err != nil {
@@ -215,13 +250,19 @@ func (c *Channel) JoinPresence(ctx context.Context, p stanza.Presence, opt ...Op
 
 	select {
 	case err := <-errChan:
+		c.forget()
 		return err
-	case roomAddr := <-c.join:
+	case roomAddr := <-join:
+		c.client.managedM.Lock()
 		c.addr = roomAddr
+		c.client.managedM.Unlock()
 	case <-ctx.Done():
+		c.forget()
 		return ctx.Err()
 	}
 
+	c.setState(StateJoined)
+	c.startPing()
 	return nil
 }
 
@@ -236,7 +277,7 @@ func (c *Channel) Subject(ctx context.Context, subject string) error {
 // message stanza. Changing the receipient or type has no effect.
 func (c *Channel) SubjectMessage(ctx context.Context, subject string, m stanza.Message) error {
 	m.Type = stanza.GroupChatMessage
-	m.To = c.addr.Bare()
+	m.To = c.Addr()
 	return c.session.Send(ctx, m.Wrap(xmlstream.Wrap(
 		xmlstream.Token(xml.CharData(subject)),
 		xml.StartElement{Name: xml.Name{Local: "subject"}},
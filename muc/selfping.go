@@ -0,0 +1,170 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"mellium.im/xmpp/ping"
+	"mellium.im/xmpp/stanza"
+)
+
+// RejoinPolicy is used by a Client to decide whether to rejoin a channel that
+// we have been removed from without leaving it, for example because the MUC
+// service was restarted.
+// Attempt is the number of rejoin attempts that have already failed.
+// If ok is false no further attempts are made, otherwise the client waits for
+// the returned duration before attempting to rejoin.
+type RejoinPolicy func(c *Channel, attempt int) (wait time.Duration, ok bool)
+
+// RejoinBackoff returns a RejoinPolicy that makes up to attempts attempts to
+// rejoin a channel, waiting for initial before the first attempt and doubling
+// the wait after each failure.
+// If attempts is zero, the policy never gives up.
+func RejoinBackoff(initial time.Duration, attempts int) RejoinPolicy {
+	return func(_ *Channel, attempt int) (time.Duration, bool) {
+		if attempts > 0 && attempt >= attempts {
+			return 0, false
+		}
+		wait := initial
+		for i := 0; i < attempt && wait < time.Hour; i++ {
+			wait *= 2
+		}
+		return wait, true
+	}
+}
+
+// selfPing pings our own occupant JID in the channel and interprets the result
+// as described in XEP-0410: MUC Self-Ping (Schrödinger's Chat).
+func (c *Channel) selfPing(ctx context.Context) State {
+	err := c.session.UnmarshalIQ(ctx, ping.IQ{IQ: stanza.IQ{
+		Type: stanza.GetIQ,
+		To:   c.Me(),
+	}}.TokenReader(), nil)
+	if err == nil {
+		return StateJoined
+	}
+	var stanzaErr stanza.Error
+	if !errors.As(err, &stanzaErr) {
+		// Timeouts and other errors tell us nothing about whether we are still
+		// joined.
+		return StateUnreachable
+	}
+	switch stanzaErr.Condition {
+	case stanza.ServiceUnavailable, stanza.FeatureNotImplemented:
+		// We are joined, but our other client (or the one sharing our nickname)
+		// does not support pings.
+		return StateJoined
+	case stanza.ItemNotFound:
+		// We are joined, but our nickname was just changed.
+		return StateJoined
+	case stanza.RemoteServerNotFound, stanza.RemoteServerTimeout:
+		return StateUnreachable
+	}
+	return StateLeft
+}
+
+// setState records the state of the channel and calls the clients state
+// handler if it changed.
+func (c *Channel) setState(state State) {
+	c.client.managedM.Lock()
+	changed := c.state != state
+	c.state = state
+	c.client.managedM.Unlock()
+
+	if changed && c.client.HandleState != nil {
+		c.client.HandleState(c, state)
+	}
+}
+
+// startPing starts pinging the channel if the client is configured to do so
+// and we are not already pinging it.
+func (c *Channel) startPing() {
+	interval := c.client.PingInterval
+	if interval <= 0 {
+		return
+	}
+	c.client.managedM.Lock()
+	defer c.client.managedM.Unlock()
+	if c.stopPing != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.stopPing = cancel
+	go c.pingLoop(ctx, interval)
+}
+
+// cancelPing stops pinging the channel.
+// The caller must hold the clients lock.
+func (c *Channel) cancelPing() {
+	if c.stopPing != nil {
+		c.stopPing()
+		c.stopPing = nil
+	}
+}
+
+func (c *Channel) pingLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, interval)
+		state := c.selfPing(pingCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if state != StateLeft {
+			c.setState(state)
+			continue
+		}
+
+		c.forget()
+		c.setState(StateLeft)
+		if !c.rejoin(ctx, interval) {
+			c.client.managedM.Lock()
+			c.cancelPing()
+			c.client.managedM.Unlock()
+			return
+		}
+	}
+}
+
+// rejoin attempts to rejoin the channel according to the clients rejoin policy
+// and reports whether it was successful.
+func (c *Channel) rejoin(ctx context.Context, timeout time.Duration) bool {
+	policy := c.client.Rejoin
+	if policy == nil {
+		return false
+	}
+	for attempt := 0; ; attempt++ {
+		wait, ok := policy(c, attempt)
+		if !ok {
+			return false
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(wait):
+		}
+
+		c.client.managedM.Lock()
+		p, opts := c.presence, c.opts
+		c.client.managedM.Unlock()
+		joinCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := c.JoinPresence(joinCtx, p, opts...)
+		cancel()
+		if err == nil {
+			return true
+		}
+	}
+}
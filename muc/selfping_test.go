@@ -0,0 +1,127 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc_test
+
+import (
+	"context"
+	"encoding/xml"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/ping"
+	"mellium.im/xmpp/stanza"
+)
+
+func TestSelfPingRejoin(t *testing.T) {
+	const pass = "cauldronburn"
+	passwords := make(chan string, 10)
+	var pings int32
+	states := make(chan muc.State, 10)
+	h := &muc.Client{
+		PingInterval: 10 * time.Millisecond,
+		Rejoin:       muc.RejoinBackoff(time.Millisecond, 3),
+		HandleState: func(_ *muc.Channel, state muc.State) {
+			states <- state
+		},
+	}
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(muc.HandleClient(h))),
+		xmpptest.ServerHandler(mux.New(
+			mux.PresenceFunc("", xml.Name{Local: "x"}, func(p stanza.Presence, r xmlstream.TokenReadEncoder) error {
+				x := struct {
+					Password string `xml:"http://jabber.org/protocol/muc x>password"`
+				}{}
+				err := xml.NewTokenDecoder(r).Decode(&x)
+				if err != nil {
+					return err
+				}
+				passwords <- x.Password
+				p.To, p.From = p.From, p.To
+				_, err = xmlstream.Copy(r, p.Wrap(xmlstream.Wrap(
+					nil,
+					xml.StartElement{Name: xml.Name{Space: muc.NSUser, Local: "x"}},
+				)))
+				return err
+			}),
+			mux.PresenceFunc(stanza.UnavailablePresence, xml.Name{}, func(p stanza.Presence, r xmlstream.TokenReadEncoder) error {
+				p.To, p.From = p.From, p.To
+				_, err := xmlstream.Copy(r, p.Wrap(xmlstream.Wrap(
+					nil,
+					xml.StartElement{Name: xml.Name{Space: muc.NSUser, Local: "x"}},
+				)))
+				return err
+			}),
+			mux.IQFunc(stanza.GetIQ, xml.Name{Space: ping.NS, Local: "ping"}, func(iq stanza.IQ, r xmlstream.TokenReadEncoder, _ *xml.StartElement) error {
+				// The first ping indicates that the service restarted and we are no
+				// longer joined.
+				if atomic.AddInt32(&pings, 1) == 1 {
+					_, err := xmlstream.Copy(r, iq.Error(stanza.Error{
+						Type:      stanza.Cancel,
+						Condition: stanza.NotAcceptable,
+					}))
+					return err
+				}
+				_, err := xmlstream.Copy(r, iq.Result(nil))
+				return err
+			}),
+		)),
+	)
+
+	channel, err := h.Join(context.Background(), jid.MustParse("coven@chat.shakespeare.lit/thirdwitch"), s.Client, muc.Password(pass))
+	if err != nil {
+		t.Fatalf("error joining: %v", err)
+	}
+	if p := <-passwords; p != pass {
+		t.Errorf("wrong password on join: want=%q, got=%q", pass, p)
+	}
+
+	for _, want := range []muc.State{muc.StateJoined, muc.StateLeft, muc.StateJoined} {
+		if state := <-states; state != want {
+			t.Errorf("wrong state: want=%v, got=%v", want, state)
+		}
+	}
+	if p := <-passwords; p != pass {
+		t.Errorf("wrong password on rejoin: want=%q, got=%q", pass, p)
+	}
+	if !channel.Joined() {
+		t.Errorf("expected channel to be joined after rejoining")
+	}
+
+	err = channel.Leave(context.Background(), "")
+	if err != nil {
+		t.Fatalf("error leaving: %v", err)
+	}
+	if state := <-states; state != muc.StateLeft {
+		t.Errorf("wrong state after leaving: want=%v, got=%v", muc.StateLeft, state)
+	}
+	// Make sure that we stop pinging after leaving.
+	n := atomic.LoadInt32(&pings)
+	time.Sleep(5 * h.PingInterval)
+	if after := atomic.LoadInt32(&pings); after != n {
+		t.Errorf("expected pings to stop after leaving, got %d more", after-n)
+	}
+}
+
+func TestRejoinBackoff(t *testing.T) {
+	policy := muc.RejoinBackoff(time.Second, 3)
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		wait, ok := policy(nil, i)
+		if !ok {
+			t.Fatalf("attempt %d: expected policy to allow rejoin", i)
+		}
+		if wait != want {
+			t.Errorf("attempt %d: wrong wait: want=%v, got=%v", i, want, wait)
+		}
+	}
+	if _, ok := policy(nil, 3); ok {
+		t.Errorf("expected policy to give up after 3 attempts")
+	}
+}
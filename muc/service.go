@@ -0,0 +1,702 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/delay"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+const defaultMaxHistory = 20
+
+// Service is an xmpp.Handler that hosts channels from the perspective of a
+// Multi-User Chat service.
+//
+// It handles every presence, message, and IQ stanza that it is passed and is
+// normally served on its own session, for example a component session created
+// with component.NewSession, or called by an in-process server for all stanzas
+// addressed to the services domain:
+//
+//     svc := &muc.Service{}
+//     err := session.Serve(svc)
+//
+// Outgoing stanzas, including those addressed to occupants other than the
+// sender of the stanza being handled, are written to the handlers
+// TokenReadEncoder, so when it is used by an in-process server the encoder
+// must route stanzas based on their "to" attribute.
+// Stanzas received by the service must have a "from" attribute set to the real
+// JID of the sender.
+//
+// Channels are created when the first user joins them and that user becomes
+// the owner.
+// New channels are locked until the owner submits the configuration form (or
+// an empty form to accept the default configuration).
+type Service struct {
+	// MaxHistory is the maximum number of messages stored in each channels
+	// history and sent to new occupants.
+	// If MaxHistory is zero, a default of 20 is used.
+	// If it is negative, no history is stored.
+	MaxHistory int
+
+	roomsM sync.Mutex
+	rooms  map[string]*hostedRoom
+}
+
+type roomConfig struct {
+	name          string
+	desc          string
+	password      string
+	persistent    bool
+	public        bool
+	membersOnly   bool
+	moderated     bool
+	nonAnonymous  bool
+	changeSubject bool
+	allowInvites  bool
+}
+
+type hostedOccupant struct {
+	nick    string
+	jid     jid.JID
+	role    Role
+	payload []element
+}
+
+type historyItem struct {
+	nick    string
+	id      string
+	stamp   time.Time
+	payload []element
+}
+
+type hostedRoom struct {
+	addr         jid.JID
+	locked       bool
+	config       roomConfig
+	subject      string
+	subjectNick  string
+	affiliations map[string]Affiliation
+	occupants    []*hostedOccupant
+	history      []historyItem
+}
+
+// element is a single XML element including its start and end tokens.
+type element []xml.Token
+
+func (e element) start() xml.StartElement {
+	return e[0].(xml.StartElement)
+}
+
+func (e element) decode(v interface{}) error {
+	r := tokenSlice(e)
+	return xml.NewTokenDecoder(&r).Decode(v)
+}
+
+type tokenSlice []xml.Token
+
+func (t *tokenSlice) Token() (xml.Token, error) {
+	if len(*t) == 0 {
+		return nil, io.EOF
+	}
+	tok := (*t)[0]
+	*t = (*t)[1:]
+	return tok, nil
+}
+
+// elementsReader returns a new token reader over the provided elements.
+func elementsReader(els []element) xml.TokenReader {
+	var r tokenSlice
+	for _, el := range els {
+		r = append(r, el...)
+	}
+	return &r
+}
+
+// readElements reads all child elements from r.
+func readElements(r xml.TokenReader) ([]element, error) {
+	iter := xmlstream.NewIter(r)
+	/* #nosec */
+	defer iter.Close()
+	var els []element
+	for iter.Next() {
+		start, inner := iter.Current()
+		if start == nil {
+			continue
+		}
+		el := element{start.Copy()}
+		for {
+			tok, err := inner.Token()
+			if tok != nil {
+				el = append(el, xml.CopyToken(tok))
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		els = append(els, el)
+	}
+	return els, iter.Err()
+}
+
+// without returns the elements that do not have the provided name.
+func without(els []element, name xml.Name) []element {
+	var filtered []element
+	for _, el := range els {
+		if el.start().Name != name {
+			filtered = append(filtered, el)
+		}
+	}
+	return filtered
+}
+
+func find(els []element, name xml.Name) (element, bool) {
+	for _, el := range els {
+		if el.start().Name == name {
+			return el, true
+		}
+	}
+	return nil, false
+}
+
+// HandleXMPP satisfies xmpp.Handler.
+func (s *Service) HandleXMPP(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	els, err := readElements(xmlstream.Inner(t))
+	if err != nil {
+		return err
+	}
+
+	var out []xml.TokenReader
+	switch start.Name.Local {
+	case "presence":
+		p, err := stanza.NewPresence(*start)
+		if err != nil {
+			return err
+		}
+		out = s.handlePresence(p, els)
+	case "message":
+		msg, err := stanza.NewMessage(*start)
+		if err != nil {
+			return err
+		}
+		out = s.handleMessage(msg, els)
+	case "iq":
+		iq, err := stanza.NewIQ(*start)
+		if err != nil {
+			return err
+		}
+		out = s.handleIQ(iq, els)
+	}
+
+	// Stanzas are collected and written after the rooms have been updated so
+	// that we don't hold the lock while writing.
+	for _, r := range out {
+		_, err = xmlstream.Copy(t, r)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) maxHistory() int {
+	switch {
+	case s.MaxHistory == 0:
+		return defaultMaxHistory
+	case s.MaxHistory < 0:
+		return 0
+	}
+	return s.MaxHistory
+}
+
+// room returns the room with the provided address or nil if it does not
+// exist.
+// The caller must hold the lock.
+func (s *Service) room(addr jid.JID) *hostedRoom {
+	return s.rooms[addr.Bare().String()]
+}
+
+func (s *Service) deleteRoom(r *hostedRoom) {
+	delete(s.rooms, r.addr.String())
+}
+
+// clone returns a copy of the room whose affiliations and occupants can be
+// modified without affecting r.
+func (r *hostedRoom) clone() *hostedRoom {
+	c := *r
+	c.affiliations = make(map[string]Affiliation, len(r.affiliations))
+	for j, a := range r.affiliations {
+		c.affiliations[j] = a
+	}
+	c.occupants = make([]*hostedOccupant, 0, len(r.occupants))
+	for _, o := range r.occupants {
+		occupant := *o
+		c.occupants = append(c.occupants, &occupant)
+	}
+	return &c
+}
+
+func (r *hostedRoom) affiliation(j jid.JID) Affiliation {
+	return r.affiliations[j.Bare().String()]
+}
+
+func (r *hostedRoom) setAffiliation(j jid.JID, a Affiliation) {
+	if a == AffiliationNone {
+		delete(r.affiliations, j.Bare().String())
+		return
+	}
+	r.affiliations[j.Bare().String()] = a
+}
+
+func (r *hostedRoom) byNick(nick string) *hostedOccupant {
+	for _, o := range r.occupants {
+		if o.nick == nick {
+			return o
+		}
+	}
+	return nil
+}
+
+func (r *hostedRoom) byJID(j jid.JID) *hostedOccupant {
+	for _, o := range r.occupants {
+		if o.jid.Equal(j) {
+			return o
+		}
+	}
+	return nil
+}
+
+func (r *hostedRoom) remove(o *hostedOccupant) {
+	for i, occ := range r.occupants {
+		if occ == o {
+			r.occupants = append(r.occupants[:i], r.occupants[i+1:]...)
+			return
+		}
+	}
+}
+
+func defaultRole(a Affiliation, moderated bool) Role {
+	switch a {
+	case AffiliationOwner, AffiliationAdmin:
+		return RoleModerator
+	case AffiliationMember:
+		return RoleParticipant
+	case AffiliationOutcast:
+		return RoleNone
+	}
+	if moderated {
+		return RoleVisitor
+	}
+	return RoleParticipant
+}
+
+func statusCodes(codes ...int) xml.TokenReader {
+	var r []xml.TokenReader
+	for _, code := range codes {
+		r = append(r, xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: "status"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "code"}, Value: strconv.Itoa(code)}},
+		}))
+	}
+	return xmlstream.MultiReader(r...)
+}
+
+func mucUser(r ...xml.TokenReader) xml.TokenReader {
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(r...),
+		xml.StartElement{Name: xml.Name{Space: NSUser, Local: "x"}},
+	)
+}
+
+func (r *hostedRoom) occupantAddr(o *hostedOccupant) jid.JID {
+	/* #nosec */
+	addr, _ := r.addr.WithResource(o.nick)
+	return addr
+}
+
+// item returns the item describing the occupant o as seen by to.
+// The real JID is only shown to moderators or in non-anonymous rooms.
+func (r *hostedRoom) item(o, to *hostedOccupant, nick, reason string) xml.TokenReader {
+	attr := []xml.Attr{
+		{Name: xml.Name{Local: "affiliation"}, Value: r.affiliation(o.jid).String()},
+		{Name: xml.Name{Local: "role"}, Value: o.role.String()},
+	}
+	if to == o || to.role == RoleModerator || r.config.nonAnonymous {
+		attr = append(attr, xml.Attr{Name: xml.Name{Local: "jid"}, Value: o.jid.String()})
+	}
+	if nick != "" {
+		attr = append(attr, xml.Attr{Name: xml.Name{Local: "nick"}, Value: nick})
+	}
+	return xmlstream.Wrap(
+		optionalReason(reason),
+		xml.StartElement{Name: xml.Name{Local: "item"}, Attr: attr},
+	)
+}
+
+// presence returns a presence from the occupant o to the occupant to.
+func (r *hostedRoom) presence(o, to *hostedOccupant, typ stanza.PresenceType, payload []element, x ...xml.TokenReader) xml.TokenReader {
+	return stanza.Presence{
+		From: r.occupantAddr(o),
+		To:   to.jid,
+		Type: typ,
+	}.Wrap(xmlstream.MultiReader(
+		elementsReader(payload),
+		mucUser(x...),
+	))
+}
+
+// broadcast returns a presence from o to every occupant in the room.
+// The copy sent to o itself also contains the self-presence status code and
+// any selfCodes.
+func (r *hostedRoom) broadcast(o *hostedOccupant, typ stanza.PresenceType, payload []element, nick, reason string, codes []int, selfCodes ...int) []xml.TokenReader {
+	var out []xml.TokenReader
+	for _, to := range r.occupants {
+		c := codes
+		if to == o {
+			c = append(append([]int{110}, selfCodes...), codes...)
+		}
+		out = append(out, r.presence(o, to, typ, payload, r.item(o, to, nick, reason), statusCodes(c...)))
+	}
+	return out
+}
+
+// evict broadcasts that the occupant is no longer in the room and removes
+// them.
+func (r *hostedRoom) evict(o *hostedOccupant, reason string, codes ...int) []xml.TokenReader {
+	o.role = RoleNone
+	out := r.broadcast(o, stanza.UnavailablePresence, nil, "", reason, codes)
+	r.remove(o)
+	return out
+}
+
+func presenceError(p stanza.Presence, typ stanza.ErrorType, cond stanza.Condition) []xml.TokenReader {
+	return []xml.TokenReader{p.Error(stanza.Error{
+		By:        p.To.Bare(),
+		Type:      typ,
+		Condition: cond,
+	})}
+}
+
+func messageError(msg stanza.Message, typ stanza.ErrorType, cond stanza.Condition) []xml.TokenReader {
+	return []xml.TokenReader{msg.Error(stanza.Error{
+		By:        msg.To.Bare(),
+		Type:      typ,
+		Condition: cond,
+	})}
+}
+
+func (s *Service) handlePresence(p stanza.Presence, els []element) []xml.TokenReader {
+	if p.To.Localpart() == "" {
+		return nil
+	}
+	s.roomsM.Lock()
+	defer s.roomsM.Unlock()
+
+	r := s.room(p.To)
+	var o *hostedOccupant
+	if r != nil {
+		o = r.byJID(p.From)
+	}
+	payload := without(els, xml.Name{Space: NS, Local: "x"})
+
+	switch p.Type {
+	case stanza.UnavailablePresence:
+		if o == nil {
+			return nil
+		}
+		o.role = RoleNone
+		out := r.broadcast(o, stanza.UnavailablePresence, payload, "", "", nil)
+		r.remove(o)
+		if len(r.occupants) == 0 && (!r.config.persistent || r.locked) {
+			s.deleteRoom(r)
+		}
+		return out
+	case stanza.AvailablePresence:
+	default:
+		return nil
+	}
+
+	nick := p.To.Resourcepart()
+	if nick == "" {
+		return presenceError(p, stanza.Modify, stanza.JIDMalformed)
+	}
+	if o == nil {
+		return s.join(r, p, nick, els, payload)
+	}
+
+	// If we're already in the room this is a presence update or nickname
+	// change.
+	if o.nick == nick {
+		o.payload = payload
+		return r.broadcast(o, stanza.AvailablePresence, payload, "", "", nil)
+	}
+	if r.byNick(nick) != nil {
+		return presenceError(p, stanza.Cancel, stanza.Conflict)
+	}
+	out := r.broadcast(o, stanza.UnavailablePresence, nil, nick, "", []int{303})
+	o.nick = nick
+	o.payload = payload
+	return append(out, r.broadcast(o, stanza.AvailablePresence, payload, "", "", nil)...)
+}
+
+type joinRequest struct {
+	XMLName  xml.Name `xml:"http://jabber.org/protocol/muc x"`
+	Password string   `xml:"password"`
+	History  *struct {
+		MaxChars   *int   `xml:"maxchars,attr"`
+		MaxStanzas *int   `xml:"maxstanzas,attr"`
+		Seconds    *int   `xml:"seconds,attr"`
+		Since      string `xml:"since,attr"`
+	} `xml:"history"`
+}
+
+func (s *Service) join(r *hostedRoom, p stanza.Presence, nick string, els, payload []element) []xml.TokenReader {
+	var req joinRequest
+	if el, ok := find(els, xml.Name{Space: NS, Local: "x"}); ok {
+		err := el.decode(&req)
+		if err != nil {
+			return presenceError(p, stanza.Modify, stanza.BadRequest)
+		}
+	}
+
+	var created bool
+	if r == nil {
+		r = &hostedRoom{
+			addr:         p.To.Bare(),
+			locked:       true,
+			config:       roomConfig{public: true},
+			affiliations: make(map[string]Affiliation),
+		}
+		r.setAffiliation(p.From, AffiliationOwner)
+		if s.rooms == nil {
+			s.rooms = make(map[string]*hostedRoom)
+		}
+		s.rooms[r.addr.String()] = r
+		created = true
+	}
+
+	aff := r.affiliation(p.From)
+	switch {
+	case r.locked && aff != AffiliationOwner:
+		return presenceError(p, stanza.Cancel, stanza.ItemNotFound)
+	case aff == AffiliationOutcast:
+		return presenceError(p, stanza.Auth, stanza.Forbidden)
+	case r.byNick(nick) != nil:
+		return presenceError(p, stanza.Cancel, stanza.Conflict)
+	case r.config.membersOnly && aff == AffiliationNone:
+		return presenceError(p, stanza.Auth, stanza.RegistrationRequired)
+	case r.config.password != "" && req.Password != r.config.password:
+		return presenceError(p, stanza.Auth, stanza.NotAuthorized)
+	}
+
+	o := &hostedOccupant{
+		nick:    nick,
+		jid:     p.From,
+		role:    defaultRole(aff, r.config.moderated),
+		payload: payload,
+	}
+
+	// Send the existing occupants to the new occupant and the new occupant to
+	// the existing occupants, then finish with the new occupants self-presence.
+	var out []xml.TokenReader
+	for _, occ := range r.occupants {
+		out = append(out, r.presence(occ, o, stanza.AvailablePresence, occ.payload, r.item(occ, o, "", ""), nil))
+	}
+	for _, occ := range r.occupants {
+		out = append(out, r.presence(o, occ, stanza.AvailablePresence, payload, r.item(o, occ, "", ""), nil))
+	}
+	r.occupants = append(r.occupants, o)
+	codes := []int{110}
+	if r.config.nonAnonymous {
+		codes = append(codes, 100)
+	}
+	if created {
+		codes = append(codes, 201)
+	}
+	out = append(out, r.presence(o, o, stanza.AvailablePresence, payload, r.item(o, o, "", ""), statusCodes(codes...)))
+
+	// Then send the discussion history followed by the subject.
+	if req.History == nil || req.History.MaxChars == nil || *req.History.MaxChars != 0 {
+		history := r.history
+		if req.History != nil && req.History.MaxStanzas != nil && *req.History.MaxStanzas < len(history) {
+			n := *req.History.MaxStanzas
+			if n < 0 {
+				n = 0
+			}
+			history = history[len(history)-n:]
+		}
+		var since time.Time
+		if req.History != nil && req.History.Seconds != nil {
+			since = time.Now().Add(-time.Duration(*req.History.Seconds) * time.Second)
+		}
+		if req.History != nil && req.History.Since != "" {
+			t, err := time.Parse(time.RFC3339, req.History.Since)
+			if err == nil && t.After(since) {
+				since = t
+			}
+		}
+		for _, item := range history {
+			if item.stamp.Before(since) {
+				continue
+			}
+			from, _ := r.addr.WithResource(item.nick)
+			out = append(out, stanza.Message{
+				ID:   item.id,
+				From: from,
+				To:   o.jid,
+				Type: stanza.GroupChatMessage,
+			}.Wrap(xmlstream.MultiReader(
+				elementsReader(item.payload),
+				delay.Delay{From: r.addr, Time: item.stamp}.TokenReader(),
+			)))
+		}
+	}
+	subjectFrom := r.addr
+	if r.subjectNick != "" {
+		subjectFrom, _ = r.addr.WithResource(r.subjectNick)
+	}
+	out = append(out, stanza.Message{
+		From: subjectFrom,
+		To:   o.jid,
+		Type: stanza.GroupChatMessage,
+	}.Wrap(xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(r.subject)),
+		xml.StartElement{Name: xml.Name{Local: "subject"}},
+	)))
+	return out
+}
+
+func (s *Service) handleMessage(msg stanza.Message, els []element) []xml.TokenReader {
+	if msg.To.Localpart() == "" || msg.Type == stanza.ErrorMessage {
+		return nil
+	}
+	s.roomsM.Lock()
+	defer s.roomsM.Unlock()
+
+	r := s.room(msg.To)
+	if r == nil {
+		return messageError(msg, stanza.Cancel, stanza.ItemNotFound)
+	}
+	sender := r.byJID(msg.From)
+	if sender == nil {
+		return messageError(msg, stanza.Modify, stanza.NotAcceptable)
+	}
+
+	// Private messages are forwarded to the occupant.
+	if nick := msg.To.Resourcepart(); nick != "" {
+		if msg.Type == stanza.GroupChatMessage {
+			return messageError(msg, stanza.Modify, stanza.BadRequest)
+		}
+		to := r.byNick(nick)
+		if to == nil {
+			return messageError(msg, stanza.Cancel, stanza.ItemNotFound)
+		}
+		return []xml.TokenReader{stanza.Message{
+			ID:   msg.ID,
+			From: r.occupantAddr(sender),
+			To:   to.jid,
+			Type: msg.Type,
+		}.Wrap(xmlstream.MultiReader(elementsReader(els), mucUser()))}
+	}
+
+	switch msg.Type {
+	case stanza.GroupChatMessage:
+		return s.groupchat(r, sender, msg, els)
+	case stanza.NormalMessage:
+		if el, ok := find(els, xml.Name{Space: NSUser, Local: "x"}); ok {
+			return s.invite(r, sender, msg, el)
+		}
+	}
+	return nil
+}
+
+func (s *Service) groupchat(r *hostedRoom, sender *hostedOccupant, msg stanza.Message, els []element) []xml.TokenReader {
+	_, hasBody := find(els, xml.Name{Space: msg.XMLName.Space, Local: "body"})
+	subjectEl, hasSubject := find(els, xml.Name{Space: msg.XMLName.Space, Local: "subject"})
+	switch {
+	case hasSubject && !hasBody:
+		if sender.role != RoleModerator && (!r.config.changeSubject || sender.role != RoleParticipant) {
+			return messageError(msg, stanza.Auth, stanza.Forbidden)
+		}
+		subject := struct {
+			Text string `xml:",chardata"`
+		}{}
+		err := subjectEl.decode(&subject)
+		if err != nil {
+			return messageError(msg, stanza.Modify, stanza.BadRequest)
+		}
+		r.subject = subject.Text
+		r.subjectNick = sender.nick
+	case sender.role == RoleVisitor:
+		return messageError(msg, stanza.Auth, stanza.Forbidden)
+	}
+
+	var out []xml.TokenReader
+	from := r.occupantAddr(sender)
+	for _, to := range r.occupants {
+		out = append(out, stanza.Message{
+			ID:   msg.ID,
+			From: from,
+			To:   to.jid,
+			Type: stanza.GroupChatMessage,
+		}.Wrap(elementsReader(els)))
+	}
+
+	if max := s.maxHistory(); hasBody && max > 0 {
+		r.history = append(r.history, historyItem{
+			nick:    sender.nick,
+			id:      msg.ID,
+			stamp:   time.Now().UTC(),
+			payload: els,
+		})
+		if len(r.history) > max {
+			r.history = r.history[len(r.history)-max:]
+		}
+	}
+	return out
+}
+
+// invite forwards a mediated invitation to the invitee.
+func (s *Service) invite(r *hostedRoom, sender *hostedOccupant, msg stanza.Message, el element) []xml.TokenReader {
+	var invite Invitation
+	err := el.decode(&invite)
+	if err != nil || invite.JID.Equal(jid.JID{}) {
+		return messageError(msg, stanza.Modify, stanza.BadRequest)
+	}
+	aff := r.affiliation(sender.jid)
+	privileged := aff == AffiliationOwner || aff == AffiliationAdmin
+	if r.config.membersOnly && !r.config.allowInvites && !privileged {
+		return messageError(msg, stanza.Auth, stanza.Forbidden)
+	}
+	if r.config.membersOnly && r.affiliation(invite.JID) == AffiliationNone {
+		r.setAffiliation(invite.JID, AffiliationMember)
+	}
+
+	return []xml.TokenReader{stanza.Message{
+		ID:   msg.ID,
+		From: r.addr,
+		To:   invite.JID,
+		Type: stanza.NormalMessage,
+	}.Wrap(mucUser(
+		xmlstream.Wrap(
+			optionalReason(invite.Reason),
+			xml.StartElement{
+				Name: xml.Name{Local: "invite"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "from"}, Value: sender.jid.String()}},
+			},
+		),
+		optionalString(r.config.password, xml.Name{Local: "password"}),
+	))}
+}
@@ -0,0 +1,613 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc
+
+import (
+	"encoding/xml"
+	"sort"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/ping"
+	"mellium.im/xmpp/stanza"
+)
+
+const nsRoomConfig = NS + "#roomconfig"
+
+// form returns the owner configuration form for the room.
+func (c roomConfig) form() *form.Data {
+	boolValue := func(b bool) form.Option {
+		if b {
+			return form.Value("1")
+		}
+		return form.Value("0")
+	}
+	whois := "moderators"
+	if c.nonAnonymous {
+		whois = "anyone"
+	}
+	return form.New(
+		form.Hidden("FORM_TYPE", form.Value(nsRoomConfig)),
+		form.Text("muc#roomconfig_roomname", form.Label("Natural-Language Room Name"), form.Value(c.name)),
+		form.Text("muc#roomconfig_roomdesc", form.Label("Short Description of Room"), form.Value(c.desc)),
+		form.Boolean("muc#roomconfig_persistentroom", form.Label("Make Room Persistent?"), boolValue(c.persistent)),
+		form.Boolean("muc#roomconfig_publicroom", form.Label("Make Room Publicly Searchable?"), boolValue(c.public)),
+		form.Boolean("muc#roomconfig_membersonly", form.Label("Make Room Members-Only?"), boolValue(c.membersOnly)),
+		form.Boolean("muc#roomconfig_moderatedroom", form.Label("Make Room Moderated?"), boolValue(c.moderated)),
+		form.List("muc#roomconfig_whois",
+			form.Label("Who May Discover Real JIDs?"),
+			form.ListItem("Moderators Only", "moderators"),
+			form.ListItem("Anyone", "anyone"),
+			form.Value(whois),
+		),
+		form.Boolean("muc#roomconfig_changesubject", form.Label("Allow Occupants to Change Subject?"), boolValue(c.changeSubject)),
+		form.Boolean("muc#roomconfig_allowinvites", form.Label("Allow Occupants to Invite Others?"), boolValue(c.allowInvites)),
+		form.Boolean("muc#roomconfig_passwordprotectedroom", form.Label("Password Required to Enter?"), boolValue(c.password != "")),
+		form.TextPrivate("muc#roomconfig_roomsecret", form.Label("Password"), form.Value(c.password)),
+	)
+}
+
+// formBool returns a boolean form value.
+// Submitted forms do not have to include the field type, so the raw string
+// values are accepted as well.
+func formBool(data *form.Data, id string) (b, ok bool) {
+	v, ok := data.Get(id)
+	switch vv := v.(type) {
+	case bool:
+		return vv, ok
+	case string:
+		return vv == "1" || vv == "true", ok
+	}
+	return false, false
+}
+
+// update applies any values submitted in data to the config.
+func (c *roomConfig) update(data *form.Data) {
+	for id, v := range map[string]*bool{
+		"muc#roomconfig_persistentroom": &c.persistent,
+		"muc#roomconfig_publicroom":     &c.public,
+		"muc#roomconfig_membersonly":    &c.membersOnly,
+		"muc#roomconfig_moderatedroom":  &c.moderated,
+		"muc#roomconfig_changesubject":  &c.changeSubject,
+		"muc#roomconfig_allowinvites":   &c.allowInvites,
+	} {
+		if b, ok := formBool(data, id); ok {
+			*v = b
+		}
+	}
+	if s, ok := data.GetString("muc#roomconfig_roomname"); ok {
+		c.name = s
+	}
+	if s, ok := data.GetString("muc#roomconfig_roomdesc"); ok {
+		c.desc = s
+	}
+	if s, ok := data.GetString("muc#roomconfig_whois"); ok {
+		c.nonAnonymous = s == "anyone"
+	}
+	if s, ok := data.GetString("muc#roomconfig_roomsecret"); ok {
+		c.password = s
+	}
+	if b, ok := formBool(data, "muc#roomconfig_passwordprotectedroom"); ok && !b {
+		c.password = ""
+	}
+}
+
+func iqError(iq stanza.IQ, typ stanza.ErrorType, cond stanza.Condition) []xml.TokenReader {
+	return []xml.TokenReader{iq.Error(stanza.Error{
+		By:        iq.To.Bare(),
+		Type:      typ,
+		Condition: cond,
+	})}
+}
+
+func (s *Service) handleIQ(iq stanza.IQ, els []element) []xml.TokenReader {
+	if iq.Type != stanza.GetIQ && iq.Type != stanza.SetIQ {
+		return nil
+	}
+	if len(els) == 0 {
+		return iqError(iq, stanza.Modify, stanza.BadRequest)
+	}
+	payload := els[0]
+
+	s.roomsM.Lock()
+	defer s.roomsM.Unlock()
+
+	name := payload.start().Name
+	switch {
+	case name == xml.Name{Space: ping.NS, Local: "ping"} && iq.Type == stanza.GetIQ:
+		return s.ping(iq)
+	case name == xml.Name{Space: disco.NSInfo, Local: "query"} && iq.Type == stanza.GetIQ:
+		return s.info(iq, payload)
+	case name == xml.Name{Space: disco.NSItems, Local: "query"} && iq.Type == stanza.GetIQ:
+		return s.items(iq)
+	case iq.To.Localpart() == "" || iq.To.Resourcepart() != "":
+	case name == xml.Name{Space: NSOwner, Local: "query"}:
+		return s.owner(iq, payload)
+	case name == xml.Name{Space: NSAdmin, Local: "query"}:
+		return s.admin(iq, payload)
+	}
+	return iqError(iq, stanza.Cancel, stanza.ServiceUnavailable)
+}
+
+// ping responds to pings sent to the service, to a room, or to our own
+// occupant JID as described in XEP-0410: MUC Self-Ping (Schrödinger's Chat).
+func (s *Service) ping(iq stanza.IQ) []xml.TokenReader {
+	if iq.To.Localpart() == "" {
+		return []xml.TokenReader{iq.Result(nil)}
+	}
+	r := s.room(iq.To)
+	if r == nil {
+		return iqError(iq, stanza.Cancel, stanza.ItemNotFound)
+	}
+	nick := iq.To.Resourcepart()
+	if nick == "" {
+		return []xml.TokenReader{iq.Result(nil)}
+	}
+	if o := r.byJID(iq.From); o == nil || o.nick != nick {
+		return iqError(iq, stanza.Cancel, stanza.NotAcceptable)
+	}
+	return []xml.TokenReader{iq.Result(nil)}
+}
+
+func identity(category, typ, name string) xml.TokenReader {
+	attr := []xml.Attr{
+		{Name: xml.Name{Local: "category"}, Value: category},
+		{Name: xml.Name{Local: "type"}, Value: typ},
+	}
+	if name != "" {
+		attr = append(attr, xml.Attr{Name: xml.Name{Local: "name"}, Value: name})
+	}
+	return xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Local: "identity"}, Attr: attr})
+}
+
+func features(vars ...string) xml.TokenReader {
+	var r []xml.TokenReader
+	for _, v := range vars {
+		r = append(r, xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: "feature"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "var"}, Value: v}},
+		}))
+	}
+	return xmlstream.MultiReader(r...)
+}
+
+func pick(b bool, yes, no string) string {
+	if b {
+		return yes
+	}
+	return no
+}
+
+func (s *Service) info(iq stanza.IQ, payload element) []xml.TokenReader {
+	query := xml.StartElement{Name: xml.Name{Space: disco.NSInfo, Local: "query"}}
+	if iq.To.Localpart() == "" {
+		return []xml.TokenReader{iq.Result(xmlstream.Wrap(
+			xmlstream.MultiReader(
+				identity("conference", "text", ""),
+				features(disco.NSInfo, disco.NSItems, NS, ping.NS),
+			),
+			query,
+		))}
+	}
+
+	r := s.room(iq.To)
+	if r == nil || iq.To.Resourcepart() != "" {
+		return iqError(iq, stanza.Cancel, stanza.ItemNotFound)
+	}
+
+	// Clients may request the nickname they are using in the room before
+	// joining.
+	for _, attr := range payload.start().Attr {
+		if attr.Name.Local != "node" || attr.Value != "x-roomuser-item" {
+			continue
+		}
+		query.Attr = append(query.Attr, attr)
+		var ident xml.TokenReader
+		if o := r.byJID(iq.From); o != nil {
+			ident = identity("conference", "text", o.nick)
+		}
+		return []xml.TokenReader{iq.Result(xmlstream.Wrap(ident, query))}
+	}
+
+	return []xml.TokenReader{iq.Result(xmlstream.Wrap(
+		xmlstream.MultiReader(
+			identity("conference", "text", r.config.name),
+			features(
+				NS,
+				pick(r.config.public, "muc_public", "muc_hidden"),
+				pick(r.config.persistent, "muc_persistent", "muc_temporary"),
+				pick(r.config.membersOnly, "muc_membersonly", "muc_open"),
+				pick(r.config.moderated, "muc_moderated", "muc_unmoderated"),
+				pick(r.config.nonAnonymous, "muc_nonanonymous", "muc_semianonymous"),
+				pick(r.config.password != "", "muc_passwordprotected", "muc_unsecured"),
+			),
+		),
+		query,
+	))}
+}
+
+// items lists the public rooms hosted by the service.
+func (s *Service) items(iq stanza.IQ) []xml.TokenReader {
+	var items []xml.TokenReader
+	if iq.To.Localpart() == "" {
+		var rooms []*hostedRoom
+		for _, r := range s.rooms {
+			if r.config.public && !r.locked {
+				rooms = append(rooms, r)
+			}
+		}
+		sort.Slice(rooms, func(i, j int) bool {
+			return rooms[i].addr.String() < rooms[j].addr.String()
+		})
+		for _, r := range rooms {
+			attr := []xml.Attr{{Name: xml.Name{Local: "jid"}, Value: r.addr.String()}}
+			if r.config.name != "" {
+				attr = append(attr, xml.Attr{Name: xml.Name{Local: "name"}, Value: r.config.name})
+			}
+			items = append(items, xmlstream.Wrap(nil, xml.StartElement{
+				Name: xml.Name{Local: "item"},
+				Attr: attr,
+			}))
+		}
+	}
+	return []xml.TokenReader{iq.Result(xmlstream.Wrap(
+		xmlstream.MultiReader(items...),
+		xml.StartElement{Name: xml.Name{Space: disco.NSItems, Local: "query"}},
+	))}
+}
+
+func (s *Service) owner(iq stanza.IQ, payload element) []xml.TokenReader {
+	r := s.room(iq.To)
+	if r == nil {
+		return iqError(iq, stanza.Cancel, stanza.ItemNotFound)
+	}
+	if r.affiliation(iq.From) != AffiliationOwner {
+		return iqError(iq, stanza.Auth, stanza.Forbidden)
+	}
+	query := xml.StartElement{Name: xml.Name{Space: NSOwner, Local: "query"}}
+
+	if iq.Type == stanza.GetIQ {
+		return []xml.TokenReader{iq.Result(xmlstream.Wrap(r.config.form().TokenReader(), query))}
+	}
+
+	t := tokenSlice(payload[1:])
+	children, err := readElements(xmlstream.Inner(&t))
+	if err != nil || len(children) == 0 {
+		return iqError(iq, stanza.Modify, stanza.BadRequest)
+	}
+	child := children[0]
+
+	switch child.start().Name {
+	case xml.Name{Space: NSOwner, Local: "destroy"}:
+		return append(s.destroy(r, child), iq.Result(nil))
+	case xml.Name{Space: form.NS, Local: "x"}:
+	default:
+		return iqError(iq, stanza.Cancel, stanza.FeatureNotImplemented)
+	}
+
+	var typ string
+	for _, attr := range child.start().Attr {
+		if attr.Name.Local == "type" {
+			typ = attr.Value
+		}
+	}
+	switch form.Type(typ) {
+	case form.TypeCancel:
+		// Canceling the initial configuration of a reserved room destroys it.
+		if !r.locked {
+			return []xml.TokenReader{iq.Result(nil)}
+		}
+		return append(s.destroy(r, nil), iq.Result(nil))
+	case form.TypeSubmit:
+	default:
+		return iqError(iq, stanza.Modify, stanza.BadRequest)
+	}
+
+	data := &form.Data{}
+	err = child.decode(data)
+	if err != nil {
+		return iqError(iq, stanza.Modify, stanza.BadRequest)
+	}
+	wasMembersOnly := r.config.membersOnly
+	r.config.update(data)
+
+	out := []xml.TokenReader{iq.Result(nil)}
+	if r.locked {
+		r.locked = false
+		return out
+	}
+
+	// Let everyone know that the configuration changed and remove anyone who
+	// is no longer allowed to be in the room.
+	for _, o := range r.occupants {
+		out = append(out, stanza.Message{
+			From: r.addr,
+			To:   o.jid,
+			Type: stanza.GroupChatMessage,
+		}.Wrap(mucUser(statusCodes(104))))
+	}
+	if r.config.membersOnly && !wasMembersOnly {
+		for _, o := range append([]*hostedOccupant(nil), r.occupants...) {
+			if r.affiliation(o.jid) == AffiliationNone {
+				out = append(out, r.evict(o, "", 322)...)
+			}
+		}
+	}
+	return out
+}
+
+// destroy removes all occupants from the room and deletes it.
+// If el is non-nil it is the destroy element containing the reason for
+// destroying the room and an optional alternate venue.
+func (s *Service) destroy(r *hostedRoom, el element) []xml.TokenReader {
+	var out []xml.TokenReader
+	for _, o := range r.occupants {
+		o.role = RoleNone
+	}
+	for _, o := range r.occupants {
+		destroy := xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Local: "destroy"}})
+		if el != nil {
+			// Move the owners destroy element into the muc#user namespace.
+			t := make(tokenSlice, 0, len(el))
+			for _, tok := range el {
+				switch tt := tok.(type) {
+				case xml.StartElement:
+					tt.Name.Space = ""
+					tok = tt
+				case xml.EndElement:
+					tt.Name.Space = ""
+					tok = tt
+				}
+				t = append(t, tok)
+			}
+			destroy = &t
+		}
+		out = append(out, r.presence(o, o, stanza.UnavailablePresence, nil, xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: "item"},
+			Attr: []xml.Attr{
+				{Name: xml.Name{Local: "affiliation"}, Value: AffiliationNone.String()},
+				{Name: xml.Name{Local: "role"}, Value: RoleNone.String()},
+			},
+		}), destroy, statusCodes(110)))
+	}
+	r.occupants = nil
+	s.deleteRoom(r)
+	return out
+}
+
+// adminItem is a muc#admin item where unset attributes can be distinguished
+// from the zero value.
+type adminItem struct {
+	jid         jid.JID
+	nick        string
+	affiliation *Affiliation
+	role        *Role
+	reason      string
+}
+
+func parseAdminItem(el element) (adminItem, error) {
+	var item adminItem
+	for _, attr := range el.start().Attr {
+		var err error
+		switch attr.Name.Local {
+		case "jid":
+			item.jid, err = jid.Parse(attr.Value)
+		case "nick":
+			item.nick = attr.Value
+		case "affiliation":
+			item.affiliation = new(Affiliation)
+			err = item.affiliation.UnmarshalXMLAttr(attr)
+		case "role":
+			item.role = new(Role)
+			err = item.role.UnmarshalXMLAttr(attr)
+		}
+		if err != nil {
+			return item, err
+		}
+	}
+	reason := struct {
+		Reason string `xml:"reason"`
+	}{}
+	err := el.decode(&reason)
+	item.reason = reason.Reason
+	return item, err
+}
+
+func (s *Service) admin(iq stanza.IQ, payload element) []xml.TokenReader {
+	r := s.room(iq.To)
+	if r == nil {
+		return iqError(iq, stanza.Cancel, stanza.ItemNotFound)
+	}
+	t := tokenSlice(payload[1:])
+	children, err := readElements(xmlstream.Inner(&t))
+	if err != nil {
+		return iqError(iq, stanza.Modify, stanza.BadRequest)
+	}
+	var items []adminItem
+	for _, child := range children {
+		if child.start().Name.Local != "item" {
+			continue
+		}
+		item, err := parseAdminItem(child)
+		if err != nil {
+			return iqError(iq, stanza.Modify, stanza.BadRequest)
+		}
+		items = append(items, item)
+	}
+	if len(items) == 0 {
+		return iqError(iq, stanza.Modify, stanza.BadRequest)
+	}
+
+	if iq.Type == stanza.GetIQ {
+		return r.list(iq, items[0])
+	}
+
+	// The items are applied to a copy of the room first so that if any of them
+	// fail the room is left unchanged and no presence is broadcast.
+	if _, errType, cond := r.clone().applyAdmin(iq.From, items); cond != "" {
+		return iqError(iq, errType, cond)
+	}
+	out, _, _ := r.applyAdmin(iq.From, items)
+	return append(out, iq.Result(nil))
+}
+
+// applyAdmin applies the items from a muc#admin set request in order and
+// returns the resulting presence broadcasts, or the error for the first item
+// that could not be applied.
+func (r *hostedRoom) applyAdmin(from jid.JID, items []adminItem) ([]xml.TokenReader, stanza.ErrorType, stanza.Condition) {
+	var out []xml.TokenReader
+	for _, item := range items {
+		var (
+			updates []xml.TokenReader
+			errType stanza.ErrorType
+			cond    stanza.Condition
+		)
+		switch {
+		case item.affiliation != nil && !item.jid.Equal(jid.JID{}):
+			updates, errType, cond = r.changeAffiliation(from, item)
+		case item.role != nil && item.nick != "":
+			updates, errType, cond = r.changeRole(from, item)
+		default:
+			return nil, stanza.Modify, stanza.BadRequest
+		}
+		if cond != "" {
+			return nil, errType, cond
+		}
+		out = append(out, updates...)
+	}
+	return out, "", ""
+}
+
+// list returns the users with the affiliation or role requested by item.
+func (r *hostedRoom) list(iq stanza.IQ, item adminItem) []xml.TokenReader {
+	var items []xml.TokenReader
+	itemAttr := func(j jid.JID, a Affiliation, nick string, role *Role) xml.TokenReader {
+		attr := []xml.Attr{
+			{Name: xml.Name{Local: "affiliation"}, Value: a.String()},
+			{Name: xml.Name{Local: "jid"}, Value: j.String()},
+		}
+		if nick != "" {
+			attr = append(attr, xml.Attr{Name: xml.Name{Local: "nick"}, Value: nick})
+		}
+		if role != nil {
+			attr = append(attr, xml.Attr{Name: xml.Name{Local: "role"}, Value: role.String()})
+		}
+		return xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Local: "item"}, Attr: attr})
+	}
+
+	switch {
+	case item.affiliation != nil:
+		aff := r.affiliation(iq.From)
+		if aff != AffiliationOwner && aff != AffiliationAdmin {
+			return iqError(iq, stanza.Auth, stanza.Forbidden)
+		}
+		var jids []string
+		for j, a := range r.affiliations {
+			if a == *item.affiliation {
+				jids = append(jids, j)
+			}
+		}
+		sort.Strings(jids)
+		for _, j := range jids {
+			parsed, err := jid.Parse(j)
+			if err != nil {
+				continue
+			}
+			items = append(items, itemAttr(parsed, *item.affiliation, "", nil))
+		}
+	case item.role != nil:
+		if o := r.byJID(iq.From); o == nil || o.role != RoleModerator {
+			return iqError(iq, stanza.Auth, stanza.Forbidden)
+		}
+		for _, o := range r.occupants {
+			if o.role == *item.role {
+				role := o.role
+				items = append(items, itemAttr(o.jid, r.affiliation(o.jid), o.nick, &role))
+			}
+		}
+	default:
+		return iqError(iq, stanza.Modify, stanza.BadRequest)
+	}
+	return []xml.TokenReader{iq.Result(xmlstream.Wrap(
+		xmlstream.MultiReader(items...),
+		xml.StartElement{Name: xml.Name{Space: NSAdmin, Local: "query"}},
+	))}
+}
+
+// changeRole changes the role of an occupant, removing them from the room if
+// the new role is none.
+func (r *hostedRoom) changeRole(from jid.JID, item adminItem) ([]xml.TokenReader, stanza.ErrorType, stanza.Condition) {
+	actor := r.byJID(from)
+	if actor == nil || actor.role != RoleModerator {
+		return nil, stanza.Auth, stanza.Forbidden
+	}
+	o := r.byNick(item.nick)
+	if o == nil {
+		return nil, stanza.Cancel, stanza.ItemNotFound
+	}
+	actorAff := r.affiliation(actor.jid)
+	targetAff := r.affiliation(o.jid)
+	actorPrivileged := actorAff == AffiliationOwner || actorAff == AffiliationAdmin
+	switch {
+	case (targetAff == AffiliationOwner || targetAff == AffiliationAdmin) && *item.role != RoleModerator:
+		// Moderators can't kick or revoke voice from admins and owners.
+		return nil, stanza.Cancel, stanza.NotAllowed
+	case (*item.role == RoleModerator || o.role == RoleModerator) && !actorPrivileged:
+		// Only admins and owners may grant or revoke moderator status.
+		return nil, stanza.Auth, stanza.Forbidden
+	}
+
+	if *item.role == RoleNone {
+		return r.evict(o, item.reason, 307), "", ""
+	}
+	o.role = *item.role
+	return r.broadcast(o, stanza.AvailablePresence, o.payload, "", item.reason, nil), "", ""
+}
+
+// changeAffiliation changes the affiliation of a user, updating or removing
+// any of their occupants in the room.
+func (r *hostedRoom) changeAffiliation(from jid.JID, item adminItem) ([]xml.TokenReader, stanza.ErrorType, stanza.Condition) {
+	actorAff := r.affiliation(from)
+	oldAff := r.affiliation(item.jid)
+	newAff := *item.affiliation
+	switch {
+	case actorAff != AffiliationOwner && actorAff != AffiliationAdmin:
+		return nil, stanza.Auth, stanza.Forbidden
+	case actorAff != AffiliationOwner && (oldAff == AffiliationOwner || oldAff == AffiliationAdmin ||
+		newAff == AffiliationOwner || newAff == AffiliationAdmin):
+		// Only owners may modify other owners and admins.
+		return nil, stanza.Auth, stanza.Forbidden
+	case oldAff == AffiliationOwner && newAff != AffiliationOwner:
+		var owners int
+		for _, a := range r.affiliations {
+			if a == AffiliationOwner {
+				owners++
+			}
+		}
+		if owners == 1 {
+			return nil, stanza.Cancel, stanza.Conflict
+		}
+	}
+	r.setAffiliation(item.jid, newAff)
+
+	var out []xml.TokenReader
+	for _, o := range append([]*hostedOccupant(nil), r.occupants...) {
+		if !o.jid.Bare().Equal(item.jid.Bare()) {
+			continue
+		}
+		switch {
+		case newAff == AffiliationOutcast:
+			out = append(out, r.evict(o, item.reason, 301)...)
+		case newAff == AffiliationNone && r.config.membersOnly:
+			out = append(out, r.evict(o, item.reason, 321)...)
+		default:
+			o.role = defaultRole(newAff, r.config.moderated)
+			out = append(out, r.broadcast(o, stanza.AvailablePresence, o.payload, "", item.reason, nil)...)
+		}
+	}
+	return out, "", ""
+}
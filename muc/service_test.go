@@ -0,0 +1,585 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/delay"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// serviceRouter connects several clients to a single muc.Service.
+// Stanzas sent by each client are stamped with the clients JID and stanzas
+// written by the service are routed to the client they are addressed to.
+type serviceRouter struct {
+	svc    *muc.Service
+	queueM sync.Mutex
+	queues map[string]chan xmpptest.Tokens
+}
+
+func (r *serviceRouter) connect(t *testing.T, j jid.JID, h xmpp.Handler) *xmpp.Session {
+	queue := make(chan xmpptest.Tokens, 100)
+	r.queueM.Lock()
+	if r.queues == nil {
+		r.queues = make(map[string]chan xmpptest.Tokens)
+	}
+	r.queues[j.String()] = queue
+	r.queueM.Unlock()
+
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(h),
+		xmpptest.ServerHandlerFunc(func(rw xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			stamped := start.Copy()
+			stamped.Attr = append(stamped.Attr[:0:0], xml.Attr{Name: xml.Name{Local: "from"}, Value: j.String()})
+			for _, attr := range start.Attr {
+				if attr.Name.Local != "from" {
+					stamped.Attr = append(stamped.Attr, attr)
+				}
+			}
+			return r.svc.HandleXMPP(&routingEncoder{TokenReader: rw, rw: rw, self: j, router: r}, &stamped)
+		}),
+	)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case tokens := <-queue:
+				/* #nosec */
+				s.Server.Send(context.Background(), &tokens)
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		close(done)
+		/* #nosec */
+		s.Close()
+	})
+	return s.Client
+}
+
+func (r *serviceRouter) route(tokens xmpptest.Tokens) {
+	var to string
+	for _, attr := range tokens[0].(xml.StartElement).Attr {
+		if attr.Name.Local == "to" {
+			to = attr.Value
+		}
+	}
+	r.queueM.Lock()
+	queue, ok := r.queues[to]
+	r.queueM.Unlock()
+	if ok {
+		queue <- tokens
+	}
+}
+
+// routingEncoder writes stanzas addressed to the client being handled directly
+// to its session and queues everything else to be sent to the other clients.
+type routingEncoder struct {
+	xml.TokenReader
+	rw     xmlstream.TokenReadEncoder
+	self   jid.JID
+	router *serviceRouter
+	depth  int
+	stanza xmpptest.Tokens
+}
+
+func (e *routingEncoder) EncodeToken(t xml.Token) error {
+	e.stanza = append(e.stanza, xml.CopyToken(t))
+	switch t.(type) {
+	case xml.StartElement:
+		e.depth++
+	case xml.EndElement:
+		e.depth--
+	}
+	if e.depth > 0 {
+		return nil
+	}
+	tokens := e.stanza
+	e.stanza = nil
+	if tokens[0].(xml.StartElement).Attr != nil {
+		for _, attr := range tokens[0].(xml.StartElement).Attr {
+			if attr.Name.Local == "to" && attr.Value == e.self.String() {
+				_, err := xmlstream.Copy(e.rw, &tokens)
+				return err
+			}
+		}
+	}
+	e.router.route(tokens)
+	return nil
+}
+
+func (e *routingEncoder) Encode(interface{}) error {
+	return errors.New("routingEncoder: Encode not supported")
+}
+
+func (e *routingEncoder) EncodeElement(interface{}, xml.StartElement) error {
+	return errors.New("routingEncoder: EncodeElement not supported")
+}
+
+func stanzaCondition(err error) stanza.Condition {
+	var stanzaErr stanza.Error
+	if errors.As(err, &stanzaErr) {
+		return stanzaErr.Condition
+	}
+	return ""
+}
+
+type groupchatMessage struct {
+	stanza.Message
+	Body  string      `xml:"body"`
+	Delay delay.Delay `xml:"urn:xmpp:delay delay"`
+}
+
+func TestService(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	room := jid.MustParse("coven@chat.shakespeare.lit")
+	crone := jid.MustParse("crone1@shakespeare.lit/desktop")
+	hag := jid.MustParse("hag66@shakespeare.lit/pda")
+	wiccan := jid.MustParse("wiccarocks@shakespeare.lit/laptop")
+	router := &serviceRouter{svc: &muc.Service{}}
+
+	messages := make(chan groupchatMessage, 10)
+	handleMessage := mux.MessageFunc(stanza.GroupChatMessage, xml.Name{Local: "body"}, func(_ stanza.Message, r xmlstream.TokenReadEncoder) error {
+		var msg groupchatMessage
+		err := xml.NewTokenDecoder(r).Decode(&msg)
+		if err != nil {
+			return err
+		}
+		messages <- msg
+		return nil
+	})
+
+	// The first user creates the room and becomes its owner.
+	owner := &muc.Client{}
+	ownerSession := router.connect(t, crone, mux.New(muc.HandleClient(owner), handleMessage))
+	ownerChannel, err := owner.Create(ctx, jid.MustParse("coven@chat.shakespeare.lit/firstwitch"), ownerSession, nil)
+	if err != nil {
+		t.Fatalf("error creating room: %v", err)
+	}
+
+	// A second user joins and is sent invites and subject changes.
+	subjects := make(chan muc.SubjectChanged, 10)
+	invites := make(chan muc.Invitation, 1)
+	hagStates := make(chan muc.State, 10)
+	hagClient := &muc.Client{
+		Tracker: &muc.Tracker{
+			HandleEvent: func(e muc.Event) {
+				if subject, ok := e.(muc.SubjectChanged); ok {
+					subjects <- subject
+				}
+			},
+		},
+		HandleInvite: func(invite muc.Invitation) {
+			invites <- invite
+		},
+		HandleState: func(_ *muc.Channel, state muc.State) {
+			hagStates <- state
+		},
+	}
+	hagSession := router.connect(t, hag, mux.New(muc.HandleClient(hagClient)))
+	_, err = hagClient.Join(ctx, jid.MustParse("coven@chat.shakespeare.lit/secondwitch"), hagSession)
+	if err != nil {
+		t.Fatalf("error joining room: %v", err)
+	}
+	if state := <-hagStates; state != muc.StateJoined {
+		t.Fatalf("wrong state after joining: %v", state)
+	}
+	if subject := <-subjects; subject.Subject != "" {
+		t.Errorf("expected empty initial subject, got %q", subject.Subject)
+	}
+
+	wiccanStates := make(chan muc.State, 10)
+	wiccanClient := &muc.Client{
+		HandleState: func(_ *muc.Channel, state muc.State) {
+			wiccanStates <- state
+		},
+	}
+	wiccanSession := router.connect(t, wiccan, mux.New(muc.HandleClient(wiccanClient), handleMessage))
+	_, err = wiccanClient.Join(ctx, jid.MustParse("coven@chat.shakespeare.lit/secondwitch"), wiccanSession)
+	if cond := stanzaCondition(err); cond != stanza.Conflict {
+		t.Errorf("wrong error when joining with a taken nickname: want=%v, got=%v", stanza.Conflict, err)
+	}
+	_, err = muc.GetConfig(ctx, room, hagSession)
+	if cond := stanzaCondition(err); cond != stanza.Forbidden {
+		t.Errorf("wrong error getting config as a participant: want=%v, got=%v", stanza.Forbidden, err)
+	}
+
+	err = ownerChannel.Subject(ctx, "Fire Burn and Cauldron Bubble!")
+	if err != nil {
+		t.Fatalf("error setting subject: %v", err)
+	}
+	if subject := <-subjects; subject.Subject != "Fire Burn and Cauldron Bubble!" || subject.Nick != "firstwitch" {
+		t.Errorf("wrong subject change: %+v", subject)
+	}
+
+	err = ownerChannel.Invite(ctx, "Hecate is coming.", hag)
+	if err != nil {
+		t.Fatalf("error sending invite: %v", err)
+	}
+	if invite := <-invites; invite.Reason != "Hecate is coming." {
+		t.Errorf("wrong invite reason: %q", invite.Reason)
+	}
+
+	// Messages are stored in the history and sent to new occupants.
+	err = ownerSession.Send(ctx, stanza.Message{
+		ID:   "history",
+		To:   room,
+		Type: stanza.GroupChatMessage,
+	}.Wrap(xmlstream.Wrap(
+		xmlstream.Token(xml.CharData("Thrice the brinded cat hath mew'd.")),
+		xml.StartElement{Name: xml.Name{Local: "body"}},
+	)))
+	if err != nil {
+		t.Fatalf("error sending message: %v", err)
+	}
+	if msg := <-messages; !msg.Delay.Time.IsZero() || msg.ID != "history" {
+		t.Errorf("wrong echoed message: %+v", msg)
+	}
+
+	_, err = wiccanClient.Join(ctx, jid.MustParse("coven@chat.shakespeare.lit/thirdwitch"), wiccanSession)
+	if err != nil {
+		t.Fatalf("error joining room: %v", err)
+	}
+	<-wiccanStates
+	msg := <-messages
+	if msg.Body != "Thrice the brinded cat hath mew'd." || msg.From.Resourcepart() != "firstwitch" {
+		t.Errorf("wrong history message: %+v", msg)
+	}
+	if msg.Delay.Time.IsZero() || !msg.Delay.From.Equal(room) {
+		t.Errorf("expected history message to be delayed by the room, got %+v", msg.Delay)
+	}
+
+	// Moderation.
+	err = ownerChannel.Kick(ctx, "thirdwitch", "")
+	if err != nil {
+		t.Fatalf("error kicking: %v", err)
+	}
+	if state := <-wiccanStates; state != muc.StateLeft {
+		t.Errorf("wrong state after being kicked: %v", state)
+	}
+	err = ownerChannel.Kick(ctx, "firstwitch", "")
+	if !errors.Is(err, muc.ErrNotAllowed) {
+		t.Errorf("wrong error kicking owner: want=%v, got=%v", muc.ErrNotAllowed, err)
+	}
+	err = ownerChannel.SetAffiliation(ctx, muc.AffiliationMember, crone, "", "")
+	if !errors.Is(err, muc.ErrConflict) {
+		t.Errorf("wrong error removing last owner: want=%v, got=%v", muc.ErrConflict, err)
+	}
+	err = ownerChannel.Ban(ctx, hag, "")
+	if err != nil {
+		t.Fatalf("error banning: %v", err)
+	}
+	if state := <-hagStates; state != muc.StateLeft {
+		t.Errorf("wrong state after being banned: %v", state)
+	}
+	_, err = hagClient.Join(ctx, jid.MustParse("coven@chat.shakespeare.lit/secondwitch"), hagSession)
+	if cond := stanzaCondition(err); cond != stanza.Forbidden {
+		t.Errorf("wrong error joining after ban: want=%v, got=%v", stanza.Forbidden, err)
+	}
+	iter := ownerChannel.Outcasts(ctx)
+	var outcasts []jid.JID
+	for iter.Next() {
+		outcasts = append(outcasts, iter.Item().JID)
+	}
+	if err := iter.Err(); err != nil {
+		t.Errorf("error listing outcasts: %v", err)
+	}
+	/* #nosec */
+	iter.Close()
+	if len(outcasts) != 1 || !outcasts[0].Equal(hag.Bare()) {
+		t.Errorf("wrong outcasts: %v", outcasts)
+	}
+
+	// Configuration.
+	config, err := muc.GetConfig(ctx, room, ownerSession)
+	if err != nil {
+		t.Fatalf("error getting config: %v", err)
+	}
+	_, err = config.Set("muc#roomconfig_membersonly", true)
+	if err != nil {
+		t.Fatalf("error setting members only: %v", err)
+	}
+	err = muc.SetConfig(ctx, room, config, ownerSession)
+	if err != nil {
+		t.Fatalf("error setting config: %v", err)
+	}
+	_, err = wiccanClient.Join(ctx, jid.MustParse("coven@chat.shakespeare.lit/thirdwitch"), wiccanSession)
+	if cond := stanzaCondition(err); cond != stanza.RegistrationRequired {
+		t.Errorf("wrong error joining members-only room: want=%v, got=%v", stanza.RegistrationRequired, err)
+	}
+
+	err = ownerChannel.Destroy(ctx, "Macbeth doth come.", jid.JID{}, "")
+	if err != nil {
+		t.Fatalf("error destroying room: %v", err)
+	}
+	if ownerChannel.Joined() {
+		t.Errorf("expected owner to have left destroyed room")
+	}
+}
+
+// recordingEncoder collects the stanzas written by a muc.Service.
+type recordingEncoder struct {
+	xml.TokenReader
+	depth   int
+	stanza  xmpptest.Tokens
+	stanzas []xmpptest.Tokens
+}
+
+func (e *recordingEncoder) EncodeToken(t xml.Token) error {
+	e.stanza = append(e.stanza, xml.CopyToken(t))
+	switch t.(type) {
+	case xml.StartElement:
+		e.depth++
+	case xml.EndElement:
+		e.depth--
+	}
+	if e.depth == 0 {
+		e.stanzas = append(e.stanzas, e.stanza)
+		e.stanza = nil
+	}
+	return nil
+}
+
+func (e *recordingEncoder) Encode(interface{}) error {
+	return errors.New("recordingEncoder: Encode not supported")
+}
+
+func (e *recordingEncoder) EncodeElement(interface{}, xml.StartElement) error {
+	return errors.New("recordingEncoder: EncodeElement not supported")
+}
+
+type adminResponse struct {
+	XMLName xml.Name
+	Type    string        `xml:"type,attr"`
+	Err     *stanza.Error `xml:"error"`
+	Query   struct {
+		Items []struct {
+			JID string `xml:"jid,attr"`
+		} `xml:"item"`
+	} `xml:"http://jabber.org/protocol/muc#admin query"`
+}
+
+func adminAttr(attr ...string) []xml.Attr {
+	var attrs []xml.Attr
+	for i := 0; i < len(attr); i += 2 {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: attr[i]}, Value: attr[i+1]})
+	}
+	return attrs
+}
+
+func TestServiceAdmin(t *testing.T) {
+	room := jid.MustParse("coven@chat.shakespeare.lit")
+	crone := jid.MustParse("crone1@shakespeare.lit/desktop")
+	hag := jid.MustParse("hag66@shakespeare.lit/pda")
+	wiccan := jid.MustParse("wiccarocks@shakespeare.lit/laptop")
+	bard := jid.MustParse("bard@shakespeare.lit/globe")
+
+	// handle passes a stanza from the provided user to the service and returns
+	// the stanzas written in response.
+	handle := func(t *testing.T, svc *muc.Service, from jid.JID, r xml.TokenReader) []xmpptest.Tokens {
+		t.Helper()
+		tok, err := r.Token()
+		if err != nil {
+			t.Fatalf("error reading stanza: %v", err)
+		}
+		start := tok.(xml.StartElement)
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "from"}, Value: from.String()})
+		e := &recordingEncoder{TokenReader: r}
+		err = svc.HandleXMPP(e, &start)
+		if err != nil {
+			t.Fatalf("error handling stanza: %v", err)
+		}
+		return e.stanzas
+	}
+	adminIQ := func(typ stanza.IQType, items ...[]xml.Attr) xml.TokenReader {
+		var payload []xml.TokenReader
+		for _, item := range items {
+			payload = append(payload, xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Local: "item"}, Attr: item}))
+		}
+		return stanza.IQ{ID: "admin", To: room, Type: typ}.Wrap(xmlstream.Wrap(
+			xmlstream.MultiReader(payload...),
+			xml.StartElement{Name: xml.Name{Space: muc.NSAdmin, Local: "query"}},
+		))
+	}
+	// response decodes the IQ response, which must be the last stanza written.
+	response := func(t *testing.T, out []xmpptest.Tokens) adminResponse {
+		t.Helper()
+		var resp adminResponse
+		if len(out) == 0 {
+			t.Fatalf("no response written")
+		}
+		err := xml.NewTokenDecoder(&out[len(out)-1]).Decode(&resp)
+		if err != nil {
+			t.Fatalf("error decoding response: %v", err)
+		}
+		if resp.XMLName.Local != "iq" {
+			t.Fatalf("expected IQ response, got %s", resp.XMLName.Local)
+		}
+		return resp
+	}
+	set := func(t *testing.T, svc *muc.Service, from jid.JID, items ...[]xml.Attr) {
+		t.Helper()
+		if resp := response(t, handle(t, svc, from, adminIQ(stanza.SetIQ, items...))); resp.Err != nil {
+			t.Fatalf("error setting up room: %v", resp.Err)
+		}
+	}
+	list := func(t *testing.T, svc *muc.Service, affiliation string) []string {
+		t.Helper()
+		resp := response(t, handle(t, svc, crone, adminIQ(stanza.GetIQ, adminAttr("affiliation", affiliation))))
+		if resp.Err != nil {
+			t.Fatalf("error listing %s affiliations: %v", affiliation, resp.Err)
+		}
+		var jids []string
+		for _, item := range resp.Query.Items {
+			jids = append(jids, item.JID)
+		}
+		return jids
+	}
+
+	// newRoom creates a room owned by crone with hag as an admin, wiccan as a
+	// moderator with no affiliation, and bard as a participant.
+	newRoom := func(t *testing.T) *muc.Service {
+		t.Helper()
+		svc := &muc.Service{}
+		join := func(from jid.JID, nick string) {
+			t.Helper()
+			handle(t, svc, from, stanza.Presence{To: jid.MustParse(room.String() + "/" + nick)}.Wrap(
+				xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: muc.NS, Local: "x"}}),
+			))
+		}
+		join(crone, "firstwitch")
+		resp := response(t, handle(t, svc, crone, stanza.IQ{ID: "unlock", To: room, Type: stanza.SetIQ}.Wrap(xmlstream.Wrap(
+			xmlstream.Wrap(nil, xml.StartElement{
+				Name: xml.Name{Space: "jabber:x:data", Local: "x"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "type"}, Value: "submit"}},
+			}),
+			xml.StartElement{Name: xml.Name{Space: muc.NSOwner, Local: "query"}},
+		))))
+		if resp.Err != nil {
+			t.Fatalf("error unlocking room: %v", resp.Err)
+		}
+		join(hag, "secondwitch")
+		join(wiccan, "thirdwitch")
+		join(bard, "fourthwitch")
+		set(t, svc, crone, adminAttr("affiliation", "admin", "jid", hag.Bare().String()))
+		set(t, svc, crone, adminAttr("role", "moderator", "nick", "thirdwitch"))
+		return svc
+	}
+
+	for _, tc := range []struct {
+		name  string
+		from  jid.JID
+		items [][]xml.Attr
+		cond  stanza.Condition
+	}{
+		{
+			name:  "kick admin",
+			from:  wiccan,
+			items: [][]xml.Attr{adminAttr("role", "none", "nick", "secondwitch")},
+			cond:  stanza.NotAllowed,
+		},
+		{
+			name:  "kick owner",
+			from:  wiccan,
+			items: [][]xml.Attr{adminAttr("role", "none", "nick", "firstwitch")},
+			cond:  stanza.NotAllowed,
+		},
+		{
+			name:  "revoke moderator as moderator",
+			from:  wiccan,
+			items: [][]xml.Attr{adminAttr("role", "participant", "nick", "thirdwitch")},
+			cond:  stanza.Forbidden,
+		},
+		{
+			name:  "demote last owner",
+			from:  crone,
+			items: [][]xml.Attr{adminAttr("affiliation", "admin", "jid", crone.Bare().String())},
+			cond:  stanza.Conflict,
+		},
+		{
+			name:  "ban as moderator",
+			from:  wiccan,
+			items: [][]xml.Attr{adminAttr("affiliation", "outcast", "jid", bard.Bare().String())},
+			cond:  stanza.Forbidden,
+		},
+		{
+			name:  "admin demotes owner",
+			from:  hag,
+			items: [][]xml.Attr{adminAttr("affiliation", "member", "jid", crone.Bare().String())},
+			cond:  stanza.Forbidden,
+		},
+		{
+			name: "multiple items with one invalid",
+			from: crone,
+			items: [][]xml.Attr{
+				adminAttr("affiliation", "outcast", "jid", bard.Bare().String()),
+				adminAttr("affiliation", "member", "jid", crone.Bare().String()),
+			},
+			cond: stanza.Conflict,
+		},
+		{
+			name: "multiple items that remove every owner",
+			from: crone,
+			items: [][]xml.Attr{
+				adminAttr("affiliation", "owner", "jid", hag.Bare().String()),
+				adminAttr("affiliation", "member", "jid", hag.Bare().String()),
+				adminAttr("affiliation", "member", "jid", crone.Bare().String()),
+			},
+			cond: stanza.Conflict,
+		},
+		{
+			name: "multiple items with missing occupant",
+			from: crone,
+			items: [][]xml.Attr{
+				adminAttr("role", "none", "nick", "fourthwitch"),
+				adminAttr("role", "none", "nick", "fifthwitch"),
+			},
+			cond: stanza.ItemNotFound,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := newRoom(t)
+			out := handle(t, svc, tc.from, adminIQ(stanza.SetIQ, tc.items...))
+			if len(out) != 1 {
+				t.Errorf("expected only an error response, got %d stanzas", len(out))
+			}
+			resp := response(t, out)
+			if resp.Err == nil || resp.Err.Condition != tc.cond {
+				t.Errorf("wrong error: want=%v, got=%v", tc.cond, resp.Err)
+			}
+
+			// Nothing in the room changed.
+			if owners := list(t, svc, "owner"); len(owners) != 1 || owners[0] != crone.Bare().String() {
+				t.Errorf("wrong owners after failed request: %v", owners)
+			}
+			if admins := list(t, svc, "admin"); len(admins) != 1 || admins[0] != hag.Bare().String() {
+				t.Errorf("wrong admins after failed request: %v", admins)
+			}
+			if outcasts := list(t, svc, "outcast"); len(outcasts) != 0 {
+				t.Errorf("wrong outcasts after failed request: %v", outcasts)
+			}
+			resp = response(t, handle(t, svc, crone, adminIQ(stanza.GetIQ, adminAttr("role", "participant"))))
+			if resp.Err != nil || len(resp.Query.Items) != 1 || resp.Query.Items[0].JID != bard.String() {
+				t.Errorf("wrong participants after failed request: %+v", resp)
+			}
+		})
+	}
+}
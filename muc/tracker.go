@@ -0,0 +1,269 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc
+
+import (
+	"sort"
+	"sync"
+
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// NSOccupantID is the namespace used by XEP-0421: Anonymous unique occupant
+// identifiers for MUCs.
+const NSOccupantID = `urn:xmpp:occupant-id:0`
+
+// Occupant is a user present in a channel as seen by a Tracker.
+type Occupant struct {
+	Nick        string
+	Affiliation Affiliation
+	Role        Role
+
+	// JID is the real JID of the occupant if the channel exposes it.
+	JID jid.JID
+
+	// ID is the stable occupant identifier assigned by the channel if it
+	// supports XEP-0421: Anonymous unique occupant identifiers for MUCs.
+	ID string
+}
+
+// Event is a change to the state of a channel that is reported by a Tracker.
+// It will be one of the event types in this package, such as OccupantJoined or
+// SubjectChanged.
+type Event interface {
+	event()
+}
+
+// OccupantJoined is emitted when a new occupant is seen in the channel,
+// including any occupants that were already present when we joined.
+type OccupantJoined struct {
+	Room     jid.JID
+	Occupant Occupant
+}
+
+// OccupantLeft is emitted when an occupant leaves the channel.
+type OccupantLeft struct {
+	Room     jid.JID
+	Occupant Occupant
+}
+
+// NickChanged is emitted when an occupant changes their nickname.
+// Occupant contains the new nickname.
+type NickChanged struct {
+	Room     jid.JID
+	Occupant Occupant
+	OldNick  string
+}
+
+// OccupantKicked is emitted when an occupant is kicked from the channel.
+type OccupantKicked struct {
+	Room     jid.JID
+	Occupant Occupant
+	Reason   string
+}
+
+// OccupantBanned is emitted when an occupant is banned from the channel.
+type OccupantBanned struct {
+	Room     jid.JID
+	Occupant Occupant
+	Reason   string
+}
+
+// RoleChanged is emitted when the role of an occupant changes.
+type RoleChanged struct {
+	Room     jid.JID
+	Occupant Occupant
+	OldRole  Role
+}
+
+// SubjectChanged is emitted when the subject of the channel is set, including
+// when it is first received after joining.
+// Nick is the nickname of the occupant that set the subject, if known.
+type SubjectChanged struct {
+	Room    jid.JID
+	Subject string
+	Nick    string
+}
+
+// RoomDestroyed is emitted when the channel is destroyed by its owner.
+// If the owner provided an alternate venue its address is set.
+type RoomDestroyed struct {
+	Room      jid.JID
+	Alternate jid.JID
+	Reason    string
+}
+
+func (OccupantJoined) event() {}
+func (OccupantLeft) event()   {}
+func (NickChanged) event()    {}
+func (OccupantKicked) event() {}
+func (OccupantBanned) event() {}
+func (RoleChanged) event()    {}
+func (SubjectChanged) event() {}
+func (RoomDestroyed) event()  {}
+
+type roomState struct {
+	subject   string
+	occupants map[string]Occupant
+}
+
+// Tracker keeps track of the occupants and subject of every channel managed by
+// a Client.
+// It is opt-in: to use it, set the Tracker field of the Client before
+// registering the client with HandleClient.
+//
+// All methods on Tracker are safe for concurrent use.
+type Tracker struct {
+	// HandleEvent, if set, is called for every change to a channel.
+	// It is called from the handler goroutine, so it should not block.
+	HandleEvent func(Event)
+
+	roomsM sync.Mutex
+	rooms  map[string]*roomState
+}
+
+// Occupants returns the occupants of the channel, sorted by nickname.
+func (t *Tracker) Occupants(room jid.JID) []Occupant {
+	t.roomsM.Lock()
+	defer t.roomsM.Unlock()
+	state, ok := t.rooms[room.Bare().String()]
+	if !ok {
+		return nil
+	}
+	occupants := make([]Occupant, 0, len(state.occupants))
+	for _, o := range state.occupants {
+		occupants = append(occupants, o)
+	}
+	sort.Slice(occupants, func(i, j int) bool {
+		return occupants[i].Nick < occupants[j].Nick
+	})
+	return occupants
+}
+
+// Occupant returns the occupant of the channel with the provided nickname and
+// whether they are present.
+func (t *Tracker) Occupant(room jid.JID, nick string) (Occupant, bool) {
+	t.roomsM.Lock()
+	defer t.roomsM.Unlock()
+	state, ok := t.rooms[room.Bare().String()]
+	if !ok {
+		return Occupant{}, false
+	}
+	o, ok := state.occupants[nick]
+	return o, ok
+}
+
+// Subject returns the last known subject of the channel.
+func (t *Tracker) Subject(room jid.JID) string {
+	t.roomsM.Lock()
+	defer t.roomsM.Unlock()
+	state, ok := t.rooms[room.Bare().String()]
+	if !ok {
+		return ""
+	}
+	return state.subject
+}
+
+func (t *Tracker) state(room jid.JID) *roomState {
+	if t.rooms == nil {
+		t.rooms = make(map[string]*roomState)
+	}
+	key := room.Bare().String()
+	state, ok := t.rooms[key]
+	if !ok {
+		state = &roomState{occupants: make(map[string]Occupant)}
+		t.rooms[key] = state
+	}
+	return state
+}
+
+func (t *Tracker) emit(events ...Event) {
+	if t.HandleEvent == nil {
+		return
+	}
+	for _, e := range events {
+		t.HandleEvent(e)
+	}
+}
+
+// presence updates the channel state from an occupant presence.
+func (t *Tracker) presence(p *mucPresence) {
+	room := p.From.Bare()
+	nick := p.From.Resourcepart()
+	item := p.X.Item
+	o := Occupant{
+		Nick:        nick,
+		Affiliation: item.Affiliation,
+		Role:        item.Role,
+		JID:         item.JID,
+		ID:          p.OccupantID.ID,
+	}
+
+	var events []Event
+	t.roomsM.Lock()
+	state := t.state(room)
+	prev, ok := state.occupants[nick]
+	if ok {
+		if o.JID.Equal(jid.JID{}) {
+			o.JID = prev.JID
+		}
+		if o.ID == "" {
+			o.ID = prev.ID
+		}
+	}
+
+	switch p.Type {
+	case stanza.UnavailablePresence:
+		delete(state.occupants, nick)
+		switch {
+		case p.HasStatus(303):
+			o.Nick = item.Nick
+			state.occupants[o.Nick] = o
+			events = append(events, NickChanged{Room: room, Occupant: o, OldNick: nick})
+		case p.X.Destroy != nil:
+			events = append(events, RoomDestroyed{
+				Room:      room,
+				Alternate: p.X.Destroy.JID,
+				Reason:    p.X.Destroy.Reason,
+			})
+		case p.HasStatus(301):
+			events = append(events, OccupantBanned{Room: room, Occupant: o, Reason: item.Reason})
+		case p.HasStatus(307):
+			events = append(events, OccupantKicked{Room: room, Occupant: o, Reason: item.Reason})
+		default:
+			events = append(events, OccupantLeft{Room: room, Occupant: o})
+		}
+		// If we're no longer in the room, forget everything we know about it.
+		if p.HasStatus(110) && !p.HasStatus(303) {
+			delete(t.rooms, room.String())
+		}
+	default:
+		state.occupants[nick] = o
+		switch {
+		case !ok:
+			events = append(events, OccupantJoined{Room: room, Occupant: o})
+		case prev.Role != o.Role:
+			events = append(events, RoleChanged{Room: room, Occupant: o, OldRole: prev.Role})
+		}
+	}
+	t.roomsM.Unlock()
+
+	t.emit(events...)
+}
+
+// subject updates the channel state from a subject change message.
+func (t *Tracker) subject(from jid.JID, subject string) {
+	room := from.Bare()
+	t.roomsM.Lock()
+	t.state(room).subject = subject
+	t.roomsM.Unlock()
+
+	t.emit(SubjectChanged{
+		Room:    room,
+		Subject: subject,
+		Nick:    from.Resourcepart(),
+	})
+}
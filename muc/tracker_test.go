@@ -0,0 +1,241 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package muc_test
+
+import (
+	"context"
+	"encoding/xml"
+	"reflect"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// occupantPresence returns a presence from the provided occupant with the
+// given item attributes and status codes.
+func occupantPresence(from jid.JID, typ stanza.PresenceType, id string, item []xml.Attr, inner xml.TokenReader, codes ...string) xml.TokenReader {
+	var payload []xml.TokenReader
+	payload = append(payload, xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Local: "item"},
+		Attr: item,
+	}))
+	for _, code := range codes {
+		payload = append(payload, xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: "status"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "code"}, Value: code}},
+		}))
+	}
+	if inner != nil {
+		payload = append(payload, inner)
+	}
+	var occupantID xml.TokenReader
+	if id != "" {
+		occupantID = xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Space: muc.NSOccupantID, Local: "occupant-id"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: id}},
+		})
+	}
+	return stanza.Presence{
+		From: from,
+		To:   jid.MustParse("crone1@shakespeare.lit/desktop"),
+		Type: typ,
+	}.Wrap(xmlstream.MultiReader(
+		xmlstream.Wrap(
+			xmlstream.MultiReader(payload...),
+			xml.StartElement{Name: xml.Name{Space: muc.NSUser, Local: "x"}},
+		),
+		occupantID,
+	))
+}
+
+func itemAttr(affiliation, role string) []xml.Attr {
+	return []xml.Attr{
+		{Name: xml.Name{Local: "affiliation"}, Value: affiliation},
+		{Name: xml.Name{Local: "role"}, Value: role},
+	}
+}
+
+func TestTracker(t *testing.T) {
+	room := jid.MustParse("coven@chat.shakespeare.lit")
+	me := jid.MustParse("coven@chat.shakespeare.lit/firstwitch")
+	witch := jid.MustParse("coven@chat.shakespeare.lit/secondwitch")
+	hag := jid.MustParse("coven@chat.shakespeare.lit/oldhag")
+
+	events := make(chan muc.Event, 10)
+	tracker := &muc.Tracker{
+		HandleEvent: func(e muc.Event) {
+			events <- e
+		},
+	}
+	h := &muc.Client{Tracker: tracker}
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(muc.HandleClient(h))),
+		xmpptest.ServerHandler(mux.New(
+			mux.PresenceFunc("", xml.Name{Local: "x"}, func(p stanza.Presence, r xmlstream.TokenReadEncoder) error {
+				_, err := xmlstream.Copy(r, xmlstream.MultiReader(
+					occupantPresence(witch, "", "dd72603deec90a38ba552f7c68cbcc61bca202cd", append(itemAttr("member", "participant"),
+						xml.Attr{Name: xml.Name{Local: "jid"}, Value: "hag66@shakespeare.lit/pda"},
+					), nil),
+					occupantPresence(me, "", "", itemAttr("owner", "moderator"), nil, "110"),
+					stanza.Message{
+						From: witch,
+						To:   p.From,
+						Type: stanza.GroupChatMessage,
+					}.Wrap(xmlstream.Wrap(
+						xmlstream.Token(xml.CharData("Fire Burn and Cauldron Bubble!")),
+						xml.StartElement{Name: xml.Name{Local: "subject"}},
+					)),
+				))
+				return err
+			}),
+		)),
+	)
+
+	_, err := h.Join(context.Background(), me, s.Client)
+	if err != nil {
+		t.Fatalf("error joining: %v", err)
+	}
+	send := func(r xml.TokenReader) {
+		t.Helper()
+		err := s.Server.Send(context.Background(), r)
+		if err != nil {
+			t.Fatalf("error sending: %v", err)
+		}
+	}
+	send(occupantPresence(witch, "", "", itemAttr("member", "moderator"), nil))
+	send(occupantPresence(witch, stanza.UnavailablePresence, "", append(itemAttr("member", "moderator"),
+		xml.Attr{Name: xml.Name{Local: "nick"}, Value: "oldhag"},
+	), nil, "303"))
+	send(occupantPresence(hag, "", "", itemAttr("member", "moderator"), nil))
+	send(occupantPresence(hag, stanza.UnavailablePresence, "", itemAttr("member", "none"), nil, "307"))
+
+	witchOccupant := muc.Occupant{
+		Nick:        "secondwitch",
+		Affiliation: muc.AffiliationMember,
+		Role:        muc.RoleParticipant,
+		JID:         jid.MustParse("hag66@shakespeare.lit/pda"),
+		ID:          "dd72603deec90a38ba552f7c68cbcc61bca202cd",
+	}
+	moderator := witchOccupant
+	moderator.Role = muc.RoleModerator
+	hagOccupant := moderator
+	hagOccupant.Nick = "oldhag"
+	kicked := hagOccupant
+	kicked.Role = muc.RoleNone
+	expected := []muc.Event{
+		muc.OccupantJoined{Room: room, Occupant: witchOccupant},
+		muc.OccupantJoined{Room: room, Occupant: muc.Occupant{
+			Nick:        "firstwitch",
+			Affiliation: muc.AffiliationOwner,
+			Role:        muc.RoleModerator,
+		}},
+		muc.SubjectChanged{Room: room, Subject: "Fire Burn and Cauldron Bubble!", Nick: "secondwitch"},
+		muc.RoleChanged{Room: room, Occupant: moderator, OldRole: muc.RoleParticipant},
+		muc.NickChanged{Room: room, Occupant: hagOccupant, OldNick: "secondwitch"},
+		muc.OccupantKicked{Room: room, Occupant: kicked},
+		muc.RoomDestroyed{Room: room, Alternate: jid.MustParse("chamber@chat.shakespeare.lit"), Reason: "Macbeth doth come."},
+	}
+	for i, want := range expected[:len(expected)-1] {
+		e := <-events
+		if !reflect.DeepEqual(e, want) {
+			t.Errorf("wrong event %d:\nwant=%#v,\n got=%#v", i, want, e)
+		}
+	}
+	if subject := tracker.Subject(room); subject != "Fire Burn and Cauldron Bubble!" {
+		t.Errorf("wrong subject: %q", subject)
+	}
+	occupants := tracker.Occupants(room)
+	if len(occupants) != 1 || occupants[0].Nick != "firstwitch" {
+		t.Errorf("wrong occupants: %+v", occupants)
+	}
+	if _, ok := tracker.Occupant(room, "oldhag"); ok {
+		t.Errorf("did not expect kicked occupant to still be tracked")
+	}
+
+	send(occupantPresence(me, stanza.UnavailablePresence, "", itemAttr("none", "none"), xmlstream.Wrap(
+		xmlstream.Wrap(
+			xmlstream.Token(xml.CharData("Macbeth doth come.")),
+			xml.StartElement{Name: xml.Name{Local: "reason"}},
+		),
+		xml.StartElement{
+			Name: xml.Name{Local: "destroy"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "jid"}, Value: "chamber@chat.shakespeare.lit"}},
+		},
+	), "110"))
+	if e := <-events; !reflect.DeepEqual(e, expected[len(expected)-1]) {
+		t.Errorf("wrong final event:\nwant=%#v,\n got=%#v", expected[len(expected)-1], e)
+	}
+	if occupants := tracker.Occupants(room); occupants != nil {
+		t.Errorf("expected room state to be cleared after leaving, got %+v", occupants)
+	}
+}
+
+func TestHandleOccupantPresence(t *testing.T) {
+	me := jid.MustParse("coven@chat.shakespeare.lit/firstwitch")
+	witch := jid.MustParse("coven@chat.shakespeare.lit/secondwitch")
+
+	user := make(chan string, 10)
+	occupants := make(chan string, 10)
+	h := &muc.Client{
+		HandleUserPresence: func(p stanza.Presence, i muc.Item) {
+			user <- p.From.Resourcepart() + " " + i.Role.String()
+		},
+		HandleOccupantPresence: func(p stanza.Presence, i muc.Item) {
+			occupants <- p.From.Resourcepart() + " " + i.Role.String()
+		},
+	}
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(muc.HandleClient(h))),
+		xmpptest.ServerHandler(mux.New(
+			mux.PresenceFunc("", xml.Name{Local: "x"}, func(p stanza.Presence, r xmlstream.TokenReadEncoder) error {
+				_, err := xmlstream.Copy(r, xmlstream.MultiReader(
+					occupantPresence(witch, "", "", itemAttr("member", "participant"), nil),
+					occupantPresence(me, "", "", itemAttr("member", "participant"), nil, "110"),
+				))
+				return err
+			}),
+		)),
+	)
+
+	_, err := h.Join(context.Background(), me, s.Client)
+	if err != nil {
+		t.Fatalf("error joining: %v", err)
+	}
+	for _, r := range []xml.TokenReader{
+		occupantPresence(witch, "", "", itemAttr("member", "moderator"), nil),
+		occupantPresence(me, "", "", itemAttr("member", "moderator"), nil, "110"),
+	} {
+		err = s.Server.Send(context.Background(), r)
+		if err != nil {
+			t.Fatalf("error sending: %v", err)
+		}
+	}
+
+	// Only changes to our own presence after joining are passed to
+	// HandleUserPresence.
+	if got := <-user; got != "firstwitch moderator" {
+		t.Errorf("wrong user presence: %q", got)
+	}
+	for i, want := range []string{
+		"secondwitch participant",
+		"firstwitch participant",
+		"secondwitch moderator",
+		"firstwitch moderator",
+	} {
+		if got := <-occupants; got != want {
+			t.Errorf("wrong occupant presence %d: want=%q, got=%q", i, want, got)
+		}
+	}
+	select {
+	case got := <-user:
+		t.Errorf("unexpected user presence: %q", got)
+	default:
+	}
+}
@@ -2,7 +2,7 @@
 // Use of this source code is governed by the BSD 2-clause
 // license that can be found in the LICENSE file.
 
-//go:generate go run -tags=tools golang.org/x/tools/cmd/stringer -type=Affiliation,Role,Privileges -linecomment
+//go:generate go run -tags=tools golang.org/x/tools/cmd/stringer -type=Affiliation,Role,Privileges,State -linecomment
 
 package muc
 
@@ -131,3 +131,21 @@ const (
 	PrivilegesParticipant = PrivilegesVisitor | PrivilegeSendMessages | PrivilegeModifySubject
 	PrivilegesModerator   = PrivilegesParticipant | PrivilegeKick | PrivilegeGrantVoice | PrivilegeRevokeVoice
 )
+
+// State indicates whether we are currently joined to a channel.
+type State uint8
+
+// A list of channel states.
+const (
+	// StateLeft means that we are not joined to the channel, either because we
+	// left it or because the service removed us.
+	StateLeft State = iota // left
+
+	// StateJoined means that we are joined to the channel.
+	StateJoined // joined
+
+	// StateUnreachable means that the channel could not be reached to confirm
+	// that we are still joined, for example because the MUC service is
+	// restarting.
+	StateUnreachable // unreachable
+)
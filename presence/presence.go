@@ -0,0 +1,251 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package presence implements presence subscriptions and keeps track of the
+// availability of contacts.
+//
+// A Tracker records the latest presence received from each resource and can
+// be used to find the best resource to contact for a bare JID, and
+// Subscriptions handles requests to subscribe to our presence and
+// notifications about changes to our own subscriptions.
+// Both are registered on a multiplexer using Handle.
+//
+// The Tracker also understands XEP-0319: Last User Interaction in Presence.
+package presence // import "mellium.im/xmpp/presence"
+
+import (
+	"context"
+	"encoding/xml"
+	"strconv"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// NSIdle is the namespace used by XEP-0319: Last User Interaction in Presence,
+// provided as a convenience.
+const NSIdle = `urn:xmpp:idle:1`
+
+// Show is a more specific availability of an entity.
+// The empty value means that the entity is simply available.
+type Show string
+
+// A list of possible show values.
+const (
+	// Away means that the entity or resource is temporarily away.
+	Away Show = "away"
+
+	// Chat means that the entity or resource is actively interested in
+	// chatting.
+	Chat Show = "chat"
+
+	// DND means that the entity or resource is busy (do not disturb).
+	DND Show = "dnd"
+
+	// XA means that the entity or resource is away for an extended period
+	// (eXtended Away).
+	XA Show = "xa"
+)
+
+// rank orders show values from most to least available.
+func (s Show) rank() int {
+	switch s {
+	case Chat:
+		return 0
+	case "":
+		return 1
+	case Away:
+		return 2
+	case DND:
+		return 3
+	}
+	return 4
+}
+
+// State is the information contained in a presence stanza.
+type State struct {
+	stanza.Presence
+
+	Show     Show
+	Status   string
+	Priority int8
+
+	// Idle is the time of the last user interaction as indicated by
+	// XEP-0319: Last User Interaction in Presence.
+	// If the entity did not indicate that it is idle, Idle is the zero time.
+	Idle time.Time
+}
+
+// equal reports whether the availability of two states is the same.
+func (s State) equal(o State) bool {
+	return s.Type == o.Type && s.Show == o.Show && s.Status == o.Status && s.Priority == o.Priority && s.Idle.Equal(o.Idle)
+}
+
+// childCounter is a TokenReader that counts the child elements of the element
+// read through it.
+// If match is not nil only child elements for which it returns true are
+// counted.
+type childCounter struct {
+	r        xml.TokenReader
+	match    func(xml.Name) bool
+	depth    int
+	children int
+}
+
+func (c *childCounter) Token() (xml.Token, error) {
+	tok, err := c.r.Token()
+	switch tok.(type) {
+	case xml.StartElement:
+		c.depth++
+		if c.depth == 2 && (c.match == nil || c.match(tok.(xml.StartElement).Name)) {
+			c.children++
+		}
+	case xml.EndElement:
+		c.depth--
+	}
+	return tok, err
+}
+
+// parse decodes a presence stanza read from r and returns the number of child
+// elements it contained for which match returns true.
+func parse(p stanza.Presence, r xml.TokenReader, match func(xml.Name) bool) (State, int, error) {
+	pres := struct {
+		XMLName  xml.Name
+		Show     string `xml:"show"`
+		Priority string `xml:"priority"`
+		Status   []struct {
+			Lang  string `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"status"`
+		Idle *struct {
+			Since string `xml:"since,attr"`
+		} `xml:"urn:xmpp:idle:1 idle"`
+	}{}
+	counter := &childCounter{r: r, match: match}
+	err := xml.NewTokenDecoder(counter).Decode(&pres)
+	if err != nil {
+		return State{}, 0, err
+	}
+
+	state := State{
+		Presence: p,
+		Show:     Show(pres.Show),
+	}
+	// Invalid priorities are treated as zero.
+	priority, err := strconv.ParseInt(pres.Priority, 10, 8)
+	if err == nil {
+		state.Priority = int8(priority)
+	}
+	// Prefer a status in the language of the stanza, or with no language at all.
+	for i, status := range pres.Status {
+		if i == 0 || status.Lang == "" || status.Lang == p.Lang {
+			state.Status = status.Value
+		}
+		if status.Lang == "" || status.Lang == p.Lang {
+			break
+		}
+	}
+	if pres.Idle != nil {
+		// Invalid timestamps are treated as if the entity is not idle.
+		state.Idle, _ = time.Parse(time.RFC3339, pres.Idle.Since)
+	}
+	return state, counter.children, nil
+}
+
+// Handle returns an option that registers a Handler for presence.
+//
+// Handlers registered for more specific presence payloads on the same
+// multiplexer take precedence over h for those payloads.
+func Handle(h Handler) mux.Option {
+	return func(m *mux.ServeMux) {
+		h := &muxHandler{h: h, m: m}
+		for _, typ := range []stanza.PresenceType{
+			stanza.AvailablePresence,
+			stanza.UnavailablePresence,
+			stanza.ErrorPresence,
+			stanza.SubscribePresence,
+			stanza.SubscribedPresence,
+			stanza.UnsubscribePresence,
+			stanza.UnsubscribedPresence,
+		} {
+			mux.Presence(typ, xml.Name{}, h)(m)
+		}
+	}
+}
+
+// muxHandler is the Handler registered by Handle.
+// It knows which multiplexer it is registered on so that it can tell which
+// child elements of a presence will be passed to it.
+type muxHandler struct {
+	h Handler
+	m *mux.ServeMux
+}
+
+func (h *muxHandler) HandlePresence(p stanza.Presence, r xmlstream.TokenReadEncoder) error {
+	return h.h.handle(p, r, func(name xml.Name) bool {
+		handler, _ := h.m.PresenceHandler(p.Type, name)
+		return handler == mux.PresenceHandler(h)
+	})
+}
+
+// Handler passes incoming presence to a Tracker and subscription related
+// presence to Subscriptions.
+// Either may be nil.
+type Handler struct {
+	Tracker       *Tracker
+	Subscriptions *Subscriptions
+}
+
+// HandlePresence satisfies mux.PresenceHandler.
+// it is used by the multiplexer and normally does not need to be called by the
+// user.
+//
+// The multiplexer calls presence handlers once for each child element of the
+// presence, so the Tracker ignores presence that does not change its state and
+// Subscriptions ignores all but the first call for each stanza.
+// When registered directly, instead of with Handle, h assumes that it will be
+// called for every child element.
+func (h Handler) HandlePresence(p stanza.Presence, r xmlstream.TokenReadEncoder) error {
+	return h.handle(p, r, nil)
+}
+
+func (h Handler) handle(p stanza.Presence, r xmlstream.TokenReadEncoder, match func(xml.Name) bool) error {
+	state, children, err := parse(p, r, match)
+	if err != nil {
+		return err
+	}
+	switch p.Type {
+	case stanza.AvailablePresence, stanza.UnavailablePresence, stanza.ErrorPresence:
+		if h.Tracker != nil {
+			h.Tracker.update(state)
+		}
+	default:
+		if h.Subscriptions != nil {
+			return h.Subscriptions.handle(state, children, r)
+		}
+	}
+	return nil
+}
+
+// Subscribe asks to be sent the presence of j.
+func Subscribe(ctx context.Context, s *xmpp.Session, j jid.JID) error {
+	return send(ctx, s, j, stanza.SubscribePresence)
+}
+
+// Unsubscribe asks to no longer be sent the presence of j.
+func Unsubscribe(ctx context.Context, s *xmpp.Session, j jid.JID) error {
+	return send(ctx, s, j, stanza.UnsubscribePresence)
+}
+
+func send(ctx context.Context, s *xmpp.Session, j jid.JID, typ stanza.PresenceType) error {
+	return s.Send(ctx, subscription(j, typ))
+}
+
+func subscription(j jid.JID, typ stanza.PresenceType) xml.TokenReader {
+	return stanza.Presence{To: j.Bare(), Type: typ}.Wrap(nil)
+}
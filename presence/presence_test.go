@@ -0,0 +1,309 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package presence_test
+
+import (
+	"context"
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/presence"
+	"mellium.im/xmpp/roster"
+	"mellium.im/xmpp/stanza"
+)
+
+func elem(local, value string, attrs ...xml.Attr) xml.TokenReader {
+	return xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(value)),
+		xml.StartElement{Name: xml.Name{Local: local}, Attr: attrs},
+	)
+}
+
+func idle(since time.Time) xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: presence.NSIdle, Local: "idle"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "since"}, Value: since.Format(time.RFC3339)}},
+	})
+}
+
+func TestTracker(t *testing.T) {
+	changes := make(chan presence.State, 10)
+	tracker := &presence.Tracker{
+		Changed: func(s presence.State) {
+			changes <- s
+		},
+	}
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(presence.Handle(presence.Handler{Tracker: tracker}))),
+	)
+
+	juliet := jid.MustParse("juliet@example.com")
+	balcony := jid.MustParse("juliet@example.com/balcony")
+	chamber := jid.MustParse("juliet@example.com/chamber")
+	tomb := jid.MustParse("juliet@example.com/tomb")
+	lastWeek := time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)
+	yesterday := lastWeek.Add(6 * 24 * time.Hour)
+
+	ctx := context.Background()
+	send := func(from jid.JID, typ stanza.PresenceType, payload ...xml.TokenReader) {
+		t.Helper()
+		err := s.Server.Send(ctx, stanza.Presence{From: from, Type: typ}.Wrap(xmlstream.MultiReader(payload...)))
+		if err != nil {
+			t.Fatalf("error sending presence: %v", err)
+		}
+	}
+	next := func() presence.State {
+		t.Helper()
+		select {
+		case s := <-changes:
+			return s
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for presence change")
+		}
+		return presence.State{}
+	}
+
+	send(balcony, "", elem("show", "away"), elem("status", "Gone to the orchard"), elem("priority", "5"))
+	if state := next(); state.From.String() != balcony.String() || state.Show != presence.Away || state.Status != "Gone to the orchard" || state.Priority != 5 {
+		t.Errorf("wrong state for balcony: %+v", state)
+	}
+	send(chamber, "", elem("priority", "5"), idle(lastWeek))
+	if state := next(); state.From.String() != chamber.String() || !state.Idle.Equal(lastWeek) {
+		t.Errorf("wrong state for chamber: %+v", state)
+	}
+	send(tomb, "", elem("show", "chat"), elem("priority", "-1"))
+	next()
+
+	// The chamber resource has a better show value than balcony despite being
+	// idle, and the tomb has a lower priority.
+	if best, _ := tracker.Best(juliet); best.From.String() != chamber.String() {
+		t.Errorf("wrong best resource: want=%s, got=%s", chamber, best.From)
+	}
+
+	// Resending the same presence does not change anything.
+	send(chamber, "", elem("priority", "5"), idle(lastWeek))
+	send(chamber, "", elem("show", "away"), elem("priority", "5"), idle(yesterday))
+	if state := next(); state.Show != presence.Away || !state.Idle.Equal(yesterday) {
+		t.Errorf("wrong updated state for chamber: %+v", state)
+	}
+	// Now both are away but balcony is not idle.
+	if best, _ := tracker.Best(juliet); best.From.String() != balcony.String() {
+		t.Errorf("wrong best resource: want=%s, got=%s", balcony, best.From)
+	}
+
+	send(balcony, stanza.UnavailablePresence)
+	if state := next(); state.From.String() != balcony.String() || state.Type != stanza.UnavailablePresence {
+		t.Errorf("wrong state for unavailable balcony: %+v", state)
+	}
+	if _, ok := tracker.Get(balcony); ok {
+		t.Errorf("expected balcony to be unavailable")
+	}
+	if resources := tracker.Resources(juliet); len(resources) != 2 {
+		t.Errorf("wrong number of resources: want=2, got=%d", len(resources))
+	}
+
+	// Resources with a negative priority are never the best resource.
+	send(chamber, stanza.UnavailablePresence)
+	next()
+	if resources := tracker.Resources(juliet); len(resources) != 1 || resources[0].From.String() != tomb.String() {
+		t.Errorf("wrong resources: want=[%s], got=%+v", tomb, resources)
+	}
+	if best, ok := tracker.Best(juliet); ok {
+		t.Errorf("expected no best resource, got %s", best.From)
+	}
+
+	// Unavailable presence from the bare JID applies to all resources.
+	send(juliet, stanza.UnavailablePresence)
+	next()
+	if resources := tracker.Resources(juliet); len(resources) != 0 {
+		t.Errorf("expected no resources to be available, got %+v", resources)
+	}
+	select {
+	case s := <-changes:
+		t.Errorf("unexpected change: %+v", s)
+	default:
+	}
+}
+
+func TestSubscriptions(t *testing.T) {
+	sent := make(chan stanza.Presence, 10)
+	requests := make(chan presence.State, 10)
+	unsubscribed := make(chan jid.JID, 10)
+	subscribed := make(chan jid.JID, 10)
+
+	store := &roster.MemoryStore{}
+	romeo := jid.MustParse("romeo@example.net")
+	nurse := jid.MustParse("nurse@example.com")
+	benvolio := jid.MustParse("benvolio@example.net")
+	err := store.Set("1", roster.Item{JID: romeo, Subscription: "to"})
+	if err != nil {
+		t.Fatalf("error setting roster item: %v", err)
+	}
+	subs := &presence.Subscriptions{
+		AutoApprove: presence.ApproveRoster(store),
+		Request: func(s presence.State) bool {
+			requests <- s
+			return false
+		},
+		Subscribed: func(j jid.JID) {
+			subscribed <- j
+		},
+		Unsubscribed: func(j jid.JID) {
+			unsubscribed <- j
+		},
+	}
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(presence.Handle(presence.Handler{Subscriptions: subs}))),
+		xmpptest.ServerHandlerFunc(func(_ xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			p, err := stanza.NewPresence(*start)
+			if err != nil {
+				return err
+			}
+			sent <- p
+			return nil
+		}),
+	)
+
+	ctx := context.Background()
+	send := func(from jid.JID, typ stanza.PresenceType, payload ...xml.TokenReader) {
+		t.Helper()
+		from = jid.MustParse(from.String() + "/home")
+		err := s.Server.Send(ctx, stanza.Presence{From: from, Type: typ}.Wrap(xmlstream.MultiReader(payload...)))
+		if err != nil {
+			t.Fatalf("error sending presence: %v", err)
+		}
+	}
+	expectSent := func(to jid.JID, typ stanza.PresenceType) {
+		t.Helper()
+		select {
+		case p := <-sent:
+			if !p.To.Equal(to) || p.Type != typ {
+				t.Errorf("wrong presence sent: want=%s to %s, got=%s to %s", typ, to, p.Type, p.To)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s presence to %s", typ, to)
+		}
+	}
+
+	// Contacts we are subscribed to are approved automatically.
+	send(romeo, stanza.SubscribePresence)
+	expectSent(romeo, stanza.SubscribedPresence)
+
+	// Others are passed to Request once even if the presence has several
+	// children.
+	send(nurse, stanza.SubscribePresence, elem("status", "It is the nurse"), elem("nick", "Nurse"))
+	req := <-requests
+	if req.Status != "It is the nurse" {
+		t.Errorf("wrong status: want=%q, got=%q", "It is the nurse", req.Status)
+	}
+	send(nurse, stanza.SubscribePresence)
+	if pending := subs.Pending(); len(pending) != 1 || !pending[0].From.Bare().Equal(nurse) {
+		t.Errorf("wrong pending requests: %+v", pending)
+	}
+	err = subs.Approve(ctx, s.Client, nurse)
+	if err != nil {
+		t.Fatalf("error approving request: %v", err)
+	}
+	expectSent(nurse, stanza.SubscribedPresence)
+	if pending := subs.Pending(); len(pending) != 0 {
+		t.Errorf("expected no pending requests, got %+v", pending)
+	}
+
+	// Pre-approved requests are approved automatically.
+	err = subs.PreApprove(ctx, s.Client, benvolio)
+	if err != nil {
+		t.Fatalf("error pre-approving: %v", err)
+	}
+	expectSent(benvolio, stanza.SubscribedPresence)
+	send(benvolio, stanza.SubscribePresence)
+	expectSent(benvolio, stanza.SubscribedPresence)
+
+	send(benvolio, stanza.UnsubscribedPresence)
+	if j := <-unsubscribed; !j.Equal(benvolio) {
+		t.Errorf("wrong unsubscribed JID: want=%s, got=%s", benvolio, j)
+	}
+
+	// Every approval is reported once, even if it has several children or is
+	// received more than once.
+	send(romeo, stanza.SubscribedPresence, elem("status", "Approved"), elem("nick", "Romeo"))
+	send(romeo, stanza.SubscribedPresence)
+	for i := 0; i < 2; i++ {
+		select {
+		case j := <-subscribed:
+			if !j.Equal(romeo) {
+				t.Errorf("wrong subscribed JID: want=%s, got=%s", romeo, j)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for subscribed notification %d", i)
+		}
+	}
+
+	err = presence.Subscribe(ctx, s.Client, jid.MustParse("romeo@example.net/orchard"))
+	if err != nil {
+		t.Fatalf("error subscribing: %v", err)
+	}
+	expectSent(romeo, stanza.SubscribePresence)
+
+	select {
+	case r := <-requests:
+		t.Errorf("unexpected request: %+v", r)
+	case j := <-subscribed:
+		t.Errorf("unexpected subscribed notification from %s", j)
+	default:
+	}
+}
+
+func TestSubscriptionsCompetingHandler(t *testing.T) {
+	subscribed := make(chan jid.JID, 10)
+	nicks := make(chan struct{}, 10)
+	subs := &presence.Subscriptions{
+		Subscribed: func(j jid.JID) {
+			subscribed <- j
+		},
+	}
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(
+			presence.Handle(presence.Handler{Subscriptions: subs}),
+			mux.PresenceFunc(stanza.SubscribedPresence, xml.Name{Local: "nick"}, func(stanza.Presence, xmlstream.TokenReadEncoder) error {
+				nicks <- struct{}{}
+				return nil
+			}),
+		)),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	romeo := jid.MustParse("romeo@example.net")
+	// Both presences have the same ID so that only the number of calls can tell
+	// them apart.
+	for i := 0; i < 2; i++ {
+		err := s.Server.Send(ctx, stanza.Presence{
+			ID:   "approve",
+			From: jid.MustParse("romeo@example.net/home"),
+			Type: stanza.SubscribedPresence,
+		}.Wrap(xmlstream.MultiReader(elem("status", "Approved"), elem("nick", "Romeo"))))
+		if err != nil {
+			t.Fatalf("error sending presence %d: %v", i, err)
+		}
+		select {
+		case j := <-subscribed:
+			if !j.Equal(romeo) {
+				t.Errorf("wrong subscribed JID: want=%s, got=%s", romeo, j)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for subscribed notification %d", i)
+		}
+		select {
+		case <-nicks:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for nick handler %d", i)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package presence
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/roster"
+	"mellium.im/xmpp/stanza"
+)
+
+// Subscriptions handles subscription requests and notifications.
+// The zero value leaves all subscription requests pending and ignores
+// notifications.
+//
+// Subscriptions is safe for concurrent use by multiple goroutines.
+type Subscriptions struct {
+	// AutoApprove, if not nil, is called for every subscription request and if
+	// it returns true the request is approved without calling Request.
+	// See ApproveAll and ApproveRoster for common policies.
+	AutoApprove func(jid.JID) bool
+
+	// Request is called for subscription requests that were not approved
+	// automatically.
+	// If it returns true the request is approved immediately, otherwise the
+	// request stays pending until Approve or Deny is called.
+	// Approve and Deny must not be called from within Request.
+	Request func(State) bool
+
+	// Subscribed, Unsubscribe, and Unsubscribed, if not nil, are called when the
+	// sender approves our subscription request, unsubscribes from our presence,
+	// or denies or cancels our subscription respectively.
+	Subscribed   func(jid.JID)
+	Unsubscribe  func(jid.JID)
+	Unsubscribed func(jid.JID)
+
+	m           sync.Mutex
+	pending     map[string]State
+	preapproved map[string]struct{}
+
+	// current identifies the subscription presence that was most recently
+	// handled and calls is the number of times the handler will still be called
+	// for it by the multiplexer (once for each additional child element that is
+	// not claimed by a more specific handler).
+	current string
+	calls   int
+}
+
+// ApproveAll is a policy that approves all subscription requests.
+func ApproveAll(jid.JID) bool {
+	return true
+}
+
+// ApproveRoster returns a policy that approves subscription requests from
+// contacts in the roster that we are already subscribed to.
+func ApproveRoster(store roster.Store) func(jid.JID) bool {
+	return func(j jid.JID) bool {
+		item, ok, err := store.Item(j.Bare())
+		if err != nil || !ok {
+			return false
+		}
+		return item.Subscription == "to" || item.Subscription == "both"
+	}
+}
+
+func (s *Subscriptions) handle(state State, children int, w xmlstream.TokenWriter) error {
+	bare := state.From.Bare()
+	key := bare.String()
+	id := state.From.String() + " " + string(state.Type) + " " + state.ID
+
+	s.m.Lock()
+	if s.current == id && s.calls > 0 {
+		// This is a duplicate call for a presence with multiple children.
+		s.calls--
+		s.m.Unlock()
+		return nil
+	}
+	s.current = id
+	s.calls = children - 1
+	if _, ok := s.pending[key]; ok && state.Type == stanza.SubscribePresence {
+		// The request is already pending so there is nothing more to do.
+		s.m.Unlock()
+		return nil
+	}
+	var approve bool
+	if state.Type == stanza.SubscribePresence {
+		_, approve = s.preapproved[key]
+		delete(s.preapproved, key)
+	}
+	s.m.Unlock()
+
+	var f func(jid.JID)
+	switch state.Type {
+	case stanza.SubscribePresence:
+		if !approve && s.AutoApprove != nil {
+			approve = s.AutoApprove(bare)
+		}
+		if !approve && s.Request != nil {
+			approve = s.Request(state)
+		}
+		if !approve {
+			s.m.Lock()
+			if s.pending == nil {
+				s.pending = make(map[string]State)
+			}
+			s.pending[key] = state
+			s.m.Unlock()
+			return nil
+		}
+		_, err := xmlstream.Copy(w, subscription(bare, stanza.SubscribedPresence))
+		return err
+	case stanza.SubscribedPresence:
+		f = s.Subscribed
+	case stanza.UnsubscribePresence:
+		f = s.Unsubscribe
+	case stanza.UnsubscribedPresence:
+		f = s.Unsubscribed
+	}
+	if f != nil {
+		f(bare)
+	}
+	return nil
+}
+
+// Pending returns the subscription requests that have not been approved or
+// denied, sorted by JID.
+func (s *Subscriptions) Pending() []State {
+	s.m.Lock()
+	defer s.m.Unlock()
+	pending := make([]State, 0, len(s.pending))
+	for _, state := range s.pending {
+		pending = append(pending, state)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].From.String() < pending[j].From.String()
+	})
+	return pending
+}
+
+// Approve approves a subscription request from j.
+func (s *Subscriptions) Approve(ctx context.Context, sess *xmpp.Session, j jid.JID) error {
+	s.m.Lock()
+	delete(s.pending, j.Bare().String())
+	s.current, s.calls = "", 0
+	s.m.Unlock()
+	return send(ctx, sess, j, stanza.SubscribedPresence)
+}
+
+// Deny denies a subscription request from j, or cancels an existing
+// subscription.
+func (s *Subscriptions) Deny(ctx context.Context, sess *xmpp.Session, j jid.JID) error {
+	s.m.Lock()
+	delete(s.pending, j.Bare().String())
+	s.current, s.calls = "", 0
+	s.m.Unlock()
+	return send(ctx, sess, j, stanza.UnsubscribedPresence)
+}
+
+// PreApprove approves a subscription request from j before it is received.
+//
+// The approval is sent to the server, which will automatically approve the
+// request if it supports subscription pre-approval.
+// If it does not, the request is approved automatically when it is received.
+func (s *Subscriptions) PreApprove(ctx context.Context, sess *xmpp.Session, j jid.JID) error {
+	key := j.Bare().String()
+	s.m.Lock()
+	if s.preapproved == nil {
+		s.preapproved = make(map[string]struct{})
+	}
+	s.preapproved[key] = struct{}{}
+	s.m.Unlock()
+	return send(ctx, sess, j, stanza.SubscribedPresence)
+}
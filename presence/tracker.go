@@ -0,0 +1,156 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package presence
+
+import (
+	"sort"
+	"sync"
+
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// Tracker keeps track of the latest presence received from each resource.
+// The zero value is an empty tracker ready for use.
+//
+// Tracker is safe for concurrent use by multiple goroutines.
+type Tracker struct {
+	// Changed, if not nil, is called when a resource becomes available, changes
+	// its availability, or becomes unavailable.
+	// When a resource becomes unavailable the state will have a type of
+	// stanza.UnavailablePresence or stanza.ErrorPresence.
+	Changed func(State)
+
+	m         sync.Mutex
+	seq       uint64
+	resources map[string]map[string]resource
+}
+
+type resource struct {
+	state State
+	// seq records the order in which updates were received so that the most
+	// recently updated resource wins when all else is equal.
+	seq uint64
+}
+
+func (t *Tracker) update(state State) {
+	var changed []State
+	t.m.Lock()
+	bare := state.From.Bare().String()
+	full := state.From.String()
+	switch state.Type {
+	case stanza.AvailablePresence:
+		old, ok := t.resources[bare][full]
+		if ok && old.state.equal(state) {
+			break
+		}
+		if t.resources == nil {
+			t.resources = make(map[string]map[string]resource)
+		}
+		if t.resources[bare] == nil {
+			t.resources[bare] = make(map[string]resource)
+		}
+		t.seq++
+		t.resources[bare][full] = resource{state: state, seq: t.seq}
+		changed = append(changed, state)
+	default:
+		// Unavailable or error presence from a bare JID applies to all of its
+		// resources.
+		for key, res := range t.resources[bare] {
+			if key != full && bare != full {
+				continue
+			}
+			delete(t.resources[bare], key)
+			gone := state
+			gone.From = res.state.From
+			changed = append(changed, gone)
+		}
+		if len(t.resources[bare]) == 0 {
+			delete(t.resources, bare)
+		}
+	}
+	t.m.Unlock()
+
+	if t.Changed == nil {
+		return
+	}
+	for _, state := range changed {
+		t.Changed(state)
+	}
+}
+
+// Get returns the latest presence received from the provided JID, and whether
+// it is currently available.
+func (t *Tracker) Get(j jid.JID) (State, bool) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	res, ok := t.resources[j.Bare().String()][j.String()]
+	return res.state, ok
+}
+
+// Resources returns the available resources for the bare form of j, ordered
+// from best to worst.
+// Unlike Best it includes resources with a negative priority.
+//
+// Resources are ordered by priority, then by how available they are based on
+// their show value, then by how recently the user interacted with them, and
+// finally by how recently their presence was updated.
+func (t *Tracker) Resources(j jid.JID) []State {
+	t.m.Lock()
+	resources := make([]resource, 0, len(t.resources[j.Bare().String()]))
+	for _, res := range t.resources[j.Bare().String()] {
+		resources = append(resources, res)
+	}
+	t.m.Unlock()
+
+	sort.Slice(resources, func(x, y int) bool {
+		a, b := resources[x], resources[y]
+		if a.state.Priority != b.state.Priority {
+			return a.state.Priority > b.state.Priority
+		}
+		if ra, rb := a.state.Show.rank(), b.state.Show.rank(); ra != rb {
+			return ra < rb
+		}
+		if !a.state.Idle.Equal(b.state.Idle) {
+			// A resource that is not idle sorts before any that are.
+			switch {
+			case a.state.Idle.IsZero():
+				return true
+			case b.state.Idle.IsZero():
+				return false
+			}
+			return a.state.Idle.After(b.state.Idle)
+		}
+		return a.seq > b.seq
+	})
+	states := make([]State, 0, len(resources))
+	for _, res := range resources {
+		states = append(states, res.state)
+	}
+	return states
+}
+
+// Best returns the best available resource for the bare form of j.
+// For more information see Resources.
+//
+// Resources with a negative priority are never returned by Best since, as
+// described in RFC 6121 § 8.5.2, they should never receive messages
+// addressed to the bare JID.
+func (t *Tracker) Best(j jid.JID) (State, bool) {
+	resources := t.Resources(j)
+	if len(resources) == 0 || resources[0].Priority < 0 {
+		return State{}, false
+	}
+	return resources[0], true
+}
+
+// Reset forgets all tracked presence.
+// It should be called when the session is closed since presence from the
+// previous session is no longer valid.
+func (t *Tracker) Reset() {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.resources = nil
+}
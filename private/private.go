@@ -0,0 +1,190 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package private stores small amounts of arbitrary XML on the server where
+// it can only be accessed by the owner of the account.
+//
+// Two backends are supported: private XML storage as described in XEP-0049:
+// Private XML Storage, and private PEP nodes as described in XEP-0223:
+// Persistent Storage of Private Data via PubSub.
+// Both are exposed through the Storage interface so that data can be stored
+// and retrieved in the same way regardless of which backend is used:
+//
+//     store, err := private.NewPEP(ctx, session)
+//     if err != nil {
+//         store = private.NewXML(session)
+//     }
+//     err = store.Unmarshal(ctx, xml.Name{Space: "urn:example", Local: "settings"}, &settings)
+package private // import "mellium.im/xmpp/private"
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/internal/pubsub"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// NS is the namespace used by private XML storage, provided as a convenience.
+const NS = `jabber:iq:private`
+
+// ErrNoPublishOptions is returned by NewPEP if the server does not support
+// publish options.
+// Without publish options there is no way to ensure that the data will remain
+// private so it must not be stored using PEP.
+var ErrNoPublishOptions = errors.New("private: server does not support publish options")
+
+// Storage is a backend that can store and retrieve private XML.
+//
+// The payload is identified by the name of its root element.
+// If nothing has been stored with the provided name, Unmarshal decodes an empty
+// element with that name into v so that the result is the same whether the
+// backend returns an empty payload or no payload at all.
+type Storage interface {
+	// Set stores the payload, replacing any existing payload with the same
+	// name.
+	Set(ctx context.Context, v xmlstream.Marshaler) error
+
+	// Unmarshal retrieves the payload with the provided name and decodes it into
+	// v.
+	Unmarshal(ctx context.Context, name xml.Name, v interface{}) error
+}
+
+// NewXML returns a Storage that uses private XML storage.
+func NewXML(s *xmpp.Session) Storage {
+	return xmlStorage{s: s}
+}
+
+// NewPEP returns a Storage that uses private PEP nodes.
+// Before returning it checks that the server supports publish options, which
+// are used to ensure that nodes are only accessible by the owner of the
+// account, and returns ErrNoPublishOptions if it does not.
+//
+// Each payload is published to a node named after its namespace.
+// If the node already exists with a different configuration the server will
+// refuse to publish to it and Set returns the resulting error.
+func NewPEP(ctx context.Context, s *xmpp.Session) (Storage, error) {
+	info, err := disco.GetInfo(ctx, "", s.LocalAddr().Bare(), s)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range info.Features {
+		if f.Var == pubsub.NSPublishOptions {
+			return pepStorage{s: s}, nil
+		}
+	}
+	return nil, ErrNoPublishOptions
+}
+
+// decodeEmpty decodes an empty element with the provided name into v.
+func decodeEmpty(name xml.Name, v interface{}) error {
+	return xml.NewTokenDecoder(xmlstream.Wrap(nil, xml.StartElement{Name: name})).Decode(v)
+}
+
+type xmlStorage struct {
+	s *xmpp.Session
+}
+
+func (x xmlStorage) Set(ctx context.Context, v xmlstream.Marshaler) error {
+	return x.s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		v.TokenReader(),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "query"}},
+	), stanza.IQ{
+		Type: stanza.SetIQ,
+	}, nil)
+}
+
+func (x xmlStorage) Unmarshal(ctx context.Context, name xml.Name, v interface{}) error {
+	resp, err := x.s.SendIQElement(ctx, xmlstream.Wrap(
+		xmlstream.Wrap(nil, xml.StartElement{Name: name}),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "query"}},
+	), stanza.IQ{
+		Type: stanza.GetIQ,
+	})
+	if err != nil {
+		return err
+	}
+	/* #nosec */
+	defer resp.Close()
+
+	tok, err := resp.Token()
+	if err != nil {
+		return err
+	}
+	start, ok := tok.(xml.StartElement)
+	if !ok {
+		return fmt.Errorf("private: expected IQ start token, got %T %[1]v", tok)
+	}
+	_, err = stanza.UnmarshalIQError(resp, start)
+	if err != nil {
+		return err
+	}
+
+	// Skip the query element and decode the first payload inside it.
+	var depth int
+	for {
+		tok, err = resp.Token()
+		switch {
+		case err == io.EOF:
+			return decodeEmpty(name, v)
+		case err != nil:
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if depth == 1 {
+				return xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(t), resp)).Decode(v)
+			}
+			depth++
+		case xml.EndElement:
+			if depth <= 1 {
+				return decodeEmpty(name, v)
+			}
+			depth--
+		}
+	}
+}
+
+type pepStorage struct {
+	s *xmpp.Session
+}
+
+func (p pepStorage) Set(ctx context.Context, v xmlstream.Marshaler) error {
+	r := v.TokenReader()
+	tok, err := r.Token()
+	if err != nil {
+		return err
+	}
+	start, ok := tok.(xml.StartElement)
+	if !ok {
+		return fmt.Errorf("private: expected payload start token, got %T %[1]v", tok)
+	}
+	_, err = pubsub.Publish(
+		ctx, p.s, jid.JID{}, start.Name.Space, "current",
+		pubsub.PrivateOptions(),
+		xmlstream.MultiReader(xmlstream.Token(start), r),
+	)
+	return err
+}
+
+func (p pepStorage) Unmarshal(ctx context.Context, name xml.Name, v interface{}) error {
+	items, err := pubsub.Fetch(ctx, p.s, jid.JID{}, name.Space, 1)
+	if err != nil {
+		if errors.Is(err, stanza.Error{Condition: stanza.ItemNotFound}) {
+			return decodeEmpty(name, v)
+		}
+		return err
+	}
+	if len(items) == 0 {
+		return decodeEmpty(name, v)
+	}
+	return items[len(items)-1].Decode(v)
+}
@@ -0,0 +1,198 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package private_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"sync"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/internal/pubsub"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/private"
+	"mellium.im/xmpp/stanza"
+)
+
+const exampleNS = "urn:example:settings"
+
+var exampleName = xml.Name{Space: exampleNS, Local: "settings"}
+
+type settings struct {
+	XMLName xml.Name `xml:"urn:example:settings settings"`
+	Theme   string   `xml:"theme"`
+}
+
+func (s settings) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(
+		xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(s.Theme)),
+			xml.StartElement{Name: xml.Name{Local: "theme"}},
+		),
+		xml.StartElement{Name: exampleName},
+	)
+}
+
+// xmlServer returns a multiplexer option that implements private XML storage
+// for a single payload.
+func xmlServer() mux.Option {
+	var (
+		m      sync.Mutex
+		stored []byte
+	)
+	name := xml.Name{Space: private.NS, Local: "query"}
+	return func(mx *mux.ServeMux) {
+		mux.IQFunc(stanza.SetIQ, name, func(iq stanza.IQ, r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			var buf bytes.Buffer
+			e := xml.NewEncoder(&buf)
+			_, err := xmlstream.Copy(e, xmlstream.MultiReader(xmlstream.Token(*start), r))
+			if err != nil {
+				return err
+			}
+			err = e.Flush()
+			if err != nil {
+				return err
+			}
+			m.Lock()
+			stored = buf.Bytes()
+			m.Unlock()
+			_, err = xmlstream.Copy(r, iq.Result(nil))
+			return err
+		})(mx)
+		mux.IQFunc(stanza.GetIQ, name, func(iq stanza.IQ, r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			m.Lock()
+			defer m.Unlock()
+			if stored == nil {
+				// Respond with the query as it was sent.
+				_, err := xmlstream.Copy(r, iq.Result(xmlstream.MultiReader(xmlstream.Token(*start), r)))
+				return err
+			}
+			_, err := xmlstream.Copy(r, iq.Result(xml.NewDecoder(bytes.NewReader(stored))))
+			return err
+		})(mx)
+	}
+}
+
+// discoServer returns a multiplexer option that responds to disco info
+// requests with the provided features.
+func discoServer(features ...string) mux.Option {
+	info := disco.Info{}
+	for _, f := range features {
+		info.Features = append(info.Features, disco.Feature{Var: f})
+	}
+	return mux.IQFunc(stanza.GetIQ, xml.Name{Space: disco.NSInfo, Local: "query"}, func(iq stanza.IQ, r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		_, err := xmlstream.Copy(r, iq.Result(info.TokenReader()))
+		return err
+	})
+}
+
+func testStorage(t *testing.T, store private.Storage) {
+	ctx := context.Background()
+
+	var got settings
+	err := store.Unmarshal(ctx, exampleName, &got)
+	if err != nil {
+		t.Fatalf("error fetching unset payload: %v", err)
+	}
+	if want := (settings{XMLName: exampleName}); got != want {
+		t.Errorf("wrong unset payload: want=%+v, got=%+v", want, got)
+	}
+
+	want := settings{XMLName: exampleName, Theme: "dark"}
+	err = store.Set(ctx, want)
+	if err != nil {
+		t.Fatalf("error storing payload: %v", err)
+	}
+	got = settings{}
+	err = store.Unmarshal(ctx, exampleName, &got)
+	if err != nil {
+		t.Fatalf("error fetching payload: %v", err)
+	}
+	if got != want {
+		t.Errorf("wrong payload: want=%+v, got=%+v", want, got)
+	}
+}
+
+func TestXML(t *testing.T) {
+	s := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(xmlServer())),
+	)
+	testStorage(t, private.NewXML(s.Client))
+}
+
+func TestPEP(t *testing.T) {
+	pep := &xmpptest.PubSub{}
+	s := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(
+			pep.Handle(),
+			discoServer(pubsub.NSPublishOptions),
+		)),
+	)
+	store, err := private.NewPEP(context.Background(), s.Client)
+	if err != nil {
+		t.Fatalf("error creating PEP storage: %v", err)
+	}
+	testStorage(t, store)
+
+	items := pep.Items(exampleNS)
+	if len(items) != 1 || items[0].ID != "current" {
+		t.Errorf("wrong items published: %+v", items)
+	}
+	opts := pep.PublishOptions(exampleNS)
+	if opts == nil {
+		t.Fatalf("no publish options submitted")
+	}
+	if v, _ := opts.GetString("pubsub#access_model"); v != "whitelist" {
+		t.Errorf("wrong access model: want=whitelist, got=%q", v)
+	}
+	if v, _ := opts.GetBool("pubsub#persist_items"); !v {
+		t.Errorf("expected items to be persisted")
+	}
+}
+
+func TestPEPItemNotFound(t *testing.T) {
+	s := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(
+			discoServer(pubsub.NSPublishOptions),
+			mux.IQFunc(stanza.GetIQ, xml.Name{Space: pubsub.NS, Local: "pubsub"}, func(iq stanza.IQ, r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+				_, err := xmlstream.Copy(r, iq.Error(stanza.Error{
+					Type:      stanza.Cancel,
+					Condition: stanza.ItemNotFound,
+				}))
+				return err
+			}),
+		)),
+	)
+	ctx := context.Background()
+	store, err := private.NewPEP(ctx, s.Client)
+	if err != nil {
+		t.Fatalf("error creating PEP storage: %v", err)
+	}
+	var got settings
+	err = store.Unmarshal(ctx, exampleName, &got)
+	if err != nil {
+		t.Fatalf("error fetching missing node: %v", err)
+	}
+	if want := (settings{XMLName: exampleName}); got != want {
+		t.Errorf("wrong payload: want=%+v, got=%+v", want, got)
+	}
+}
+
+func TestPEPNoPublishOptions(t *testing.T) {
+	s := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(
+			discoServer(pubsub.NS),
+		)),
+	)
+	_, err := private.NewPEP(context.Background(), s.Client)
+	if !errors.Is(err, private.ErrNoPublishOptions) {
+		t.Errorf("wrong error: want=%v, got=%v", private.ErrNoPublishOptions, err)
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package profile
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1" // #nosec G505
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"image"
+	"strconv"
+	"strings"
+	"unicode"
+
+	// Register the image formats most commonly used for avatars.
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/pubsub"
+	"mellium.im/xmpp/jid"
+)
+
+// Errors returned when fetching avatars.
+var (
+	// ErrHashMismatch is returned if the fetched avatar data does not hash to
+	// its ID.
+	ErrHashMismatch = errors.New("profile: avatar data does not match its ID")
+
+	// ErrInvalidID is returned if an avatar ID is not a hex encoded SHA-1 hash.
+	ErrInvalidID = errors.New("profile: invalid avatar ID")
+
+	// ErrNotFound is returned if the requested avatar has not been published.
+	ErrNotFound = errors.New("profile: avatar not found")
+)
+
+// Avatar contains information about a published avatar image.
+type Avatar struct {
+	XMLName xml.Name `xml:"urn:xmpp:avatar:metadata info"`
+
+	// ID is the hex encoded SHA-1 hash of the image data.
+	ID string `xml:"id,attr"`
+
+	// Bytes is the size of the image data.
+	Bytes int `xml:"bytes,attr"`
+
+	// Type is the media type of the image, eg. "image/png".
+	Type string `xml:"type,attr"`
+
+	// Width and Height are the dimensions of the image in pixels, if known.
+	Width  int `xml:"width,attr,omitempty"`
+	Height int `xml:"height,attr,omitempty"`
+
+	// URL is set if the image is hosted over HTTP instead of being published to
+	// the data node.
+	URL string `xml:"url,attr,omitempty"`
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (a Avatar) TokenReader() xml.TokenReader {
+	attrs := []xml.Attr{
+		{Name: xml.Name{Local: "id"}, Value: a.ID},
+		{Name: xml.Name{Local: "bytes"}, Value: strconv.Itoa(a.Bytes)},
+		{Name: xml.Name{Local: "type"}, Value: a.Type},
+	}
+	if a.Width > 0 {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "width"}, Value: strconv.Itoa(a.Width)})
+	}
+	if a.Height > 0 {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "height"}, Value: strconv.Itoa(a.Height)})
+	}
+	if a.URL != "" {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "url"}, Value: a.URL})
+	}
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NSMetadata, Local: "info"},
+		Attr: attrs,
+	})
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (a Avatar) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, a.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (a Avatar) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	_, err := a.WriteXML(e)
+	return err
+}
+
+// ID returns the avatar ID for the provided image data.
+func ID(data []byte) string {
+	/* #nosec */
+	h := sha1.Sum(data)
+	return hex.EncodeToString(h[:])
+}
+
+func validID(id string) bool {
+	if len(id) != 2*sha1.Size {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// PublishAvatar publishes the image data to the data node and then publishes
+// metadata pointing to it.
+// The image type and dimensions are detected by decoding the image header, so
+// the data must be in a format understood by DecodeAvatar.
+func PublishAvatar(ctx context.Context, s *xmpp.Session, data []byte) (Avatar, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Avatar{}, err
+	}
+	avatar := Avatar{
+		ID:     ID(data),
+		Bytes:  len(data),
+		Type:   "image/" + format,
+		Width:  cfg.Width,
+		Height: cfg.Height,
+	}
+	_, err = pubsub.Publish(ctx, s, jid.JID{}, NSData, avatar.ID, nil, xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(base64.StdEncoding.EncodeToString(data))),
+		xml.StartElement{Name: xml.Name{Space: NSData, Local: "data"}},
+	))
+	if err != nil {
+		return Avatar{}, err
+	}
+	return avatar, PublishMetadata(ctx, s, avatar)
+}
+
+// PublishMetadata publishes metadata for one or more avatars without
+// publishing any image data.
+// This can be used to advertise alternative formats or avatars hosted over
+// HTTP.
+// The ID of the first avatar is used as the item ID and its data should have
+// been published to the data node.
+// If no avatars are provided, the avatar is disabled.
+func PublishMetadata(ctx context.Context, s *xmpp.Session, avatars ...Avatar) error {
+	var id string
+	var infos []xml.TokenReader
+	for _, a := range avatars {
+		if id == "" {
+			id = a.ID
+		}
+		infos = append(infos, a.TokenReader())
+	}
+	_, err := pubsub.Publish(ctx, s, jid.JID{}, NSMetadata, id, nil, xmlstream.Wrap(
+		xmlstream.MultiReader(infos...),
+		xml.StartElement{Name: xml.Name{Space: NSMetadata, Local: "metadata"}},
+	))
+	return err
+}
+
+// DisableAvatar publishes empty metadata, signaling to contacts that we no
+// longer have an avatar.
+func DisableAvatar(ctx context.Context, s *xmpp.Session) error {
+	return PublishMetadata(ctx, s)
+}
+
+func decodeMetadata(item pubsub.Item) ([]Avatar, error) {
+	metadata := struct {
+		XMLName xml.Name `xml:"urn:xmpp:avatar:metadata metadata"`
+		Info    []Avatar `xml:"info"`
+	}{}
+	err := item.Decode(&metadata)
+	return metadata.Info, err
+}
+
+// FetchMetadata fetches the latest avatar metadata published by the provided
+// entity.
+// If the entity has disabled their avatar the result will be empty.
+func FetchMetadata(ctx context.Context, s *xmpp.Session, from jid.JID) ([]Avatar, error) {
+	items, err := pubsub.Fetch(ctx, s, from.Bare(), NSMetadata, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+	return decodeMetadata(items[len(items)-1])
+}
+
+// FetchAvatar fetches the image data for the avatar with the provided ID from
+// the data node of the provided entity.
+// If the data does not match the ID, ErrHashMismatch is returned.
+func FetchAvatar(ctx context.Context, s *xmpp.Session, from jid.JID, id string) ([]byte, error) {
+	if !validID(id) {
+		return nil, ErrInvalidID
+	}
+	items, err := pubsub.Fetch(ctx, s, from.Bare(), NSData, 0, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if item.ID != id {
+			continue
+		}
+		payload := struct {
+			XMLName xml.Name `xml:"urn:xmpp:avatar:data data"`
+			Data    string   `xml:",chardata"`
+		}{}
+		err = item.Decode(&payload)
+		if err != nil {
+			return nil, err
+		}
+		// Base64 data may be wrapped across multiple lines.
+		data, err := base64.StdEncoding.DecodeString(strings.Map(func(r rune) rune {
+			if unicode.IsSpace(r) {
+				return -1
+			}
+			return r
+		}, payload.Data))
+		if err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(ID(data), id) {
+			return nil, ErrHashMismatch
+		}
+		return data, nil
+	}
+	return nil, ErrNotFound
+}
+
+// DecodeAvatar decodes avatar image data.
+// PNG, JPEG, GIF, and WebP images are supported, as well as any other format
+// registered with the image package.
+// The returned string is the format name used during format registration.
+func DecodeAvatar(data []byte) (image.Image, string, error) {
+	return image.Decode(bytes.NewReader(data))
+}
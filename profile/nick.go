@@ -0,0 +1,52 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package profile
+
+import (
+	"context"
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/pubsub"
+	"mellium.im/xmpp/jid"
+)
+
+func decodeNick(item pubsub.Item) (string, error) {
+	nick := struct {
+		XMLName xml.Name `xml:"http://jabber.org/protocol/nick nick"`
+		Nick    string   `xml:",chardata"`
+	}{}
+	err := item.Decode(&nick)
+	return nick.Nick, err
+}
+
+// PublishNick publishes our nickname.
+// If nick is empty, an empty nickname is published signaling to contacts that
+// we no longer have a nickname.
+func PublishNick(ctx context.Context, s *xmpp.Session, nick string) error {
+	var inner xml.TokenReader
+	if nick != "" {
+		inner = xmlstream.Token(xml.CharData(nick))
+	}
+	_, err := pubsub.Publish(ctx, s, jid.JID{}, NSNick, "current", nil, xmlstream.Wrap(
+		inner,
+		xml.StartElement{Name: xml.Name{Space: NSNick, Local: "nick"}},
+	))
+	return err
+}
+
+// FetchNick fetches the latest nickname published by the provided entity.
+// If the entity has not published a nickname the result will be empty.
+func FetchNick(ctx context.Context, s *xmpp.Session, from jid.JID) (string, error) {
+	items, err := pubsub.Fetch(ctx, s, from.Bare(), NSNick, 1)
+	if err != nil {
+		return "", err
+	}
+	if len(items) == 0 {
+		return "", nil
+	}
+	return decodeNick(items[len(items)-1])
+}
@@ -0,0 +1,111 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package profile publishes and fetches user avatars and nicknames.
+//
+// Avatars are published using the Personal Eventing Protocol (PEP) as
+// described in XEP-0084: User Avatar.
+// Each avatar is stored in two nodes: the data node, which contains the image
+// itself, and the metadata node, which contains information about the image
+// such as its size and type.
+// Because the metadata node is small, contacts are normally notified of
+// changes to it and then fetch the data only if they do not already have the
+// avatar cached.
+// Avatars are identified by the hex encoded SHA-1 hash of their data, which
+// is verified when the avatar is fetched.
+//
+// Nicknames are also published using PEP as described in XEP-0172: User
+// Nickname.
+//
+// To be notified when contacts change their avatar or nickname, register a
+// Handler with a multiplexer:
+//
+//     m := mux.New(
+//         profile.Handle(&profile.Handler{
+//             HandleAvatar: func(from jid.JID, avatars []profile.Avatar) {
+//                 …
+//             },
+//         }),
+//     )
+//
+// Because PEP only sends notifications for nodes that we are interested in,
+// the features registered by the Handler should also be advertised.
+package profile // import "mellium.im/xmpp/profile"
+
+import (
+	"mellium.im/xmpp/internal/pubsub"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// Various namespaces used by this package, provided as a convenience.
+const (
+	NSData     = `urn:xmpp:avatar:data`
+	NSMetadata = `urn:xmpp:avatar:metadata`
+	NSNick     = `http://jabber.org/protocol/nick`
+)
+
+// Features that may be advertised to receive notifications when the metadata
+// or nickname of a contact changes.
+const (
+	FeatureMetadataNotify = NSMetadata + "+notify"
+	FeatureNickNotify     = NSNick + "+notify"
+)
+
+// Handler contains callbacks that are called when notifications of avatar or
+// nickname changes are received.
+type Handler struct {
+	// HandleAvatar is called when a contact publishes new avatar metadata.
+	// If the contact has disabled their avatar, avatars will be empty.
+	HandleAvatar func(from jid.JID, avatars []Avatar)
+
+	// HandleNick is called when a contact publishes a new nickname.
+	// If the contact has removed their nickname, nick will be empty.
+	HandleNick func(from jid.JID, nick string)
+}
+
+// Handle returns an option that registers the handler for use with a
+// multiplexer.
+func Handle(h *Handler) mux.Option {
+	return func(m *mux.ServeMux) {
+		pubsub.HandleEvents(NSMetadata, h.handleMetadata)(m)
+		pubsub.HandleEvents(NSNick, h.handleNick)(m)
+	}
+}
+
+func (h *Handler) handleMetadata(msg stanza.Message, items pubsub.Items) error {
+	if h.HandleAvatar == nil {
+		return nil
+	}
+	from := msg.From.Bare()
+	for _, item := range items.Item {
+		// Events can be sent by anyone, so invalid items are skipped instead of
+		// returning an error which would end the session.
+		avatars, err := decodeMetadata(item)
+		if err != nil {
+			continue
+		}
+		h.HandleAvatar(from, avatars)
+	}
+	return nil
+}
+
+func (h *Handler) handleNick(msg stanza.Message, items pubsub.Items) error {
+	if h.HandleNick == nil {
+		return nil
+	}
+	from := msg.From.Bare()
+	for _, item := range items.Item {
+		nick, err := decodeNick(item)
+		if err != nil {
+			continue
+		}
+		h.HandleNick(from, nick)
+	}
+	if len(items.Retract) > 0 {
+		h.HandleNick(from, "")
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package profile_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"image"
+	"image/png"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/profile"
+)
+
+func testPNG(t *testing.T) []byte {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 2)))
+	if err != nil {
+		t.Fatalf("error encoding test image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestAvatar(t *testing.T) {
+	pep := &xmpptest.PubSub{}
+	s := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(pep.Handle())),
+	)
+	ctx := context.Background()
+	self := s.Client.LocalAddr()
+
+	data := testPNG(t)
+	avatar, err := profile.PublishAvatar(ctx, s.Client, data)
+	if err != nil {
+		t.Fatalf("error publishing avatar: %v", err)
+	}
+	if avatar.ID != profile.ID(data) || avatar.Type != "image/png" || avatar.Width != 4 || avatar.Height != 2 || avatar.Bytes != len(data) {
+		t.Errorf("wrong avatar info: %+v", avatar)
+	}
+
+	metadata, err := profile.FetchMetadata(ctx, s.Client, self)
+	if err != nil {
+		t.Fatalf("error fetching metadata: %v", err)
+	}
+	if len(metadata) != 1 {
+		t.Fatalf("wrong number of avatars: want=1, got=%d", len(metadata))
+	}
+	metadata[0].XMLName = xml.Name{}
+	if metadata[0] != avatar {
+		t.Errorf("wrong metadata: want=%+v, got=%+v", avatar, metadata[0])
+	}
+
+	fetched, err := profile.FetchAvatar(ctx, s.Client, self, avatar.ID)
+	if err != nil {
+		t.Fatalf("error fetching avatar: %v", err)
+	}
+	if !bytes.Equal(fetched, data) {
+		t.Errorf("fetched avatar data does not match")
+	}
+	img, format, err := profile.DecodeAvatar(fetched)
+	if err != nil {
+		t.Fatalf("error decoding avatar: %v", err)
+	}
+	if format != "png" || img.Bounds().Dx() != 4 {
+		t.Errorf("wrong image decoded: %s %v", format, img.Bounds())
+	}
+
+	_, err = profile.FetchAvatar(ctx, s.Client, self, "notanid")
+	if !errors.Is(err, profile.ErrInvalidID) {
+		t.Errorf("wrong error for invalid ID: want=%v, got=%v", profile.ErrInvalidID, err)
+	}
+	_, err = profile.FetchAvatar(ctx, s.Client, self, strings.Repeat("0", 40))
+	if !errors.Is(err, profile.ErrNotFound) {
+		t.Errorf("wrong error for unknown ID: want=%v, got=%v", profile.ErrNotFound, err)
+	}
+
+	// Publish data under an ID that does not match it.
+	badID := strings.Repeat("a", 40)
+	items := pep.Items(profile.NSData)
+	item := items[0]
+	item.ID = badID
+	pep.SetItems(profile.NSData, append(items, item))
+	_, err = profile.FetchAvatar(ctx, s.Client, self, badID)
+	if !errors.Is(err, profile.ErrHashMismatch) {
+		t.Errorf("wrong error for mismatched data: want=%v, got=%v", profile.ErrHashMismatch, err)
+	}
+
+	err = profile.DisableAvatar(ctx, s.Client)
+	if err != nil {
+		t.Fatalf("error disabling avatar: %v", err)
+	}
+	metadata, err = profile.FetchMetadata(ctx, s.Client, self)
+	if err != nil {
+		t.Fatalf("error fetching metadata: %v", err)
+	}
+	if len(metadata) != 0 {
+		t.Errorf("expected avatar to be disabled, got %+v", metadata)
+	}
+}
+
+func TestNick(t *testing.T) {
+	pep := &xmpptest.PubSub{}
+	s := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(pep.Handle())),
+	)
+	ctx := context.Background()
+	self := s.Client.LocalAddr()
+
+	nick, err := profile.FetchNick(ctx, s.Client, self)
+	if err != nil {
+		t.Fatalf("error fetching unset nick: %v", err)
+	}
+	if nick != "" {
+		t.Errorf("expected no nick, got %q", nick)
+	}
+
+	err = profile.PublishNick(ctx, s.Client, "Ishmael")
+	if err != nil {
+		t.Fatalf("error publishing nick: %v", err)
+	}
+	nick, err = profile.FetchNick(ctx, s.Client, self)
+	if err != nil {
+		t.Fatalf("error fetching nick: %v", err)
+	}
+	if nick != "Ishmael" {
+		t.Errorf("wrong nick: want=Ishmael, got=%q", nick)
+	}
+}
+
+const eventsXML = `
+<message from='mallory@example.net' to='romeo@montague.lit/home'>
+  <event xmlns='http://jabber.org/protocol/pubsub#event'>
+    <items node='urn:xmpp:avatar:metadata'>
+      <item id='x'/>
+    </items>
+  </event>
+</message>
+<message from='mallory@example.net' to='romeo@montague.lit/home'>
+  <event xmlns='http://jabber.org/protocol/pubsub#event'>
+    <items node='http://jabber.org/protocol/nick'>
+      <item id='x'/>
+      <item id='y'>
+        <nick xmlns='urn:example'>Mallory</nick>
+      </item>
+    </items>
+  </event>
+</message>
+<message from='mallory@example.net' to='romeo@montague.lit/home'>
+  <event xmlns='http://jabber.org/protocol/pubsub#event'>
+    <items node='urn:xmpp:avatar:metadata'>
+      <item id='y'>
+        <info xmlns='urn:xmpp:avatar:metadata' bytes='1' id='x'/>
+      </item>
+    </items>
+  </event>
+</message>
+<message from='juliet@capulet.lit' to='romeo@montague.lit/home' type='headline'>
+  <event xmlns='http://jabber.org/protocol/pubsub#event'>
+    <items node='urn:xmpp:avatar:metadata'>
+      <item id='111f4b3c50d7b0df729d299bc6f8e9ef9066971f'>
+        <metadata xmlns='urn:xmpp:avatar:metadata'>
+          <info bytes='12345' width='64' height='64' id='111f4b3c50d7b0df729d299bc6f8e9ef9066971f' type='image/png'/>
+          <info bytes='12345' width='64' height='64' id='e279f80c38f99c1e7e53e262b440993b2f7eea57' type='image/png' url='http://avatars.example.org/happy.png'/>
+        </metadata>
+      </item>
+    </items>
+  </event>
+</message>
+<message from='juliet@capulet.lit/balcony' to='romeo@montague.lit/home'>
+  <event xmlns='http://jabber.org/protocol/pubsub#event'>
+    <items node='http://jabber.org/protocol/nick'>
+      <item>
+        <nick xmlns='http://jabber.org/protocol/nick'>Jules</nick>
+      </item>
+    </items>
+  </event>
+</message>
+<message from='juliet@capulet.lit' to='romeo@montague.lit/home'>
+  <event xmlns='http://jabber.org/protocol/pubsub#event'>
+    <items node='urn:xmpp:avatar:metadata'>
+      <item>
+        <metadata xmlns='urn:xmpp:avatar:metadata'/>
+      </item>
+    </items>
+  </event>
+</message>`
+
+type avatarEvent struct {
+	from    jid.JID
+	avatars []profile.Avatar
+}
+
+type nickEvent struct {
+	from jid.JID
+	nick string
+}
+
+func TestHandler(t *testing.T) {
+	events := make(chan interface{}, 10)
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(profile.Handle(&profile.Handler{
+			HandleAvatar: func(from jid.JID, avatars []profile.Avatar) {
+				events <- avatarEvent{from: from, avatars: avatars}
+			},
+			HandleNick: func(from jid.JID, nick string) {
+				events <- nickEvent{from: from, nick: nick}
+			},
+		}))),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// Remove indentation between elements.
+	d := xml.NewDecoder(strings.NewReader(regexp.MustCompile(`>\s+<`).ReplaceAllString(eventsXML, "><")))
+	iter := xmlstream.NewIter(d)
+	for iter.Next() {
+		start, inner := iter.Current()
+		if start == nil {
+			continue
+		}
+		err := s.Server.Send(ctx, xmlstream.MultiReader(
+			xmlstream.Token(*start),
+			inner,
+		))
+		if err != nil {
+			t.Fatalf("error sending: %v", err)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatalf("error iterating over events: %v", err)
+	}
+
+	juliet := jid.MustParse("juliet@capulet.lit")
+	metadata := xml.Name{Space: profile.NSMetadata, Local: "info"}
+	want := []interface{}{
+		avatarEvent{from: juliet, avatars: []profile.Avatar{{
+			XMLName: metadata,
+			ID:      "111f4b3c50d7b0df729d299bc6f8e9ef9066971f",
+			Bytes:   12345,
+			Type:    "image/png",
+			Width:   64,
+			Height:  64,
+		}, {
+			XMLName: metadata,
+			ID:      "e279f80c38f99c1e7e53e262b440993b2f7eea57",
+			Bytes:   12345,
+			Type:    "image/png",
+			Width:   64,
+			Height:  64,
+			URL:     "http://avatars.example.org/happy.png",
+		}}},
+		nickEvent{from: juliet, nick: "Jules"},
+		avatarEvent{from: juliet},
+	}
+	// Invalid events are skipped without ending the session.
+	for i, w := range want {
+		select {
+		case e := <-events:
+			if !reflect.DeepEqual(e, w) {
+				t.Errorf("wrong event %d:\nwant=%+v,\n got=%+v", i, w, e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event %d", i)
+		}
+	}
+}
+
+var marshalTestCases = []xmpptest.EncodingTestCase{
+	0: {
+		Value: &profile.Avatar{
+			ID:    "111f4b3c50d7b0df729d299bc6f8e9ef9066971f",
+			Bytes: 12345,
+			Type:  "image/png",
+		},
+		XML:         `<info xmlns="urn:xmpp:avatar:metadata" id="111f4b3c50d7b0df729d299bc6f8e9ef9066971f" bytes="12345" type="image/png"></info>`,
+		NoUnmarshal: true,
+	},
+}
+
+func TestEncode(t *testing.T) {
+	xmpptest.RunEncodingTests(t, marshalTestCases)
+}
@@ -0,0 +1,113 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package push
+
+import (
+	"encoding/xml"
+	"errors"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/internal/pubsub"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// Notification is a push notification that was published to an app server by
+// a user's server.
+type Notification struct {
+	// From is the server that published the notification.
+	From jid.JID
+
+	// Node is the node that the client registered with the app server.
+	Node string
+
+	// Summary is the optional summary of the event that triggered the
+	// notification.
+	// It is nil if no summary was included.
+	Summary *form.Data
+
+	// Options are the publish options that the client provided when it enabled
+	// notifications, such as a secret shared with the app server.
+	// It is nil if no publish options were included.
+	Options *form.Data
+}
+
+// Handle returns an option that registers a Handler for notifications
+// published to an app server.
+func Handle(h Handler) mux.Option {
+	return mux.IQ(stanza.SetIQ, xml.Name{Space: pubsub.NS, Local: "pubsub"}, h)
+}
+
+// Handler receives notifications published to an app server and passes them on
+// to a push gateway.
+type Handler struct {
+	// Dispatch is called for every notification that is received.
+	// If Dispatch returns an error the publish request is rejected and the user's
+	// server will normally disable notifications for the node.
+	// If the error is a stanza.Error it is sent as is, otherwise an
+	// internal-server-error is returned.
+	Dispatch func(Notification) error
+}
+
+// HandleIQ satisfies mux.IQHandler.
+// it is used by the multiplexer and normally does not need to be called by the
+// user.
+func (h Handler) HandleIQ(iq stanza.IQ, r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	req := struct {
+		Publish *struct {
+			Node string      `xml:"node,attr"`
+			Item pubsub.Item `xml:"item"`
+		} `xml:"publish"`
+		Options *form.Data `xml:"publish-options>x"`
+	}{}
+	err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), r)).Decode(&req)
+	if err != nil {
+		return err
+	}
+	if req.Publish == nil || req.Publish.Node == "" {
+		_, err = xmlstream.Copy(r, iq.Error(stanza.Error{
+			Type:      stanza.Modify,
+			Condition: stanza.BadRequest,
+		}))
+		return err
+	}
+
+	payload := struct {
+		XMLName xml.Name   `xml:"urn:xmpp:push:0 notification"`
+		Summary *form.Data `xml:"jabber:x:data x"`
+	}{}
+	err = req.Publish.Item.Decode(&payload)
+	if err != nil {
+		_, err = xmlstream.Copy(r, iq.Error(stanza.Error{
+			Type:      stanza.Modify,
+			Condition: stanza.BadRequest,
+		}))
+		return err
+	}
+
+	if h.Dispatch != nil {
+		err = h.Dispatch(Notification{
+			From:    iq.From,
+			Node:    req.Publish.Node,
+			Summary: payload.Summary,
+			Options: req.Options,
+		})
+	}
+	if err != nil {
+		stanzaErr := stanza.Error{}
+		if !errors.As(err, &stanzaErr) {
+			stanzaErr = stanza.Error{
+				Type:      stanza.Wait,
+				Condition: stanza.InternalServerError,
+			}
+		}
+		_, err = xmlstream.Copy(r, iq.Error(stanzaErr))
+		return err
+	}
+	_, err = xmlstream.Copy(r, iq.Result(nil))
+	return err
+}
@@ -0,0 +1,103 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package push implements XEP-0357: Push Notifications.
+//
+// Push notifications involve three parties: the client, which registers a push
+// service with its server using Enable, the user's server, which publishes a
+// notification to the push service using Notify whenever something happens
+// while the client is offline, and the "app server" which runs the push
+// service and forwards notifications to a proprietary push gateway.
+// App servers can use Handler to receive notifications.
+package push // import "mellium.im/xmpp/push"
+
+import (
+	"context"
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/internal/pubsub"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// Namespaces used by this package, provided as a convenience.
+const (
+	NS        = `urn:xmpp:push:0`
+	NSSummary = `urn:xmpp:push:summary`
+)
+
+// Enable asks the user's server to start sending notifications to the node on
+// the push service.
+// If opts is not nil, it is submitted as the publish options of each
+// notification and can be used to pass secrets or other registration data to
+// the app server.
+func Enable(ctx context.Context, s *xmpp.Session, service jid.JID, node string, opts *form.Data) error {
+	return EnableIQ(ctx, stanza.IQ{}, s, service, node, opts)
+}
+
+// EnableIQ is like Enable except that it lets you customize the IQ.
+// Changing the type of the provided IQ has no effect.
+func EnableIQ(ctx context.Context, iq stanza.IQ, s *xmpp.Session, service jid.JID, node string, opts *form.Data) error {
+	var inner xml.TokenReader
+	if opts != nil {
+		inner, _ = opts.Submit()
+	}
+	return doIQ(ctx, iq, s, xmlstream.Wrap(inner, xml.StartElement{
+		Name: xml.Name{Space: NS, Local: "enable"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "jid"}, Value: service.String()},
+			{Name: xml.Name{Local: "node"}, Value: node},
+		},
+	}))
+}
+
+// Disable asks the user's server to stop sending notifications to the node on
+// the push service.
+// If node is empty, notifications to all nodes on the push service are
+// disabled.
+func Disable(ctx context.Context, s *xmpp.Session, service jid.JID, node string) error {
+	return DisableIQ(ctx, stanza.IQ{}, s, service, node)
+}
+
+// DisableIQ is like Disable except that it lets you customize the IQ.
+// Changing the type of the provided IQ has no effect.
+func DisableIQ(ctx context.Context, iq stanza.IQ, s *xmpp.Session, service jid.JID, node string) error {
+	attrs := []xml.Attr{{Name: xml.Name{Local: "jid"}, Value: service.String()}}
+	if node != "" {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "node"}, Value: node})
+	}
+	return doIQ(ctx, iq, s, xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NS, Local: "disable"},
+		Attr: attrs,
+	}))
+}
+
+func doIQ(ctx context.Context, iq stanza.IQ, s *xmpp.Session, payload xml.TokenReader) error {
+	if iq.Type != stanza.SetIQ {
+		iq.Type = stanza.SetIQ
+	}
+	return s.UnmarshalIQElement(ctx, payload, iq, nil)
+}
+
+// Notify publishes a notification to the node on the push service.
+// It is meant to be used by the user's server when a client has enabled push
+// notifications.
+// If summary is not nil it is submitted along with the notification and should
+// contain fields from the NSSummary form, such as "message-count" or
+// "last-message-sender".
+// Opts should be the publish options that the client provided when enabling
+// notifications.
+func Notify(ctx context.Context, s *xmpp.Session, service jid.JID, node string, summary, opts *form.Data) error {
+	var inner xml.TokenReader
+	if summary != nil {
+		inner, _ = summary.Submit()
+	}
+	_, err := pubsub.Publish(ctx, s, service, node, "", opts, xmlstream.Wrap(inner, xml.StartElement{
+		Name: xml.Name{Space: NS, Local: "notification"},
+	}))
+	return err
+}
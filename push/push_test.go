@@ -0,0 +1,141 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package push_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/push"
+	"mellium.im/xmpp/stanza"
+)
+
+type request struct {
+	XMLName xml.Name
+	JID     string     `xml:"jid,attr"`
+	Node    string     `xml:"node,attr"`
+	Form    *form.Data `xml:"jabber:x:data x"`
+}
+
+func TestEnableDisable(t *testing.T) {
+	requests := make(chan request, 2)
+	handler := func(iq stanza.IQ, r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		var req request
+		err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), r)).Decode(&req)
+		if err != nil {
+			return err
+		}
+		requests <- req
+		_, err = xmlstream.Copy(r, iq.Result(nil))
+		return err
+	}
+	s := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(
+			mux.IQFunc(stanza.SetIQ, xml.Name{Space: push.NS, Local: "enable"}, handler),
+			mux.IQFunc(stanza.SetIQ, xml.Name{Space: push.NS, Local: "disable"}, handler),
+		)),
+	)
+
+	ctx := context.Background()
+	service := jid.MustParse("push.example.net")
+	opts := form.New(
+		form.Hidden("FORM_TYPE", form.Value("http://jabber.org/protocol/pubsub#publish-options")),
+		form.Text("secret", form.Value("eruio234vzxc2kla-91")),
+	)
+	err := push.Enable(ctx, s.Client, service, "yxs32uqsflafdk3iuqo", opts)
+	if err != nil {
+		t.Fatalf("error enabling push: %v", err)
+	}
+	req := <-requests
+	if req.XMLName != (xml.Name{Space: push.NS, Local: "enable"}) {
+		t.Errorf("wrong payload: want=enable, got=%v", req.XMLName)
+	}
+	if req.JID != service.String() || req.Node != "yxs32uqsflafdk3iuqo" {
+		t.Errorf("wrong service: want=%s/%s, got=%s/%s", service, "yxs32uqsflafdk3iuqo", req.JID, req.Node)
+	}
+	if req.Form == nil {
+		t.Fatalf("expected publish options to be sent")
+	}
+	if secret, _ := req.Form.GetString("secret"); secret != "eruio234vzxc2kla-91" {
+		t.Errorf("wrong secret: want=%q, got=%q", "eruio234vzxc2kla-91", secret)
+	}
+
+	err = push.Disable(ctx, s.Client, service, "")
+	if err != nil {
+		t.Fatalf("error disabling push: %v", err)
+	}
+	req = <-requests
+	if req.XMLName != (xml.Name{Space: push.NS, Local: "disable"}) {
+		t.Errorf("wrong payload: want=disable, got=%v", req.XMLName)
+	}
+	if req.JID != service.String() || req.Node != "" {
+		t.Errorf("wrong service: want=%s, got=%s/%s", service, req.JID, req.Node)
+	}
+	if req.Form != nil {
+		t.Errorf("unexpected form sent while disabling")
+	}
+}
+
+func TestNotify(t *testing.T) {
+	const secret = "eruio234vzxc2kla-91"
+	gateway := make(chan push.Notification, 1)
+	s := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(push.Handle(push.Handler{
+			Dispatch: func(n push.Notification) error {
+				if s, _ := n.Options.GetString("secret"); s != secret {
+					return stanza.Error{Type: stanza.Auth, Condition: stanza.NotAuthorized}
+				}
+				gateway <- n
+				return nil
+			},
+		}))),
+	)
+
+	ctx := context.Background()
+	service := jid.MustParse("push.example.net")
+	summary := form.New(
+		form.Hidden("FORM_TYPE", form.Value(push.NSSummary)),
+		form.Text("message-count", form.Value("1")),
+		form.Text("last-message-sender", form.Value("juliet@capulet.example/balcony")),
+	)
+	opts := func(secret string) *form.Data {
+		return form.New(
+			form.Hidden("FORM_TYPE", form.Value("http://jabber.org/protocol/pubsub#publish-options")),
+			form.Text("secret", form.Value(secret)),
+		)
+	}
+
+	err := push.Notify(ctx, s.Client, service, "yxs32uqsflafdk3iuqo", summary, opts(secret))
+	if err != nil {
+		t.Fatalf("error publishing notification: %v", err)
+	}
+	n := <-gateway
+	if n.Node != "yxs32uqsflafdk3iuqo" {
+		t.Errorf("wrong node: want=%q, got=%q", "yxs32uqsflafdk3iuqo", n.Node)
+	}
+	if n.Summary == nil {
+		t.Fatalf("expected notification to have a summary")
+	}
+	if sender, _ := n.Summary.GetString("last-message-sender"); sender != "juliet@capulet.example/balcony" {
+		t.Errorf("wrong sender: want=%q, got=%q", "juliet@capulet.example/balcony", sender)
+	}
+
+	err = push.Notify(ctx, s.Client, service, "yxs32uqsflafdk3iuqo", nil, opts("wrong"))
+	if !errors.Is(err, stanza.Error{Condition: stanza.NotAuthorized}) {
+		t.Errorf("wrong error: want=%v, got=%v", stanza.NotAuthorized, err)
+	}
+	select {
+	case n := <-gateway:
+		t.Errorf("unexpected notification dispatched: %+v", n)
+	default:
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package reactions
+
+import (
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/fallback"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// Reacted is a message that replaces the reactions of its sender to an earlier
+// message.
+type Reacted struct {
+	stanza.Message
+
+	// ID is the ID of the message being reacted to.
+	ID string
+	// Reactions is the full set of reactions by the sender.
+	// If it is empty, all previous reactions by the sender should be removed.
+	Reactions []string
+}
+
+// Replied is a message that replies to an earlier message.
+type Replied struct {
+	stanza.Message
+
+	Reply Reply
+	// Body is the body of the message with any quotation that was included as a
+	// fallback removed.
+	Body string
+}
+
+// Handle returns an option that registers a Handler for reactions and replies.
+func Handle(h *Handler) mux.Option {
+	return func(m *mux.ServeMux) {
+		reactions := xml.Name{Space: NS, Local: "reactions"}
+		reply := xml.Name{Space: NSReply, Local: "reply"}
+		for _, typ := range []stanza.MessageType{"", stanza.NormalMessage, stanza.ChatMessage, stanza.GroupChatMessage} {
+			mux.Message(typ, reactions, h)(m)
+			mux.Message(typ, reply, h)(m)
+		}
+	}
+}
+
+// Handler reports reactions and replies.
+type Handler struct {
+	HandleReactions func(Reacted)
+	HandleReply     func(Replied)
+}
+
+// HandleMessage satisfies mux.MessageHandler.
+// it is used by the multiplexer and normally does not need to be called by the
+// user.
+func (h *Handler) HandleMessage(p stanza.Message, r xmlstream.TokenReadEncoder) error {
+	msg := struct {
+		stanza.Message
+		Body      string              `xml:"body"`
+		Reactions *Reactions          `xml:"urn:xmpp:reactions:0 reactions"`
+		Reply     *Reply              `xml:"urn:xmpp:reply:0 reply"`
+		Fallback  []fallback.Fallback `xml:"urn:xmpp:fallback:0 fallback"`
+	}{}
+	err := xml.NewTokenDecoder(r).Decode(&msg)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case msg.Reactions != nil:
+		if h.HandleReactions == nil {
+			return nil
+		}
+		h.HandleReactions(Reacted{
+			Message:   p,
+			ID:        msg.Reactions.ID,
+			Reactions: msg.Reactions.Reactions,
+		})
+	case msg.Reply != nil:
+		if h.HandleReply == nil {
+			return nil
+		}
+		h.HandleReply(Replied{
+			Message: p,
+			Reply:   *msg.Reply,
+			Body:    fallback.Strip(msg.Body, msg.Fallback, NSReply),
+		})
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package reactions implements reacting and replying to messages.
+//
+// Reactions are described in XEP-0444: Message Reactions.
+// Each reaction message contains the full set of reactions by its sender to a
+// single message, replacing any reactions that were previously sent, so
+// removing a reaction is done by sending the remaining reactions and removing
+// all of them is done by sending an empty set.
+//
+// Replies are described in XEP-0461: Message Replies.
+// Replies may quote the message being replied to for clients that do not
+// support replies, in which case the quotation is marked as a fallback and is
+// removed from the body before it is reported by the Handler.
+package reactions // import "mellium.im/xmpp/reactions"
+
+import (
+	"encoding/xml"
+	"strings"
+	"unicode/utf8"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/fallback"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/styling"
+)
+
+// Various namespaces used by this package, provided as a convenience.
+const (
+	NS      = `urn:xmpp:reactions:0`
+	NSReply = `urn:xmpp:reply:0`
+)
+
+// Reactions is the set of reactions by a single sender to the message with
+// the provided ID.
+type Reactions struct {
+	XMLName   xml.Name `xml:"urn:xmpp:reactions:0 reactions"`
+	ID        string   `xml:"id,attr"`
+	Reactions []string `xml:"reaction"`
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (r Reactions) TokenReader() xml.TokenReader {
+	var inner []xml.TokenReader
+	for _, reaction := range r.Reactions {
+		inner = append(inner, xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(reaction)),
+			xml.StartElement{Name: xml.Name{Local: "reaction"}},
+		))
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{
+			Name: xml.Name{Space: NS, Local: "reactions"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: r.ID}},
+		},
+	)
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (r Reactions) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, r.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (r Reactions) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	_, err := r.WriteXML(e)
+	return err
+}
+
+// Reply indicates that a message is a reply to the message with the provided
+// ID sent by To.
+type Reply struct {
+	XMLName xml.Name `xml:"urn:xmpp:reply:0 reply"`
+	To      jid.JID  `xml:"to,attr,omitempty"`
+	ID      string   `xml:"id,attr"`
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (r Reply) TokenReader() xml.TokenReader {
+	start := xml.StartElement{Name: xml.Name{Space: NSReply, Local: "reply"}}
+	if !r.To.Equal(jid.JID{}) {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "to"}, Value: r.To.String()})
+	}
+	start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "id"}, Value: r.ID})
+	return xmlstream.Wrap(nil, start)
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (r Reply) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, r.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (r Reply) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	_, err := r.WriteXML(e)
+	return err
+}
+
+// React returns a message that replaces our reactions to the message with the
+// provided ID.
+// Duplicate reactions are removed, and if no reactions are provided any
+// previous reactions are removed.
+func React(msg stanza.Message, id string, reactions ...string) xml.TokenReader {
+	r := Reactions{ID: id}
+	seen := make(map[string]struct{}, len(reactions))
+	for _, reaction := range reactions {
+		if _, ok := seen[reaction]; ok {
+			continue
+		}
+		seen[reaction] = struct{}{}
+		r.Reactions = append(r.Reactions, reaction)
+	}
+	return msg.Wrap(r.TokenReader())
+}
+
+// Quote returns body as a block quote suitable for use as the fallback in a
+// reply.
+// Block quotes in body are removed first so that replying to a reply does not
+// result in ever deeper quotations.
+// Block quotes are detected using the rules from XEP-0393: Message Styling so
+// that, for example, lines that start with ">" inside a preformatted text block
+// are kept.
+func Quote(body string) string {
+	var unquoted strings.Builder
+	d := styling.NewDecoder(strings.NewReader(body))
+	for d.Next() {
+		tok := d.Token()
+		if tok.Mask&styling.BlockQuote == styling.BlockQuote {
+			continue
+		}
+		unquoted.Write(tok.Data)
+	}
+
+	text := strings.TrimRight(unquoted.String(), "\n")
+	if text == "" {
+		return ""
+	}
+	var buf strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		if line == "" {
+			buf.WriteString(">\n")
+			continue
+		}
+		buf.WriteString("> ")
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// ReplyTo returns a message that replies to the message with the provided ID
+// sent by to.
+// If quote is not empty it is quoted at the start of the body (see Quote) and
+// marked as a fallback for clients that do not support replies.
+func ReplyTo(msg stanza.Message, to jid.JID, id, quote, body string) xml.TokenReader {
+	quote = Quote(quote)
+	inner := []xml.TokenReader{
+		xmlstream.Wrap(
+			xmlstream.Token(xml.CharData(quote+body)),
+			xml.StartElement{Name: xml.Name{Local: "body"}},
+		),
+		Reply{To: to, ID: id}.TokenReader(),
+	}
+	if quote != "" {
+		inner = append(inner, fallback.Fallback{
+			For:  NSReply,
+			Body: []fallback.Range{{Start: 0, End: utf8.RuneCountInString(quote)}},
+		}.TokenReader())
+	}
+	return msg.Wrap(xmlstream.MultiReader(inner...))
+}
@@ -0,0 +1,219 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package reactions_test
+
+import (
+	"context"
+	"encoding/xml"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/reactions"
+	"mellium.im/xmpp/stanza"
+)
+
+var (
+	_ xml.Marshaler       = reactions.Reactions{}
+	_ xmlstream.Marshaler = reactions.Reactions{}
+	_ xmlstream.WriterTo  = reactions.Reactions{}
+	_ xml.Marshaler       = reactions.Reply{}
+	_ xmlstream.Marshaler = reactions.Reply{}
+	_ xmlstream.WriterTo  = reactions.Reply{}
+)
+
+var encodingTestCases = []xmpptest.EncodingTestCase{
+	0: {
+		Value: &reactions.Reactions{
+			XMLName: xml.Name{Space: reactions.NS, Local: "reactions"},
+			ID:      "744f6e18",
+		},
+		XML: `<reactions xmlns="urn:xmpp:reactions:0" id="744f6e18"></reactions>`,
+	},
+	1: {
+		Value: &reactions.Reactions{
+			XMLName:   xml.Name{Space: reactions.NS, Local: "reactions"},
+			ID:        "744f6e18",
+			Reactions: []string{"👋", "🐢"},
+		},
+		XML: `<reactions xmlns="urn:xmpp:reactions:0" id="744f6e18"><reaction>👋</reaction><reaction>🐢</reaction></reactions>`,
+	},
+	2: {
+		Value: &reactions.Reply{
+			XMLName: xml.Name{Space: reactions.NSReply, Local: "reply"},
+			To:      jid.MustParse("anna@example.com/tablet"),
+			ID:      "message-id1",
+		},
+		XML: `<reply xmlns="urn:xmpp:reply:0" to="anna@example.com/tablet" id="message-id1"></reply>`,
+	},
+	3: {
+		Value: &reactions.Reply{
+			XMLName: xml.Name{Space: reactions.NSReply, Local: "reply"},
+			ID:      "message-id1",
+		},
+		XML: `<reply xmlns="urn:xmpp:reply:0" id="message-id1"></reply>`,
+	},
+}
+
+func TestEncode(t *testing.T) {
+	xmpptest.RunEncodingTests(t, encodingTestCases)
+}
+
+func encode(t *testing.T, r xml.TokenReader) string {
+	t.Helper()
+	var buf strings.Builder
+	e := xml.NewEncoder(&buf)
+	_, err := xmlstream.Copy(e, r)
+	if err != nil {
+		t.Fatalf("error encoding: %v", err)
+	}
+	err = e.Flush()
+	if err != nil {
+		t.Fatalf("error flushing: %v", err)
+	}
+	return buf.String()
+}
+
+func TestBuild(t *testing.T) {
+	msg := stanza.Message{
+		To:   jid.MustParse("romeo@montague.lit/orchard"),
+		Type: stanza.ChatMessage,
+	}
+	const wantReact = `<message type="chat" to="romeo@montague.lit/orchard"><reactions xmlns="urn:xmpp:reactions:0" id="744f6e18"><reaction>👋</reaction><reaction>🐢</reaction></reactions></message>`
+	if out := encode(t, reactions.React(msg, "744f6e18", "👋", "🐢", "👋")); out != wantReact {
+		t.Errorf("wrong reactions:\nwant=%s,\n got=%s", wantReact, out)
+	}
+	const wantRemove = `<message type="chat" to="romeo@montague.lit/orchard"><reactions xmlns="urn:xmpp:reactions:0" id="744f6e18"></reactions></message>`
+	if out := encode(t, reactions.React(msg, "744f6e18")); out != wantRemove {
+		t.Errorf("wrong removal:\nwant=%s,\n got=%s", wantRemove, out)
+	}
+	const wantReply = `<message type="chat" to="romeo@montague.lit/orchard"><body>&gt; We should bake a 🎂` + "\n" +
+		`Great idea!</body><reply xmlns="urn:xmpp:reply:0" to="romeo@montague.lit/orchard" id="message-id1"></reply><fallback xmlns="urn:xmpp:fallback:0" for="urn:xmpp:reply:0"><body start="0" end="21"></body></fallback></message>`
+	if out := encode(t, reactions.ReplyTo(msg, msg.To, "message-id1", "We should bake a 🎂", "Great idea!")); out != wantReply {
+		t.Errorf("wrong reply:\nwant=%s,\n got=%s", wantReply, out)
+	}
+	const wantNoQuote = `<message type="chat" to="romeo@montague.lit/orchard"><body>Great idea!</body><reply xmlns="urn:xmpp:reply:0" id="message-id1"></reply></message>`
+	if out := encode(t, reactions.ReplyTo(msg, jid.JID{}, "message-id1", "", "Great idea!")); out != wantNoQuote {
+		t.Errorf("wrong reply without quote:\nwant=%s,\n got=%s", wantNoQuote, out)
+	}
+}
+
+var quoteTestCases = [...]struct {
+	in  string
+	out string
+}{
+	0: {},
+	1: {
+		in:  "We should bake a cake",
+		out: "> We should bake a cake\n",
+	},
+	2: {
+		in:  "We should bake a cake\n\nWith candles",
+		out: "> We should bake a cake\n>\n> With candles\n",
+	},
+	3: {
+		// Existing quotes are removed.
+		in:  "> Anna wrote:\n> We should bake a cake\nGreat idea!\n",
+		out: "> Great idea!\n",
+	},
+	4: {
+		// Nested quotes are removed as well.
+		in:  "> > Nested\n> Quote\nReply",
+		out: "> Reply\n",
+	},
+	5: {
+		// Only block quotes are removed, not other lines that start with ">".
+		in:  "```\n> not a quote\n```",
+		out: "> ```\n> > not a quote\n> ```\n",
+	},
+	6: {
+		in: "> Only a quote",
+	},
+}
+
+func TestQuote(t *testing.T) {
+	for i, tc := range quoteTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			out := reactions.Quote(tc.in)
+			if out != tc.out {
+				t.Errorf("wrong output: want=%q, got=%q", tc.out, out)
+			}
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	romeo := jid.MustParse("romeo@montague.lit/orchard")
+
+	events := make(chan interface{}, 10)
+	h := &reactions.Handler{
+		HandleReactions: func(r reactions.Reacted) {
+			events <- r
+		},
+		HandleReply: func(r reactions.Replied) {
+			events <- r
+		},
+	}
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(reactions.Handle(h))),
+	)
+
+	msg := stanza.Message{From: romeo, Type: stanza.ChatMessage}
+	ctx := context.Background()
+	for i, r := range []xml.TokenReader{
+		reactions.React(msg, "744f6e18", "👋", "🐢"),
+		reactions.React(msg, "744f6e18"),
+		reactions.ReplyTo(msg, romeo, "message-id1", "We should bake a cake", "Great idea!"),
+	} {
+		err := s.Server.Send(ctx, r)
+		if err != nil {
+			t.Fatalf("error sending message %d: %v", i, err)
+		}
+	}
+
+	msg.XMLName = xml.Name{Space: "jabber:client", Local: "message"}
+	want := []interface{}{
+		reactions.Reacted{
+			Message:   msg,
+			ID:        "744f6e18",
+			Reactions: []string{"👋", "🐢"},
+		},
+		reactions.Reacted{
+			Message: msg,
+			ID:      "744f6e18",
+		},
+		reactions.Replied{
+			Message: msg,
+			Reply: reactions.Reply{
+				XMLName: xml.Name{Space: reactions.NSReply, Local: "reply"},
+				To:      romeo,
+				ID:      "message-id1",
+			},
+			Body: "Great idea!",
+		},
+	}
+	for i, w := range want {
+		e := <-events
+		// IDs are assigned randomly when sending.
+		switch ev := e.(type) {
+		case reactions.Reacted:
+			ev.Message.ID = ""
+			ev.Message.To = jid.JID{}
+			e = ev
+		case reactions.Replied:
+			ev.Message.ID = ""
+			ev.Message.To = jid.JID{}
+			e = ev
+		}
+		if !reflect.DeepEqual(e, w) {
+			t.Errorf("wrong event %d:\nwant=%+v,\n got=%+v", i, w, e)
+		}
+	}
+}
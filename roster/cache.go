@@ -0,0 +1,227 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package roster
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/stanza"
+)
+
+// ChangeType is the kind of change that was made to a roster item.
+type ChangeType uint8
+
+// A list of possible changes.
+const (
+	ItemAdded ChangeType = iota
+	ItemUpdated
+	ItemRemoved
+)
+
+// Change is a change to an item in the roster.
+// If the item was removed, Item is the item as it was before it was removed.
+type Change struct {
+	Type ChangeType
+	Item Item
+}
+
+// Cache keeps a Store up to date with the roster on the server.
+//
+// Use Sync after establishing a session to bring the store up to date, and set
+// the Cache on a Handler so that roster pushes keep it current.
+// The Store field must be set before the Cache is used.
+type Cache struct {
+	// Store is where the roster is kept between sessions.
+	Store Store
+
+	// Changed, if not nil, is called for every item that is added, updated, or
+	// removed.
+	Changed func(Change)
+
+	m sync.Mutex
+}
+
+// Sync requests the roster and updates the store.
+//
+// If the server advertised roster versioning (see Versioning) the stored
+// version is sent along with the request.
+// If the server replies without a roster the stored roster is still current or
+// any changes will be sent as roster pushes, which are applied by a Handler
+// that uses the same Cache.
+// If a roster is returned, or if the server does not support roster
+// versioning, the store is replaced and the differences are reported.
+func (c *Cache) Sync(ctx context.Context, s *xmpp.Session) error {
+	_, versioning := s.Feature(NSFeatures)
+	return c.sync(ctx, s, versioning)
+}
+
+func (c *Cache) sync(ctx context.Context, s *xmpp.Session, versioning bool) error {
+	var attrs []xml.Attr
+	if versioning {
+		ver, err := c.Store.Version()
+		if err != nil {
+			return err
+		}
+		// The ver attribute is included even if it is empty to tell the server
+		// that we support versioning but do not have a stored roster.
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "ver"}, Value: ver})
+	}
+
+	// The lock is not held while waiting for the response so that any roster
+	// pushes that arrive in the meantime can still be handled.
+	resp, err := s.SendIQElement(ctx, xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NS, Local: "query"},
+		Attr: attrs,
+	}), stanza.IQ{Type: stanza.GetIQ})
+	if err != nil {
+		return err
+	}
+	/* #nosec */
+	defer resp.Close()
+
+	tok, err := resp.Token()
+	if err != nil {
+		return err
+	}
+	start, ok := tok.(xml.StartElement)
+	if !ok {
+		return fmt.Errorf("roster: expected IQ start token, got %T %[1]v", tok)
+	}
+	_, err = stanza.UnmarshalIQError(resp, start)
+	if err != nil {
+		return err
+	}
+
+	// An empty result means that the stored roster is current.
+	// Whitespace between the IQ and the query is skipped.
+	for {
+		tok, err = resp.Token()
+		switch {
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			return err
+		}
+		if _, ok := tok.(xml.EndElement); ok {
+			return nil
+		}
+		if start, ok = tok.(xml.StartElement); ok {
+			break
+		}
+	}
+	query := struct {
+		Ver  string `xml:"ver,attr"`
+		Item []Item `xml:"item"`
+	}{}
+	err = xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(start), resp)).Decode(&query)
+	if err != nil {
+		return err
+	}
+	return c.replace(query.Ver, query.Item)
+}
+
+// replace replaces the stored roster and reports the differences.
+func (c *Cache) replace(ver string, items []Item) error {
+	c.m.Lock()
+	old, err := c.Store.Items()
+	if err != nil {
+		c.m.Unlock()
+		return err
+	}
+	err = c.Store.Replace(ver, items)
+	c.m.Unlock()
+	if err != nil {
+		return err
+	}
+
+	oldItems := make(map[string]Item, len(old))
+	for _, item := range old {
+		oldItems[item.JID.String()] = item
+	}
+	var changes []Change
+	for _, item := range items {
+		key := item.JID.String()
+		oldItem, ok := oldItems[key]
+		delete(oldItems, key)
+		switch {
+		case !ok:
+			changes = append(changes, Change{Type: ItemAdded, Item: item})
+		case !itemEqual(oldItem, item):
+			changes = append(changes, Change{Type: ItemUpdated, Item: item})
+		}
+	}
+	for _, item := range old {
+		if _, ok := oldItems[item.JID.String()]; ok {
+			changes = append(changes, Change{Type: ItemRemoved, Item: item})
+		}
+	}
+	c.changed(changes...)
+	return nil
+}
+
+// push applies a roster push to the store and reports the change, if any.
+func (c *Cache) push(ver string, item Item) error {
+	c.m.Lock()
+	oldItem, ok, err := c.Store.Item(item.JID)
+	if err != nil {
+		c.m.Unlock()
+		return err
+	}
+	if item.Subscription == "remove" {
+		err = c.Store.Delete(ver, item.JID)
+	} else {
+		err = c.Store.Set(ver, item)
+	}
+	c.m.Unlock()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case item.Subscription == "remove":
+		if ok {
+			c.changed(Change{Type: ItemRemoved, Item: oldItem})
+		}
+	case !ok:
+		c.changed(Change{Type: ItemAdded, Item: item})
+	case !itemEqual(oldItem, item):
+		c.changed(Change{Type: ItemUpdated, Item: item})
+	}
+	return nil
+}
+
+func (c *Cache) changed(changes ...Change) {
+	if c.Changed == nil {
+		return
+	}
+	for _, change := range changes {
+		c.Changed(change)
+	}
+}
+
+// itemEqual reports whether two items are the same, ignoring the order of
+// their groups.
+func itemEqual(a, b Item) bool {
+	if !a.JID.Equal(b.JID) || a.Name != b.Name || a.Subscription != b.Subscription || a.Ask != b.Ask || len(a.Group) != len(b.Group) {
+		return false
+	}
+	groupsA := append([]string(nil), a.Group...)
+	groupsB := append([]string(nil), b.Group...)
+	sort.Strings(groupsA)
+	sort.Strings(groupsB)
+	for i := range groupsA {
+		if groupsA[i] != groupsB[i] {
+			return false
+		}
+	}
+	return true
+}
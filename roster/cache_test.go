@@ -0,0 +1,266 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package roster_test
+
+import (
+	"context"
+	"encoding/xml"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/roster"
+	"mellium.im/xmpp/stanza"
+)
+
+var (
+	_ roster.Store = (*roster.MemoryStore)(nil)
+	_ roster.Store = (*roster.FileStore)(nil)
+)
+
+var (
+	juliet = roster.Item{
+		JID:          jid.MustParse("juliet@example.com"),
+		Name:         "Juliet",
+		Subscription: "both",
+		Group:        []string{"Friends"},
+	}
+	nurse = roster.Item{
+		JID:          jid.MustParse("nurse@example.com"),
+		Subscription: "from",
+	}
+)
+
+func testStore(t *testing.T, store roster.Store) {
+	t.Helper()
+	checkStore(t, store, "", nil)
+
+	err := store.Replace("1", []roster.Item{nurse, juliet})
+	if err != nil {
+		t.Fatalf("error replacing items: %v", err)
+	}
+	checkStore(t, store, "1", []roster.Item{juliet, nurse})
+
+	romeo := roster.Item{JID: jid.MustParse("romeo@example.net"), Subscription: "none"}
+	err = store.Set("2", romeo)
+	if err != nil {
+		t.Fatalf("error setting item: %v", err)
+	}
+	err = store.Delete("3", nurse.JID)
+	if err != nil {
+		t.Fatalf("error deleting item: %v", err)
+	}
+	checkStore(t, store, "3", []roster.Item{juliet, romeo})
+
+	item, ok, err := store.Item(juliet.JID)
+	if err != nil {
+		t.Fatalf("error getting item: %v", err)
+	}
+	if !ok || !reflect.DeepEqual(item, juliet) {
+		t.Errorf("wrong item: want=%+v, got=%+v (found: %t)", juliet, item, ok)
+	}
+	_, ok, err = store.Item(nurse.JID)
+	if err != nil {
+		t.Fatalf("error getting removed item: %v", err)
+	}
+	if ok {
+		t.Errorf("expected removed item to not be found")
+	}
+}
+
+func checkStore(t *testing.T, store roster.Store, ver string, want []roster.Item) {
+	t.Helper()
+	v, err := store.Version()
+	if err != nil {
+		t.Fatalf("error getting version: %v", err)
+	}
+	if v != ver {
+		t.Errorf("wrong version: want=%q, got=%q", ver, v)
+	}
+	items, err := store.Items()
+	if err != nil {
+		t.Fatalf("error getting items: %v", err)
+	}
+	if len(items) == 0 && len(want) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(items, want) {
+		t.Errorf("wrong items:\nwant=%+v,\n got=%+v", want, items)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, &roster.MemoryStore{})
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "roster.xml")
+	testStore(t, roster.NewFileStore(path))
+
+	// A new store using the same file should load the saved roster.
+	romeo := roster.Item{JID: jid.MustParse("romeo@example.net"), Subscription: "none"}
+	checkStore(t, roster.NewFileStore(path), "3", []roster.Item{juliet, romeo})
+}
+
+func TestCacheSync(t *testing.T) {
+	updatedJuliet := juliet
+	updatedJuliet.Group = []string{"Friends", "Capulets"}
+
+	responses := []roster.IQ{
+		{Query: struct {
+			Ver  string        `xml:"ver,attr"`
+			Item []roster.Item `xml:"item"`
+		}{Ver: "1", Item: []roster.Item{juliet, nurse}}},
+		// An empty result means that nothing changed.
+		{},
+		{Query: struct {
+			Ver  string        `xml:"ver,attr"`
+			Item []roster.Item `xml:"item"`
+		}{Ver: "2", Item: []roster.Item{updatedJuliet}}},
+	}
+	vers := make(chan string, len(responses))
+	s := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(
+			mux.IQFunc(stanza.GetIQ, xml.Name{Space: roster.NS, Local: "query"}, func(iq stanza.IQ, r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+				idx, ver := attr.Get(start.Attr, "ver")
+				if idx == -1 {
+					ver = "none"
+				}
+				vers <- ver
+				resp := responses[0]
+				responses = responses[1:]
+				if resp.Query.Ver == "" {
+					// Whitespace in an otherwise empty result must not be mistaken for a
+					// roster.
+					_, err := xmlstream.Copy(r, iq.Result(xmlstream.Token(xml.CharData("\n  "))))
+					return err
+				}
+				resp.IQ = stanza.IQ{ID: iq.ID, Type: stanza.ResultIQ}
+				// Whitespace before the query must not be mistaken for an empty
+				// result.
+				tr := resp.TokenReader()
+				tok, err := tr.Token()
+				if err != nil {
+					return err
+				}
+				_, err = xmlstream.Copy(r, xmlstream.MultiReader(
+					xmlstream.Token(tok),
+					xmlstream.Token(xml.CharData("\n  ")),
+					tr,
+				))
+				return err
+			}),
+		)),
+	)
+
+	var changes []roster.Change
+	cache := &roster.Cache{
+		Store: &roster.MemoryStore{},
+		Changed: func(c roster.Change) {
+			changes = append(changes, c)
+		},
+	}
+	ctx := context.Background()
+	for i, want := range []struct {
+		ver     string
+		changes []roster.Change
+	}{
+		0: {ver: "", changes: []roster.Change{
+			{Type: roster.ItemAdded, Item: juliet},
+			{Type: roster.ItemAdded, Item: nurse},
+		}},
+		1: {ver: "1"},
+		2: {ver: "1", changes: []roster.Change{
+			{Type: roster.ItemUpdated, Item: updatedJuliet},
+			{Type: roster.ItemRemoved, Item: nurse},
+		}},
+	} {
+		changes = nil
+		err := cache.SyncVersioned(ctx, s.Client)
+		if err != nil {
+			t.Fatalf("error syncing roster %d: %v", i, err)
+		}
+		if ver := <-vers; ver != want.ver {
+			t.Errorf("wrong version sent %d: want=%q, got=%q", i, want.ver, ver)
+		}
+		if len(changes) != 0 || len(want.changes) != 0 {
+			if !reflect.DeepEqual(changes, want.changes) {
+				t.Errorf("wrong changes %d:\nwant=%+v,\n got=%+v", i, want.changes, changes)
+			}
+		}
+	}
+	checkStore(t, cache.Store, "2", []roster.Item{updatedJuliet})
+}
+
+func TestCachePush(t *testing.T) {
+	changes := make(chan roster.Change, 10)
+	cache := &roster.Cache{
+		Store: &roster.MemoryStore{},
+		Changed: func(c roster.Change) {
+			changes <- c
+		},
+	}
+	pushed := make(chan roster.Item, 10)
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(roster.Handle(roster.Handler{
+			Cache: cache,
+			Push: func(item roster.Item) error {
+				pushed <- item
+				return nil
+			},
+		}))),
+	)
+
+	renamedNurse := nurse
+	renamedNurse.Name = "Nurse"
+	ctx := context.Background()
+	for i, push := range []struct {
+		from jid.JID
+		ver  string
+		item roster.Item
+	}{
+		0: {ver: "1", item: nurse},
+		1: {ver: "2", item: renamedNurse},
+		// Pushes from other entities are ignored.
+		2: {from: jid.MustParse("mallory@example.net"), ver: "3", item: juliet},
+		3: {ver: "4", item: roster.Item{JID: nurse.JID, Subscription: "remove"}},
+	} {
+		iq := roster.IQ{IQ: stanza.IQ{ID: strconv.Itoa(i), From: push.from, Type: stanza.SetIQ}}
+		iq.Query.Ver = push.ver
+		iq.Query.Item = []roster.Item{push.item}
+		// Wait for the response so that the session does not block writing it
+		// while we are still sending the next push.
+		resp, err := s.Server.SendIQ(ctx, iq.TokenReader())
+		if err != nil {
+			t.Fatalf("error sending push %d: %v", i, err)
+		}
+		err = resp.Close()
+		if err != nil {
+			t.Fatalf("error closing response %d: %v", i, err)
+		}
+	}
+
+	for i, want := range []roster.Change{
+		{Type: roster.ItemAdded, Item: nurse},
+		{Type: roster.ItemUpdated, Item: renamedNurse},
+		{Type: roster.ItemRemoved, Item: renamedNurse},
+	} {
+		if change := <-changes; !reflect.DeepEqual(change, want) {
+			t.Errorf("wrong change %d:\nwant=%+v,\n got=%+v", i, want, change)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if item := <-pushed; item.JID.Equal(juliet.JID) {
+			t.Errorf("push %d from another entity was not ignored", i)
+		}
+	}
+	checkStore(t, cache.Store, "4", nil)
+}
@@ -0,0 +1,18 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package roster
+
+import (
+	"context"
+
+	"mellium.im/xmpp"
+)
+
+// SyncVersioned is like Sync except that it always acts as if the server
+// advertised roster versioning since the test sessions do not negotiate any
+// stream features.
+func (c *Cache) SyncVersioned(ctx context.Context, s *xmpp.Session) error {
+	return c.sync(ctx, s, true)
+}
@@ -12,6 +12,7 @@ import (
 
 	"mellium.im/xmlstream"
 	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/attr"
 	"mellium.im/xmpp/jid"
 	"mellium.im/xmpp/mux"
 	"mellium.im/xmpp/stanza"
@@ -31,15 +32,37 @@ func Handle(h Handler) mux.Option {
 // Handler responds to roster pushes.
 type Handler struct {
 	Push func(Item) error
+
+	// Cache, if not nil, is updated with each roster push before Push is
+	// called.
+	Cache *Cache
 }
 
 // HandleIQ responds to roster push IQs.
+//
+// Roster pushes can only be sent by our own account, so any pushes with a
+// "from" attribute are ignored.
+// The session removes the "from" attribute from stanzas sent by our own bare
+// JID, so legitimate pushes never have one by the time they reach the handler.
 func (h Handler) HandleIQ(iq stanza.IQ, t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	if !iq.From.Equal(jid.JID{}) {
+		return nil
+	}
 	item := Item{}
 	err := xml.NewTokenDecoder(t).Decode(&item)
 	if err != nil {
 		return err
 	}
+	if h.Cache != nil {
+		_, ver := attr.Get(start.Attr, "ver")
+		err = h.Cache.push(ver, item)
+		if err != nil {
+			return err
+		}
+	}
+	if h.Push == nil {
+		return nil
+	}
 	return h.Push(item)
 }
 
@@ -204,6 +227,7 @@ type Item struct {
 	JID          jid.JID  `xml:"jid,attr,omitempty"`
 	Name         string   `xml:"name,attr,omitempty"`
 	Subscription string   `xml:"subscription,attr,omitempty"`
+	Ask          string   `xml:"ask,attr,omitempty"`
 	Group        []string `xml:"group,omitempty"`
 }
 
@@ -229,6 +253,9 @@ func (item Item) TokenReader() xml.TokenReader {
 	if item.Subscription != "" {
 		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "subscription"}, Value: item.Subscription})
 	}
+	if item.Ask != "" {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "ask"}, Value: item.Ask})
+	}
 
 	return xmlstream.Wrap(
 		xmlstream.MultiReader(group...),
@@ -0,0 +1,436 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package roster
+
+import (
+	"encoding/xml"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// HandleService returns an option that registers a Service for roster get and
+// set requests.
+func HandleService(s *Service) mux.Option {
+	return func(m *mux.ServeMux) {
+		name := xml.Name{Space: NS, Local: "query"}
+		mux.IQ(stanza.GetIQ, name, s)(m)
+		mux.IQ(stanza.SetIQ, name, s)(m)
+	}
+}
+
+// Service manages rosters on behalf of the users of a server.
+//
+// It answers roster requests from clients, sends roster pushes to every
+// resource that has requested the roster when it changes, and applies the
+// subscription state transitions from RFC 6121 when the server passes it
+// subscription related presence using Inbound and Outbound.
+//
+// IQs handled by the service must have a "from" attribute set to the full JID
+// of the users resource.
+// Roster pushes and presence generated by the service, including those
+// addressed to resources other than the sender of the stanza being handled, are
+// written to the handlers TokenReadEncoder or the provided TokenWriter, so the
+// server must route them based on their "to" attribute.
+// If the server advertises Versioning the version sent by clients is compared
+// to the one returned by the Storage and the roster is only sent if it has
+// changed.
+//
+// The Storage field must be set before the Service is used.
+type Service struct {
+	Storage Storage
+
+	// ErrorHandler, if not nil, is called with any error returned by the
+	// Storage.
+	// The request that caused the error is answered with an internal server
+	// error and the session is not affected.
+	ErrorHandler func(error)
+
+	m          sync.Mutex
+	interested map[string]map[string]jid.JID
+}
+
+// HandleIQ satisfies mux.IQHandler.
+// it is used by the multiplexer and normally does not need to be called by the
+// user.
+func (s *Service) HandleIQ(iq stanza.IQ, r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	if iq.From.Equal(jid.JID{}) {
+		_, err := xmlstream.Copy(r, iq.Error(stanza.Error{
+			Type:      stanza.Modify,
+			Condition: stanza.BadRequest,
+		}))
+		return err
+	}
+	// Users may only access their own roster.
+	if !iq.To.Equal(jid.JID{}) && !iq.To.Equal(iq.From.Bare()) {
+		_, err := xmlstream.Copy(r, iq.Error(stanza.Error{
+			Type:      stanza.Auth,
+			Condition: stanza.Forbidden,
+		}))
+		return err
+	}
+
+	switch iq.Type {
+	case stanza.GetIQ:
+		return s.get(iq, r, start)
+	case stanza.SetIQ:
+		return s.set(iq, r, start)
+	}
+	return nil
+}
+
+func (s *Service) get(iq stanza.IQ, r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	user := iq.From.Bare()
+	s.m.Lock()
+	if s.interested == nil {
+		s.interested = make(map[string]map[string]jid.JID)
+	}
+	if s.interested[user.String()] == nil {
+		s.interested[user.String()] = make(map[string]jid.JID)
+	}
+	s.interested[user.String()][iq.From.String()] = iq.From
+	ver, items, err := s.Storage.Roster(user)
+	s.m.Unlock()
+	if err != nil {
+		return s.storageError(iq, r, err)
+	}
+
+	// If the client supports versioning and its copy of the roster is current it
+	// is sent an empty result.
+	idx, clientVer := attr.Get(start.Attr, "ver")
+	versioning := idx != -1
+	if versioning && clientVer != "" && clientVer == ver {
+		_, err = xmlstream.Copy(r, iq.Result(nil))
+		return err
+	}
+	q := IQ{}
+	if versioning {
+		q.Query.Ver = ver
+	}
+	q.Query.Item = items
+	_, err = xmlstream.Copy(r, iq.Result(q.payload()))
+	return err
+}
+
+func (s *Service) set(iq stanza.IQ, r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	query := struct {
+		Item []Item `xml:"item"`
+	}{}
+	err := xml.NewTokenDecoder(xmlstream.MultiReader(xmlstream.Token(*start), r)).Decode(&query)
+	if err != nil {
+		return err
+	}
+	if stanzaErr, ok := checkSet(query.Item); !ok {
+		_, err = xmlstream.Copy(r, iq.Error(stanzaErr))
+		return err
+	}
+
+	user := iq.From.Bare()
+	item := query.Item[0]
+	item.JID = item.JID.Bare()
+	var out []xml.TokenReader
+	s.m.Lock()
+	old, ok, err := s.Storage.Item(user, item.JID)
+	if err != nil {
+		s.m.Unlock()
+		return s.storageError(iq, r, err)
+	}
+	if item.Subscription == "remove" {
+		if !ok {
+			s.m.Unlock()
+			_, err = xmlstream.Copy(r, iq.Error(stanza.Error{
+				Type:      stanza.Cancel,
+				Condition: stanza.ItemNotFound,
+			}))
+			return err
+		}
+		out, err = s.remove(user, old)
+	} else {
+		// Clients cannot change the subscription state directly.
+		item.Subscription = old.Subscription
+		item.Ask = old.Ask
+		if !ok {
+			item.Subscription = "none"
+		}
+		var ver string
+		ver, err = s.Storage.Set(user, item)
+		if err == nil {
+			out = s.push(user, ver, item)
+		}
+	}
+	s.m.Unlock()
+	if err != nil {
+		return s.storageError(iq, r, err)
+	}
+
+	_, err = xmlstream.Copy(r, iq.Result(nil))
+	if err != nil {
+		return err
+	}
+	return writeAll(r, out)
+}
+
+// storageError reports err to the ErrorHandler and replies to iq with an error
+// indicating that the request may be retried later.
+// Storage errors are not returned because doing so would end the session.
+func (s *Service) storageError(iq stanza.IQ, w xmlstream.TokenWriter, err error) error {
+	if s.ErrorHandler != nil {
+		s.ErrorHandler(err)
+	}
+	_, err = xmlstream.Copy(w, iq.Error(stanza.Error{
+		Type:      stanza.Wait,
+		Condition: stanza.InternalServerError,
+	}))
+	return err
+}
+
+// checkSet returns the error to reply with if the items in a roster set are
+// invalid.
+func checkSet(items []Item) (stanza.Error, bool) {
+	if len(items) != 1 || items[0].JID.Equal(jid.JID{}) {
+		return stanza.Error{Type: stanza.Modify, Condition: stanza.BadRequest}, false
+	}
+	seen := make(map[string]struct{}, len(items[0].Group))
+	for _, group := range items[0].Group {
+		if group == "" {
+			return stanza.Error{Type: stanza.Modify, Condition: stanza.NotAcceptable}, false
+		}
+		if _, ok := seen[group]; ok {
+			return stanza.Error{Type: stanza.Modify, Condition: stanza.BadRequest}, false
+		}
+		seen[group] = struct{}{}
+	}
+	return stanza.Error{}, true
+}
+
+// remove deletes an item from the users roster and cancels any subscriptions.
+// It must be called with the lock held.
+func (s *Service) remove(user jid.JID, item Item) ([]xml.TokenReader, error) {
+	pending, err := s.Storage.PendingIn(user, item.JID)
+	if err != nil {
+		return nil, err
+	}
+	ver, err := s.Storage.Delete(user, item.JID)
+	if err != nil {
+		return nil, err
+	}
+	if pending {
+		err = s.Storage.SetPendingIn(user, item.JID, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var out []xml.TokenReader
+	to, from := splitSubscription(item.Subscription)
+	if to || item.Ask != "" {
+		out = append(out, stanza.Presence{
+			From: user,
+			To:   item.JID,
+			Type: stanza.UnsubscribePresence,
+		}.Wrap(nil))
+	}
+	if from || pending {
+		out = append(out, stanza.Presence{
+			From: user,
+			To:   item.JID,
+			Type: stanza.UnsubscribedPresence,
+		}.Wrap(nil))
+	}
+	return append(out, s.push(user, ver, Item{JID: item.JID, Subscription: "remove"})...), nil
+}
+
+// push returns roster pushes for every interested resource of the user.
+// It must be called with the lock held.
+func (s *Service) push(user jid.JID, ver string, item Item) []xml.TokenReader {
+	var out []xml.TokenReader
+	for _, res := range s.interested[user.String()] {
+		q := IQ{IQ: stanza.IQ{
+			ID:   attr.RandomID(),
+			To:   res,
+			From: user,
+			Type: stanza.SetIQ,
+		}}
+		q.Query.Ver = ver
+		q.Query.Item = []Item{item}
+		out = append(out, q.TokenReader())
+	}
+	return out
+}
+
+// Forget stops sending roster pushes to j.
+// It should be called when a resource goes offline.
+func (s *Service) Forget(j jid.JID) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	bare := j.Bare().String()
+	delete(s.interested[bare], j.String())
+	if len(s.interested[bare]) == 0 {
+		delete(s.interested, bare)
+	}
+}
+
+// Outbound updates the users roster for a subscription related presence sent
+// by the user (identified by the "from" attribute) to a contact and reports
+// whether the presence should be routed to the contact.
+//
+// The server should stamp the presence with the users bare JID before routing
+// it.
+// Presence that is not subscription related is not affected and should always
+// be routed.
+// Any resulting roster pushes are written to w.
+func (s *Service) Outbound(w xmlstream.TokenWriter, p stanza.Presence) (route bool, err error) {
+	return s.subscription(w, p, p.From.Bare(), p.To.Bare(), true)
+}
+
+// Inbound updates the users roster for a subscription related presence sent by
+// a contact to the user (identified by the "to" attribute) and reports whether
+// the presence should be delivered to the user.
+//
+// Presence that is not subscription related is not affected and should always
+// be delivered.
+// Any resulting roster pushes are written to w, as is an automatic approval if
+// the contact asks to subscribe to the users presence and is already
+// subscribed.
+func (s *Service) Inbound(w xmlstream.TokenWriter, p stanza.Presence) (deliver bool, err error) {
+	return s.subscription(w, p, p.To.Bare(), p.From.Bare(), false)
+}
+
+func (s *Service) subscription(w xmlstream.TokenWriter, p stanza.Presence, user, contact jid.JID, outbound bool) (bool, error) {
+	switch p.Type {
+	case stanza.SubscribePresence, stanza.SubscribedPresence,
+		stanza.UnsubscribePresence, stanza.UnsubscribedPresence:
+	default:
+		return true, nil
+	}
+
+	s.m.Lock()
+	out, ok, err := s.transition(p.Type, user, contact, outbound)
+	s.m.Unlock()
+	if err != nil {
+		return false, err
+	}
+	return ok, writeAll(w, out)
+}
+
+// transition applies the state transitions from RFC 6121 appendix A.
+// It must be called with the lock held.
+func (s *Service) transition(typ stanza.PresenceType, user, contact jid.JID, outbound bool) ([]xml.TokenReader, bool, error) {
+	old, found, err := s.Storage.Item(user, contact)
+	if err != nil {
+		return nil, false, err
+	}
+	pending, err := s.Storage.PendingIn(user, contact)
+	if err != nil {
+		return nil, false, err
+	}
+	if !found {
+		old = Item{JID: contact, Subscription: "none"}
+	}
+	item := old
+	to, from := splitSubscription(item.Subscription)
+	clearPending := false
+	var out []xml.TokenReader
+	var ok bool
+
+	switch {
+	case outbound && typ == stanza.SubscribePresence:
+		if !to {
+			item.Ask = "subscribe"
+		}
+		ok = true
+	case outbound && typ == stanza.SubscribedPresence:
+		// Pre-approving subscription requests is not supported, so approvals are
+		// only routed if there is a request.
+		ok = pending
+		from = from || pending
+		clearPending = pending
+	case outbound && typ == stanza.UnsubscribePresence:
+		to = false
+		item.Ask = ""
+		ok = true
+	case outbound && typ == stanza.UnsubscribedPresence:
+		ok = from || pending
+		from = false
+		clearPending = pending
+	case typ == stanza.SubscribePresence:
+		switch {
+		case from:
+			// The contact is already subscribed so we can approve the request on the
+			// users behalf without bothering them.
+			out = append(out, stanza.Presence{
+				From: user,
+				To:   contact,
+				Type: stanza.SubscribedPresence,
+			}.Wrap(nil))
+		case !pending:
+			err = s.Storage.SetPendingIn(user, contact, true)
+			if err != nil {
+				return nil, false, err
+			}
+			ok = true
+		}
+	case typ == stanza.SubscribedPresence:
+		if item.Ask != "" {
+			to = true
+			item.Ask = ""
+			ok = true
+		}
+	case typ == stanza.UnsubscribePresence:
+		ok = from || pending
+		from = false
+		clearPending = pending
+	case typ == stanza.UnsubscribedPresence:
+		ok = to || item.Ask != ""
+		to = false
+		item.Ask = ""
+	}
+
+	if clearPending {
+		err = s.Storage.SetPendingIn(user, contact, false)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	item.Subscription = joinSubscription(to, from)
+	if itemEqual(old, item) {
+		return out, ok, nil
+	}
+	ver, err := s.Storage.Set(user, item)
+	if err != nil {
+		return nil, false, err
+	}
+	return append(out, s.push(user, ver, item)...), ok, nil
+}
+
+func splitSubscription(sub string) (to, from bool) {
+	return sub == "to" || sub == "both", sub == "from" || sub == "both"
+}
+
+func joinSubscription(to, from bool) string {
+	switch {
+	case to && from:
+		return "both"
+	case to:
+		return "to"
+	case from:
+		return "from"
+	}
+	return "none"
+}
+
+func writeAll(w xmlstream.TokenWriter, out []xml.TokenReader) error {
+	for _, r := range out {
+		_, err := xmlstream.Copy(w, r)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
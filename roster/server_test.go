@@ -0,0 +1,369 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package roster_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/attr"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/roster"
+	"mellium.im/xmpp/stanza"
+)
+
+var _ roster.Storage = (*roster.MemoryStorage)(nil)
+
+// recorder is a TokenWriter that records the type and recipient of every
+// stanza written to it.
+type recorder struct {
+	depth int
+	sent  []string
+}
+
+func (r *recorder) EncodeToken(tok xml.Token) error {
+	switch t := tok.(type) {
+	case xml.StartElement:
+		if r.depth == 0 {
+			_, typ := attr.Get(t.Attr, "type")
+			_, to := attr.Get(t.Attr, "to")
+			r.sent = append(r.sent, t.Name.Local+" "+typ+" "+to)
+		}
+		r.depth++
+	case xml.EndElement:
+		r.depth--
+	}
+	return nil
+}
+
+func TestService(t *testing.T) {
+	user := jid.MustParse("test@example.net")
+	balcony := jid.MustParse("test@example.net/balcony")
+	romeo := jid.MustParse("romeo@example.net")
+	storage := &roster.MemoryStorage{}
+	svc := &roster.Service{Storage: storage}
+	serverMux := mux.New(roster.HandleService(svc))
+
+	changes := make(chan roster.Change, 10)
+	cache := &roster.Cache{
+		Store: &roster.MemoryStore{},
+		Changed: func(c roster.Change) {
+			changes <- c
+		},
+	}
+	s := xmpptest.NewClientServer(
+		xmpptest.ClientHandler(mux.New(roster.Handle(roster.Handler{Cache: cache}))),
+		xmpptest.ServerHandlerFunc(func(r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			// Stamp the stanza with the full JID of the client as the server would.
+			start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "from"}, Value: balcony.String()})
+			return serverMux.HandleXMPP(r, start)
+		}),
+	)
+	next := func() roster.Change {
+		t.Helper()
+		select {
+		case c := <-changes:
+			return c
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for roster push")
+		}
+		return roster.Change{}
+	}
+
+	ctx := context.Background()
+	err := cache.SyncVersioned(ctx, s.Client)
+	if err != nil {
+		t.Fatalf("error fetching empty roster: %v", err)
+	}
+	checkStore(t, cache.Store, "0", nil)
+
+	// Clients can add items but cannot set the subscription state.
+	err = roster.Set(ctx, s.Client, roster.Item{
+		JID:          romeo,
+		Name:         "Romeo",
+		Subscription: "both",
+		Group:        []string{"Friends"},
+	})
+	if err != nil {
+		t.Fatalf("error setting item: %v", err)
+	}
+	added := roster.Item{JID: romeo, Name: "Romeo", Subscription: "none", Group: []string{"Friends"}}
+	if c := next(); !reflect.DeepEqual(c, roster.Change{Type: roster.ItemAdded, Item: added}) {
+		t.Errorf("wrong change: want=%+v, got=%+v", added, c)
+	}
+	checkStore(t, cache.Store, "1", []roster.Item{added})
+
+	// A current version results in an empty response.
+	err = cache.SyncVersioned(ctx, s.Client)
+	if err != nil {
+		t.Fatalf("error syncing current roster: %v", err)
+	}
+	select {
+	case c := <-changes:
+		t.Errorf("unexpected change: %+v", c)
+	default:
+	}
+
+	// Subscription changes are pushed to interested resources.
+	w := &recorder{}
+	route, err := svc.Outbound(w, stanza.Presence{From: balcony, To: romeo, Type: stanza.SubscribePresence})
+	if err != nil {
+		t.Fatalf("error handling outbound subscribe: %v", err)
+	}
+	if !route {
+		t.Errorf("expected outbound subscribe to be routed")
+	}
+	if want := []string{"iq set " + balcony.String()}; !reflect.DeepEqual(w.sent, want) {
+		t.Errorf("wrong stanzas sent: want=%q, got=%q", want, w.sent)
+	}
+
+	// Clients that missed a push get the updated roster when they sync.
+	err = cache.SyncVersioned(ctx, s.Client)
+	if err != nil {
+		t.Fatalf("error syncing updated roster: %v", err)
+	}
+	asked := added
+	asked.Ask = "subscribe"
+	if c := next(); !reflect.DeepEqual(c, roster.Change{Type: roster.ItemUpdated, Item: asked}) {
+		t.Errorf("wrong change: want=%+v, got=%+v", asked, c)
+	}
+	checkStore(t, cache.Store, "2", []roster.Item{asked})
+
+	// Invalid requests are rejected.
+	for i, items := range [][]roster.Item{
+		0: {},
+		1: {{JID: romeo}, {JID: romeo}},
+		2: {{JID: romeo, Group: []string{"Friends", "Friends"}}},
+	} {
+		err = s.Client.UnmarshalIQElement(ctx, setPayload(items), stanza.IQ{Type: stanza.SetIQ}, nil)
+		stanzaErr := stanza.Error{}
+		if !errors.As(err, &stanzaErr) || stanzaErr.Condition != stanza.BadRequest {
+			t.Errorf("wrong error for invalid set %d: %v", i, err)
+		}
+	}
+
+	err = roster.Delete(ctx, s.Client, romeo)
+	if err != nil {
+		t.Fatalf("error removing item: %v", err)
+	}
+	if c := next(); c.Type != roster.ItemRemoved || !c.Item.JID.Equal(romeo) {
+		t.Errorf("wrong change after removing item: %+v", c)
+	}
+	ver, items, err := storage.Roster(user)
+	if err != nil {
+		t.Fatalf("error getting roster: %v", err)
+	}
+	if ver != "3" || len(items) != 0 {
+		t.Errorf("wrong roster after removing item: ver=%q, items=%+v", ver, items)
+	}
+
+	// Removing an item that does not exist is an error.
+	err = s.Client.UnmarshalIQElement(ctx, setPayload([]roster.Item{{JID: romeo, Subscription: "remove"}}), stanza.IQ{Type: stanza.SetIQ}, nil)
+	stanzaErr := stanza.Error{}
+	if !errors.As(err, &stanzaErr) || stanzaErr.Condition != stanza.ItemNotFound {
+		t.Errorf("wrong error removing missing item: %v", err)
+	}
+}
+
+// failingStorage is a Storage that fails to read or write rosters.
+type failingStorage struct {
+	roster.MemoryStorage
+}
+
+var errStorage = errors.New("storage unavailable")
+
+func (*failingStorage) Roster(jid.JID) (string, []roster.Item, error) {
+	return "", nil, errStorage
+}
+
+func (*failingStorage) Set(jid.JID, roster.Item) (string, error) {
+	return "", errStorage
+}
+
+func TestServiceStorageError(t *testing.T) {
+	balcony := jid.MustParse("test@example.net/balcony")
+	storageErrs := make(chan error, 10)
+	serverMux := mux.New(roster.HandleService(&roster.Service{
+		Storage: &failingStorage{},
+		ErrorHandler: func(err error) {
+			storageErrs <- err
+		},
+	}))
+	s := xmpptest.NewClientServer(
+		xmpptest.ServerHandlerFunc(func(r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+			start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "from"}, Value: balcony.String()})
+			return serverMux.HandleXMPP(r, start)
+		}),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i, req := range []struct {
+		typ     stanza.IQType
+		payload xml.TokenReader
+	}{
+		0: {typ: stanza.GetIQ, payload: setPayload(nil)},
+		1: {typ: stanza.SetIQ, payload: setPayload([]roster.Item{{JID: jid.MustParse("romeo@example.net")}})},
+	} {
+		err := s.Client.UnmarshalIQElement(ctx, req.payload, stanza.IQ{Type: req.typ}, nil)
+		stanzaErr := stanza.Error{}
+		if !errors.As(err, &stanzaErr) || stanzaErr.Type != stanza.Wait || stanzaErr.Condition != stanza.InternalServerError {
+			t.Errorf("wrong error %d: %v", i, err)
+		}
+		if err := <-storageErrs; !errors.Is(err, errStorage) {
+			t.Errorf("wrong storage error reported %d: %v", i, err)
+		}
+	}
+
+	// The session is still usable after the storage errors.
+	err := s.Client.UnmarshalIQElement(ctx, setPayload([]roster.Item{{JID: jid.MustParse("romeo@example.net"), Subscription: "remove"}}), stanza.IQ{Type: stanza.SetIQ}, nil)
+	stanzaErr := stanza.Error{}
+	if !errors.As(err, &stanzaErr) || stanzaErr.Condition != stanza.ItemNotFound {
+		t.Errorf("wrong error after storage errors: %v", err)
+	}
+}
+
+func setPayload(items []roster.Item) xml.TokenReader {
+	var inner []xml.TokenReader
+	for _, item := range items {
+		inner = append(inner, item.TokenReader())
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Space: roster.NS, Local: "query"}},
+	)
+}
+
+func TestServiceSubscriptions(t *testing.T) {
+	juliet := jid.MustParse("juliet@example.com")
+	romeo := jid.MustParse("romeo@example.net")
+	storage := &roster.MemoryStorage{}
+	svc := &roster.Service{Storage: storage}
+
+	// Romeo asks to subscribe to Juliet's presence and she approves, then he
+	// changes his mind.
+	for i, tc := range []struct {
+		outbound bool
+		p        stanza.Presence
+		ok       bool
+		sent     []string
+
+		// The expected state of the roster of the recipient of the presence for
+		// inbound presence, or of the sender for outbound presence.
+		sub     string
+		ask     string
+		pending bool
+	}{
+		0: {
+			outbound: true,
+			p:        stanza.Presence{From: romeo, To: juliet, Type: stanza.SubscribePresence},
+			ok:       true,
+			sub:      "none",
+			ask:      "subscribe",
+		},
+		1: {
+			p:       stanza.Presence{From: romeo, To: juliet, Type: stanza.SubscribePresence},
+			ok:      true,
+			pending: true,
+		},
+		// Requests that are already pending are not delivered again.
+		2: {
+			p:       stanza.Presence{From: romeo, To: juliet, Type: stanza.SubscribePresence},
+			pending: true,
+		},
+		3: {
+			outbound: true,
+			p:        stanza.Presence{From: juliet, To: romeo, Type: stanza.SubscribedPresence},
+			ok:       true,
+			sub:      "from",
+		},
+		4: {
+			p:   stanza.Presence{From: juliet, To: romeo, Type: stanza.SubscribedPresence},
+			ok:  true,
+			sub: "to",
+		},
+		// Approvals that were not asked for are ignored.
+		5: {
+			p:   stanza.Presence{From: juliet, To: romeo, Type: stanza.SubscribedPresence},
+			sub: "to",
+		},
+		6: {
+			outbound: true,
+			p:        stanza.Presence{From: juliet, To: romeo, Type: stanza.SubscribedPresence},
+			sub:      "from",
+		},
+		// Requests from contacts that are already subscribed are approved
+		// automatically.
+		7: {
+			p:    stanza.Presence{From: romeo, To: juliet, Type: stanza.SubscribePresence},
+			sent: []string{"presence subscribed " + romeo.String()},
+			sub:  "from",
+		},
+		8: {
+			outbound: true,
+			p:        stanza.Presence{From: romeo, To: juliet, Type: stanza.UnsubscribePresence},
+			ok:       true,
+			sub:      "none",
+		},
+		9: {
+			p:   stanza.Presence{From: romeo, To: juliet, Type: stanza.UnsubscribePresence},
+			ok:  true,
+			sub: "none",
+		},
+		10: {
+			p:   stanza.Presence{From: romeo, To: juliet, Type: stanza.UnsubscribePresence},
+			sub: "none",
+		},
+		// Other presence is not affected.
+		11: {
+			p:   stanza.Presence{From: romeo, To: juliet},
+			ok:  true,
+			sub: "none",
+		},
+	} {
+		w := &recorder{}
+		var ok bool
+		var err error
+		user, contact := tc.p.To, tc.p.From
+		if tc.outbound {
+			user, contact = contact, user
+			ok, err = svc.Outbound(w, tc.p)
+		} else {
+			ok, err = svc.Inbound(w, tc.p)
+		}
+		if err != nil {
+			t.Fatalf("error handling presence %d: %v", i, err)
+		}
+		if ok != tc.ok {
+			t.Errorf("wrong result for presence %d: want=%t, got=%t", i, tc.ok, ok)
+		}
+		if len(w.sent) != 0 || len(tc.sent) != 0 {
+			if !reflect.DeepEqual(w.sent, tc.sent) {
+				t.Errorf("wrong stanzas sent for presence %d: want=%q, got=%q", i, tc.sent, w.sent)
+			}
+		}
+		item, found, err := storage.Item(user, contact)
+		if err != nil {
+			t.Fatalf("error getting item %d: %v", i, err)
+		}
+		if found != (tc.sub != "") || item.Subscription != tc.sub || item.Ask != tc.ask {
+			t.Errorf("wrong item %d: want=%q/%q, got=%q/%q (found: %t)", i, tc.sub, tc.ask, item.Subscription, item.Ask, found)
+		}
+		pending, err := storage.PendingIn(user, contact)
+		if err != nil {
+			t.Fatalf("error getting pending state %d: %v", i, err)
+		}
+		if pending != tc.pending {
+			t.Errorf("wrong pending state %d: want=%t, got=%t", i, tc.pending, pending)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package roster
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+
+	"mellium.im/xmpp/jid"
+)
+
+// Storage holds the rosters of every user served by a Service.
+//
+// Users are identified by their bare JID.
+// Every change to a users roster must result in a new version string that has
+// not been used for that user before.
+//
+// Implementations must be safe for concurrent use by multiple goroutines.
+type Storage interface {
+	// Roster returns the current version and all items in the users roster.
+	Roster(user jid.JID) (ver string, items []Item, err error)
+
+	// Item returns the item with the provided JID from the users roster and
+	// whether it was found.
+	Item(user, contact jid.JID) (Item, bool, error)
+
+	// Set adds an item to the users roster or replaces an existing item with the
+	// same JID and returns the new roster version.
+	Set(user jid.JID, item Item) (ver string, err error)
+
+	// Delete removes the item with the provided JID from the users roster, if
+	// any, and returns the new roster version.
+	Delete(user, contact jid.JID) (ver string, err error)
+
+	// PendingIn reports whether the contact has asked to subscribe to the users
+	// presence and the user has not yet approved or denied the request.
+	// Pending requests are not part of the roster and may exist for contacts that
+	// are not in the roster at all.
+	PendingIn(user, contact jid.JID) (bool, error)
+
+	// SetPendingIn records or clears a pending subscription request from the
+	// contact.
+	SetPendingIn(user, contact jid.JID, pending bool) error
+}
+
+// MemoryStorage is a Storage that keeps rosters in memory.
+// The zero value is an empty storage ready for use.
+type MemoryStorage struct {
+	m     sync.Mutex
+	users map[string]*memoryRoster
+}
+
+type memoryRoster struct {
+	ver     uint64
+	items   map[string]Item
+	pending map[string]struct{}
+}
+
+// user returns the roster for the bare form of j, creating it if create is set.
+// It must be called with the lock held.
+func (s *MemoryStorage) user(j jid.JID, create bool) *memoryRoster {
+	key := j.Bare().String()
+	r, ok := s.users[key]
+	if ok || !create {
+		return r
+	}
+	if s.users == nil {
+		s.users = make(map[string]*memoryRoster)
+	}
+	r = &memoryRoster{
+		items:   make(map[string]Item),
+		pending: make(map[string]struct{}),
+	}
+	s.users[key] = r
+	return r
+}
+
+// Roster satisfies the Storage interface.
+// Items are returned sorted by JID.
+func (s *MemoryStorage) Roster(user jid.JID) (string, []Item, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	r := s.user(user, false)
+	if r == nil {
+		return "0", nil, nil
+	}
+	items := make([]Item, 0, len(r.items))
+	for _, item := range r.items {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].JID.String() < items[j].JID.String()
+	})
+	return strconv.FormatUint(r.ver, 10), items, nil
+}
+
+// Item satisfies the Storage interface.
+func (s *MemoryStorage) Item(user, contact jid.JID) (Item, bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	r := s.user(user, false)
+	if r == nil {
+		return Item{}, false, nil
+	}
+	item, ok := r.items[contact.Bare().String()]
+	return item, ok, nil
+}
+
+// Set satisfies the Storage interface.
+func (s *MemoryStorage) Set(user jid.JID, item Item) (string, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	r := s.user(user, true)
+	r.items[item.JID.Bare().String()] = item
+	r.ver++
+	return strconv.FormatUint(r.ver, 10), nil
+}
+
+// Delete satisfies the Storage interface.
+func (s *MemoryStorage) Delete(user, contact jid.JID) (string, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	r := s.user(user, true)
+	delete(r.items, contact.Bare().String())
+	r.ver++
+	return strconv.FormatUint(r.ver, 10), nil
+}
+
+// PendingIn satisfies the Storage interface.
+func (s *MemoryStorage) PendingIn(user, contact jid.JID) (bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	r := s.user(user, false)
+	if r == nil {
+		return false, nil
+	}
+	_, ok := r.pending[contact.Bare().String()]
+	return ok, nil
+}
+
+// SetPendingIn satisfies the Storage interface.
+func (s *MemoryStorage) SetPendingIn(user, contact jid.JID, pending bool) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	r := s.user(user, pending)
+	switch {
+	case r == nil:
+	case pending:
+		r.pending[contact.Bare().String()] = struct{}{}
+	default:
+		delete(r.pending, contact.Bare().String())
+	}
+	return nil
+}
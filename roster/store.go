@@ -0,0 +1,280 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package roster
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+)
+
+// Store is a local copy of the roster that lets a Cache avoid downloading the
+// entire roster every time a session is established.
+//
+// Implementations must be safe for concurrent use by multiple goroutines.
+type Store interface {
+	// Version returns the roster version that was last stored, or the empty
+	// string if nothing has been stored yet.
+	Version() (string, error)
+
+	// Items returns every item in the store.
+	Items() ([]Item, error)
+
+	// Item returns the item with the provided JID and whether it was found.
+	Item(j jid.JID) (Item, bool, error)
+
+	// Replace removes all items from the store and replaces them with items.
+	Replace(ver string, items []Item) error
+
+	// Set adds an item or replaces an existing item with the same JID.
+	Set(ver string, item Item) error
+
+	// Delete removes the item with the provided JID, if any.
+	Delete(ver string, j jid.JID) error
+}
+
+// MemoryStore is a Store that keeps the roster in memory.
+// The zero value is an empty store ready for use.
+type MemoryStore struct {
+	m     sync.Mutex
+	ver   string
+	items map[string]Item
+}
+
+// Version satisfies the Store interface.
+func (s *MemoryStore) Version() (string, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.ver, nil
+}
+
+// Items satisfies the Store interface.
+// Items are returned sorted by JID.
+func (s *MemoryStore) Items() ([]Item, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	items := make([]Item, 0, len(s.items))
+	for _, item := range s.items {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].JID.String() < items[j].JID.String()
+	})
+	return items, nil
+}
+
+// Item satisfies the Store interface.
+func (s *MemoryStore) Item(j jid.JID) (Item, bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	item, ok := s.items[j.String()]
+	return item, ok, nil
+}
+
+// Replace satisfies the Store interface.
+func (s *MemoryStore) Replace(ver string, items []Item) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.ver = ver
+	s.items = make(map[string]Item, len(items))
+	for _, item := range items {
+		s.items[item.JID.String()] = item
+	}
+	return nil
+}
+
+// Set satisfies the Store interface.
+func (s *MemoryStore) Set(ver string, item Item) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.items == nil {
+		s.items = make(map[string]Item)
+	}
+	s.ver = ver
+	s.items[item.JID.String()] = item
+	return nil
+}
+
+// Delete satisfies the Store interface.
+func (s *MemoryStore) Delete(ver string, j jid.JID) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.ver = ver
+	delete(s.items, j.String())
+	return nil
+}
+
+// FileStore is a Store that keeps the roster in memory and writes it to a file
+// every time it changes.
+//
+// Every change, including each roster push, rewrites the entire file.
+// This is cheap for rosters of a typical size but for very large rosters that
+// change often a Store backed by a database that can update individual items
+// may be a better choice.
+type FileStore struct {
+	path   string
+	m      sync.Mutex
+	loaded bool
+	mem    MemoryStore
+}
+
+// NewFileStore returns a store that persists the roster to the file at path.
+// The file is read the first time the store is used and is created the first
+// time the store is changed if it does not already exist.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Version satisfies the Store interface.
+func (s *FileStore) Version() (string, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	err := s.load()
+	if err != nil {
+		return "", err
+	}
+	return s.mem.Version()
+}
+
+// Items satisfies the Store interface.
+// Items are returned sorted by JID.
+func (s *FileStore) Items() ([]Item, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return s.mem.Items()
+}
+
+// Item satisfies the Store interface.
+func (s *FileStore) Item(j jid.JID) (Item, bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	err := s.load()
+	if err != nil {
+		return Item{}, false, err
+	}
+	return s.mem.Item(j)
+}
+
+// Replace satisfies the Store interface.
+func (s *FileStore) Replace(ver string, items []Item) error {
+	return s.update(func() error {
+		return s.mem.Replace(ver, items)
+	})
+}
+
+// Set satisfies the Store interface.
+func (s *FileStore) Set(ver string, item Item) error {
+	return s.update(func() error {
+		return s.mem.Set(ver, item)
+	})
+}
+
+// Delete satisfies the Store interface.
+func (s *FileStore) Delete(ver string, j jid.JID) error {
+	return s.update(func() error {
+		return s.mem.Delete(ver, j)
+	})
+}
+
+func (s *FileStore) update(f func() error) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	err := s.load()
+	if err != nil {
+		return err
+	}
+	err = f()
+	if err != nil {
+		return err
+	}
+	return s.save()
+}
+
+// load reads the roster from the file if it has not already been read.
+// It must be called with the lock held.
+func (s *FileStore) load() error {
+	if s.loaded {
+		return nil
+	}
+	f, err := os.Open(s.path)
+	switch {
+	case os.IsNotExist(err):
+		s.loaded = true
+		return nil
+	case err != nil:
+		return err
+	}
+	/* #nosec */
+	defer f.Close()
+
+	query := struct {
+		XMLName xml.Name `xml:"jabber:iq:roster query"`
+		Ver     string   `xml:"ver,attr"`
+		Item    []Item   `xml:"item"`
+	}{}
+	err = xml.NewDecoder(f).Decode(&query)
+	if err != nil {
+		return err
+	}
+	err = s.mem.Replace(query.Ver, query.Item)
+	if err != nil {
+		return err
+	}
+	s.loaded = true
+	return nil
+}
+
+// save writes the roster to a temporary file and then moves it into place so
+// that the file is never left partially written.
+// It must be called with the lock held.
+func (s *FileStore) save() (e error) {
+	iq := IQ{}
+	var err error
+	iq.Query.Ver, err = s.mem.Version()
+	if err != nil {
+		return err
+	}
+	iq.Query.Item, err = s.mem.Items()
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e != nil {
+			/* #nosec */
+			f.Close()
+			/* #nosec */
+			os.Remove(f.Name())
+		}
+	}()
+	enc := xml.NewEncoder(f)
+	_, err = xmlstream.Copy(enc, iq.payload())
+	if err != nil {
+		return err
+	}
+	err = enc.Flush()
+	if err != nil {
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
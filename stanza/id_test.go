@@ -133,3 +133,68 @@ func TestAddID(t *testing.T) {
 		})
 	}
 }
+
+func TestUnmarshalIDs(t *testing.T) {
+	by := jid.MustParse("test@example.net")
+	r := stanza.AddOriginID(stanza.AddID(by)(xml.NewDecoder(strings.NewReader(`<message xmlns="jabber:client"></message>`))))
+	msg := struct {
+		stanza.Message
+		OriginID stanza.OriginID `xml:"urn:xmpp:sid:0 origin-id"`
+		IDs      []stanza.ID     `xml:"urn:xmpp:sid:0 stanza-id"`
+	}{}
+	err := xml.NewTokenDecoder(r).Decode(&msg)
+	if err != nil {
+		t.Fatalf("error decoding message: %v", err)
+	}
+	if msg.OriginID.ID == "" {
+		t.Errorf("expected origin ID to be decoded")
+	}
+	if len(msg.IDs) != 1 || msg.IDs[0].ID == "" || !msg.IDs[0].By.Equal(by) {
+		t.Errorf("wrong stanza IDs: %+v", msg.IDs)
+	}
+}
+
+var trustedIDTestCases = [...]struct {
+	ids     []stanza.ID
+	trusted []jid.JID
+	id      string
+	ok      bool
+}{
+	0: {},
+	1: {
+		ids:     []stanza.ID{{ID: "1", By: jid.MustParse("juliet@example.net")}},
+		trusted: []jid.JID{jid.MustParse("romeo@example.net")},
+	},
+	2: {
+		ids: []stanza.ID{
+			{ID: "1", By: jid.MustParse("juliet@example.net")},
+			{ID: "2", By: jid.MustParse("romeo@example.net")},
+		},
+		trusted: []jid.JID{jid.MustParse("romeo@example.net/orchard")},
+		id:      "2",
+		ok:      true,
+	},
+	3: {
+		ids: []stanza.ID{
+			{ID: "1", By: jid.MustParse("room@muc.example.net")},
+			{ID: "2", By: jid.MustParse("romeo@example.net")},
+		},
+		trusted: []jid.JID{jid.MustParse("romeo@example.net"), jid.MustParse("room@muc.example.net")},
+		id:      "1",
+		ok:      true,
+	},
+}
+
+func TestTrustedID(t *testing.T) {
+	for i, tc := range trustedIDTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			id, ok := stanza.TrustedID(tc.ids, tc.trusted...)
+			if ok != tc.ok {
+				t.Errorf("wrong result: want=%t, got=%t", tc.ok, ok)
+			}
+			if id.ID != tc.id {
+				t.Errorf("wrong ID: want=%q, got=%q", tc.id, id.ID)
+			}
+		})
+	}
+}
@@ -47,6 +47,26 @@ func (id ID) WriteXML(w xmlstream.TokenWriter) (int, error) {
 	return xmlstream.Copy(w, id.TokenReader())
 }
 
+// TrustedID returns the first stanza ID in ids that was assigned by one of the
+// trusted entities.
+// Stanza IDs can be added by anyone along the path of a stanza, including the
+// original sender, so only IDs assigned by entities that we trust to have
+// checked for and removed spoofed IDs should be used.
+// This is normally our own account (for messages stored in our archive) or the
+// channel (for group chat messages).
+// The "by" attribute of each ID is compared to the bare JID of the trusted
+// entities.
+func TrustedID(ids []ID, trusted ...jid.JID) (ID, bool) {
+	for _, id := range ids {
+		for _, j := range trusted {
+			if id.By.Equal(j.Bare()) {
+				return id, true
+			}
+		}
+	}
+	return ID{}, false
+}
+
 // OriginID is a unique and stable stanza ID generated by an originating entity
 // that may want to hide its identity.
 type OriginID struct {
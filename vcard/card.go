@@ -0,0 +1,271 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package vcard
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"strings"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/internal/pubsub"
+	"mellium.im/xmpp/jid"
+)
+
+// Card is a profile in the vCard4 XML format.
+type Card struct {
+	FN       string
+	Name     Name
+	Nickname string
+	Birthday string
+	Org      Org
+	Title    string
+	Role     string
+	Email    []Email
+	Tel      []Tel
+	JID      jid.JID
+	URL      string
+	Note     string
+	Photo    Photo
+}
+
+// property returns a vCard4 property containing a single value of the provided
+// value type.
+func property(local, valueType, value string) xml.TokenReader {
+	if value == "" {
+		return nil
+	}
+	return xmlstream.Wrap(
+		optionalString(value, valueType),
+		xml.StartElement{Name: xml.Name{Local: local}},
+	)
+}
+
+// typedProperty is like property except that it also includes type
+// parameters.
+func typedProperty(local, valueType, value string, types []string) xml.TokenReader {
+	var params, typ []xml.TokenReader
+	for _, t := range types {
+		if t == "pref" {
+			params = append(params, xmlstream.Wrap(
+				optionalString("1", "integer"),
+				xml.StartElement{Name: xml.Name{Local: "pref"}},
+			))
+			continue
+		}
+		typ = append(typ, optionalString(t, "text"))
+	}
+	if len(typ) > 0 {
+		params = append(params, xmlstream.Wrap(
+			xmlstream.MultiReader(typ...),
+			xml.StartElement{Name: xml.Name{Local: "type"}},
+		))
+	}
+	var parameters xml.TokenReader
+	if len(params) > 0 {
+		parameters = xmlstream.Wrap(
+			xmlstream.MultiReader(params...),
+			xml.StartElement{Name: xml.Name{Local: "parameters"}},
+		)
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(parameters, optionalString(value, valueType)),
+		xml.StartElement{Name: xml.Name{Local: local}},
+	)
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (c Card) TokenReader() xml.TokenReader {
+	var inner []xml.TokenReader
+	inner = append(inner, property("fn", "text", c.FN))
+	if c.Name != (Name{}) {
+		inner = append(inner, xmlstream.Wrap(
+			xmlstream.MultiReader(
+				optionalString(c.Name.Family, "surname"),
+				optionalString(c.Name.Given, "given"),
+				optionalString(c.Name.Middle, "additional"),
+				optionalString(c.Name.Prefix, "prefix"),
+				optionalString(c.Name.Suffix, "suffix"),
+			),
+			xml.StartElement{Name: xml.Name{Local: "n"}},
+		))
+	}
+	inner = append(inner,
+		property("nickname", "text", c.Nickname),
+		property("bday", "date", c.Birthday),
+	)
+	if c.Org.Name != "" || len(c.Org.Units) > 0 {
+		org := []xml.TokenReader{optionalString(c.Org.Name, "text")}
+		for _, unit := range c.Org.Units {
+			org = append(org, optionalString(unit, "text"))
+		}
+		inner = append(inner, xmlstream.Wrap(
+			xmlstream.MultiReader(org...),
+			xml.StartElement{Name: xml.Name{Local: "org"}},
+		))
+	}
+	inner = append(inner,
+		property("title", "text", c.Title),
+		property("role", "text", c.Role),
+	)
+	for _, email := range c.Email {
+		inner = append(inner, typedProperty("email", "text", email.Address, email.Types))
+	}
+	for _, tel := range c.Tel {
+		valueType := "text"
+		if strings.HasPrefix(tel.Number, "tel:") {
+			valueType = "uri"
+		}
+		inner = append(inner, typedProperty("tel", valueType, tel.Number, tel.Types))
+	}
+	if !c.JID.Equal(jid.JID{}) {
+		inner = append(inner, property("impp", "uri", "xmpp:"+c.JID.String()))
+	}
+	inner = append(inner, property("url", "uri", c.URL))
+	switch {
+	case len(c.Photo.Data) > 0:
+		inner = append(inner, property("photo", "uri", "data:"+c.Photo.Type+";base64,"+base64.StdEncoding.EncodeToString(c.Photo.Data)))
+	case c.Photo.URL != "":
+		inner = append(inner, property("photo", "uri", c.Photo.URL))
+	}
+	inner = append(inner, property("note", "text", c.Note))
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "vcard"}},
+	)
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (c Card) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, c.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (c Card) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	_, err := c.WriteXML(e)
+	return err
+}
+
+type typed struct {
+	Types []string `xml:"parameters>type>text"`
+	Pref  string   `xml:"parameters>pref>integer"`
+	Text  string   `xml:"text"`
+	URI   string   `xml:"uri"`
+}
+
+func (t typed) value() string {
+	if t.URI != "" {
+		return t.URI
+	}
+	return t.Text
+}
+
+func (t typed) types() []string {
+	types := t.Types
+	if t.Pref != "" && !hasType(types, "pref") {
+		types = append(types, "pref")
+	}
+	return types
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (c *Card) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	v := struct {
+		FN string `xml:"fn>text"`
+		N  struct {
+			Family string `xml:"surname"`
+			Given  string `xml:"given"`
+			Middle string `xml:"additional"`
+			Prefix string `xml:"prefix"`
+			Suffix string `xml:"suffix"`
+		} `xml:"n"`
+		Nickname string   `xml:"nickname>text"`
+		BDay     string   `xml:"bday>date"`
+		Org      []string `xml:"org>text"`
+		Title    string   `xml:"title>text"`
+		Role     string   `xml:"role>text"`
+		Email    []typed  `xml:"email"`
+		Tel      []typed  `xml:"tel"`
+		IMPP     []string `xml:"impp>uri"`
+		URL      string   `xml:"url>uri"`
+		Photo    string   `xml:"photo>uri"`
+		Note     string   `xml:"note>text"`
+	}{}
+	err := d.DecodeElement(&v, &start)
+	if err != nil {
+		return err
+	}
+
+	*c = Card{
+		FN:       v.FN,
+		Name:     Name(v.N),
+		Nickname: v.Nickname,
+		Birthday: v.BDay,
+		Title:    v.Title,
+		Role:     v.Role,
+		URL:      v.URL,
+		Note:     v.Note,
+	}
+	if len(v.Org) > 0 {
+		c.Org.Name = v.Org[0]
+	}
+	if len(v.Org) > 1 {
+		c.Org.Units = v.Org[1:]
+	}
+	for _, email := range v.Email {
+		c.Email = append(c.Email, Email{Address: email.value(), Types: email.types()})
+	}
+	for _, tel := range v.Tel {
+		c.Tel = append(c.Tel, Tel{Number: tel.value(), Types: tel.types()})
+	}
+	for _, impp := range v.IMPP {
+		if !strings.HasPrefix(impp, "xmpp:") {
+			continue
+		}
+		c.JID, err = jid.Parse(strings.TrimPrefix(impp, "xmpp:"))
+		if err != nil {
+			return err
+		}
+		break
+	}
+	if strings.HasPrefix(v.Photo, "data:") {
+		// Data URIs have the form "data:[<mediatype>][;base64],<data>".
+		idx := strings.IndexByte(v.Photo, ',')
+		if idx == -1 {
+			return nil
+		}
+		meta, data := v.Photo[len("data:"):idx], v.Photo[idx+1:]
+		if !strings.HasSuffix(meta, ";base64") {
+			return nil
+		}
+		c.Photo.Type = strings.TrimSuffix(meta, ";base64")
+		c.Photo.Data, err = base64.StdEncoding.DecodeString(data)
+		return err
+	}
+	c.Photo.URL = v.Photo
+	return nil
+}
+
+// Fetch requests the vCard4 published by the provided entity, or our own if j
+// is the zero value.
+// If no vCard4 has been published the result will be empty.
+func Fetch(ctx context.Context, s *xmpp.Session, j jid.JID) (Card, error) {
+	items, err := pubsub.Fetch(ctx, s, j.Bare(), NodePEP, 1)
+	if err != nil || len(items) == 0 {
+		return Card{}, err
+	}
+	var c Card
+	err = items[len(items)-1].Decode(&c)
+	return c, err
+}
+
+// Publish publishes our vCard4, replacing any existing one.
+func Publish(ctx context.Context, s *xmpp.Session, c Card) error {
+	_, err := pubsub.Publish(ctx, s, jid.JID{}, NodePEP, "current", nil, c.TokenReader())
+	return err
+}
@@ -0,0 +1,252 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package vcard
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"strings"
+	"unicode"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// Temp is a profile in the vcard-temp format.
+type Temp struct {
+	FN       string
+	Name     Name
+	Nickname string
+	Birthday string
+	Org      Org
+	Title    string
+	Role     string
+	Email    []Email
+	Tel      []Tel
+	JID      jid.JID
+	URL      string
+	Note     string
+	Photo    Photo
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (t Temp) TokenReader() xml.TokenReader {
+	var inner []xml.TokenReader
+	inner = append(inner, optionalString(t.FN, "FN"))
+	if t.Name != (Name{}) {
+		inner = append(inner, xmlstream.Wrap(
+			xmlstream.MultiReader(
+				optionalString(t.Name.Family, "FAMILY"),
+				optionalString(t.Name.Given, "GIVEN"),
+				optionalString(t.Name.Middle, "MIDDLE"),
+				optionalString(t.Name.Prefix, "PREFIX"),
+				optionalString(t.Name.Suffix, "SUFFIX"),
+			),
+			xml.StartElement{Name: xml.Name{Local: "N"}},
+		))
+	}
+	inner = append(inner,
+		optionalString(t.Nickname, "NICKNAME"),
+		optionalString(t.URL, "URL"),
+		optionalString(t.Birthday, "BDAY"),
+	)
+	if t.Org.Name != "" || len(t.Org.Units) > 0 {
+		units := []xml.TokenReader{optionalString(t.Org.Name, "ORGNAME")}
+		for _, unit := range t.Org.Units {
+			units = append(units, optionalString(unit, "ORGUNIT"))
+		}
+		inner = append(inner, xmlstream.Wrap(
+			xmlstream.MultiReader(units...),
+			xml.StartElement{Name: xml.Name{Local: "ORG"}},
+		))
+	}
+	inner = append(inner,
+		optionalString(t.Title, "TITLE"),
+		optionalString(t.Role, "ROLE"),
+	)
+	for _, tel := range t.Tel {
+		inner = append(inner, tempFlagged("TEL", "NUMBER", tel.Number, tel.Types))
+	}
+	for _, email := range t.Email {
+		inner = append(inner, tempFlagged("EMAIL", "USERID", email.Address, email.Types))
+	}
+	if !t.JID.Equal(jid.JID{}) {
+		inner = append(inner, optionalString(t.JID.String(), "JABBERID"))
+	}
+	inner = append(inner, optionalString(t.Note, "DESC"))
+	if len(t.Photo.Data) > 0 || t.Photo.URL != "" {
+		var val xml.TokenReader
+		if len(t.Photo.Data) > 0 {
+			val = optionalString(base64.StdEncoding.EncodeToString(t.Photo.Data), "BINVAL")
+		} else {
+			val = optionalString(t.Photo.URL, "EXTVAL")
+		}
+		inner = append(inner, xmlstream.Wrap(
+			xmlstream.MultiReader(optionalString(t.Photo.Type, "TYPE"), val),
+			xml.StartElement{Name: xml.Name{Local: "PHOTO"}},
+		))
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Space: NSTemp, Local: "vCard"}},
+	)
+}
+
+// tempFlagged returns an element that contains a value along with empty
+// elements that describe the type of the value.
+func tempFlagged(local, valueLocal, value string, types []string) xml.TokenReader {
+	var inner []xml.TokenReader
+	for _, typ := range types {
+		inner = append(inner, empty(strings.ToUpper(typ)))
+	}
+	inner = append(inner, optionalString(value, valueLocal))
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Local: local}},
+	)
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (t Temp) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, t.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (t Temp) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	_, err := t.WriteXML(e)
+	return err
+}
+
+// flagged is a value with a list of empty elements indicating its type.
+type flagged struct {
+	Value string
+	Types []string
+}
+
+func (f *flagged) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "NUMBER", "USERID":
+				err = d.DecodeElement(&f.Value, &t)
+			default:
+				f.Types = append(f.Types, strings.ToLower(t.Name.Local))
+				err = d.Skip()
+			}
+			if err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (t *Temp) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	v := struct {
+		FN string `xml:"FN"`
+		N  struct {
+			Family string `xml:"FAMILY"`
+			Given  string `xml:"GIVEN"`
+			Middle string `xml:"MIDDLE"`
+			Prefix string `xml:"PREFIX"`
+			Suffix string `xml:"SUFFIX"`
+		} `xml:"N"`
+		Nickname string `xml:"NICKNAME"`
+		URL      string `xml:"URL"`
+		BDay     string `xml:"BDAY"`
+		Org      struct {
+			Name  string   `xml:"ORGNAME"`
+			Units []string `xml:"ORGUNIT"`
+		} `xml:"ORG"`
+		Title    string    `xml:"TITLE"`
+		Role     string    `xml:"ROLE"`
+		Tel      []flagged `xml:"TEL"`
+		Email    []flagged `xml:"EMAIL"`
+		JabberID string    `xml:"JABBERID"`
+		Desc     string    `xml:"DESC"`
+		Photo    struct {
+			Type   string `xml:"TYPE"`
+			BinVal string `xml:"BINVAL"`
+			ExtVal string `xml:"EXTVAL"`
+		} `xml:"PHOTO"`
+	}{}
+	err := d.DecodeElement(&v, &start)
+	if err != nil {
+		return err
+	}
+
+	*t = Temp{
+		FN:       v.FN,
+		Name:     Name(v.N),
+		Nickname: v.Nickname,
+		Birthday: v.BDay,
+		Org:      Org(v.Org),
+		Title:    v.Title,
+		Role:     v.Role,
+		URL:      v.URL,
+		Note:     v.Desc,
+		Photo: Photo{
+			Type: v.Photo.Type,
+			URL:  v.Photo.ExtVal,
+		},
+	}
+	for _, tel := range v.Tel {
+		t.Tel = append(t.Tel, Tel{Number: tel.Value, Types: tel.Types})
+	}
+	for _, email := range v.Email {
+		t.Email = append(t.Email, Email{Address: email.Value, Types: email.Types})
+	}
+	if v.JabberID != "" {
+		t.JID, err = jid.Parse(v.JabberID)
+		if err != nil {
+			return err
+		}
+	}
+	if v.Photo.BinVal != "" {
+		// Base64 data is often wrapped across multiple lines.
+		t.Photo.Data, err = base64.StdEncoding.DecodeString(strings.Map(func(r rune) rune {
+			if unicode.IsSpace(r) {
+				return -1
+			}
+			return r
+		}, v.Photo.BinVal))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FetchTemp requests the vcard-temp of the provided entity, or our own if j is
+// the zero value.
+func FetchTemp(ctx context.Context, s *xmpp.Session, j jid.JID) (Temp, error) {
+	var t Temp
+	err := s.UnmarshalIQElement(ctx, xmlstream.Wrap(
+		nil,
+		xml.StartElement{Name: xml.Name{Space: NSTemp, Local: "vCard"}},
+	), stanza.IQ{
+		Type: stanza.GetIQ,
+		To:   j.Bare(),
+	}, &t)
+	return t, err
+}
+
+// SetTemp replaces our vcard-temp.
+func SetTemp(ctx context.Context, s *xmpp.Session, t Temp) error {
+	return s.UnmarshalIQElement(ctx, t.TokenReader(), stanza.IQ{
+		Type: stanza.SetIQ,
+	}, nil)
+}
@@ -0,0 +1,90 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package vcard
+
+import (
+	"crypto/sha1" // #nosec G505
+	"encoding/hex"
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/ns"
+)
+
+// Update is included in presence to advertise the hash of the photo in our
+// vcard-temp so that contacts know when to fetch it again.
+type Update struct {
+	// Photo is the hex encoded SHA-1 hash of the photo, or the empty string if
+	// the vcard-temp does not contain a photo.
+	Photo string
+}
+
+// PhotoHash returns the hash of the photo data as used in Update.
+// If the photo does not contain any data the empty string is returned.
+func PhotoHash(p Photo) string {
+	if len(p.Data) == 0 {
+		return ""
+	}
+	/* #nosec */
+	h := sha1.Sum(p.Data)
+	return hex.EncodeToString(h[:])
+}
+
+// TokenReader satisfies the xmlstream.Marshaler interface.
+func (u Update) TokenReader() xml.TokenReader {
+	var photo xml.TokenReader
+	if u.Photo != "" {
+		photo = xmlstream.Token(xml.CharData(u.Photo))
+	}
+	return xmlstream.Wrap(
+		xmlstream.Wrap(photo, xml.StartElement{Name: xml.Name{Local: "photo"}}),
+		xml.StartElement{Name: xml.Name{Space: NSUpdate, Local: "x"}},
+	)
+}
+
+// WriteXML satisfies the xmlstream.WriterTo interface.
+// It is like MarshalXML except it writes tokens to w.
+func (u Update) WriteXML(w xmlstream.TokenWriter) (int, error) {
+	return xmlstream.Copy(w, u.TokenReader())
+}
+
+// MarshalXML implements xml.Marshaler.
+func (u Update) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	_, err := u.WriteXML(e)
+	return err
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (u *Update) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	v := struct {
+		Photo string `xml:"photo"`
+	}{}
+	err := d.DecodeElement(&v, &start)
+	u.Photo = v.Photo
+	return err
+}
+
+// UpdatePresence returns a transformer that inserts the update into any
+// available presence read through it.
+// It is normally used to advertise our photo in every outgoing presence.
+func UpdatePresence(u Update) xmlstream.Transformer {
+	return xmlstream.InsertFunc(func(start xml.StartElement, level uint64, w xmlstream.TokenWriter) error {
+		if level != 1 || start.Name.Local != "presence" {
+			return nil
+		}
+		switch start.Name.Space {
+		case "", ns.Client, ns.Server:
+		default:
+			return nil
+		}
+		for _, attr := range start.Attr {
+			if attr.Name.Local == "type" && attr.Value != "" {
+				return nil
+			}
+		}
+		_, err := u.WriteXML(w)
+		return err
+	})
+}
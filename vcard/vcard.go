@@ -0,0 +1,100 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package vcard implements storing and retrieving user profiles.
+//
+// Two formats are supported: the older vcard-temp format described in
+// XEP-0054: vcard-temp, which is stored using IQs and represented by the Temp
+// type, and the vCard4 XML format described in RFC 6351, which is published
+// using the Personal Eventing Protocol as described in XEP-0292: vCard4 Over
+// XMPP and represented by the Card type.
+// Because both formats contain the same information, Temp and Card have
+// identical fields and can be converted between one another:
+//
+//     temp, err := vcard.FetchTemp(ctx, session, jid.JID{})
+//     …
+//     err = vcard.Publish(ctx, session, vcard.Card(temp))
+//
+// The package also implements XEP-0153: vCard-Based Avatars which advertises
+// the hash of the photo in the vcard-temp in our presence.
+package vcard // import "mellium.im/xmpp/vcard"
+
+import (
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+)
+
+// Various namespaces used by this package, provided as a convenience.
+const (
+	NSTemp   = `vcard-temp`
+	NS       = `urn:ietf:params:xml:ns:vcard-4.0`
+	NSUpdate = `vcard-temp:x:update`
+
+	// NodePEP is the node and namespace used when publishing vCard4 over PEP.
+	NodePEP = `urn:xmpp:vcard4`
+)
+
+// Name is the components of a persons name.
+type Name struct {
+	Family string
+	Given  string
+	Middle string
+	Prefix string
+	Suffix string
+}
+
+// Org is an organization and the units within it that a person belongs to.
+type Org struct {
+	Name  string
+	Units []string
+}
+
+// Email is an email address.
+// Types are lower case, eg. "home", "work", or "pref" for the preferred
+// address.
+type Email struct {
+	Address string
+	Types   []string
+}
+
+// Tel is a telephone number.
+// Types are lower case, eg. "home", "work", "voice", "cell", or "pref" for the
+// preferred number.
+type Tel struct {
+	Number string
+	Types  []string
+}
+
+// Photo is an image of a person.
+// Either Data or URL should be set.
+type Photo struct {
+	// Type is the media type of the data, eg. "image/png".
+	Type string
+	Data []byte
+	URL  string
+}
+
+func optionalString(s, local string) xml.TokenReader {
+	if s == "" {
+		return nil
+	}
+	return xmlstream.Wrap(
+		xmlstream.Token(xml.CharData(s)),
+		xml.StartElement{Name: xml.Name{Local: local}},
+	)
+}
+
+func empty(local string) xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Local: local}})
+}
+
+func hasType(types []string, typ string) bool {
+	for _, t := range types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
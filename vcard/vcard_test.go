@@ -0,0 +1,260 @@
+// Copyright 2021 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package vcard_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/internal/xmpptest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/vcard"
+)
+
+var marshalTestCases = []xmpptest.EncodingTestCase{
+	0: {
+		Value: &vcard.Temp{},
+		XML:   `<vCard xmlns="vcard-temp"></vCard>`,
+	},
+	1: {
+		Value: &vcard.Temp{
+			FN:   "Peter Saint-Andre",
+			Name: vcard.Name{Family: "Saint-Andre", Given: "Peter"},
+			Org:  vcard.Org{Name: "XMPP Standards Foundation", Units: []string{"Council"}},
+			Email: []vcard.Email{{
+				Address: "stpeter@jabber.org",
+				Types:   []string{"internet", "pref"},
+			}},
+			Tel: []vcard.Tel{{
+				Number: "303-308-3282",
+				Types:  []string{"work", "voice"},
+			}},
+			JID:   jid.MustParse("stpeter@jabber.org"),
+			Photo: vcard.Photo{Type: "image/png", Data: []byte("png")},
+		},
+		XML: `<vCard xmlns="vcard-temp"><FN>Peter Saint-Andre</FN><N><FAMILY>Saint-Andre</FAMILY><GIVEN>Peter</GIVEN></N><ORG><ORGNAME>XMPP Standards Foundation</ORGNAME><ORGUNIT>Council</ORGUNIT></ORG><TEL><WORK></WORK><VOICE></VOICE><NUMBER>303-308-3282</NUMBER></TEL><EMAIL><INTERNET></INTERNET><PREF></PREF><USERID>stpeter@jabber.org</USERID></EMAIL><JABBERID>stpeter@jabber.org</JABBERID><PHOTO><TYPE>image/png</TYPE><BINVAL>cG5n</BINVAL></PHOTO></vCard>`,
+	},
+	2: {
+		Value: &vcard.Card{},
+		XML:   `<vcard xmlns="urn:ietf:params:xml:ns:vcard-4.0"></vcard>`,
+	},
+	3: {
+		Value: &vcard.Card{
+			FN:       "Peter Saint-Andre",
+			Name:     vcard.Name{Family: "Saint-Andre", Given: "Peter"},
+			Nickname: "stpeter",
+			Birthday: "1966-08-06",
+			Org:      vcard.Org{Name: "XMPP Standards Foundation"},
+			Email: []vcard.Email{{
+				Address: "stpeter@jabber.org",
+				Types:   []string{"work", "pref"},
+			}},
+			Tel: []vcard.Tel{{
+				Number: "tel:+1-303-308-3282",
+				Types:  []string{"work"},
+			}},
+			JID:   jid.MustParse("stpeter@jabber.org"),
+			URL:   "https://stpeter.im/",
+			Photo: vcard.Photo{URL: "https://stpeter.im/images/stpeter_oscon.jpg"},
+		},
+		XML: `<vcard xmlns="urn:ietf:params:xml:ns:vcard-4.0"><fn><text>Peter Saint-Andre</text></fn><n><surname>Saint-Andre</surname><given>Peter</given></n><nickname><text>stpeter</text></nickname><bday><date>1966-08-06</date></bday><org><text>XMPP Standards Foundation</text></org><email><parameters><pref><integer>1</integer></pref><type><text>work</text></type></parameters><text>stpeter@jabber.org</text></email><tel><parameters><type><text>work</text></type></parameters><uri>tel:+1-303-308-3282</uri></tel><impp><uri>xmpp:stpeter@jabber.org</uri></impp><url><uri>https://stpeter.im/</uri></url><photo><uri>https://stpeter.im/images/stpeter_oscon.jpg</uri></photo></vcard>`,
+		// The order of the types is not preserved.
+		NoUnmarshal: true,
+	},
+	4: {
+		Value: &vcard.Card{
+			Photo: vcard.Photo{Type: "image/png", Data: []byte("png")},
+		},
+		XML: `<vcard xmlns="urn:ietf:params:xml:ns:vcard-4.0"><photo><uri>data:image/png;base64,cG5n</uri></photo></vcard>`,
+	},
+	5: {
+		Value: &vcard.Update{},
+		XML:   `<x xmlns="vcard-temp:x:update"><photo></photo></x>`,
+	},
+	6: {
+		Value: &vcard.Update{Photo: "01b87fcd030b72895ff8e88db57ec525450f000d"},
+		XML:   `<x xmlns="vcard-temp:x:update"><photo>01b87fcd030b72895ff8e88db57ec525450f000d</photo></x>`,
+	},
+}
+
+func TestEncode(t *testing.T) {
+	xmpptest.RunEncodingTests(t, marshalTestCases)
+}
+
+func TestUnmarshalCardPref(t *testing.T) {
+	const input = `<vcard xmlns="urn:ietf:params:xml:ns:vcard-4.0"><email><parameters><pref><integer>1</integer></pref><type><text>work</text></type></parameters><text>stpeter@jabber.org</text></email><tel><uri>tel:+1-303-308-3282</uri></tel></vcard>`
+	var c vcard.Card
+	err := xml.Unmarshal([]byte(input), &c)
+	if err != nil {
+		t.Fatalf("error unmarshaling: %v", err)
+	}
+	wantEmail := []vcard.Email{{Address: "stpeter@jabber.org", Types: []string{"work", "pref"}}}
+	if !reflect.DeepEqual(c.Email, wantEmail) {
+		t.Errorf("wrong email: want=%+v, got=%+v", wantEmail, c.Email)
+	}
+	wantTel := []vcard.Tel{{Number: "tel:+1-303-308-3282"}}
+	if !reflect.DeepEqual(c.Tel, wantTel) {
+		t.Errorf("wrong tel: want=%+v, got=%+v", wantTel, c.Tel)
+	}
+}
+
+func TestTemp(t *testing.T) {
+	var (
+		m      sync.Mutex
+		stored []byte
+	)
+	name := xml.Name{Space: vcard.NSTemp, Local: "vCard"}
+	s := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(
+			mux.IQFunc(stanza.SetIQ, name, func(iq stanza.IQ, r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+				var buf bytes.Buffer
+				e := xml.NewEncoder(&buf)
+				_, err := xmlstream.Copy(e, xmlstream.MultiReader(xmlstream.Token(*start), r))
+				if err != nil {
+					return err
+				}
+				err = e.Flush()
+				if err != nil {
+					return err
+				}
+				m.Lock()
+				stored = buf.Bytes()
+				m.Unlock()
+				_, err = xmlstream.Copy(r, iq.Result(nil))
+				return err
+			}),
+			mux.IQFunc(stanza.GetIQ, name, func(iq stanza.IQ, r xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+				m.Lock()
+				defer m.Unlock()
+				_, err := xmlstream.Copy(r, iq.Result(xml.NewDecoder(bytes.NewReader(stored))))
+				return err
+			}),
+		)),
+	)
+	ctx := context.Background()
+
+	want := vcard.Temp{
+		FN:    "Juliet Capulet",
+		Org:   vcard.Org{Name: "House of Capulet"},
+		Email: []vcard.Email{{Address: "juliet@capulet.lit", Types: []string{"home"}}},
+		Photo: vcard.Photo{Type: "image/png", Data: []byte("png")},
+	}
+	err := vcard.SetTemp(ctx, s.Client, want)
+	if err != nil {
+		t.Fatalf("error setting vcard: %v", err)
+	}
+	got, err := vcard.FetchTemp(ctx, s.Client, jid.JID{})
+	if err != nil {
+		t.Fatalf("error fetching vcard: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong vcard:\nwant=%+v,\n got=%+v", want, got)
+	}
+}
+
+func TestCard(t *testing.T) {
+	pep := &xmpptest.PubSub{}
+	s := xmpptest.NewClientServer(
+		xmpptest.ServerHandler(mux.New(pep.Handle())),
+	)
+	ctx := context.Background()
+
+	card, err := vcard.Fetch(ctx, s.Client, jid.JID{})
+	if err != nil {
+		t.Fatalf("error fetching unpublished vcard: %v", err)
+	}
+	if !reflect.DeepEqual(card, vcard.Card{}) {
+		t.Errorf("expected empty vcard, got %+v", card)
+	}
+
+	// Convert a vcard-temp to make sure that the types stay compatible.
+	want := vcard.Card(vcard.Temp{
+		FN:    "Juliet Capulet",
+		Name:  vcard.Name{Family: "Capulet", Given: "Juliet"},
+		Org:   vcard.Org{Name: "House of Capulet"},
+		Email: []vcard.Email{{Address: "juliet@capulet.lit", Types: []string{"home"}}},
+		JID:   jid.MustParse("juliet@capulet.lit"),
+		Note:  "Wherefore art thou?",
+		Photo: vcard.Photo{Type: "image/png", Data: []byte("png")},
+	})
+	err = vcard.Publish(ctx, s.Client, want)
+	if err != nil {
+		t.Fatalf("error publishing vcard: %v", err)
+	}
+	card, err = vcard.Fetch(ctx, s.Client, s.Client.LocalAddr())
+	if err != nil {
+		t.Fatalf("error fetching vcard: %v", err)
+	}
+	if !reflect.DeepEqual(card, want) {
+		t.Errorf("wrong vcard:\nwant=%+v,\n got=%+v", want, card)
+	}
+}
+
+var updateTestCases = [...]struct {
+	in  string
+	out string
+}{
+	0: {
+		in:  `<presence><show>away</show></presence>`,
+		out: `<presence><x xmlns="vcard-temp:x:update"><photo>01b87fcd030b72895ff8e88db57ec525450f000d</photo></x><show>away</show></presence>`,
+	},
+	1: {
+		in:  `<presence xmlns="jabber:server"></presence>`,
+		out: `<presence xmlns="jabber:server"><x xmlns="vcard-temp:x:update"><photo>01b87fcd030b72895ff8e88db57ec525450f000d</photo></x></presence>`,
+	},
+	2: {
+		in:  `<presence type="unavailable"></presence>`,
+		out: `<presence type="unavailable"></presence>`,
+	},
+	3: {
+		in:  `<message><presence></presence></message>`,
+		out: `<message><presence></presence></message>`,
+	},
+}
+
+func TestUpdatePresence(t *testing.T) {
+	transform := vcard.UpdatePresence(vcard.Update{Photo: "01b87fcd030b72895ff8e88db57ec525450f000d"})
+	for i, tc := range updateTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			// Prevent duplicate xmlns attributes. See https://mellium.im/issue/75
+			r := xmlstream.RemoveAttr(func(start xml.StartElement, attr xml.Attr) bool {
+				return start.Name.Local == "presence" && attr.Name.Local == "xmlns"
+			})(xml.NewDecoder(strings.NewReader(tc.in)))
+			r = transform(r)
+			var buf strings.Builder
+			e := xml.NewEncoder(&buf)
+			_, err := xmlstream.Copy(e, r)
+			if err != nil {
+				t.Fatalf("error transforming: %v", err)
+			}
+			err = e.Flush()
+			if err != nil {
+				t.Fatalf("error flushing: %v", err)
+			}
+			if out := buf.String(); out != tc.out {
+				t.Errorf("wrong output:\nwant=%s,\n got=%s", tc.out, out)
+			}
+		})
+	}
+}
+
+func TestPhotoHash(t *testing.T) {
+	if h := vcard.PhotoHash(vcard.Photo{}); h != "" {
+		t.Errorf("expected empty hash for photo without data, got %q", h)
+	}
+	const want = "9040a7d6cdf7a0d6cab1823831c6ceb7d01af97f"
+	if h := vcard.PhotoHash(vcard.Photo{Data: []byte("png")}); h != want {
+		t.Errorf("wrong hash: want=%s, got=%s", want, h)
+	}
+}